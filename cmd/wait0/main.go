@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"wait0/internal/wait0"
+	"wait0/internal/wait0/logging"
 )
 
 func main() {
@@ -30,7 +31,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("init service: %v", err)
 	}
-	defer svc.Close()
 
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
 	ln, err := net.Listen("tcp", addr)
@@ -48,20 +48,52 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	defer onSignal(ctx, summarySignals(), svc.LogSummary)()
+	defer onSignal(ctx, reloadSignals(), func() {
+		// ReloadFromFile logs a rejected config itself.
+		_ = svc.ReloadFromFile(configPath)
+	})()
+
 	go func() {
-		log.Printf("wait0 listening on %s, origin=%s", addr, cfg.Server.Origin)
+		logging.Infof("wait0 listening on %s, origin=%s", addr, cfg.Server.Origin)
 		err := srv.Serve(ln)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Printf("server error: %v", err)
+			logging.Errorf("server error: %v", err)
 			stop()
 		}
 	}()
 
 	<-ctx.Done()
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// One deadline covers draining client connections and then background
+	// jobs, so the whole shutdown fits in server.shutdownTimeout.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), svc.ShutdownTimeout())
 	defer cancel()
-	_ = srv.Shutdown(shutdownCtx)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logging.Warnf("shutdown: client connections still open at the deadline: %v", err)
+	}
+	_ = svc.Shutdown(shutdownCtx)
+}
+
+// onSignal runs fn for each of sigs received until ctx ends. The returned
+// func stops the notifications. Without sigs it does nothing.
+func onSignal(ctx context.Context, sigs []os.Signal, fn func()) func() {
+	if len(sigs) == 0 {
+		return func() {}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				fn()
+			}
+		}
+	}()
+	return func() { signal.Stop(ch) }
 }
 
 func getenvDefault(name, def string) string {
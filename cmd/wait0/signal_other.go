@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// summarySignals is empty where SIGUSR1 does not exist.
+func summarySignals() []os.Signal {
+	return nil
+}
+
+// reloadSignals is empty where SIGHUP does not exist.
+func reloadSignals() []os.Signal {
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// summarySignals are the signals that trigger an on-demand stats summary.
+func summarySignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR1}
+}
+
+// reloadSignals are the signals that reload the config file.
+func reloadSignals() []os.Signal {
+	return []os.Signal{syscall.SIGHUP}
+}
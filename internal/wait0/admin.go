@@ -0,0 +1,48 @@
+package wait0
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"wait0/internal/wait0/invalidation"
+	"wait0/internal/wait0/statapi"
+)
+
+// isAdminPath reports whether path is a bearer-token endpoint guarded by
+// gateAdmin. Health and the Basic Auth dashboard are not.
+func isAdminPath(path string) bool {
+	switch path {
+	case invalidation.EndpointPath, invalidation.StaleEndpointPath, invalidation.PurgeEndpointPath, invalidation.WarmEndpointPath,
+		statapi.MetricsEndpointPath, statapi.LiveEndpointPath, statapi.EndpointPath, statapi.EndpointPath + "/":
+		return true
+	}
+	return false
+}
+
+// gateAdmin authenticates an admin request before it is routed and reports
+// whether it may proceed. Failures are counted, logged at most every 10s and
+// answered here with auth.denied_status (403 unless set) and one body,
+// whether or not the endpoint is enabled. Scope checks stay with the
+// endpoints.
+func (s *Service) gateAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := s.invAuth.AuthenticateRequest(r); ok {
+		return true
+	}
+	n := s.adminAuthFailures.Add(1)
+	s.authFailLog.Printf("admin auth failed: remote=%q method=%s path=%s total_failures=%d", strings.TrimSpace(r.RemoteAddr), r.Method, r.URL.Path, n)
+
+	status := s.config().Auth.DeniedStatus
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	msg := "unauthorized"
+	if status == http.StatusForbidden {
+		msg = "forbidden"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": msg})
+	return false
+}
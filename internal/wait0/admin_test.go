@@ -0,0 +1,116 @@
+package wait0
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wait0/internal/wait0/auth"
+	"wait0/internal/wait0/invalidation"
+	"wait0/internal/wait0/statapi"
+	wstats "wait0/internal/wait0/stats"
+)
+
+type lineLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *lineLogger) Printf(format string, v ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func newAdminTestService(t *testing.T, deniedStatus int) (*Service, *lineLogger) {
+	t.Helper()
+	s := newTestService(t, "http://example.com", nil)
+	cfg := *s.config()
+	cfg.Auth.DeniedStatus = deniedStatus
+	cfg.Server.HealthPath = DefaultHealthPath
+	s.cfg.Store(&cfg)
+	s.invAuth = auth.NewAuthenticator([]auth.TokenConfig{{ID: "stats", Token: "secret", Scopes: []string{statapi.ReadScope}}})
+	s.stat = statapi.NewController(s.invAuth, newStatsRuntimeAdapter(s))
+	s.inv = nil
+	log := &lineLogger{}
+	s.authFailLog = wstats.NewRateLimitedLogger(time.Hour, log)
+	return s, log
+}
+
+func TestGateAdmin_DeniedStatusAnswersUniformly(t *testing.T) {
+	s, log := newAdminTestService(t, http.StatusForbidden)
+	a := newProxyRuntimeAdapter(s)
+
+	// The invalidation controller is disabled and the stats one enabled; an
+	// unauthenticated caller sees the same answer from both.
+	for _, path := range []string{invalidation.PurgeEndpointPath + "?path=/a", statapi.EndpointPath, statapi.MetricsEndpointPath} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://wait0.local"+path, nil)
+		r.Header.Set("Authorization", "Bearer wrong")
+		if !a.HandleControl(w, r) {
+			t.Fatalf("%s: expected request to be handled", path)
+		}
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s: status = %d, want 403", path, w.Code)
+		}
+		var body map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"] != "forbidden" {
+			t.Fatalf("%s: body = %v, err = %v", path, body, err)
+		}
+	}
+	if got := s.adminAuthFailures.Load(); got != 3 {
+		t.Fatalf("failures = %d, want 3", got)
+	}
+	// The rate-limited logger keeps the first line and drops the rest.
+	if len(log.lines) != 1 || !strings.Contains(log.lines[0], "admin auth failed") {
+		t.Fatalf("log lines = %q", log.lines)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local"+statapi.LiveEndpointPath, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	a.HandleControl(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bearer header: status = %d, want 200", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	if !a.HandleControl(w, httptest.NewRequest(http.MethodGet, "http://wait0.local"+DefaultHealthPath, nil)) {
+		t.Fatal("health: expected request to be handled")
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("health: status = %d, want 200 without auth", w.Code)
+	}
+	if got := s.adminAuthFailures.Load(); got != 3 {
+		t.Fatalf("failures = %d after authorized requests, want 3", got)
+	}
+}
+
+func TestGateAdmin_DefaultsTo403(t *testing.T) {
+	s, log := newAdminTestService(t, 0)
+	a := newProxyRuntimeAdapter(s)
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "http://wait0.local"+invalidation.EndpointPath, nil),
+		httptest.NewRequest(http.MethodGet, "http://wait0.local"+statapi.EndpointPath, nil),
+		httptest.NewRequest(http.MethodGet, "http://wait0.local"+statapi.EndpointPath+"?access_token=secret", nil),
+	} {
+		w := httptest.NewRecorder()
+		a.HandleControl(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s: status = %d, want 403", r.URL, w.Code)
+		}
+	}
+
+	if got := s.adminAuthFailures.Load(); got != 3 {
+		t.Fatalf("failures = %d, want 3", got)
+	}
+	if len(log.lines) != 1 {
+		t.Fatalf("log lines = %q, want one", log.lines)
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/subtle"
+	"net/http"
 	"strings"
 )
 
@@ -58,6 +59,13 @@ func (a *Authenticator) AuthenticateBearer(authHeader string) (Principal, bool)
 	return Principal{}, false
 }
 
+// AuthenticateRequest authenticates the bearer token in r's Authorization
+// header. Tokens in the query string are not accepted, since URLs end up in
+// access logs.
+func (a *Authenticator) AuthenticateRequest(r *http.Request) (Principal, bool) {
+	return a.AuthenticateBearer(r.Header.Get("Authorization"))
+}
+
 func AuthorizedForScope(p Principal, requiredScope string) bool {
 	req := strings.TrimSpace(requiredScope)
 	if req == "" {
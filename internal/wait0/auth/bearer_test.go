@@ -1,6 +1,10 @@
 package auth
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
 
 func TestParseBearerToken(t *testing.T) {
 	tok, ok := parseBearerToken("Bearer abc123")
@@ -34,6 +38,24 @@ func TestAuthenticateBearerToken(t *testing.T) {
 	}
 }
 
+func TestAuthenticateRequest(t *testing.T) {
+	a := NewAuthenticator([]TokenConfig{{ID: "a", Token: "tok-a", Scopes: []string{"stats:read"}}})
+
+	r := httptest.NewRequest(http.MethodGet, "/wait0", nil)
+	r.Header.Set("Authorization", "Bearer tok-a")
+	if p, ok := a.AuthenticateRequest(r); !ok || p.ID != "a" {
+		t.Fatalf("header token: principal=%+v ok=%v", p, ok)
+	}
+
+	if _, ok := a.AuthenticateRequest(httptest.NewRequest(http.MethodGet, "/wait0?access_token=tok-a", nil)); ok {
+		t.Fatal("expected a query string token to be ignored")
+	}
+
+	if _, ok := a.AuthenticateRequest(httptest.NewRequest(http.MethodGet, "/wait0", nil)); ok {
+		t.Fatal("expected request without a token to fail")
+	}
+}
+
 func TestAuthorizedForScope(t *testing.T) {
 	tok := Principal{Scopes: map[string]struct{}{"invalidation:write": {}}}
 	if !AuthorizedForScope(tok, "invalidation:write") {
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// TierAudit samples keys held by both RAM and disk and compares the two
+// copies. Copies with different bodies (Hash32) have diverged; with
+// Reconcile set the one with the older StoredAt gives way: a newer RAM copy
+// is rewritten to disk, and an older one, or one stored in the same second,
+// is dropped from RAM so the next request loads the disk copy. Copies with the same body but different
+// StoredAt only differ in revalidation timestamps, which Reconcile copies
+// onto the older one.
+type TierAudit struct {
+	RAM       *RAM
+	Disk      *Disk
+	Every     time.Duration
+	Sample    int
+	Reconcile bool
+	StopCh    <-chan struct{}
+	Logger    Logger
+}
+
+// AuditResult summarizes one audit pass.
+type AuditResult struct {
+	// Checked counts sampled keys found in both tiers.
+	Checked int
+	// Diverged counts keys whose tiers hold different bodies; Reconciled
+	// those whose copies were brought in line, timestamps included.
+	Diverged   int
+	Reconciled int
+	// Example is one diverged key, for the log line.
+	Example string
+}
+
+// Loop runs Check every Every until StopCh closes, logging passes that found
+// diverged keys.
+func (a TierAudit) Loop() {
+	t := time.NewTicker(a.Every)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.StopCh:
+			return
+		case <-t.C:
+		}
+		res := a.Check()
+		if res.Diverged > 0 && a.Logger != nil {
+			a.Logger.Printf("tier audit: %d of %d sampled keys diverged between RAM and disk, %d reconciled (e.g. key=%q)", res.Diverged, res.Checked, res.Reconciled, res.Example)
+		}
+	}
+}
+
+// Check compares up to Sample random keys present in both tiers.
+func (a TierAudit) Check() AuditResult {
+	var res AuditResult
+	keys := a.RAM.Keys()
+	for i := 0; i < len(keys) && res.Checked < a.Sample; i++ {
+		// Partial Fisher-Yates shuffle: keys[i] is a random unvisited key.
+		j := i + rand.IntN(len(keys)-i)
+		keys[i], keys[j] = keys[j], keys[i]
+		key := keys[i]
+		if !a.Disk.HasKey(key) {
+			continue
+		}
+		ram, ok := a.RAM.Peek(key)
+		if !ok {
+			continue
+		}
+		disk, ok := a.Disk.Peek(key)
+		if !ok {
+			continue
+		}
+		res.Checked++
+		if ram.Hash32 == disk.Hash32 && ram.StoredAt == disk.StoredAt {
+			continue
+		}
+		if ram.Hash32 != disk.Hash32 {
+			res.Diverged++
+			if res.Example == "" {
+				res.Example = key
+			}
+		}
+		if !a.Reconcile {
+			continue
+		}
+		if a.reconcile(key, ram, disk) {
+			res.Reconciled++
+		}
+	}
+	return res
+}
+
+// reconcile keeps the newer of key's two copies and reports whether it
+// changed either tier.
+func (a TierAudit) reconcile(key string, ram, disk Entry) bool {
+	ramNewer := ram.StoredAt > disk.StoredAt
+	if ram.Hash32 == disk.Hash32 {
+		if ramNewer {
+			return a.Disk.Refresh(key, ram)
+		}
+		return a.RAM.Refresh(key, disk)
+	}
+	if ramNewer {
+		a.Disk.PutAsync(key, ram)
+		return true
+	}
+	_, ok := a.RAM.Purge(key)
+	return ok
+}
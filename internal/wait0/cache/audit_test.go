@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTierAudit_DetectsAndReconcilesDivergence(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+	ram := NewRAM(10 * 1024 * 1024)
+
+	put := func(key string, ramEnt, diskEnt Entry) {
+		ram.Put(key, ramEnt, d, nil)
+		d.PutAsync(key, diskEnt)
+	}
+	put("/same", Entry{Hash32: 1, StoredAt: 100}, Entry{Hash32: 1, StoredAt: 100})
+	put("/ram-newer", Entry{Hash32: 2, StoredAt: 200, Body: []byte("new")}, Entry{Hash32: 3, StoredAt: 100, Body: []byte("old")})
+	put("/disk-newer", Entry{Hash32: 4, StoredAt: 100}, Entry{Hash32: 5, StoredAt: 200})
+	put("/touched", Entry{Hash32: 6, StoredAt: 100}, Entry{Hash32: 6, StoredAt: 300, RevalidatedBy: "warmup"})
+	ram.Put("/ram-only", Entry{Hash32: 7, StoredAt: 100}, d, nil)
+	waitForDisk(t, func() bool { return d.KeyCount() == 4 })
+
+	a := TierAudit{RAM: ram, Disk: d, Sample: 10}
+	if res := a.Check(); res.Checked != 4 || res.Diverged != 2 || res.Reconciled != 0 {
+		t.Fatalf("report-only = %+v, want 4 checked, 2 diverged, none reconciled", res)
+	}
+
+	a.Reconcile = true
+	if res := a.Check(); res.Diverged != 2 || res.Reconciled != 3 {
+		t.Fatalf("reconcile = %+v, want 2 diverged and 3 reconciled", res)
+	}
+	waitForDisk(t, func() bool { ent, _ := d.Peek("/ram-newer"); return ent.Hash32 == 2 })
+	if _, ok := ram.Peek("/disk-newer"); ok {
+		t.Fatalf("older RAM copy of /disk-newer should be dropped")
+	}
+	if ent, _ := ram.Peek("/touched"); ent.StoredAt != 300 || ent.RevalidatedBy != "warmup" {
+		t.Fatalf("/touched RAM copy = %+v, want the disk timestamps", ent)
+	}
+
+	if res := a.Check(); res.Diverged != 0 || res.Checked != 3 {
+		t.Fatalf("after reconcile = %+v, want 3 consistent keys", res)
+	}
+}
+
+func TestTierAudit_SampleCapsCheckedKeys(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+	ram := NewRAM(10 * 1024 * 1024)
+	for _, k := range []string{"/a", "/b", "/c", "/d"} {
+		ram.Put(k, Entry{Hash32: 1}, d, nil)
+		d.PutAsync(k, Entry{Hash32: 1})
+	}
+	waitForDisk(t, func() bool { return d.KeyCount() == 4 })
+
+	if res := (TierAudit{RAM: ram, Disk: d, Sample: 2}).Check(); res.Checked != 2 {
+		t.Fatalf("checked = %d, want the sample size", res.Checked)
+	}
+}
@@ -2,7 +2,10 @@ package cache
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"net/http"
 )
 
@@ -20,6 +23,174 @@ func decodeGob(b []byte, v any) error {
 	return dec.Decode(v)
 }
 
+// entryMagic starts every entry in the binary format. A gob stream opens
+// with a non-zero message length, so its first byte is never 0 and entries
+// written before the binary format still decode through gob.
+var entryMagic = []byte{0, 'w', '0', 'e'}
+
+const entryCodecVersion = 1
+
+var errShortEntry = errors.New("cache entry: truncated")
+
+// marshalEntry encodes ent without reflection: the magic and version, the
+// scalar fields as varints, strings and the body length-prefixed, and the
+// header as a key count followed by each key and its values. Every Entry is
+// encodable; header names and values are stored as raw bytes.
+func marshalEntry(ent Entry) []byte {
+	n := len(entryMagic) + 1 + 6*binary.MaxVarintLen64 + 1 +
+		len(ent.DiscoveredBy) + len(ent.RevalidatedBy) + len(ent.ETag) + len(ent.LastModified) +
+		len(ent.Body) + 6*binary.MaxVarintLen32
+	for k, vs := range ent.Header {
+		n += len(k) + 2*binary.MaxVarintLen32
+		for _, v := range vs {
+			n += len(v) + binary.MaxVarintLen32
+		}
+	}
+
+	b := make([]byte, 0, n)
+	b = append(b, entryMagic...)
+	b = append(b, entryCodecVersion)
+	b = binary.AppendVarint(b, int64(ent.Status))
+	b = binary.AppendUvarint(b, uint64(ent.Hash32))
+	b = binary.AppendVarint(b, ent.StoredAt)
+	if ent.Inactive {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	b = appendString(b, ent.DiscoveredBy)
+	b = binary.AppendVarint(b, ent.RevalidatedAt)
+	b = appendString(b, ent.RevalidatedBy)
+	b = binary.AppendVarint(b, ent.MaxAge)
+	b = appendString(b, ent.ETag)
+	b = appendString(b, ent.LastModified)
+	b = binary.AppendUvarint(b, uint64(len(ent.Header)))
+	for k, vs := range ent.Header {
+		b = appendString(b, k)
+		b = binary.AppendUvarint(b, uint64(len(vs)))
+		for _, v := range vs {
+			b = appendString(b, v)
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(ent.Body)))
+	return append(b, ent.Body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// decodeEntry decodes an entry in either the binary format or legacy gob.
+// The decoded Body aliases b.
+func decodeEntry(b []byte) (Entry, error) {
+	if !bytes.HasPrefix(b, entryMagic) {
+		var ent Entry
+		err := decodeGob(b, &ent)
+		return ent, err
+	}
+	r := entryReader{b: b[len(entryMagic):]}
+	if v := r.byte(); r.err == nil && v != entryCodecVersion {
+		return Entry{}, fmt.Errorf("cache entry: unknown codec version %d", v)
+	}
+
+	var ent Entry
+	ent.Status = int(r.varint())
+	ent.Hash32 = uint32(r.uvarint())
+	ent.StoredAt = r.varint()
+	ent.Inactive = r.byte() == 1
+	ent.DiscoveredBy = r.string()
+	ent.RevalidatedAt = r.varint()
+	ent.RevalidatedBy = r.string()
+	ent.MaxAge = r.varint()
+	ent.ETag = r.string()
+	ent.LastModified = r.string()
+	if n := r.count(); n > 0 {
+		ent.Header = make(http.Header, n)
+		for range n {
+			k := r.string()
+			vs := make([]string, r.count())
+			for i := range vs {
+				vs[i] = r.string()
+			}
+			ent.Header[k] = vs
+		}
+	}
+	if n := r.count(); n > 0 {
+		ent.Body = r.next(n)
+	}
+	if r.err != nil {
+		return Entry{}, r.err
+	}
+	return ent, nil
+}
+
+// entryReader reads the binary entry format. The first error sticks and
+// every later read returns a zero value.
+type entryReader struct {
+	b   []byte
+	err error
+}
+
+func (r *entryReader) next(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errShortEntry
+		return nil
+	}
+	out := r.b[:n:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *entryReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *entryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errShortEntry
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *entryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = errShortEntry
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// count reads a length. Every counted item takes at least one byte, so a
+// length past the remaining input is corrupt and is rejected before it can
+// size an allocation.
+func (r *entryReader) count() int {
+	v := r.uvarint()
+	if v > uint64(len(r.b)) {
+		r.err = errShortEntry
+		return 0
+	}
+	return int(v)
+}
+
+func (r *entryReader) string() string {
+	return string(r.next(r.count()))
+}
+
 func init() {
 	gob.Register(http.Header{})
 }
@@ -2,6 +2,8 @@ package cache
 
 import (
 	"net/http"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -26,3 +28,114 @@ func TestCodec_DecodeError(t *testing.T) {
 		t.Fatalf("expected decode error")
 	}
 }
+
+func TestCodec_EntryRoundTrip(t *testing.T) {
+	in := Entry{
+		Status:        203,
+		Header:        http.Header{"Content-Type": {"text/html"}, "Set-Cookie": {"a=1", "b=2"}, "X-Empty": {}},
+		Body:          []byte("<p>hi</p>"),
+		StoredAt:      1700000000,
+		Hash32:        0xdeadbeef,
+		Inactive:      true,
+		DiscoveredBy:  "sitemap",
+		RevalidatedAt: 1700000000123456789,
+		RevalidatedBy: "warmup",
+		MaxAge:        -5,
+		ETag:          `"v1"`,
+		LastModified:  "Mon, 02 Jan 2006 15:04:05 GMT",
+	}
+	out, err := decodeEntry(marshalEntry(in))
+	if err != nil {
+		t.Fatalf("decodeEntry: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("decoded mismatch:\n got %+v\nwant %+v", out, in)
+	}
+
+	out, err = decodeEntry(marshalEntry(Entry{Status: 204}))
+	if err != nil || out.Status != 204 || out.Header != nil || out.Body != nil {
+		t.Fatalf("empty entry = %+v, %v", out, err)
+	}
+}
+
+func TestCodec_DecodeEntryReadsGob(t *testing.T) {
+	in := Entry{Status: 200, Header: http.Header{"X": {"1"}}, Body: []byte("legacy"), ETag: `"g"`}
+	b, err := encodeGob(in)
+	if err != nil {
+		t.Fatalf("encodeGob: %v", err)
+	}
+	out, err := decodeEntry(b)
+	if err != nil || !reflect.DeepEqual(out, in) {
+		t.Fatalf("gob entry = %+v, %v; want %+v", out, err, in)
+	}
+}
+
+func TestCodec_DecodeEntryRejectsCorrupt(t *testing.T) {
+	b := marshalEntry(Entry{Status: 200, Header: http.Header{"X": {"1"}}, Body: []byte("body")})
+	for n := len(entryMagic); n < len(b); n++ {
+		if _, err := decodeEntry(b[:n]); err == nil {
+			t.Fatalf("decodeEntry accepted %d of %d bytes", n, len(b))
+		}
+	}
+
+	future := append([]byte{}, b...)
+	future[len(entryMagic)] = entryCodecVersion + 1
+	if _, err := decodeEntry(future); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Fatalf("err = %v, want an unknown version error", err)
+	}
+}
+
+func benchEntry() Entry {
+	return Entry{
+		Status: 200,
+		Header: http.Header{
+			"Content-Type":  {"text/html; charset=utf-8"},
+			"Cache-Control": {"public, max-age=60"},
+			"Etag":          {`"abc123"`},
+			"Vary":          {"Accept-Encoding"},
+		},
+		Body:          []byte(strings.Repeat("<div>cached page</div>", 200)),
+		StoredAt:      1700000000,
+		DiscoveredBy:  "sitemap",
+		RevalidatedAt: 1700000000123456789,
+		RevalidatedBy: "warmup",
+		MaxAge:        60,
+		ETag:          `"abc123"`,
+	}
+}
+
+func BenchmarkCodec_Encode(b *testing.B) {
+	ent := benchEntry()
+	b.Run("gob", func(b *testing.B) {
+		for range b.N {
+			if _, err := encodeGob(ent); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		for range b.N {
+			marshalEntry(ent)
+		}
+	})
+}
+
+func BenchmarkCodec_Decode(b *testing.B) {
+	ent := benchEntry()
+	gobBytes, _ := encodeGob(ent)
+	binBytes := marshalEntry(ent)
+	b.Run("gob", func(b *testing.B) {
+		for range b.N {
+			if _, err := decodeEntry(gobBytes); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		for range b.N {
+			if _, err := decodeEntry(binBytes); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
@@ -19,18 +20,55 @@ type diskMeta struct {
 	Inactive     bool
 	DiscoveredBy string
 	LastRefresh  int64
+	// Touch holds revalidation timestamps newer than the stored entry's,
+	// recorded without rewriting the entry; Peek applies them.
+	Touch entryTouch
+	// Hits counts Get calls since HitsFrom, the start of the current
+	// promotion window.
+	Hits     int
+	HitsFrom int64
+}
+
+// entryTouch is the part of an Entry that an unchanged revalidation updates.
+type entryTouch struct {
+	StoredAt      int64
+	RevalidatedAt int64
+	RevalidatedBy string
+	MaxAge        int64
+}
+
+func (t entryTouch) apply(ent *Entry) {
+	if t.StoredAt <= ent.StoredAt {
+		return
+	}
+	ent.StoredAt = t.StoredAt
+	ent.RevalidatedAt = t.RevalidatedAt
+	ent.RevalidatedBy = t.RevalidatedBy
+	ent.MaxAge = t.MaxAge
 }
 
 type diskOp struct {
-	putKey string
-	putEnt *Entry
-	delKey string
+	putKey   string
+	putEnt   *Entry
+	delKey   string
+	delDone  chan<- purged
+	touchKey string
+	touch    entryTouch
+	evict    bool
+	// evictDone, if set, is closed once the eviction pass has run.
+	evictDone chan struct{}
 }
 
 type Disk struct {
 	maxBytes int64
+	// maxEntry is the largest entry PutAsync accepts; see
+	// SetMaxEntryPercent.
+	maxEntry int64
+	path     string
 
 	db *leveldb.DB
+	// write commits a batch to db; tests swap it to inject failures.
+	write func(*leveldb.Batch) error
 
 	mu        sync.Mutex
 	index     map[string]diskMeta
@@ -38,8 +76,50 @@ type Disk struct {
 
 	ops  chan diskOp
 	done chan struct{}
+
+	// writeErrors counts entries that failed to persist.
+	writeErrors atomic.Uint64
+	// deadLetters counts writer ops dropped after every write attempt
+	// failed.
+	deadLetters atomic.Uint64
+
+	// paused turns PutAsync into a no-op while free space is low.
+	paused atomic.Bool
+
+	// readSem bounds concurrent LevelDB reads; nil means unlimited.
+	readSem       chan struct{}
+	readsInFlight atomic.Int64
+
+	// compactAfter triggers a full LevelDB compaction once evictions have
+	// freed that many bytes; zero disables it. evictedBytes is only touched
+	// by the writer goroutine.
+	compactAfter int64
+	evictedBytes int64
+	compactions  atomic.Uint64
+
+	evictions atomic.Uint64
+	// oversizeDrops counts entries over maxEntry that PutAsync refused.
+	oversizeDrops atomic.Uint64
+
+	// promoteAfter is how many hits within promoteWindow make an entry
+	// Promotable; <= 1 promotes on every hit.
+	promoteAfter  int
+	promoteWindow time.Duration
 }
 
+// Failed LevelDB writes are retried diskWriteAttempts times in total, backing
+// off from diskWriteBackoff and doubling, so a transient I/O error does not
+// lose the op while a persistent one stalls the writer for at most ~15ms per
+// op.
+const (
+	diskWriteAttempts = 3
+	diskWriteBackoff  = 5 * time.Millisecond
+)
+
+// DiskReadWait is how long a read queues for a free slot before it is
+// treated as a miss.
+const DiskReadWait = 100 * time.Millisecond
+
 func NewDisk(path string, maxBytes int64, invalidateOnStart bool) (*Disk, error) {
 	if invalidateOnStart {
 		_ = os.RemoveAll(path)
@@ -50,11 +130,14 @@ func NewDisk(path string, maxBytes int64, invalidateOnStart bool) (*Disk, error)
 	}
 	d := &Disk{
 		maxBytes: maxBytes,
+		maxEntry: maxBytes,
+		path:     path,
 		db:       db,
 		index:    map[string]diskMeta{},
 		ops:      make(chan diskOp, 1024),
 		done:     make(chan struct{}),
 	}
+	d.write = func(b *leveldb.Batch) error { return db.Write(b, nil) }
 	if err := d.loadIndex(); err != nil {
 		_ = db.Close()
 		return nil, err
@@ -69,6 +152,13 @@ func (d *Disk) Close() {
 	_ = d.db.Close()
 }
 
+// Check reports whether LevelDB still answers reads. It fails once the store
+// is closed or its storage returns errors.
+func (d *Disk) Check() error {
+	_, err := d.db.Has([]byte("m:"), nil)
+	return err
+}
+
 func (d *Disk) SnapshotAccessTimes() map[string]int64 {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -109,6 +199,22 @@ func (d *Disk) TotalSize() int64 {
 	return d.totalSize
 }
 
+// WriteErrors returns how many entry writes failed to persist.
+func (d *Disk) WriteErrors() uint64 {
+	return d.writeErrors.Load()
+}
+
+// DeadLetters returns how many writer ops were dropped because LevelDB kept
+// failing after every retry.
+func (d *Disk) DeadLetters() uint64 {
+	return d.deadLetters.Load()
+}
+
+// PendingOps reports how many queued writes/deletes the writer has yet to apply.
+func (d *Disk) PendingOps() int {
+	return len(d.ops)
+}
+
 func (d *Disk) KeyCount() int {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -132,15 +238,126 @@ func (d *Disk) Keys() []string {
 	return out
 }
 
+// ForEach calls fn for every indexed key until fn returns false. fn runs
+// under the index lock and must not call back into this Disk cache.
+func (d *Disk) ForEach(fn func(key string) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k := range d.index {
+		if !fn(k) {
+			return
+		}
+	}
+}
+
+// SetMaxConcurrentReads bounds concurrent disk reads; n <= 0 removes the
+// limit. Call it before the cache is shared between goroutines.
+func (d *Disk) SetMaxConcurrentReads(n int) {
+	if n <= 0 {
+		d.readSem = nil
+		return
+	}
+	d.readSem = make(chan struct{}, n)
+}
+
+// SetCompactAfter makes eviction compact LevelDB once it has freed n bytes
+// since the last compaction, so deleted entries stop occupying disk space.
+// n <= 0 disables it. Call it before the cache is shared between goroutines.
+func (d *Disk) SetCompactAfter(n int64) {
+	d.compactAfter = max(n, 0)
+}
+
+// SetMaxEntryPercent makes PutAsync refuse entries larger than pct percent of
+// the disk budget, so one huge response cannot evict most of the tier. pct
+// outside 1..99 refuses only entries larger than the whole budget. Call it
+// before the cache is shared between goroutines.
+func (d *Disk) SetMaxEntryPercent(pct int) {
+	d.maxEntry = entryLimit(d.maxBytes, pct)
+}
+
+// OversizeDrops reports how many entries PutAsync refused for exceeding the
+// entry limit.
+func (d *Disk) OversizeDrops() uint64 {
+	return d.oversizeDrops.Load()
+}
+
+// SetPromotion makes Promotable require hits Get calls within window before
+// an entry is worth copying to RAM, so a one-off scan of cold entries does
+// not push the hot set out. hits <= 1 promotes on every hit. Call it before
+// the cache is shared between goroutines.
+func (d *Disk) SetPromotion(hits int, window time.Duration) {
+	d.promoteAfter = hits
+	d.promoteWindow = window
+}
+
+// Promotable reports whether key has been read often enough, per
+// SetPromotion, to be promoted to RAM.
+func (d *Disk) Promotable(key string) bool {
+	if d.promoteAfter <= 1 {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.index[key].Hits >= d.promoteAfter
+}
+
+// Evictions reports how many entries eviction passes have deleted.
+func (d *Disk) Evictions() uint64 {
+	return d.evictions.Load()
+}
+
+// Compactions reports how many eviction-triggered compactions have run.
+func (d *Disk) Compactions() uint64 {
+	return d.compactions.Load()
+}
+
+// ReadsInFlight reports how many disk reads are currently running.
+func (d *Disk) ReadsInFlight() int64 {
+	return d.readsInFlight.Load()
+}
+
+func (d *Disk) acquireRead() bool {
+	if d.readSem != nil {
+		select {
+		case d.readSem <- struct{}{}:
+		default:
+			t := time.NewTimer(DiskReadWait)
+			defer t.Stop()
+			select {
+			case d.readSem <- struct{}{}:
+			case <-t.C:
+				return false
+			}
+		}
+	}
+	d.readsInFlight.Add(1)
+	return true
+}
+
+func (d *Disk) releaseRead() {
+	d.readsInFlight.Add(-1)
+	if d.readSem != nil {
+		<-d.readSem
+	}
+}
+
 func (d *Disk) Peek(key string) (Entry, bool) {
+	if !d.acquireRead() {
+		return Entry{}, false
+	}
 	b, err := d.db.Get([]byte("e:"+key), nil)
+	d.releaseRead()
 	if err != nil {
 		return Entry{}, false
 	}
-	var ent Entry
-	if err := decodeGob(b, &ent); err != nil {
+	ent, err := decodeEntry(b)
+	if err != nil {
 		return Entry{}, false
 	}
+	d.mu.Lock()
+	touch := d.index[key].Touch
+	d.mu.Unlock()
+	touch.apply(&ent)
 	return ent, true
 }
 
@@ -157,6 +374,12 @@ func (d *Disk) Get(key string) (Entry, bool) {
 	meta, exists := d.index[key]
 	if exists {
 		meta.LastAccess = now
+		if d.promoteAfter > 1 {
+			if now-meta.HitsFrom >= int64(d.promoteWindow/time.Second) {
+				meta.Hits, meta.HitsFrom = 0, now
+			}
+			meta.Hits++
+		}
 		d.index[key] = meta
 	}
 	d.mu.Unlock()
@@ -166,17 +389,78 @@ func (d *Disk) Get(key string) (Entry, bool) {
 	return ent, true
 }
 
+// WritesPaused reports whether the free-space guard has paused disk writes.
+func (d *Disk) WritesPaused() bool {
+	return d.paused.Load()
+}
+
 func (d *Disk) PutAsync(key string, ent Entry) {
+	if d.paused.Load() {
+		return
+	}
+	if d.maxEntry > 0 && EntryBudgetSize(ent) > d.maxEntry {
+		// Drop the previous version too, or it would keep being served.
+		d.oversizeDrops.Add(1)
+		d.ops <- diskOp{delKey: key}
+		return
+	}
 	clone := ent
 	d.ops <- diskOp{putKey: key, putEnt: &clone}
 }
 
+// Refresh records from's revalidation timestamps and MaxAge for key by
+// rewriting only its small metadata record; Peek and Get apply them over the
+// stored entry. It reports whether key is on disk.
+func (d *Disk) Refresh(key string, from Entry) bool {
+	d.mu.Lock()
+	_, ok := d.index[key]
+	d.mu.Unlock()
+	if !ok {
+		return false
+	}
+	d.ops <- diskOp{touchKey: key, touch: entryTouch{
+		StoredAt:      from.StoredAt,
+		RevalidatedAt: from.RevalidatedAt,
+		RevalidatedBy: from.RevalidatedBy,
+		MaxAge:        from.MaxAge,
+	}}
+	return true
+}
+
 func (d *Disk) Delete(key string) {
 	d.ops <- diskOp{delKey: key}
 }
 
+type purged struct {
+	size int64
+	ok   bool
+}
+
+// Purge deletes key like Delete but waits for the writer to apply it, then
+// reports the bytes it was charged against the budget and whether it was on
+// disk. The writer applies deletes one at a time, so of concurrent purges of
+// one key only one sees it.
+func (d *Disk) Purge(key string) (int64, bool) {
+	done := make(chan purged, 1)
+	d.ops <- diskOp{delKey: key, delDone: done}
+	p := <-done
+	return p.size, p.ok
+}
+
+// requestEviction queues an eviction pass on the writer goroutine.
+func (d *Disk) requestEviction() {
+	select {
+	case d.ops <- diskOp{evict: true}:
+	default:
+	}
+}
+
+// EvictSomeForTest runs one eviction pass on the writer goroutine, which
+// owns the eviction state, and waits for it.
 func (d *Disk) EvictSomeForTest() {
-	d.evictSome()
+	done := make(chan struct{})
+	d.ops <- diskOp{evict: true, evictDone: done}
+	<-done
 }
 
 func (d *Disk) loadIndex() error {
@@ -210,8 +494,22 @@ func (d *Disk) writerLoop() {
 	defer runtime.UnlockOSThread()
 
 	for op := range d.ops {
+		if op.evict {
+			d.evictSome()
+			if op.evictDone != nil {
+				close(op.evictDone)
+			}
+			continue
+		}
 		if op.delKey != "" {
-			d.applyDelete(op.delKey)
+			size, ok := d.applyDelete(op.delKey)
+			if op.delDone != nil {
+				op.delDone <- purged{size: size, ok: ok}
+			}
+			continue
+		}
+		if op.touchKey != "" {
+			d.applyRefresh(op.touchKey, op.touch)
 			continue
 		}
 		if op.putKey != "" {
@@ -230,39 +528,42 @@ func (d *Disk) applyPutOrTouch(key string, ent *Entry) {
 	batch := new(leveldb.Batch)
 
 	if ent != nil {
-		b, err := encodeGob(*ent)
-		if err != nil {
-			return
-		}
-		size := int64(len(b))
+		size := EntryBudgetSize(*ent)
 		statsSize := EntryLogicalSize(*ent)
 		lastRefresh := ent.RevalidatedAt
 		if lastRefresh <= 0 && ent.StoredAt > 0 {
 			lastRefresh = ent.StoredAt * int64(time.Second)
 		}
 
-		d.mu.Lock()
-		old := d.index[key]
-		if old.Size > 0 {
-			d.totalSize -= old.Size
-		}
 		meta.Size = size
 		meta.LastAccess = now
 		meta.StatsSize = statsSize
 		meta.Inactive = ent.Inactive
 		meta.DiscoveredBy = ent.DiscoveredBy
 		meta.LastRefresh = lastRefresh
+		meta.Touch = entryTouch{}
+
+		batch.Put([]byte("e:"+key), marshalEntry(*ent))
+		mb, _ := encodeGob(meta)
+		batch.Put([]byte("m:"+key), mb)
+		if err := d.commit(batch); err != nil {
+			// Nothing was written, so the index keeps describing the
+			// previous version, if any, and readers never see a key that
+			// is not on disk.
+			d.writeErrors.Add(1)
+			return
+		}
+
+		d.mu.Lock()
+		if old := d.index[key]; old.Size > 0 {
+			d.totalSize -= old.Size
+		}
 		d.index[key] = meta
 		d.totalSize += size
 		total := d.totalSize
 		max := d.maxBytes
 		d.mu.Unlock()
 
-		batch.Put([]byte("e:"+key), b)
-		mb, _ := encodeGob(meta)
-		batch.Put([]byte("m:"+key), mb)
-		_ = d.db.Write(batch, nil)
-
 		if total > max {
 			d.evictSome()
 		}
@@ -272,27 +573,72 @@ func (d *Disk) applyPutOrTouch(key string, ent *Entry) {
 	if meta.Size == 0 {
 		return
 	}
+	// Re-read under the lock so hits counted since the op was queued are
+	// kept.
+	d.mu.Lock()
+	meta = d.index[key]
 	meta.LastAccess = now
+	d.index[key] = meta
+	d.mu.Unlock()
+	mb, _ := encodeGob(meta)
+	batch.Put([]byte("m:"+key), mb)
+	_ = d.commit(batch)
+}
+
+func (d *Disk) applyRefresh(key string, t entryTouch) {
 	d.mu.Lock()
+	meta, ok := d.index[key]
+	if !ok || meta.Size == 0 {
+		d.mu.Unlock()
+		return
+	}
+	meta.Touch = t
+	meta.LastRefresh = t.RevalidatedAt
+	if meta.LastRefresh <= 0 && t.StoredAt > 0 {
+		meta.LastRefresh = t.StoredAt * int64(time.Second)
+	}
 	d.index[key] = meta
 	d.mu.Unlock()
 	mb, _ := encodeGob(meta)
+	batch := new(leveldb.Batch)
 	batch.Put([]byte("m:"+key), mb)
-	_ = d.db.Write(batch, nil)
+	_ = d.commit(batch)
+}
+
+// commit writes batch, retrying with backoff on failure. An op that still
+// fails after diskWriteAttempts is counted as a dead letter and dropped.
+func (d *Disk) commit(batch *leveldb.Batch) error {
+	backoff := diskWriteBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = d.write(batch); err == nil {
+			return nil
+		}
+		if attempt == diskWriteAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	d.deadLetters.Add(1)
+	return err
 }
 
-func (d *Disk) applyDelete(key string) {
+func (d *Disk) applyDelete(key string) (int64, bool) {
 	batch := new(leveldb.Batch)
 	batch.Delete([]byte("e:" + key))
 	batch.Delete([]byte("m:" + key))
-	_ = d.db.Write(batch, nil)
+	_ = d.commit(batch)
 
 	d.mu.Lock()
-	if meta, ok := d.index[key]; ok {
-		d.totalSize -= meta.Size
-		delete(d.index, key)
+	defer d.mu.Unlock()
+	meta, ok := d.index[key]
+	if !ok {
+		return 0, false
 	}
-	d.mu.Unlock()
+	d.totalSize -= meta.Size
+	delete(d.index, key)
+	return meta.Size, true
 }
 
 func (d *Disk) evictSome() {
@@ -316,5 +662,16 @@ func (d *Disk) evictSome() {
 	n := max(len(items)/10, 1)
 	for i := 0; i < n && i < len(items); i++ {
 		d.applyDelete(items[i].key)
+		d.evictedBytes += items[i].m.Size
+		d.evictions.Add(1)
+	}
+
+	if d.compactAfter > 0 && d.evictedBytes >= d.compactAfter {
+		d.evictedBytes = 0
+		if err := d.db.CompactRange(util.Range{}); err != nil {
+			d.writeErrors.Add(1)
+			return
+		}
+		d.compactions.Add(1)
 	}
 }
@@ -1,9 +1,16 @@
 package cache
 
 import (
+	"errors"
+	"net/http"
 	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 func waitForDisk(t *testing.T, cond func() bool) {
@@ -30,6 +37,7 @@ func TestDisk_BasicOpsAndReopen(t *testing.T) {
 	if d.KeyCount() == 0 || d.TotalSize() == 0 {
 		t.Fatalf("expected non-empty disk index")
 	}
+
 	if _, ok := d.Peek("/a"); !ok {
 		t.Fatalf("expected Peek hit")
 	}
@@ -43,6 +51,11 @@ func TestDisk_BasicOpsAndReopen(t *testing.T) {
 	if len(d.Keys()) == 0 {
 		t.Fatalf("expected Keys")
 	}
+	var visited []string
+	d.ForEach(func(k string) bool { visited = append(visited, k); return true })
+	if len(visited) != 1 || visited[0] != "/a" {
+		t.Fatalf("ForEach visited %v", visited)
+	}
 
 	d.PutAsync("/inactive", Entry{Inactive: true})
 	waitForDisk(t, func() bool { return d.HasKey("/inactive") })
@@ -66,6 +79,164 @@ func TestDisk_BasicOpsAndReopen(t *testing.T) {
 	}
 }
 
+func TestDisk_StoresArbitraryHeaderBytes(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+
+	hdr := http.Header{"X-Bin\x00": {"\xff\xfe\r\n", ""}, "X-None": {}}
+	d.PutAsync("/odd", Entry{Status: 200, Header: hdr, Body: []byte("x")})
+	waitForDisk(t, func() bool { return d.HasKey("/odd") })
+	got, ok := d.Peek("/odd")
+	if !ok || !reflect.DeepEqual(got.Header, hdr) || d.WriteErrors() != 0 {
+		t.Fatalf("Peek = %+v, %v; writeErrors=%d", got.Header, ok, d.WriteErrors())
+	}
+}
+
+func TestDisk_FailedWriteKeepsPreviousVersion(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+
+	d.PutAsync("/k", Entry{Status: 200, Body: []byte("v1")})
+	waitForDisk(t, func() bool { return d.HasKey("/k") })
+	size := d.TotalSize()
+
+	d.write = func(*leveldb.Batch) error { return errors.New("disk gone") }
+	d.PutAsync("/k", Entry{Status: 200, Body: []byte("version two")})
+	d.PutAsync("/new", Entry{Status: 200, Body: []byte("n")})
+	waitForDisk(t, func() bool { return d.WriteErrors() == 2 })
+
+	if d.HasKey("/new") {
+		t.Fatalf("a put that never reached disk must not be indexed")
+	}
+	if got, ok := d.Peek("/k"); !ok || string(got.Body) != "v1" {
+		t.Fatalf("Peek(/k) = %q, %v; want the previous version", got.Body, ok)
+	}
+	if d.TotalSize() != size {
+		t.Fatalf("total size = %d, want %d unchanged by failed writes", d.TotalSize(), size)
+	}
+}
+
+func TestDisk_MaxEntryPercent(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 1000, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+
+	d.PutAsync("/whole", Entry{Status: 200, Body: make([]byte, 1001)})
+	if d.OversizeDrops() != 1 {
+		t.Fatalf("entry over the whole budget must be refused by default, drops=%d", d.OversizeDrops())
+	}
+
+	d.SetMaxEntryPercent(50)
+	d.PutAsync("/k", Entry{Status: 200, Body: make([]byte, 400)})
+	waitForDisk(t, func() bool { return d.HasKey("/k") })
+	d.PutAsync("/k", Entry{Status: 200, Body: make([]byte, 600)})
+	waitForDisk(t, func() bool { return !d.HasKey("/k") })
+	if d.OversizeDrops() != 2 || d.Evictions() != 0 {
+		t.Fatalf("drops=%d evictions=%d, want the oversized entry refused without evicting", d.OversizeDrops(), d.Evictions())
+	}
+}
+
+func TestDisk_RetriesTransientWriteFailure(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+
+	var calls atomic.Int32
+	write := d.write
+	d.write = func(b *leveldb.Batch) error {
+		if calls.Add(1) < diskWriteAttempts {
+			return errors.New("transient I/O error")
+		}
+		return write(b)
+	}
+
+	d.PutAsync("/flaky", Entry{Status: 200, Body: []byte("ok")})
+	waitForDisk(t, func() bool { _, ok := d.Peek("/flaky"); return ok })
+	if got := calls.Load(); got != diskWriteAttempts {
+		t.Fatalf("write attempts = %d, want %d", got, diskWriteAttempts)
+	}
+	if d.WriteErrors() != 0 || d.DeadLetters() != 0 {
+		t.Fatalf("writeErrors=%d deadLetters=%d, want 0 after a successful retry", d.WriteErrors(), d.DeadLetters())
+	}
+}
+
+func TestDisk_PersistentWriteFailureIsDeadLettered(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+
+	var calls atomic.Int32
+	d.write = func(*leveldb.Batch) error {
+		calls.Add(1)
+		return errors.New("disk gone")
+	}
+
+	d.PutAsync("/lost", Entry{Status: 200, Body: []byte("x")})
+	d.Delete("/other")
+	waitForDisk(t, func() bool { return d.DeadLetters() == 2 })
+	if got := calls.Load(); got != 2*diskWriteAttempts {
+		t.Fatalf("write attempts = %d, want %d", got, 2*diskWriteAttempts)
+	}
+	if d.WriteErrors() != 1 {
+		t.Fatalf("writeErrors = %d, want 1 for the lost put", d.WriteErrors())
+	}
+	if _, ok := d.Peek("/lost"); ok {
+		t.Fatalf("dead-lettered put must not be readable")
+	}
+}
+
+func TestDisk_MaxConcurrentReads(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+	d.SetMaxConcurrentReads(1)
+
+	d.PutAsync("/a", Entry{Status: 200, Header: http.Header{}, Body: []byte("a")})
+	waitForDisk(t, func() bool { return d.HasKey("/a") })
+
+	if !d.acquireRead() {
+		t.Fatalf("first read slot must be free")
+	}
+	if got := d.ReadsInFlight(); got != 1 {
+		t.Fatalf("ReadsInFlight = %d, want 1", got)
+	}
+	start := time.Now()
+	if _, ok := d.Peek("/a"); ok {
+		t.Fatalf("read must miss while the only slot is held")
+	}
+	if waited := time.Since(start); waited < DiskReadWait {
+		t.Fatalf("read gave up after %v, want >= %v", waited, DiskReadWait)
+	}
+
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.releaseRead()
+		close(released)
+	}()
+	if ent, ok := d.Peek("/a"); !ok || string(ent.Body) != "a" {
+		t.Fatalf("queued read = %q, %v", ent.Body, ok)
+	}
+	<-released
+	if got := d.ReadsInFlight(); got != 0 {
+		t.Fatalf("ReadsInFlight = %d, want 0", got)
+	}
+}
+
 func TestDisk_Eviction(t *testing.T) {
 	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 256, true)
 	if err != nil {
@@ -79,3 +250,178 @@ func TestDisk_Eviction(t *testing.T) {
 	waitForDisk(t, func() bool { return d.KeyCount() > 0 })
 	d.EvictSomeForTest()
 }
+
+func TestDisk_RefreshRewritesOnlyMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leveldb")
+	d, err := NewDisk(path, 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+
+	if d.Refresh("/missing", Entry{StoredAt: 5}) {
+		t.Fatalf("Refresh of an unknown key should report false")
+	}
+
+	d.PutAsync("/a", Entry{Status: 200, Body: []byte("ok"), StoredAt: 10, MaxAge: 30})
+	waitForDisk(t, func() bool { return d.HasKey("/a") })
+	raw, err := d.db.Get([]byte("e:/a"), nil)
+	if err != nil {
+		t.Fatalf("read entry: %v", err)
+	}
+
+	if !d.Refresh("/a", Entry{StoredAt: 20, RevalidatedAt: 20e9, RevalidatedBy: "warmup", MaxAge: 60}) {
+		t.Fatalf("Refresh should report the key on disk")
+	}
+	waitForDisk(t, func() bool { ent, _ := d.Peek("/a"); return ent.StoredAt == 20 })
+	ent, _ := d.Peek("/a")
+	if ent.RevalidatedBy != "warmup" || ent.MaxAge != 60 || string(ent.Body) != "ok" {
+		t.Fatalf("refreshed entry = %+v", ent)
+	}
+	if after, _ := d.db.Get([]byte("e:/a"), nil); string(after) != string(raw) {
+		t.Fatalf("Refresh rewrote the stored entry")
+	}
+
+	// An older refresh never rolls a newer entry back, and a put clears it.
+	d.PutAsync("/a", Entry{Status: 200, Body: []byte("new"), StoredAt: 30})
+	waitForDisk(t, func() bool { ent, _ := d.Peek("/a"); return string(ent.Body) == "new" })
+	if ent, _ := d.Peek("/a"); ent.StoredAt != 30 {
+		t.Fatalf("StoredAt after put = %d, want 30", ent.StoredAt)
+	}
+
+	d.Refresh("/a", Entry{StoredAt: 40})
+	waitForDisk(t, func() bool { ent, _ := d.Peek("/a"); return ent.StoredAt == 40 })
+	d.Close()
+
+	d2, err := NewDisk(path, 10*1024*1024, false)
+	if err != nil {
+		t.Fatalf("NewDisk reopen: %v", err)
+	}
+	defer d2.Close()
+	if ent, _ := d2.Peek("/a"); ent.StoredAt != 40 {
+		t.Fatalf("StoredAt after reopen = %d, want the refresh kept", ent.StoredAt)
+	}
+}
+
+func TestDisk_PurgeCountsOnce(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+
+	d.PutAsync("/a", Entry{Status: 200, Body: []byte("purge me")})
+	waitForDisk(t, func() bool { return d.HasKey("/a") })
+	want := d.TotalSize()
+
+	var wg sync.WaitGroup
+	var hits atomic.Int32
+	var freed atomic.Int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if n, ok := d.Purge("/a"); ok {
+				hits.Add(1)
+				freed.Add(n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if hits.Load() != 1 || freed.Load() != want {
+		t.Fatalf("purges seeing the key = %d freed = %d, want 1 and %d", hits.Load(), freed.Load(), want)
+	}
+	if d.HasKey("/a") || d.TotalSize() != 0 {
+		t.Fatalf("key still indexed after Purge returned: total=%d", d.TotalSize())
+	}
+	if _, ok := d.Peek("/a"); ok {
+		t.Fatalf("entry still readable after Purge returned")
+	}
+}
+
+func TestDisk_CheckFailsAfterClose(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	if err := d.Check(); err != nil {
+		t.Fatalf("Check on open disk: %v", err)
+	}
+	d.Close()
+	if err := d.Check(); err == nil {
+		t.Fatalf("Check after Close should fail")
+	}
+}
+
+func TestDisk_EvictionCompactsPastThreshold(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+	d.SetCompactAfter(700)
+
+	for i := 0; i < 20; i++ {
+		d.PutAsync(string(rune('a'+i)), Entry{Body: make([]byte, 256)})
+	}
+	waitForDisk(t, func() bool { return d.KeyCount() == 20 })
+
+	// A pass evicts a tenth of the keys: 512 bytes, then 256 more, which
+	// crosses the threshold.
+	d.requestEviction()
+	waitForDisk(t, func() bool { return d.KeyCount() == 18 })
+	if got := d.Evictions(); got != 2 {
+		t.Fatalf("Evictions = %d, want 2", got)
+	}
+	if got := d.Compactions(); got != 0 {
+		t.Fatalf("compactions after first pass = %d, want 0", got)
+	}
+	d.requestEviction()
+	waitForDisk(t, func() bool { return d.Compactions() == 1 })
+	if d.KeyCount() != 17 {
+		t.Fatalf("keys = %d, want 17", d.KeyCount())
+	}
+
+	d.requestEviction()
+	waitForDisk(t, func() bool { return d.KeyCount() == 16 })
+	if got := d.Compactions(); got != 1 {
+		t.Fatalf("compactions = %d, want the counter reset after compacting", got)
+	}
+}
+
+func TestDisk_PromotableAfterHitsInWindow(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+	d.SetPromotion(3, time.Minute)
+
+	d.PutAsync("/hot", Entry{Body: []byte("x")})
+	waitForDisk(t, func() bool { return d.HasKey("/hot") })
+
+	for i := 1; i <= 3; i++ {
+		if _, ok := d.Get("/hot"); !ok {
+			t.Fatalf("Get #%d missed", i)
+		}
+		if got, want := d.Promotable("/hot"), i >= 3; got != want {
+			t.Fatalf("Promotable after %d hits = %v, want %v", i, got, want)
+		}
+	}
+
+	// Hits from an expired window start over.
+	d.mu.Lock()
+	meta := d.index["/hot"]
+	meta.HitsFrom -= 61
+	d.index["/hot"] = meta
+	d.mu.Unlock()
+	d.Get("/hot")
+	if d.Promotable("/hot") {
+		t.Fatalf("Promotable after the window expired, want a fresh count")
+	}
+
+	d.SetPromotion(0, 0)
+	if !d.Promotable("/cold") {
+		t.Fatalf("Promotable without a threshold = false, want true")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package cache
+
+func FreeBytes(path string) (uint64, bool) {
+	return 0, false
+}
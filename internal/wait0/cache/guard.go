@@ -0,0 +1,54 @@
+package cache
+
+import "time"
+
+// FreeSpaceGuard pauses disk writes while the volume holding the disk cache
+// has less than MinFree bytes available, evicting entries until it recovers.
+type FreeSpaceGuard struct {
+	Disk    *Disk
+	MinFree uint64
+	Every   time.Duration
+	StopCh  <-chan struct{}
+	// Logger is expected to be rate-limited; low space is reported on
+	// every check.
+	Logger Logger
+
+	// freeBytes is swapped in tests; nil means FreeBytes.
+	freeBytes func(path string) (uint64, bool)
+}
+
+func (g FreeSpaceGuard) Loop() {
+	t := time.NewTicker(g.Every)
+	defer t.Stop()
+	for {
+		g.Check()
+		select {
+		case <-g.StopCh:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Check probes free space once and updates the disk pause state.
+func (g FreeSpaceGuard) Check() {
+	probe := g.freeBytes
+	if probe == nil {
+		probe = FreeBytes
+	}
+	free, ok := probe(g.Disk.path)
+	if !ok {
+		return
+	}
+	if free < g.MinFree {
+		g.Disk.paused.Store(true)
+		if g.Logger != nil {
+			g.Logger.Printf("disk free space low (%d < %d bytes), disk cache writes paused", free, g.MinFree)
+		}
+		g.Disk.requestEviction()
+		return
+	}
+	if g.Disk.paused.Swap(false) && g.Logger != nil {
+		g.Logger.Printf("disk free space recovered (%d bytes), resuming disk cache writes", free)
+	}
+}
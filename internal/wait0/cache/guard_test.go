@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFreeSpaceGuard_PausesAndResumesWrites(t *testing.T) {
+	d, err := NewDisk(filepath.Join(t.TempDir(), "leveldb"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer d.Close()
+
+	for _, k := range []string{"/a", "/b", "/c"} {
+		d.PutAsync(k, Entry{Status: 200, Body: []byte(k)})
+	}
+	waitForDisk(t, func() bool { return d.KeyCount() == 3 })
+
+	free := uint64(10)
+	logs := &fakeLogger{}
+	g := FreeSpaceGuard{
+		Disk:      d,
+		MinFree:   100,
+		Every:     time.Hour,
+		Logger:    logs,
+		freeBytes: func(string) (uint64, bool) { return free, true },
+	}
+
+	g.Check()
+	if !d.WritesPaused() {
+		t.Fatalf("expected writes paused below minFree")
+	}
+	if logs.n != 1 {
+		t.Fatalf("log lines = %d, want 1", logs.n)
+	}
+	waitForDisk(t, func() bool { return d.KeyCount() < 3 })
+
+	d.PutAsync("/new", Entry{Status: 200, Body: []byte("new")})
+	time.Sleep(20 * time.Millisecond)
+	if d.HasKey("/new") {
+		t.Fatalf("PutAsync must be a no-op while paused")
+	}
+
+	free = 1000
+	g.Check()
+	if d.WritesPaused() {
+		t.Fatalf("expected writes resumed after space recovered")
+	}
+	d.PutAsync("/new", Entry{Status: 200, Body: []byte("new")})
+	waitForDisk(t, func() bool { return d.HasKey("/new") })
+}
+
+func TestFreeBytes_TempDir(t *testing.T) {
+	if free, ok := FreeBytes(t.TempDir()); ok && free == 0 {
+		t.Fatalf("expected non-zero free bytes when probe succeeds")
+	}
+}
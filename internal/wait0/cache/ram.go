@@ -1,7 +1,10 @@
 package cache
 
 import (
+	"hash/maphash"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,10 +20,33 @@ type ramItem struct {
 	lastAccess int64
 	prev       *ramItem
 	next       *ramItem
+
+	// ramOnly items are dropped on eviction instead of spilling to disk.
+	ramOnly bool
 }
 
+// RAM is an LRU cache split into shards by key hash, each with its own lock,
+// LRU list and an equal share of the byte budget, so concurrent requests for
+// different keys rarely contend. Eviction is per shard: a full shard evicts
+// its own least recently used entries even when others have room.
 type RAM struct {
 	maxBytes int64
+	// maxEntry is the largest entry kept in RAM; see SetMaxEntryPercent.
+	maxEntry int64
+
+	seed   maphash.Seed
+	shards []*ramShard
+
+	// oversizeDrops counts entries too big for RAM that could not spill to
+	// disk either, so they were served without being cached.
+	oversizeDrops atomic.Uint64
+	// evictions counts entries pushed out to make room.
+	evictions atomic.Uint64
+}
+
+type ramShard struct {
+	// maxBytes is this shard's share of the RAM budget.
+	maxBytes int64
 
 	mu    sync.Mutex
 	items map[string]*ramItem
@@ -29,40 +55,147 @@ type RAM struct {
 	total int64
 }
 
+const (
+	// maxRAMShards caps the number of shards.
+	maxRAMShards = 16
+	// minRAMShardBytes is the smallest per-shard budget worth splitting
+	// for. Smaller budgets use fewer shards, so entries close to the whole
+	// budget still fit in one shard.
+	minRAMShardBytes = 8 << 20
+)
+
 func NewRAM(maxBytes int64) *RAM {
-	return &RAM{maxBytes: maxBytes, items: map[string]*ramItem{}}
+	return newRAM(maxBytes, ramShardCount(maxBytes))
+}
+
+// ramShardCount is the largest power of two up to maxRAMShards that leaves
+// every shard at least minRAMShardBytes. An unlimited budget gets the most.
+func ramShardCount(maxBytes int64) int {
+	if maxBytes <= 0 {
+		return maxRAMShards
+	}
+	n := 1
+	for n < maxRAMShards && maxBytes/int64(n*2) >= minRAMShardBytes {
+		n *= 2
+	}
+	return n
+}
+
+// newRAM builds a RAM cache with n shards, n a power of two.
+func newRAM(maxBytes int64, n int) *RAM {
+	c := &RAM{maxBytes: maxBytes, seed: maphash.MakeSeed(), shards: make([]*ramShard, n)}
+	for i := range c.shards {
+		c.shards[i] = &ramShard{maxBytes: maxBytes / int64(n), items: map[string]*ramItem{}}
+	}
+	c.maxEntry = c.entryCap(maxBytes)
+	return c
+}
+
+// entryCap limits an entry to what one shard can hold.
+func (c *RAM) entryCap(limit int64) int64 {
+	if shard := c.shards[0].maxBytes; shard > 0 && limit > shard {
+		return shard
+	}
+	return limit
+}
+
+func (c *RAM) shard(key string) *ramShard {
+	return c.shards[maphash.String(c.seed, key)&uint64(len(c.shards)-1)]
+}
+
+// SetMaxEntryPercent keeps entries larger than pct percent of the RAM budget
+// out of RAM, so one huge response cannot evict most of the tier. Such
+// entries go to disk as if they exceeded the whole budget. pct outside 1..99
+// caps entries at the full budget. Either way an entry is never larger than
+// one shard's budget. Call it before the cache is shared between goroutines.
+func (c *RAM) SetMaxEntryPercent(pct int) {
+	c.maxEntry = c.entryCap(entryLimit(c.maxBytes, pct))
+}
+
+// entryLimit is pct percent of budget, or budget itself when pct is outside
+// 1..99.
+func entryLimit(budget int64, pct int) int64 {
+	if pct <= 0 || pct >= 100 {
+		return budget
+	}
+	return max(budget*int64(pct)/100, 1)
 }
 
 func (c *RAM) TotalSize() int64 {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.total
+	var total int64
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		total += sh.total
+		sh.mu.Unlock()
+	}
+	return total
 }
 
 func (c *RAM) Keys() []string {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	out := make([]string, 0, len(c.items))
-	for k := range c.items {
+	var out []string
+	c.ForEach(func(k string) bool {
 		out = append(out, k)
-	}
+		return true
+	})
 	return out
 }
 
+// ForEach calls fn for every key until fn returns false. It does not
+// allocate a key slice; fn runs under a shard lock and must not call back
+// into this RAM cache.
+func (c *RAM) ForEach(fn func(key string) bool) {
+	for _, sh := range c.shards {
+		if !sh.forEach(fn) {
+			return
+		}
+	}
+}
+
+func (sh *ramShard) forEach(fn func(key string) bool) bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for k := range sh.items {
+		if !fn(k) {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *RAM) Peek(key string) (Entry, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	it, ok := c.items[key]
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	it, ok := sh.items[key]
 	if !ok {
 		return Entry{}, false
 	}
 	return it.ent, true
 }
 
+// Refresh copies the revalidation timestamps and MaxAge of from onto key's
+// entry in place, leaving body, headers, size, and LRU position alone. It
+// reports whether key was held.
+func (c *RAM) Refresh(key string, from Entry) bool {
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	it, ok := sh.items[key]
+	if !ok {
+		return false
+	}
+	it.ent.StoredAt = from.StoredAt
+	it.ent.RevalidatedAt = from.RevalidatedAt
+	it.ent.RevalidatedBy = from.RevalidatedBy
+	it.ent.MaxAge = from.MaxAge
+	return true
+}
+
 func (c *RAM) Get(key string, nowUnix int64) (Entry, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	it, ok := c.items[key]
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	it, ok := sh.items[key]
 	if !ok {
 		return Entry{}, false
 	}
@@ -70,58 +203,88 @@ func (c *RAM) Get(key string, nowUnix int64) (Entry, bool) {
 		return Entry{}, false
 	}
 	it.lastAccess = nowUnix
-	c.moveToFront(it)
+	sh.moveToFront(it)
 	return it.ent, true
 }
 
 func (c *RAM) Delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	it, ok := c.items[key]
+	c.Purge(key)
+}
+
+// Purge deletes key and reports the bytes it was charged against the budget
+// and whether it was held. Of concurrent purges of one key only one sees it.
+func (c *RAM) Purge(key string) (int64, bool) {
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	it, ok := sh.items[key]
 	if !ok {
-		return
+		return 0, false
 	}
-	c.remove(it)
-	delete(c.items, key)
-	c.total -= it.size
+	sh.remove(it)
+	delete(sh.items, key)
+	sh.total -= it.size
+	return it.size, true
 }
 
 func (c *RAM) Put(key string, ent Entry, disk *Disk, overflowLog Logger) {
-	b, err := encodeGob(ent)
-	if err != nil {
-		return
-	}
-	sz := int64(len(b))
+	c.put(key, ent, disk, overflowLog, false)
+}
+
+// PutRAMOnly stores ent like Put but never persists it: an entry too big for
+// RAM is dropped, and the entry is dropped rather than spilled when evicted.
+// disk is still used to spill other entries evicted to make room.
+func (c *RAM) PutRAMOnly(key string, ent Entry, disk *Disk, overflowLog Logger) {
+	c.put(key, ent, disk, overflowLog, true)
+}
+
+// OversizeDrops reports how many entries were larger than the RAM entry limit
+// and had no disk tier to fall back to, so they were not cached at all.
+func (c *RAM) OversizeDrops() uint64 {
+	return c.oversizeDrops.Load()
+}
+
+func (c *RAM) put(key string, ent Entry, disk *Disk, overflowLog Logger, ramOnly bool) {
+	sz := EntryBudgetSize(ent)
 	statsSize := EntryLogicalSize(ent)
 
-	if c.maxBytes > 0 && sz > c.maxBytes {
-		if disk != nil {
+	if c.maxEntry > 0 && sz > c.maxEntry {
+		// A smaller previous version must not outlive the new one.
+		c.Purge(key)
+		if disk != nil && !ramOnly {
 			disk.PutAsync(key, ent)
+			return
+		}
+		c.oversizeDrops.Add(1)
+		if overflowLog != nil {
+			overflowLog.Printf("RAM entry too big to cache (%d > %d bytes), served uncached: key=%s", sz, c.maxEntry, key)
 		}
 		return
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 	now := time.Now().Unix()
 
-	if it, ok := c.items[key]; ok {
-		c.total -= it.size
+	if it, ok := sh.items[key]; ok {
+		sh.total -= it.size
 		it.ent = ent
 		it.size = sz
 		it.statsSize = statsSize
 		it.lastAccess = now
-		c.total += sz
-		c.moveToFront(it)
+		it.ramOnly = ramOnly
+		sh.total += sz
+		sh.moveToFront(it)
 		return
 	}
 
-	for c.maxBytes > 0 && c.total+sz > c.maxBytes {
-		c.evictToDiskLocked(disk)
-		if c.tail == nil {
+	for sh.maxBytes > 0 && sh.total+sz > sh.maxBytes {
+		c.evictions.Add(sh.evictToDiskLocked(disk))
+		if sh.tail == nil {
 			break
 		}
-		if c.total+sz <= c.maxBytes {
+		if sh.total+sz <= sh.maxBytes {
 			break
 		}
 		if overflowLog != nil {
@@ -129,27 +292,73 @@ func (c *RAM) Put(key string, ent Entry, disk *Disk, overflowLog Logger) {
 		}
 	}
 
-	it := &ramItem{key: key, ent: ent, size: sz, statsSize: statsSize, lastAccess: now}
-	c.items[key] = it
-	c.addToFront(it)
-	c.total += sz
+	it := &ramItem{key: key, ent: ent, size: sz, statsSize: statsSize, lastAccess: now, ramOnly: ramOnly}
+	sh.items[key] = it
+	sh.addToFront(it)
+	sh.total += sz
+}
+
+// FlushTo queues entries that are not RAM-only and not already on disk for a
+// disk write, most recently used first, stopping once deadline passes. It
+// reports how many entries were queued and whether every one was.
+func (c *RAM) FlushTo(disk *Disk, deadline time.Time) (int, bool) {
+	type pending struct {
+		key        string
+		ent        Entry
+		lastAccess int64
+	}
+	var out []pending
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		for it := sh.head; it != nil; it = it.next {
+			if !it.ramOnly {
+				out = append(out, pending{key: it.key, ent: it.ent, lastAccess: it.lastAccess})
+			}
+		}
+		sh.mu.Unlock()
+	}
+	// Each shard is already most recently used first; a stable sort merges
+	// them without reordering entries touched within the same second.
+	sort.SliceStable(out, func(i, j int) bool { return out[i].lastAccess > out[j].lastAccess })
+
+	n := 0
+	for _, p := range out {
+		if time.Now().After(deadline) {
+			return n, false
+		}
+		if disk.HasKey(p.key) {
+			continue
+		}
+		disk.PutAsync(p.key, p.ent)
+		n++
+	}
+	return n, true
 }
 
 func (c *RAM) SnapshotAccessTimes() map[string]int64 {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	out := make(map[string]int64, len(c.items))
-	for k, it := range c.items {
-		out[k] = it.lastAccess
+	out := map[string]int64{}
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		for k, it := range sh.items {
+			out[k] = it.lastAccess
+		}
+		sh.mu.Unlock()
 	}
 	return out
 }
 
 func (c *RAM) MetaSnapshot() map[string]EntryMeta {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	out := make(map[string]EntryMeta, len(c.items))
-	for k, it := range c.items {
+	out := map[string]EntryMeta{}
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		sh.metaSnapshotLocked(out)
+		sh.mu.Unlock()
+	}
+	return out
+}
+
+func (sh *ramShard) metaSnapshotLocked(out map[string]EntryMeta) {
+	for k, it := range sh.items {
 		lastRefresh := it.ent.RevalidatedAt
 		if lastRefresh <= 0 && it.ent.StoredAt > 0 {
 			lastRefresh = it.ent.StoredAt * int64(time.Second)
@@ -162,13 +371,13 @@ func (c *RAM) MetaSnapshot() map[string]EntryMeta {
 			StoredAtUnix:        it.ent.StoredAt,
 		}
 	}
-	return out
 }
 
 func (c *RAM) SetLastAccessForTest(key string, ts int64) bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	it, ok := c.items[key]
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	it, ok := sh.items[key]
 	if !ok {
 		return false
 	}
@@ -176,56 +385,66 @@ func (c *RAM) SetLastAccessForTest(key string, ts int64) bool {
 	return true
 }
 
-func (c *RAM) evictToDiskLocked(disk *Disk) {
-	count := len(c.items)
+// evictToDiskLocked evicts the least recently used tenth of the shard,
+// spilling entries that are not RAM-only to disk, and reports how many it
+// evicted.
+func (sh *ramShard) evictToDiskLocked(disk *Disk) uint64 {
+	count := len(sh.items)
 	if count == 0 {
-		return
+		return 0
 	}
-	n := max(count/10, 1)
-	for range n {
-		it := c.tail
+	var evicted uint64
+	for range max(count/10, 1) {
+		it := sh.tail
 		if it == nil {
-			return
+			break
 		}
-		if disk != nil {
+		if disk != nil && !it.ramOnly {
 			disk.PutAsync(it.key, it.ent)
 		}
-		c.remove(it)
-		delete(c.items, it.key)
-		c.total -= it.size
+		sh.remove(it)
+		delete(sh.items, it.key)
+		sh.total -= it.size
+		evicted++
 	}
+	return evicted
+}
+
+// Evictions reports how many entries were evicted to stay within budget.
+func (c *RAM) Evictions() uint64 {
+	return c.evictions.Load()
 }
 
-func (c *RAM) addToFront(it *ramItem) {
+func (sh *ramShard) addToFront(it *ramItem) {
 	it.prev = nil
-	it.next = c.head
-	if c.head != nil {
-		c.head.prev = it
+	it.next = sh.head
+	if sh.head != nil {
+		sh.head.prev = it
 	}
-	c.head = it
-	if c.tail == nil {
-		c.tail = it
+	sh.head = it
+	if sh.tail == nil {
+		sh.tail = it
 	}
 }
 
-func (c *RAM) remove(it *ramItem) {
+func (sh *ramShard) remove(it *ramItem) {
 	if it.prev != nil {
 		it.prev.next = it.next
 	} else {
-		c.head = it.next
+		sh.head = it.next
 	}
 	if it.next != nil {
 		it.next.prev = it.prev
 	} else {
-		c.tail = it.prev
+		sh.tail = it.prev
 	}
 	it.prev, it.next = nil, nil
 }
 
-func (c *RAM) moveToFront(it *ramItem) {
-	if c.head == it {
+func (sh *ramShard) moveToFront(it *ramItem) {
+	if sh.head == it {
 		return
 	}
-	c.remove(it)
-	c.addToFront(it)
+	sh.remove(it)
+	sh.addToFront(it)
 }
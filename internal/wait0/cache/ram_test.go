@@ -1,7 +1,11 @@
 package cache
 
 import (
+	"fmt"
+	"net/http"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -46,6 +50,74 @@ func TestRAM_BasicOps(t *testing.T) {
 	if _, ok := ram.Peek("/a"); ok {
 		t.Fatalf("expected delete")
 	}
+
+	ram.Put("/b", ent, nil, nil)
+	want := ram.TotalSize()
+	if n, ok := ram.Purge("/b"); !ok || n != want || ram.TotalSize() != 0 {
+		t.Fatalf("Purge = %d ok=%v total=%d, want %d true 0", n, ok, ram.TotalSize(), want)
+	}
+	if _, ok := ram.Purge("/b"); ok {
+		t.Fatalf("second Purge must miss")
+	}
+}
+
+func TestRAM_RefreshUpdatesTimestampsOnly(t *testing.T) {
+	ram := NewRAM(1024)
+	if ram.Refresh("/missing", Entry{StoredAt: 5}) {
+		t.Fatalf("expected Refresh false for missing key")
+	}
+	ram.Put("/a", Entry{Status: 200, Body: []byte("ok"), StoredAt: 1, ETag: `"e"`}, nil, nil)
+	size := ram.TotalSize()
+	if !ram.Refresh("/a", Entry{Body: []byte("ignored"), StoredAt: 5, RevalidatedAt: 6, RevalidatedBy: "warmup", MaxAge: 60}) {
+		t.Fatalf("expected Refresh true")
+	}
+	got, _ := ram.Peek("/a")
+	if string(got.Body) != "ok" || got.ETag != `"e"` || ram.TotalSize() != size {
+		t.Fatalf("Refresh changed body or size: %+v size=%d", got, ram.TotalSize())
+	}
+	if got.StoredAt != 5 || got.RevalidatedAt != 6 || got.RevalidatedBy != "warmup" || got.MaxAge != 60 {
+		t.Fatalf("Refresh did not copy timestamps: %+v", got)
+	}
+}
+
+func TestRAM_ForEach(t *testing.T) {
+	ram := NewRAM(1024)
+	ram.Put("/a", Entry{Body: []byte("a")}, nil, nil)
+	ram.Put("/b", Entry{Body: []byte("b")}, nil, nil)
+
+	seen := map[string]bool{}
+	ram.ForEach(func(k string) bool { seen[k] = true; return true })
+	if len(seen) != 2 || !seen["/a"] || !seen["/b"] {
+		t.Fatalf("seen = %v", seen)
+	}
+
+	calls := 0
+	ram.ForEach(func(string) bool { calls++; return false })
+	if calls != 1 {
+		t.Fatalf("ForEach should stop early, calls = %d", calls)
+	}
+}
+
+func TestRAM_BudgetCountsBodyPlusCappedHeaders(t *testing.T) {
+	ram := NewRAM(10 * 1024)
+	small := Entry{Header: http.Header{"X-A": {"1"}}, Body: []byte("body")}
+	ram.Put("/small", small, nil, nil)
+	if got, want := ram.TotalSize(), int64(len("body")+len("X-A")+len("1")); got != want {
+		t.Fatalf("total = %d, want %d", got, want)
+	}
+
+	heavy := Entry{Header: http.Header{"Set-Cookie": {strings.Repeat("c", 8*1024)}}, Body: []byte("xy")}
+	ram.Put("/heavy", heavy, nil, nil)
+	if _, ok := ram.Peek("/heavy"); !ok {
+		t.Fatalf("header-heavy entry should fit: only %d header bytes count", HeaderBudgetAllowance)
+	}
+	if got, want := EntryBudgetSize(heavy), int64(2+HeaderBudgetAllowance); got != want {
+		t.Fatalf("EntryBudgetSize = %d, want %d", got, want)
+	}
+	meta := ram.MetaSnapshot()["/heavy"]
+	if meta.Size != EntryLogicalSize(heavy) {
+		t.Fatalf("stats size = %d, want full logical size %d", meta.Size, EntryLogicalSize(heavy))
+	}
 }
 
 func TestRAM_InactiveAndOversizePaths(t *testing.T) {
@@ -88,3 +160,181 @@ func TestRAM_EvictsToDisk(t *testing.T) {
 	}
 	_ = ram.Keys()
 }
+
+func TestRAM_PutRAMOnlyNeverSpills(t *testing.T) {
+	disk, err := NewDisk(filepath.Join(t.TempDir(), "disk"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer disk.Close()
+
+	ram := NewRAM(80)
+	ram.PutRAMOnly("/big", Entry{Body: make([]byte, 1024)}, disk, nil)
+	if _, ok := ram.Peek("/big"); ok {
+		t.Fatalf("oversize RAM-only entry should be dropped")
+	}
+	if ram.OversizeDrops() != 1 {
+		t.Fatalf("OversizeDrops=%d want 1", ram.OversizeDrops())
+	}
+
+	ram.PutRAMOnly("/hot", Entry{Body: make([]byte, 30)}, disk, nil)
+	for i := 0; i < 8; i++ {
+		ram.Put(string(rune('a'+i)), Entry{Body: make([]byte, 30)}, disk, nil)
+	}
+	waitForRAM(t, func() bool { return disk.KeyCount() > 0 })
+	if _, ok := ram.Peek("/hot"); ok {
+		t.Fatalf("expected RAM-only entry to be evicted")
+	}
+	if ram.Evictions() == 0 {
+		t.Fatalf("Evictions = 0 after overflowing the budget")
+	}
+	if disk.HasKey("/hot") || disk.HasKey("/big") {
+		t.Fatalf("RAM-only entries must never reach disk")
+	}
+}
+
+func TestRAM_OversizeWithoutDiskIsCounted(t *testing.T) {
+	log := &fakeLogger{}
+	ram := NewRAM(16)
+	ram.Put("/nodisk", Entry{Body: make([]byte, 1024)}, nil, log)
+	ram.PutRAMOnly("/ramonly", Entry{Body: make([]byte, 1024)}, nil, log)
+	if _, ok := ram.Peek("/nodisk"); ok {
+		t.Fatalf("oversize entry should skip RAM")
+	}
+	if _, ok := ram.Peek("/ramonly"); ok {
+		t.Fatalf("oversize RAM-only entry should skip RAM")
+	}
+	if got := ram.OversizeDrops(); got != 2 {
+		t.Fatalf("OversizeDrops=%d want 2", got)
+	}
+	if log.n != 2 {
+		t.Fatalf("expected one log line per drop, got %d", log.n)
+	}
+
+	ram.Put("/small", Entry{Body: []byte("a")}, nil, log)
+	if got := ram.OversizeDrops(); got != 2 {
+		t.Fatalf("fitting entry must not count as a drop, got %d", got)
+	}
+}
+
+func TestRAM_MaxEntryPercent(t *testing.T) {
+	ram := NewRAM(1000)
+	ram.SetMaxEntryPercent(25)
+	ram.Put("/a", Entry{Body: make([]byte, 200)}, nil, nil)
+	if _, ok := ram.Peek("/a"); !ok {
+		t.Fatalf("entry under the limit should be cached")
+	}
+
+	ram.Put("/a", Entry{Body: make([]byte, 300)}, nil, nil)
+	if _, ok := ram.Peek("/a"); ok {
+		t.Fatalf("entry over 25%% of the budget must not be cached, nor leave the old version behind")
+	}
+	if ram.TotalSize() != 0 || ram.OversizeDrops() != 1 {
+		t.Fatalf("total=%d drops=%d", ram.TotalSize(), ram.OversizeDrops())
+	}
+
+	ram.SetMaxEntryPercent(0)
+	ram.Put("/a", Entry{Body: make([]byte, 300)}, nil, nil)
+	if _, ok := ram.Peek("/a"); !ok {
+		t.Fatalf("percent 0 should allow entries up to the whole budget")
+	}
+}
+
+func TestRAM_FlushToSkipsRAMOnlyAndPersisted(t *testing.T) {
+	disk, err := NewDisk(filepath.Join(t.TempDir(), "disk"), 10*1024*1024, true)
+	if err != nil {
+		t.Fatalf("NewDisk: %v", err)
+	}
+	defer disk.Close()
+
+	ram := NewRAM(1024)
+	ram.Put("/hot", Entry{Status: 200, Body: []byte("hot")}, nil, nil)
+	ram.Put("/stored", Entry{Status: 200, Body: []byte("stored")}, nil, nil)
+	ram.PutRAMOnly("/volatile", Entry{Status: 200, Body: []byte("v")}, nil, nil)
+	disk.PutAsync("/stored", Entry{Status: 200, Body: []byte("stored")})
+	waitForRAM(t, func() bool { return disk.HasKey("/stored") })
+
+	n, complete := ram.FlushTo(disk, time.Now().Add(time.Second))
+	if n != 1 || !complete {
+		t.Fatalf("FlushTo = %d, %v; want 1, true", n, complete)
+	}
+	waitForRAM(t, func() bool { return disk.HasKey("/hot") })
+	if disk.HasKey("/volatile") {
+		t.Fatalf("RAM-only entry must not be flushed")
+	}
+
+	ram.Put("/late", Entry{Status: 200, Body: []byte("late")}, nil, nil)
+	if n, complete := ram.FlushTo(disk, time.Now().Add(-time.Second)); n != 0 || complete {
+		t.Fatalf("expired FlushTo = %d, %v; want 0, false", n, complete)
+	}
+}
+
+func TestRAM_ShardCount(t *testing.T) {
+	cases := []struct {
+		max  int64
+		want int
+	}{
+		{max: 0, want: maxRAMShards},
+		{max: 1024, want: 1},
+		{max: 2*minRAMShardBytes - 1, want: 1},
+		{max: 2 * minRAMShardBytes, want: 2},
+		{max: 6 * minRAMShardBytes, want: 4},
+		{max: 1 << 40, want: maxRAMShards},
+	}
+	for _, tc := range cases {
+		if got := ramShardCount(tc.max); got != tc.want {
+			t.Fatalf("ramShardCount(%d) = %d, want %d", tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestRAM_ShardedBudgetAndAggregates(t *testing.T) {
+	ram := newRAM(1600, 4)
+	for i := 0; i < 64; i++ {
+		ram.Put(fmt.Sprintf("/p%d", i), Entry{Body: make([]byte, 50)}, nil, nil)
+	}
+	var total int64
+	keys := 0
+	for _, sh := range ram.shards {
+		if sh.total > sh.maxBytes {
+			t.Fatalf("shard total %d over its budget %d", sh.total, sh.maxBytes)
+		}
+		total += sh.total
+		keys += len(sh.items)
+	}
+	if ram.TotalSize() != total || len(ram.Keys()) != keys || len(ram.MetaSnapshot()) != keys {
+		t.Fatalf("TotalSize=%d Keys=%d, want the sums %d and %d", ram.TotalSize(), len(ram.Keys()), total, keys)
+	}
+	if ram.Evictions() == 0 || total > 1600 {
+		t.Fatalf("evictions=%d total=%d, want shards to evict within the budget", ram.Evictions(), total)
+	}
+
+	ram.Put("/big", Entry{Body: make([]byte, 500)}, nil, nil)
+	if _, ok := ram.Peek("/big"); ok || ram.OversizeDrops() != 1 {
+		t.Fatalf("an entry over one shard's budget must not be cached, drops=%d", ram.OversizeDrops())
+	}
+}
+
+func BenchmarkRAM_ParallelGet(b *testing.B) {
+	const keys = 1024
+	for _, shards := range []int{1, maxRAMShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			ram := newRAM(1<<30, shards)
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = fmt.Sprintf("/page/%d", i)
+				ram.Put(names[i], Entry{Status: 200, Body: []byte("ok")}, nil, nil)
+			}
+			var start atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Stagger goroutines so they do not walk the keys in lockstep.
+				i := int(start.Add(keys / 8))
+				for pb.Next() {
+					ram.Get(names[i%keys], 1)
+					i++
+				}
+			})
+		})
+	}
+}
@@ -14,6 +14,15 @@ type Entry struct {
 	DiscoveredBy  string
 	RevalidatedAt int64
 	RevalidatedBy string
+
+	// MaxAge is the origin freshness lifetime in seconds left at StoredAt,
+	// net of any upstream Age (see freshness.FromHeader).
+	MaxAge int64
+	// ETag is the origin's validator, sent as If-None-Match on revalidation.
+	ETag string
+	// LastModified is the origin's validator, sent as If-Modified-Since on
+	// revalidation.
+	LastModified string
 }
 
 type EntryMeta struct {
@@ -31,6 +40,17 @@ type EntryMeta struct {
 	StoredAtUnix int64
 }
 
+// HeaderBudgetAllowance caps how many header bytes count toward an entry's
+// RAM/disk budget size, so storage.*.max tracks payload bytes.
+const HeaderBudgetAllowance = 1024
+
+// EntryBudgetSize is the size charged against the cache byte budget: the body
+// plus header bytes up to HeaderBudgetAllowance.
+func EntryBudgetSize(ent Entry) int64 {
+	hdr := EntryLogicalSize(ent) - int64(len(ent.Body))
+	return int64(len(ent.Body)) + min(hdr, HeaderBudgetAllowance)
+}
+
 func EntryLogicalSize(ent Entry) int64 {
 	total := int64(len(ent.Body))
 	for k, vals := range ent.Header {
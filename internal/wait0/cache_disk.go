@@ -26,22 +26,70 @@ func (d *diskCache) MetaSnapshot() map[string]cache.EntryMeta {
 	return d.inner.MetaSnapshot()
 }
 
+func (d *diskCache) Check() error {
+	return d.inner.Check()
+}
+
 func (d *diskCache) TotalSize() int64 {
 	return d.inner.TotalSize()
 }
 
+func (d *diskCache) ReadsInFlight() int64 {
+	return d.inner.ReadsInFlight()
+}
+
+func (d *diskCache) PendingOps() int {
+	return d.inner.PendingOps()
+}
+
 func (d *diskCache) KeyCount() int {
 	return d.inner.KeyCount()
 }
 
+func (d *diskCache) WritesPaused() bool {
+	return d.inner.WritesPaused()
+}
+
+func (d *diskCache) Evictions() uint64 {
+	return d.inner.Evictions()
+}
+
+func (d *diskCache) Compactions() uint64 {
+	return d.inner.Compactions()
+}
+
+func (d *diskCache) OversizeDrops() uint64 {
+	return d.inner.OversizeDrops()
+}
+
+func (d *diskCache) WriteErrors() uint64 {
+	return d.inner.WriteErrors()
+}
+
+func (d *diskCache) DeadLetters() uint64 {
+	return d.inner.DeadLetters()
+}
+
+func (d *diskCache) Promotable(key string) bool {
+	return d.inner.Promotable(key)
+}
+
 func (d *diskCache) HasKey(key string) bool {
 	return d.inner.HasKey(key)
 }
 
+func (d *diskCache) Purge(key string) (int64, bool) {
+	return d.inner.Purge(key)
+}
+
 func (d *diskCache) Keys() []string {
 	return d.inner.Keys()
 }
 
+func (d *diskCache) ForEach(fn func(key string) bool) {
+	d.inner.ForEach(fn)
+}
+
 func (d *diskCache) Peek(key string) (CacheEntry, bool) {
 	ent, ok := d.inner.Peek(key)
 	if !ok {
@@ -62,6 +110,10 @@ func (d *diskCache) PutAsync(key string, ent CacheEntry) {
 	d.inner.PutAsync(key, fromWait0Entry(ent))
 }
 
+func (d *diskCache) Refresh(key string, from CacheEntry) bool {
+	return d.inner.Refresh(key, fromWait0Entry(from))
+}
+
 func (d *diskCache) Delete(key string) {
 	d.inner.Delete(key)
 }
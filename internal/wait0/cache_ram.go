@@ -1,6 +1,10 @@
 package wait0
 
-import "wait0/internal/wait0/cache"
+import (
+	"time"
+
+	"wait0/internal/wait0/cache"
+)
 
 type ramCache struct {
 	inner *cache.RAM
@@ -18,6 +22,10 @@ func (c *ramCache) Keys() []string {
 	return c.inner.Keys()
 }
 
+func (c *ramCache) ForEach(fn func(key string) bool) {
+	c.inner.ForEach(fn)
+}
+
 func (c *ramCache) Peek(key string) (CacheEntry, bool) {
 	ent, ok := c.inner.Peek(key)
 	if !ok {
@@ -34,6 +42,14 @@ func (c *ramCache) Get(key string, nowUnix int64) (CacheEntry, bool) {
 	return toWait0Entry(ent), true
 }
 
+func (c *ramCache) Refresh(key string, from CacheEntry) bool {
+	return c.inner.Refresh(key, fromWait0Entry(from))
+}
+
+func (c *ramCache) Purge(key string) (int64, bool) {
+	return c.inner.Purge(key)
+}
+
 func (c *ramCache) Delete(key string) {
 	c.inner.Delete(key)
 }
@@ -46,6 +62,26 @@ func (c *ramCache) Put(key string, ent CacheEntry, disk *diskCache, overflowLog
 	c.inner.Put(key, fromWait0Entry(ent), d, overflowLog)
 }
 
+func (c *ramCache) PutRAMOnly(key string, ent CacheEntry, disk *diskCache, overflowLog cache.Logger) {
+	var d *cache.Disk
+	if disk != nil {
+		d = disk.inner
+	}
+	c.inner.PutRAMOnly(key, fromWait0Entry(ent), d, overflowLog)
+}
+
+func (c *ramCache) FlushTo(disk *diskCache, deadline time.Time) (int, bool) {
+	return c.inner.FlushTo(disk.inner, deadline)
+}
+
+func (c *ramCache) Evictions() uint64 {
+	return c.inner.Evictions()
+}
+
+func (c *ramCache) OversizeDrops() uint64 {
+	return c.inner.OversizeDrops()
+}
+
 func (c *ramCache) SnapshotAccessTimes() map[string]int64 {
 	return c.inner.SnapshotAccessTimes()
 }
@@ -69,6 +105,9 @@ func toWait0Entry(ent cache.Entry) CacheEntry {
 		DiscoveredBy:  ent.DiscoveredBy,
 		RevalidatedAt: ent.RevalidatedAt,
 		RevalidatedBy: ent.RevalidatedBy,
+		MaxAge:        ent.MaxAge,
+		ETag:          ent.ETag,
+		LastModified:  ent.LastModified,
 	}
 }
 
@@ -83,5 +122,8 @@ func fromWait0Entry(ent CacheEntry) cache.Entry {
 		DiscoveredBy:  ent.DiscoveredBy,
 		RevalidatedAt: ent.RevalidatedAt,
 		RevalidatedBy: ent.RevalidatedBy,
+		MaxAge:        ent.MaxAge,
+		ETag:          ent.ETag,
+		LastModified:  ent.LastModified,
 	}
 }
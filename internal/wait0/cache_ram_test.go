@@ -12,7 +12,7 @@ import (
 func TestRAMCache_BasicOperations(t *testing.T) {
 	c := newRAMCache(1024)
 	ent := CacheEntry{Status: 200, Header: make(http.Header), Body: []byte("a")}
-	c.Put("/a", ent, nil, wstats.NewRateLimitedLogger(time.Hour))
+	c.Put("/a", ent, nil, wstats.NewRateLimitedLogger(time.Hour, nil))
 
 	if c.TotalSize() == 0 {
 		t.Fatalf("expected non-zero total size")
@@ -41,7 +41,7 @@ func TestRAMCache_EvictToDisk(t *testing.T) {
 	defer disk.close()
 
 	c := newRAMCache(200)
-	logr := wstats.NewRateLimitedLogger(time.Hour)
+	logr := wstats.NewRateLimitedLogger(time.Hour, nil)
 	for i := 0; i < 10; i++ {
 		k := string(rune('a' + i))
 		c.Put(k, CacheEntry{Status: 200, Header: make(http.Header), Body: []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")}, disk, logr)
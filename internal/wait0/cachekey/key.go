@@ -0,0 +1,213 @@
+package cachekey
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Key layout: <path>[#<vary>], where vary is the URL-encoded set of request
+// header values the entry varies on, plus the normalized query under
+// queryParam, the host component under hostParam, the key version under
+// versionParam, a request method other than GET under methodParam and the
+// request body hash of a cached POST under bodyParam when set. A key without
+// variants is the bare path, so plain keys stay compatible with path-based
+// lookups, unless the path itself contains '#': then the separator is always
+// written, so Parse, which splits on the last '#', never takes part of the
+// path for vary values.
+const varySep = "#"
+
+// hostParam, queryParam, versionParam, methodParam and bodyParam cannot
+// collide with canonical header names.
+const (
+	hostParam    = "@host"
+	queryParam   = "@q"
+	versionParam = "@v"
+	methodParam  = "@m"
+	bodyParam    = "@b"
+)
+
+type Parts struct {
+	Path string
+	// Query is the normalized request query string, if any.
+	Query string
+	// Host is the component extracted from the request host, if any.
+	Host string
+	// Version is the storage.keyVersion the key was built under, if any.
+	Version string
+	// Method is the request method for entries other than GET responses,
+	// such as cached OPTIONS preflights; empty means GET.
+	Method string
+	// Body is the hex SHA-256 of the request body of a cached POST.
+	Body string
+	// Vary maps canonical request header names to the values the key varies on.
+	Vary url.Values
+}
+
+// String encodes the parts as a cache key.
+func (p Parts) String() string {
+	if len(p.Vary) == 0 && p.Query == "" && p.Host == "" && p.Version == "" && p.Method == "" && p.Body == "" {
+		if strings.Contains(p.Path, varySep) {
+			return p.Path + varySep
+		}
+		return p.Path
+	}
+	vals := make(url.Values, len(p.Vary)+5)
+	for k, v := range p.Vary {
+		vals[k] = v
+	}
+	if p.Query != "" {
+		vals.Set(queryParam, p.Query)
+	}
+	if p.Host != "" {
+		vals.Set(hostParam, p.Host)
+	}
+	if p.Version != "" {
+		vals.Set(versionParam, p.Version)
+	}
+	if p.Method != "" {
+		vals.Set(methodParam, p.Method)
+	}
+	if p.Body != "" {
+		vals.Set(bodyParam, p.Body)
+	}
+	return p.Path + varySep + vals.Encode()
+}
+
+// Parse splits a cache key into its parts.
+func Parse(key string) Parts {
+	i := strings.LastIndex(key, varySep)
+	if i < 0 {
+		return Parts{Path: key}
+	}
+	if i == len(key)-len(varySep) {
+		// A path containing '#' with no variants.
+		return Parts{Path: key[:i]}
+	}
+	vary, err := url.ParseQuery(key[i+len(varySep):])
+	if err != nil || len(vary) == 0 {
+		return Parts{Path: key}
+	}
+	query := vary.Get(queryParam)
+	host := vary.Get(hostParam)
+	version := vary.Get(versionParam)
+	method := vary.Get(methodParam)
+	body := vary.Get(bodyParam)
+	vary.Del(queryParam)
+	vary.Del(hostParam)
+	vary.Del(versionParam)
+	vary.Del(methodParam)
+	vary.Del(bodyParam)
+	if len(vary) == 0 {
+		vary = nil
+	}
+	return Parts{Path: key[:i], Query: query, Host: host, Version: version, Method: method, Body: body, Vary: vary}
+}
+
+// Path returns the request path a cache key was built from.
+func Path(key string) string {
+	return Parse(key).Path
+}
+
+// NormalizeQuery returns raw with its parameters sorted by name, so the same
+// parameters in a different order share a key. Values keep their order. A
+// query that does not parse is returned unchanged.
+func NormalizeQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	vals, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	return vals.Encode()
+}
+
+// FilterQuery keeps only the parameters named in allow and returns them
+// normalized like NormalizeQuery. A query that does not parse keeps the
+// parameters that do.
+func FilterQuery(raw string, allow []string) string {
+	if raw == "" {
+		return ""
+	}
+	vals, _ := url.ParseQuery(raw)
+	out := make(url.Values, len(allow))
+	for _, name := range allow {
+		if v, ok := vals[name]; ok {
+			out[name] = v
+		}
+	}
+	return out.Encode()
+}
+
+// VaryValues collects the values of the named request headers. Headers absent
+// from the request are omitted, so requests without them share the plain key.
+func VaryValues(h http.Header, names []string) url.Values {
+	if len(names) == 0 {
+		return nil
+	}
+	var out url.Values
+	for _, name := range names {
+		vals := h.Values(name)
+		if len(vals) == 0 {
+			continue
+		}
+		if out == nil {
+			out = make(url.Values, len(names))
+		}
+		out.Set(http.CanonicalHeaderKey(name), strings.Join(vals, ","))
+	}
+	return out
+}
+
+// ResponseVary returns the request headers named by a response's Vary header,
+// canonicalized and deduplicated, and whether it is "*", which no cached
+// variant can satisfy. Accept-Encoding is left out: wait0 asks origin for its
+// own encoding and decodes per client, so the stored body never depends on
+// the client's value.
+func ResponseVary(h http.Header) (names []string, any bool) {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			switch {
+			case name == "":
+			case name == "*":
+				return nil, true
+			default:
+				name = http.CanonicalHeaderKey(name)
+				if name != "Accept-Encoding" && !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	return names, false
+}
+
+// WithVary folds h's values for names into key, keeping what key already
+// holds. Headers h lacks are left out, as in VaryValues.
+func WithVary(key string, h http.Header, names []string) string {
+	extra := VaryValues(h, names)
+	if len(extra) == 0 {
+		return key
+	}
+	p := Parse(key)
+	if p.Vary == nil {
+		p.Vary = make(url.Values, len(extra))
+	}
+	for name, vals := range extra {
+		p.Vary[name] = vals
+	}
+	return p.String()
+}
+
+// ApplyVary sets the header values recorded in a key on an outgoing request.
+func ApplyVary(h http.Header, vary url.Values) {
+	for name, vals := range vary {
+		if len(vals) == 0 {
+			continue
+		}
+		h.Set(name, vals[0])
+	}
+}
@@ -0,0 +1,198 @@
+package cachekey
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestParts_RoundTrip(t *testing.T) {
+	h := http.Header{}
+	h.Set("Accept", "application/json")
+	p := Parts{Path: "/api/items", Vary: VaryValues(h, []string{"accept", "Accept-Language"})}
+
+	key := p.String()
+	if key != "/api/items#Accept=application%2Fjson" {
+		t.Fatalf("key = %q", key)
+	}
+	got := Parse(key)
+	if got.Path != "/api/items" || got.Vary.Get("Accept") != "application/json" {
+		t.Fatalf("Parse = %+v", got)
+	}
+	if Path(key) != "/api/items" {
+		t.Fatalf("Path = %q", Path(key))
+	}
+}
+
+func TestParts_PlainKey(t *testing.T) {
+	if got := (Parts{Path: "/a"}).String(); got != "/a" {
+		t.Fatalf("plain key = %q", got)
+	}
+	if got := VaryValues(http.Header{}, []string{"Accept"}); got != nil {
+		t.Fatalf("missing headers should not vary, got %v", got)
+	}
+	if got := Parse("/a"); got.Path != "/a" || got.Vary != nil {
+		t.Fatalf("Parse plain = %+v", got)
+	}
+}
+
+func TestParts_PathWithHashRoundTrip(t *testing.T) {
+	// "/a%23b" decodes to "/a#b"; its key must not parse as path "/a"
+	// varying on "b", or revalidation would fetch "/a" into it.
+	for _, path := range []string{"/a#b", "/a#b=c", "/a#", "/#/#"} {
+		for _, p := range []Parts{
+			{Path: path},
+			{Path: path, Query: "x=1"},
+			{Path: path, Vary: url.Values{"Accept": {"text/html"}}},
+		} {
+			key := p.String()
+			if got := Parse(key); got.Path != path || got.Query != p.Query || got.Vary.Get("Accept") != p.Vary.Get("Accept") || len(got.Vary) != len(p.Vary) {
+				t.Fatalf("Parse(%q) = %+v, want %+v", key, got, p)
+			}
+		}
+	}
+}
+
+func TestParts_HostRoundTrip(t *testing.T) {
+	key := Parts{Path: "/a", Host: "acme"}.String()
+	if key != "/a#%40host=acme" {
+		t.Fatalf("host key = %q", key)
+	}
+	got := Parse(key)
+	if got.Path != "/a" || got.Host != "acme" || got.Vary != nil {
+		t.Fatalf("Parse host key = %+v", got)
+	}
+
+	h := http.Header{}
+	h.Set("Accept", "text/html")
+	key = Parts{Path: "/a", Host: "acme", Vary: VaryValues(h, []string{"Accept"})}.String()
+	got = Parse(key)
+	if got.Host != "acme" || got.Vary.Get("Accept") != "text/html" || len(got.Vary) != 1 {
+		t.Fatalf("Parse host+vary key = %+v", got)
+	}
+}
+
+func TestParts_VersionRoundTrip(t *testing.T) {
+	key := Parts{Path: "/a", Version: "7"}.String()
+	if key != "/a#%40v=7" {
+		t.Fatalf("version key = %q", key)
+	}
+	got := Parse(key)
+	if got.Path != "/a" || got.Version != "7" || got.Host != "" || got.Vary != nil {
+		t.Fatalf("Parse version key = %+v", got)
+	}
+
+	got = Parse(Parts{Path: "/a", Host: "acme", Version: "7"}.String())
+	if got.Host != "acme" || got.Version != "7" || got.Vary != nil {
+		t.Fatalf("Parse host+version key = %+v", got)
+	}
+	if Parse("/a").Version != "" {
+		t.Fatalf("plain key must have no version")
+	}
+}
+
+func TestParts_MethodRoundTrip(t *testing.T) {
+	key := Parts{Path: "/api", Method: http.MethodOptions, Vary: url.Values{"Origin": {"https://a.example"}}}.String()
+	got := Parse(key)
+	if got.Path != "/api" || got.Method != http.MethodOptions || got.Vary.Get("Origin") != "https://a.example" {
+		t.Fatalf("Parse method key = %+v", got)
+	}
+	if got.Vary.Has(methodParam) {
+		t.Fatalf("method must not leak into Vary: %v", got.Vary)
+	}
+	if Parse("/api").Method != "" {
+		t.Fatalf("plain key must have no method")
+	}
+}
+
+func TestParts_BodyRoundTrip(t *testing.T) {
+	key := Parts{Path: "/graphql", Method: http.MethodPost, Body: "ab12"}.String()
+	got := Parse(key)
+	if got.Path != "/graphql" || got.Method != http.MethodPost || got.Body != "ab12" || got.Vary != nil {
+		t.Fatalf("Parse body key = %+v", got)
+	}
+}
+
+func TestApplyVary(t *testing.T) {
+	h := http.Header{}
+	ApplyVary(h, Parse("/a#Accept=text%2Fxml").Vary)
+	if h.Get("Accept") != "text/xml" {
+		t.Fatalf("Accept = %q", h.Get("Accept"))
+	}
+}
+
+func TestFilterQuery(t *testing.T) {
+	got := FilterQuery("utm_source=x&sort=asc&page=2&utm_medium=y", []string{"page", "sort"})
+	if got != "page=2&sort=asc" {
+		t.Fatalf("FilterQuery = %q", got)
+	}
+	if got := FilterQuery("utm_source=x", []string{"page"}); got != "" {
+		t.Fatalf("FilterQuery without allowed params = %q", got)
+	}
+	if got := FilterQuery("page=1&bad=%zz", []string{"page", "bad"}); got != "page=1" {
+		t.Fatalf("FilterQuery with bad param = %q", got)
+	}
+}
+
+func TestParts_QueryRoundTrip(t *testing.T) {
+	q := NormalizeQuery("sort=asc&page=2&page=1")
+	if q != "page=2&page=1&sort=asc" {
+		t.Fatalf("NormalizeQuery = %q", q)
+	}
+	if NormalizeQuery("page=2&sort=asc") != NormalizeQuery("sort=asc&page=2") {
+		t.Fatalf("parameter order must not change the normalized query")
+	}
+	if NormalizeQuery("") != "" {
+		t.Fatalf("empty query should stay empty")
+	}
+
+	key := Parts{Path: "/search", Query: "q=cats", Host: "acme"}.String()
+	if key != "/search#%40host=acme&%40q=q%3Dcats" {
+		t.Fatalf("query key = %q", key)
+	}
+	got := Parse(key)
+	if got.Path != "/search" || got.Query != "q=cats" || got.Host != "acme" || got.Vary != nil {
+		t.Fatalf("Parse query key = %+v", got)
+	}
+	if (Parts{Path: "/search", Query: "q=dogs"}).String() == key {
+		t.Fatalf("different queries must not share a key")
+	}
+}
+
+func TestResponseVary(t *testing.T) {
+	h := http.Header{}
+	h.Add("Vary", "accept-language, Accept-Encoding")
+	h.Add("Vary", "Accept-Language,Cookie")
+	names, any := ResponseVary(h)
+	if any {
+		t.Fatalf("any = true, want false")
+	}
+	if want := []string{"Accept-Language", "Cookie"}; !slices.Equal(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+
+	h.Set("Vary", "Accept-Language, *")
+	if _, any := ResponseVary(h); !any {
+		t.Fatalf("any = false for Vary: *")
+	}
+	if names, _ := ResponseVary(http.Header{}); names != nil {
+		t.Fatalf("names = %v without Vary, want nil", names)
+	}
+}
+
+func TestWithVary(t *testing.T) {
+	base := Parts{Path: "/a", Query: "x=1"}.String()
+	h := http.Header{"Accept-Language": {"de"}}
+
+	got := Parse(WithVary(base, h, []string{"Accept-Language", "Cookie"}))
+	if got.Path != "/a" || got.Query != "x=1" {
+		t.Fatalf("parts = %+v, want path and query kept", got)
+	}
+	if got.Vary.Get("Accept-Language") != "de" || got.Vary.Has("Cookie") {
+		t.Fatalf("vary = %v, want only Accept-Language=de", got.Vary)
+	}
+	if k := WithVary(base, http.Header{}, []string{"Accept-Language"}); k != base {
+		t.Fatalf("key = %q, want base %q for a request without the header", k, base)
+	}
+}
@@ -1,13 +1,24 @@
 package wait0
 
 import (
+	"crypto/x509"
 	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"wait0/internal/wait0/invalidation"
+	"wait0/internal/wait0/logging"
+	"wait0/internal/wait0/proxy"
+	"wait0/internal/wait0/revalidation"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,34 +27,212 @@ type Config struct {
 	Storage struct {
 		RAM struct {
 			Max string `yaml:"max"`
+			// FlushOnShutdown bounds a Close-time pass that writes RAM entries
+			// missing from disk, so planned restarts keep the hot set.
+			FlushOnShutdown    string        `yaml:"flushOnShutdown"`
+			flushOnShutdownDur time.Duration `yaml:"-"`
+			// PromoteAfterHits copies a disk entry to RAM only once it has
+			// been hit this many times within PromoteWindow; 0 or 1 promotes
+			// on every disk hit.
+			PromoteAfterHits int           `yaml:"promoteAfterHits"`
+			PromoteWindow    string        `yaml:"promoteWindow"`
+			promoteWindowDur time.Duration `yaml:"-"`
+			// MaxEntryPercent keeps entries larger than this share of Max
+			// out of RAM; they go to disk instead. 0 allows up to Max.
+			MaxEntryPercent int `yaml:"maxEntryPercent"`
 		} `yaml:"ram"`
 		Disk struct {
 			Max string `yaml:"max"`
+			// MinFree pauses disk writes while the volume has less free space.
+			MinFree      string `yaml:"minFree"`
+			minFreeBytes int64  `yaml:"-"`
+			// MaxConcurrentReads bounds simultaneous disk reads; 0 is unlimited.
+			MaxConcurrentReads int `yaml:"maxConcurrentReads"`
+			// CompactAfter compacts LevelDB once evictions have freed this
+			// much since the last compaction. Empty disables it.
+			CompactAfter      string `yaml:"compactAfter"`
+			compactAfterBytes int64  `yaml:"-"`
+			// MaxEntryPercent refuses entries larger than this share of Max,
+			// so they are served uncached. 0 refuses only entries over Max.
+			MaxEntryPercent int `yaml:"maxEntryPercent"`
+			// path is the LevelDB directory; empty means defaultDiskPath.
+			// Tests set it to keep their databases out of the package
+			// directory.
+			path string `yaml:"-"`
 		} `yaml:"disk"`
+
+		// Audit periodically compares sampled keys held by both RAM and disk.
+		Audit struct {
+			Every      string        `yaml:"every"`
+			everyDur   time.Duration `yaml:"-"`
+			SampleSize int           `yaml:"sampleSize"`
+			// Reconcile keeps the newer copy of diverged keys; without it
+			// divergence is only logged.
+			Reconcile bool `yaml:"reconcile"`
+		} `yaml:"audit"`
+
+		// KeyVersion is folded into every cache key. Changing it (a reload is
+		// enough) makes all older entries unreachable; they are swept in the
+		// background.
+		KeyVersion string `yaml:"keyVersion"`
+
+		// DefaultExpiration applies to paths matching no rule and to rules
+		// without their own expiration. Zero keeps entries fresh forever.
+		DefaultExpiration string        `yaml:"defaultExpiration"`
+		defaultExpDur     time.Duration `yaml:"-"`
+
+		// MaxCacheableBytes caps the body size wait0 buffers on a miss.
+		// Larger responses are streamed to the client and not cached. Empty
+		// means no cap.
+		MaxCacheableBytes string `yaml:"maxCacheableBytes"`
+		maxCacheable      int64  `yaml:"-"`
 	} `yaml:"storage"`
 
 	Server struct {
 		Port   int    `yaml:"port"`
 		Origin string `yaml:"origin"`
+		// PublicHost is the client-facing host (optionally with scheme) used
+		// by rewriteLocation; empty means X-Forwarded-Host or the request Host.
+		PublicHost string `yaml:"publicHost"`
+		// ReadOnly answers methods other than GET and HEAD with 405 instead
+		// of forwarding them to origin.
+		ReadOnly bool `yaml:"readOnly"`
+		// DefaultContentType is set on origin responses that arrive without
+		// a Content-Type, before they are cached or served. With
+		// SniffContentType the type is detected from the body instead,
+		// falling back to DefaultContentType for encoded or empty bodies.
+		DefaultContentType string `yaml:"defaultContentType"`
+		SniffContentType   bool   `yaml:"sniffContentType"`
+		// ForwardedHeaders adds X-Forwarded-For/-Proto/-Host for the client
+		// to proxied origin requests. Leave it off when a load balancer in
+		// front of wait0 already sets them.
+		ForwardedHeaders bool `yaml:"forwardedHeaders"`
+		// PreserveHost forwards the client's Host header to origin instead
+		// of the origin URL's host. Revalidation replays the host component
+		// of the cache key, so multi-host setups should capture the full
+		// host with cacheKey.hostTemplate.
+		PreserveHost bool `yaml:"preserveHost"`
+		// UserAgent is sent on outbound requests that carry none of their
+		// own: revalidation, warmup and sitemap fetches, and proxied
+		// requests from clients without one. Defaults to defaultUserAgent.
+		UserAgent string `yaml:"userAgent"`
+		// OriginHeaders are set on every request to an origin host,
+		// replacing client values, e.g. an auth token for a protected
+		// staging origin.
+		OriginHeaders map[string]string `yaml:"originHeaders"`
+		originHeaders http.Header       `yaml:"-"`
+		// HealthPath serves the health endpoint; defaults to
+		// DefaultHealthPath.
+		HealthPath string `yaml:"healthPath"`
+		// ShutdownTimeout bounds a graceful shutdown: draining client
+		// connections, then background jobs and the RAM flush, all within
+		// one deadline. Defaults to 10s.
+		ShutdownTimeout    string        `yaml:"shutdownTimeout"`
+		shutdownTimeoutDur time.Duration `yaml:"-"`
+		// OriginTimeout caps each origin request, body included; a client
+		// deadline that ends sooner takes precedence. Defaults to 30s.
+		OriginTimeout    string        `yaml:"originTimeout"`
+		originTimeoutDur time.Duration `yaml:"-"`
+		// OriginRetries retries GET and HEAD origin requests that failed to
+		// connect or timed out, waiting OriginRetryBackoff (default 100ms)
+		// before the first retry and doubling it each time.
+		OriginRetries         int           `yaml:"originRetries"`
+		OriginRetryBackoff    string        `yaml:"originRetryBackoff"`
+		originRetryBackoffDur time.Duration `yaml:"-"`
 
 		Invalidation InvalidationConfig `yaml:"invalidation"`
+
+		Upstream struct {
+			// AcceptEncoding is sent to origin: "identity" (default) or "gzip".
+			AcceptEncoding string `yaml:"acceptEncoding"`
+			// MaxHeaderValue caps a single origin header value; longer values
+			// are dropped before caching. Defaults to 64k.
+			MaxHeaderValue      string `yaml:"maxHeaderValue"`
+			maxHeaderValueBytes int64  `yaml:"-"`
+			// TraceConnections records origin connection reuse and dial
+			// timings, reported under origin in the stats API.
+			TraceConnections bool `yaml:"traceConnections"`
+			// Timeout is the former name of server.originTimeout, used when
+			// that is unset.
+			Timeout string `yaml:"timeout"`
+		} `yaml:"upstream"`
+
+		// Transport tunes the connection pool to origin. Unset fields get
+		// the defaults in transport.go. Restart-only.
+		Transport struct {
+			MaxIdleConns        int `yaml:"maxIdleConns"`
+			MaxIdleConnsPerHost int `yaml:"maxIdleConnsPerHost"`
+			// MaxConnsPerHost caps connections to origin, idle or not; 0 is
+			// unlimited.
+			MaxConnsPerHost    int           `yaml:"maxConnsPerHost"`
+			IdleConnTimeout    string        `yaml:"idleConnTimeout"`
+			idleConnTimeoutDur time.Duration `yaml:"-"`
+			// KeepAlive is the TCP keep-alive probe interval.
+			KeepAlive    string        `yaml:"keepAlive"`
+			keepAliveDur time.Duration `yaml:"-"`
+			// DisableKeepAlives opens a new connection per origin request.
+			DisableKeepAlives bool `yaml:"disableKeepAlives"`
+			// DisableHTTP2 stays on HTTP/1.1 even when an https origin
+			// offers HTTP/2.
+			DisableHTTP2 bool `yaml:"disableHTTP2"`
+		} `yaml:"transport"`
+
+		// TLS configures how https origins are verified. Restart-only.
+		TLS struct {
+			// InsecureSkipVerify accepts any origin certificate. Meant for
+			// staging backends with self-signed certs only.
+			InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+			// CAFile is a PEM bundle trusted in addition to the system roots.
+			CAFile  string         `yaml:"caFile"`
+			rootCAs *x509.CertPool `yaml:"-"`
+			// ServerName overrides the name sent in SNI and checked against
+			// the origin certificate.
+			ServerName string `yaml:"serverName"`
+		} `yaml:"tls"`
 	} `yaml:"server"`
 
 	Auth AuthConfig `yaml:"auth"`
 
+	CacheKey struct {
+		// HostTemplate is a regular expression matched against the request
+		// host (port stripped); its first capture group, or the whole match,
+		// becomes part of the cache key. Unset keeps the host out of the key.
+		HostTemplate string         `yaml:"hostTemplate"`
+		hostRe       *regexp.Regexp `yaml:"-"`
+	} `yaml:"cacheKey"`
+
 	URLsDiscover struct {
 		// NOTE: historically this was misspelled as "initalDelay" in configs.
 		InitialDelay    string   `yaml:"initialDelay"`
 		InitalDelay     string   `yaml:"initalDelay"`
 		RediscoverEvery string   `yaml:"rediscoverEvery"`
 		Sitemaps        []string `yaml:"sitemaps"`
+		// FromRobots also crawls the sitemaps listed in the origin's
+		// robots.txt.
+		FromRobots bool `yaml:"fromRobots"`
+		// Incremental fetches sitemaps conditionally and seeds only URLs
+		// that are new since the previous run.
+		Incremental bool `yaml:"incremental"`
+		// MaxSeeded caps inactive seeds; the least recently seeded are
+		// dropped first. Zero means no cap.
+		MaxSeeded int `yaml:"maxSeeded"`
+		// MaxSitemaps and MaxURLs bound one discovery run: sitemaps fetched
+		// and sitemap URLs considered. Zero means no bound.
+		MaxSitemaps int `yaml:"maxSitemaps"`
+		MaxURLs     int `yaml:"maxURLs"`
+		// SeedTTL deletes inactive seeds not warmed or re-seeded within it.
+		// Empty keeps seeds until warmed or evicted.
+		SeedTTL string `yaml:"seedTTL"`
 
 		// compiled
 		initialDelayDur    time.Duration `yaml:"-"`
 		rediscoverEveryDur time.Duration `yaml:"-"`
+		seedTTLDur         time.Duration `yaml:"-"`
 	} `yaml:"urlsDiscover"`
 
 	Logging struct {
+		// Level is error|warn|info|debug; empty means info. Reloadable.
+		Level            string        `yaml:"level"`
 		LogStatsEvery    string        `yaml:"log_stats_every"`
 		logStatsEveryDur time.Duration `yaml:"-"`
 		// LogWarmUp prints a summary after each warmup batch drains.
@@ -53,8 +242,32 @@ type Config struct {
 		// If provided, warmup logging is enabled (the duration is validated but ignored).
 		LogRevalidationEvery string `yaml:"log_revalidation_every"`
 		LogURLAutodiscover   bool   `yaml:"log_url_autodiscover"`
+		// EventWebhook, when set, receives a JSON POST for every warmup or
+		// revalidation that changes an entry's body. Restart-only.
+		EventWebhook string `yaml:"event_webhook"`
+		// StatsFile, when set, keeps cumulative stats counters across
+		// restarts: they are saved to this JSON file every StatsFlushEvery
+		// (default 1m) and on shutdown, and added back at startup.
+		// Restart-only.
+		StatsFile          string        `yaml:"stats_file"`
+		StatsFlushEvery    string        `yaml:"stats_flush_every"`
+		statsFlushEveryDur time.Duration `yaml:"-"`
 	} `yaml:"logging"`
 
+	// Debug injects artificial latency for load and stale-path testing.
+	// Leave it unset in production.
+	Debug struct {
+		OriginDelay   string `yaml:"originDelay"`
+		ResponseDelay string `yaml:"responseDelay"`
+		// ResponseHeaders adds X-Wait0-Debug-* headers exposing cache keys,
+		// matched rules and entry freshness to every client.
+		ResponseHeaders bool `yaml:"responseHeaders"`
+
+		// compiled
+		originDelayDur   time.Duration `yaml:"-"`
+		responseDelayDur time.Duration `yaml:"-"`
+	} `yaml:"debug"`
+
 	Rules []Rule `yaml:"rules"`
 }
 
@@ -81,6 +294,10 @@ type InvalidationTokenConfig struct {
 
 type AuthConfig struct {
 	Tokens []AuthTokenConfig `yaml:"tokens"`
+	// DeniedStatus, 401 or 403 (the default), answers every unauthenticated
+	// request to a bearer-token endpoint with that status and one body,
+	// before routing, so probes cannot tell which endpoints are enabled.
+	DeniedStatus int `yaml:"denied_status"`
 }
 
 type AuthTokenConfig struct {
@@ -93,6 +310,20 @@ type AuthTokenConfig struct {
 type WarmUpConfig struct {
 	RunEvery           string `yaml:"runEvery"`
 	MaxRequestsAtATime int    `yaml:"maxRequestsAtATime"`
+	// MaxRequestsPerRun caps the origin requests one runEvery tick makes;
+	// keys left over are revalidated first on the next tick. Zero is
+	// unlimited.
+	MaxRequestsPerRun int `yaml:"maxRequestsPerRun"`
+	// RampUp grows warmup concurrency from 1 to MaxRequestsAtATime over this
+	// long after startup, so warmup does not compete with cold traffic.
+	RampUp string `yaml:"rampUp"`
+	// Schedule limits warmup to a daily "HH:MM-HH:MM" window, such as
+	// "01:00-05:00", so it pauses during peak hours. A window may wrap past
+	// midnight. Empty runs around the clock.
+	Schedule string `yaml:"schedule"`
+	// Timezone is the IANA zone Schedule is read in; empty uses the
+	// server's local zone.
+	Timezone string `yaml:"timezone"`
 
 	// compiled
 	runEveryDur time.Duration `yaml:"-"`
@@ -103,36 +334,371 @@ type Rule struct {
 	Priority          int           `yaml:"priority"`
 	Bypass            bool          `yaml:"bypass"`
 	BypassWhenCookies []string      `yaml:"bypassWhenCookies"`
+	BypassWhenHeaders []string      `yaml:"bypassWhenHeaders"`
 	Expiration        string        `yaml:"expiration"`
 	WarmUp            *WarmUpConfig `yaml:"warmUp"`
+	// Tier selects the cache tiers used for matching keys: ram, disk or both.
+	Tier string `yaml:"tier"`
+	// Streamable responses are streamed to the client on a miss and cached
+	// only if they complete within StreamBufferMax (default 1m).
+	Streamable      bool   `yaml:"streamable"`
+	StreamBufferMax string `yaml:"streamBufferMax"`
+	// VaryBy lists request headers (e.g. Accept) whose values are folded into
+	// the cache key and forwarded to the origin.
+	VaryBy []string `yaml:"varyBy"`
+	// IgnoreQuery leaves the query string out of the cache key, for sites
+	// where query parameters are only tracking noise.
+	IgnoreQuery bool `yaml:"ignoreQuery"`
+	// CacheKeyQuery lists the only query parameters kept in the cache key and
+	// forwarded on cache fills; others (utm_* and the like) are stripped.
+	CacheKeyQuery []string `yaml:"cacheKeyQuery"`
+	// RewriteLocation passes origin redirects through and points absolute
+	// Location headers aimed at the origin host to server.publicHost.
+	RewriteLocation bool `yaml:"rewriteLocation"`
+	// ResponseCacheControl overrides the origin's Cache-Control header on
+	// responses served for matching paths.
+	ResponseCacheControl string `yaml:"responseCacheControl"`
+	// CacheOptions caches OPTIONS responses (CORS preflights) per path and
+	// preflight request headers instead of passing them through.
+	CacheOptions bool `yaml:"cacheOptions"`
+	// CacheMethods lists the request methods whose responses are cached:
+	// GET (the default) and optionally POST, keyed by a hash of the request
+	// body. POST bodies over CacheBodyMax (default 64k) bypass the cache.
+	CacheMethods []string `yaml:"cacheMethods"`
+	CacheBodyMax string   `yaml:"cacheBodyMax"`
+	// MaxBodyBytes caps the response size cached for matching paths; larger
+	// responses are streamed through as bypass-too-large.
+	MaxBodyBytes string `yaml:"maxBodyBytes"`
+	// CacheWithSetCookie caches responses carrying Set-Cookie, which are
+	// otherwise passed through. The cookies are never stored.
+	CacheWithSetCookie bool `yaml:"cacheWithSetCookie"`
+	// MaxAge is a hard freshness ceiling: older entries are refetched from
+	// origin before serving, regardless of expiration.
+	MaxAge string `yaml:"maxAge"`
+	// StaleIfError keeps serving a cached entry for up to this long past its
+	// expiry while the origin errors or returns 5xx, instead of failing.
+	StaleIfError string `yaml:"staleIfError"`
+	// OriginTimeout overrides server.originTimeout for origin requests on
+	// matching paths.
+	OriginTimeout string `yaml:"originTimeout"`
+	// Origin overrides server.origin for matching paths, on proxied
+	// requests and revalidation alike.
+	Origin string `yaml:"origin"`
+	// ExpirationByStatus maps response status codes to their own expiration,
+	// taking precedence over Expiration and the origin's max-age.
+	ExpirationByStatus map[int]string `yaml:"expirationByStatus"`
+	// NegativeCache caches error responses for a short time instead of
+	// refetching them on every request. Keys are statuses ("404") or
+	// classes ("5xx"); values are how long the response is served.
+	NegativeCache map[string]string `yaml:"negativeCache"`
 
 	// compiled
-	matchers  []pathPrefixMatcher
-	expDur    time.Duration
-	warmEvery time.Duration
-	warmMax   int
+	matchers pathMatcher
+	expDur   time.Duration
+	// expInherited marks expDur as storage.defaultExpiration rather than the
+	// rule's own, so an origin max-age takes precedence over it.
+	expInherited     bool
+	expByStatus      map[int]time.Duration
+	negTTL           map[int]time.Duration
+	maxAgeDur        time.Duration
+	staleErrDur      time.Duration
+	originTimeoutDur time.Duration
+	warmEvery        time.Duration
+	warmMax          int
+	warmPerRun       int
+	warmRamp         time.Duration
+	warmWindow       revalidation.Window
+	tier             string
+	streamMax        int64
+	cachePost        bool
+	bodyMax          int64
+	maxBody          int64
+	varyBy           []string
+	keyQuery         []string
+}
+
+const defaultStreamBufferMax = 1 << 20
+
+const defaultCacheBodyMax = 64 << 10
+
+const defaultMaxHeaderValue = 64 << 10
+
+const defaultOriginTimeout = 30 * time.Second
+
+const defaultShutdownTimeout = 10 * time.Second
+
+const defaultStatsFlushEvery = time.Minute
+
+// defaultOriginRetryBackoff is the wait before the first origin retry;
+// maxOriginRetries bounds server.originRetries so backoff stays reasonable.
+const (
+	defaultOriginRetryBackoff = 100 * time.Millisecond
+	maxOriginRetries          = 10
+)
+
+// parseNegativeStatus parses a rules[].negativeCache key: a status from 300
+// to 599, or a class "3xx", "4xx" or "5xx", returned as its leading digit.
+func parseNegativeStatus(k string) (int, error) {
+	k = strings.ToLower(strings.TrimSpace(k))
+	switch k {
+	case "3xx", "4xx", "5xx":
+		return int(k[0] - '0'), nil
+	}
+	n, err := strconv.Atoi(k)
+	if err != nil || n < 300 || n > 599 {
+		return 0, fmt.Errorf("invalid status %q, want 300-599 or 3xx/4xx/5xx", k)
+	}
+	return n, nil
+}
+
+// defaultAuditSampleSize is the storage.audit.sampleSize used when unset.
+const defaultAuditSampleSize = 100
+
+// defaultPromoteWindow is the storage.ram.promoteWindow used when only
+// promoteAfterHits is set.
+const defaultPromoteWindow = time.Minute
+
+// parseOrigin checks that s is an http or https base URL and returns it
+// without trailing slashes, ready to have request URIs appended.
+func parseOrigin(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "/")
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("must be an http or https URL, got %q", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("must not have a query or fragment")
+	}
+	return s, nil
+}
+
+// originFor returns the origin base URL for path: the matching rule's origin,
+// else server.origin.
+func (c *Config) originFor(path string) string {
+	if i := c.ruleIndex(path); i >= 0 && c.Rules[i].Origin != "" {
+		return c.Rules[i].Origin
+	}
+	return c.Server.Origin
 }
 
-type pathPrefixMatcher struct{ Prefix string }
+// originTimeout returns the origin request timeout for path: the matching
+// rule's originTimeout, else server.originTimeout.
+func (c *Config) originTimeout(path string) time.Duration {
+	if i := c.ruleIndex(path); i >= 0 && c.Rules[i].originTimeoutDur > 0 {
+		return c.Rules[i].originTimeoutDur
+	}
+	return c.Server.originTimeoutDur
+}
+
+// isOriginHost reports whether host is the host of server.origin or of a
+// rule's origin.
+func (c *Config) isOriginHost(host string) bool {
+	same := func(origin string) bool {
+		_, rest, _ := strings.Cut(origin, "://")
+		h, _, _ := strings.Cut(rest, "/")
+		return h != "" && strings.EqualFold(h, host)
+	}
+	if same(c.Server.Origin) {
+		return true
+	}
+	for i := range c.Rules {
+		if c.Rules[i].Origin != "" && same(c.Rules[i].Origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostKey returns the cache key component for host under cacheKey.hostTemplate.
+// Hosts that do not match, and configs without a template, yield "".
+func (c *Config) hostKey(host string) string {
+	re := c.CacheKey.hostRe
+	if re == nil {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	m := re.FindStringSubmatch(strings.ToLower(host))
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	default:
+		return m[0]
+	}
+}
+
+// pathTerm is one compiled term of a match expression.
+type pathTerm interface {
+	matchPath(path string) bool
+}
 
-func (m pathPrefixMatcher) Match(path string) bool { return strings.HasPrefix(path, m.Prefix) }
+type exactTerm string
+
+func (t exactTerm) matchPath(p string) bool { return p == string(t) }
+
+type prefixTerm string
+
+func (t prefixTerm) matchPath(p string) bool { return strings.HasPrefix(p, string(t)) }
+
+// globTerm uses path.Match semantics, so "*" does not cross a "/".
+type globTerm string
+
+func (t globTerm) matchPath(p string) bool {
+	ok, _ := path.Match(string(t), p)
+	return ok
+}
+
+type regexpTerm struct{ re *regexp.Regexp }
+
+func (t regexpTerm) matchPath(p string) bool { return t.re.MatchString(p) }
+
+// pathMatcher is a compiled rule match expression. parseMatch orders its
+// terms so the slower regexps are tried last.
+type pathMatcher struct {
+	terms []pathTerm
+}
+
+func (m pathMatcher) Match(path string) bool {
+	for _, t := range m.terms {
+		if t.matchPath(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyLogLevel sets the process-wide log level from cfg. LoadConfig has
+// already rejected unknown levels; configs built in code default to info.
+func applyLogLevel(cfg *Config) {
+	lvl, err := logging.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		lvl = logging.DefaultLevel
+	}
+	logging.SetLevel(lvl)
+}
 
 func LoadConfig(path string) (Config, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return Config{}, err
 	}
-	var cfg Config
-	if err := yaml.Unmarshal(b, &cfg); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
 		return Config{}, err
 	}
+	if err := expandEnv(&doc, ""); err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if doc.Kind != 0 {
+		if err := doc.Decode(&cfg); err != nil {
+			return Config{}, err
+		}
+	}
 	if cfg.Server.Port == 0 {
 		cfg.Server.Port = 8080
 	}
 	if cfg.Server.Origin == "" {
 		return Config{}, fmt.Errorf("server.origin is required")
 	}
-	cfg.Server.Origin = strings.TrimRight(cfg.Server.Origin, "/")
+	origin, err := parseOrigin(cfg.Server.Origin)
+	if err != nil {
+		return Config{}, fmt.Errorf("server.origin: %w", err)
+	}
+	cfg.Server.Origin = origin
+	cfg.Server.originTimeoutDur = defaultOriginTimeout
+	timeoutKey, timeout := "server.originTimeout", cfg.Server.OriginTimeout
+	if strings.TrimSpace(timeout) == "" {
+		timeoutKey, timeout = "server.upstream.timeout", cfg.Server.Upstream.Timeout
+	}
+	if strings.TrimSpace(timeout) != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", timeoutKey, err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("%s: must be > 0", timeoutKey)
+		}
+		cfg.Server.originTimeoutDur = d
+	}
+
+	cfg.Server.DefaultContentType = strings.TrimSpace(cfg.Server.DefaultContentType)
+	if ct := cfg.Server.DefaultContentType; ct != "" {
+		if _, _, err := mime.ParseMediaType(ct); err != nil {
+			return Config{}, fmt.Errorf("server.defaultContentType: %w", err)
+		}
+	}
+
+	if err := compileTransport(&cfg); err != nil {
+		return Config{}, err
+	}
+	if err := compileTLS(&cfg); err != nil {
+		return Config{}, err
+	}
+	if err := compileOriginHeaders(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	cfg.Server.shutdownTimeoutDur = defaultShutdownTimeout
+	if strings.TrimSpace(cfg.Server.ShutdownTimeout) != "" {
+		d, err := time.ParseDuration(cfg.Server.ShutdownTimeout)
+		if err != nil {
+			return Config{}, fmt.Errorf("server.shutdownTimeout: %w", err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("server.shutdownTimeout: must be > 0")
+		}
+		cfg.Server.shutdownTimeoutDur = d
+	}
+
+	if cfg.Server.OriginRetries < 0 || cfg.Server.OriginRetries > maxOriginRetries {
+		return Config{}, fmt.Errorf("server.originRetries: must be between 0 and %d", maxOriginRetries)
+	}
+	cfg.Server.originRetryBackoffDur = defaultOriginRetryBackoff
+	if strings.TrimSpace(cfg.Server.OriginRetryBackoff) != "" {
+		d, err := time.ParseDuration(cfg.Server.OriginRetryBackoff)
+		if err != nil {
+			return Config{}, fmt.Errorf("server.originRetryBackoff: %w", err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("server.originRetryBackoff: must be > 0")
+		}
+		cfg.Server.originRetryBackoffDur = d
+	}
+
+	cfg.Server.Upstream.maxHeaderValueBytes = defaultMaxHeaderValue
+	if strings.TrimSpace(cfg.Server.Upstream.MaxHeaderValue) != "" {
+		n, err := parseBytes(cfg.Server.Upstream.MaxHeaderValue)
+		if err != nil {
+			return Config{}, fmt.Errorf("server.upstream.maxHeaderValue: %w", err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("server.upstream.maxHeaderValue: must be > 0")
+		}
+		cfg.Server.Upstream.maxHeaderValueBytes = n
+	}
+
+	switch enc := strings.ToLower(strings.TrimSpace(cfg.Server.Upstream.AcceptEncoding)); enc {
+	case "", proxy.EncodingIdentity:
+		cfg.Server.Upstream.AcceptEncoding = proxy.EncodingIdentity
+	case proxy.EncodingGzip:
+		cfg.Server.Upstream.AcceptEncoding = enc
+	default:
+		return Config{}, fmt.Errorf("server.upstream.acceptEncoding: must be one of identity, gzip")
+	}
+	cfg.Server.HealthPath = strings.TrimSpace(cfg.Server.HealthPath)
+	if cfg.Server.HealthPath == "" {
+		cfg.Server.HealthPath = DefaultHealthPath
+	}
+	if !strings.HasPrefix(cfg.Server.HealthPath, "/") {
+		return Config{}, fmt.Errorf("server.healthPath: must start with /")
+	}
 	cfg.Server.Invalidation.applyDefaults()
 	if err := cfg.Server.Invalidation.validate(); err != nil {
 		return Config{}, fmt.Errorf("server.invalidation: %w", err)
@@ -148,7 +714,7 @@ func LoadConfig(path string) (Config, error) {
 	}
 
 	// urlsDiscover (optional)
-	if len(cfg.URLsDiscover.Sitemaps) > 0 {
+	if len(cfg.URLsDiscover.Sitemaps) > 0 || cfg.URLsDiscover.FromRobots {
 		initDelay := strings.TrimSpace(cfg.URLsDiscover.InitialDelay)
 		if initDelay == "" {
 			initDelay = strings.TrimSpace(cfg.URLsDiscover.InitalDelay)
@@ -174,6 +740,30 @@ func LoadConfig(path string) (Config, error) {
 			}
 			cfg.URLsDiscover.rediscoverEveryDur = d
 		}
+
+		if strings.TrimSpace(cfg.URLsDiscover.SeedTTL) != "" {
+			d, err := time.ParseDuration(cfg.URLsDiscover.SeedTTL)
+			if err != nil {
+				return Config{}, fmt.Errorf("urlsDiscover.seedTTL: %w", err)
+			}
+			if d <= 0 {
+				return Config{}, fmt.Errorf("urlsDiscover.seedTTL: must be > 0")
+			}
+			cfg.URLsDiscover.seedTTLDur = d
+		}
+	}
+	if cfg.URLsDiscover.MaxSeeded < 0 {
+		return Config{}, fmt.Errorf("urlsDiscover.maxSeeded: must be >= 0")
+	}
+	if cfg.URLsDiscover.MaxSitemaps < 0 {
+		return Config{}, fmt.Errorf("urlsDiscover.maxSitemaps: must be >= 0")
+	}
+	if cfg.URLsDiscover.MaxURLs < 0 {
+		return Config{}, fmt.Errorf("urlsDiscover.maxURLs: must be >= 0")
+	}
+
+	if _, err := logging.ParseLevel(cfg.Logging.Level); err != nil {
+		return Config{}, fmt.Errorf("logging.level: %w", err)
 	}
 
 	if cfg.Logging.LogStatsEvery != "" {
@@ -187,6 +777,32 @@ func LoadConfig(path string) (Config, error) {
 		cfg.Logging.logStatsEveryDur = d
 	}
 
+	cfg.Logging.StatsFile = strings.TrimSpace(cfg.Logging.StatsFile)
+	cfg.Logging.statsFlushEveryDur = defaultStatsFlushEvery
+	if strings.TrimSpace(cfg.Logging.StatsFlushEvery) != "" {
+		if cfg.Logging.StatsFile == "" {
+			return Config{}, fmt.Errorf("logging.stats_flush_every: requires logging.stats_file")
+		}
+		d, err := time.ParseDuration(cfg.Logging.StatsFlushEvery)
+		if err != nil {
+			return Config{}, fmt.Errorf("logging.stats_flush_every: %w", err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("logging.stats_flush_every: must be > 0")
+		}
+		cfg.Logging.statsFlushEveryDur = d
+	}
+
+	if cfg.Logging.EventWebhook != "" {
+		u, err := url.Parse(cfg.Logging.EventWebhook)
+		if err != nil {
+			return Config{}, fmt.Errorf("logging.event_webhook: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, fmt.Errorf("logging.event_webhook: must be an absolute http(s) URL")
+		}
+	}
+
 	if strings.TrimSpace(cfg.Logging.LogRevalidationEvery) != "" {
 		// Backward compatible alias for the previous warmup logging setting.
 		_, err := time.ParseDuration(cfg.Logging.LogRevalidationEvery)
@@ -196,6 +812,128 @@ func LoadConfig(path string) (Config, error) {
 		cfg.Logging.LogWarmUp = true
 	}
 
+	if strings.TrimSpace(cfg.Storage.DefaultExpiration) != "" {
+		d, err := time.ParseDuration(cfg.Storage.DefaultExpiration)
+		if err != nil {
+			return Config{}, fmt.Errorf("storage.defaultExpiration: %w", err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("storage.defaultExpiration: must be >= 0")
+		}
+		cfg.Storage.defaultExpDur = d
+	}
+
+	if strings.TrimSpace(cfg.Storage.RAM.FlushOnShutdown) != "" {
+		d, err := time.ParseDuration(cfg.Storage.RAM.FlushOnShutdown)
+		if err != nil {
+			return Config{}, fmt.Errorf("storage.ram.flushOnShutdown: %w", err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("storage.ram.flushOnShutdown: must be >= 0")
+		}
+		cfg.Storage.RAM.flushOnShutdownDur = d
+	}
+
+	if cfg.Storage.RAM.PromoteAfterHits < 0 {
+		return Config{}, fmt.Errorf("storage.ram.promoteAfterHits: must be >= 0")
+	}
+	if p := cfg.Storage.RAM.MaxEntryPercent; p < 0 || p > 100 {
+		return Config{}, fmt.Errorf("storage.ram.maxEntryPercent: must be between 0 and 100")
+	}
+	cfg.Storage.RAM.promoteWindowDur = defaultPromoteWindow
+	if strings.TrimSpace(cfg.Storage.RAM.PromoteWindow) != "" {
+		d, err := time.ParseDuration(cfg.Storage.RAM.PromoteWindow)
+		if err != nil {
+			return Config{}, fmt.Errorf("storage.ram.promoteWindow: %w", err)
+		}
+		if d < time.Second {
+			return Config{}, fmt.Errorf("storage.ram.promoteWindow: must be >= 1s")
+		}
+		cfg.Storage.RAM.promoteWindowDur = d
+	}
+
+	if strings.TrimSpace(cfg.Storage.Audit.Every) != "" {
+		d, err := time.ParseDuration(cfg.Storage.Audit.Every)
+		if err != nil {
+			return Config{}, fmt.Errorf("storage.audit.every: %w", err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("storage.audit.every: must be > 0")
+		}
+		cfg.Storage.Audit.everyDur = d
+	}
+	if cfg.Storage.Audit.SampleSize < 0 {
+		return Config{}, fmt.Errorf("storage.audit.sampleSize: must be >= 0")
+	}
+	if cfg.Storage.Audit.SampleSize == 0 {
+		cfg.Storage.Audit.SampleSize = defaultAuditSampleSize
+	}
+
+	cfg.Storage.KeyVersion = strings.TrimSpace(cfg.Storage.KeyVersion)
+
+	if strings.TrimSpace(cfg.Debug.OriginDelay) != "" {
+		d, err := time.ParseDuration(cfg.Debug.OriginDelay)
+		if err != nil {
+			return Config{}, fmt.Errorf("debug.originDelay: %w", err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("debug.originDelay: must be >= 0")
+		}
+		cfg.Debug.originDelayDur = d
+	}
+	if strings.TrimSpace(cfg.Debug.ResponseDelay) != "" {
+		d, err := time.ParseDuration(cfg.Debug.ResponseDelay)
+		if err != nil {
+			return Config{}, fmt.Errorf("debug.responseDelay: %w", err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("debug.responseDelay: must be >= 0")
+		}
+		cfg.Debug.responseDelayDur = d
+	}
+
+	if tpl := strings.TrimSpace(cfg.CacheKey.HostTemplate); tpl != "" {
+		re, err := regexp.Compile(tpl)
+		if err != nil {
+			return Config{}, fmt.Errorf("cacheKey.hostTemplate: %w", err)
+		}
+		cfg.CacheKey.hostRe = re
+	}
+
+	if strings.TrimSpace(cfg.Storage.Disk.MinFree) != "" {
+		n, err := parseBytes(cfg.Storage.Disk.MinFree)
+		if err != nil {
+			return Config{}, fmt.Errorf("storage.disk.minFree: %w", err)
+		}
+		cfg.Storage.Disk.minFreeBytes = n
+	}
+	if p := cfg.Storage.Disk.MaxEntryPercent; p < 0 || p > 100 {
+		return Config{}, fmt.Errorf("storage.disk.maxEntryPercent: must be between 0 and 100")
+	}
+	if cfg.Storage.Disk.MaxConcurrentReads < 0 {
+		return Config{}, fmt.Errorf("storage.disk.maxConcurrentReads: must be >= 0")
+	}
+	if strings.TrimSpace(cfg.Storage.Disk.CompactAfter) != "" {
+		n, err := parseBytes(cfg.Storage.Disk.CompactAfter)
+		if err != nil {
+			return Config{}, fmt.Errorf("storage.disk.compactAfter: %w", err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("storage.disk.compactAfter: must be > 0")
+		}
+		cfg.Storage.Disk.compactAfterBytes = n
+	}
+	if strings.TrimSpace(cfg.Storage.MaxCacheableBytes) != "" {
+		n, err := parseBytes(cfg.Storage.MaxCacheableBytes)
+		if err != nil {
+			return Config{}, fmt.Errorf("storage.maxCacheableBytes: %w", err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("storage.maxCacheableBytes: must be > 0")
+		}
+		cfg.Storage.maxCacheable = n
+	}
+
 	for i := range cfg.Rules {
 		r := &cfg.Rules[i]
 		ms, err := parseMatch(r.Match)
@@ -209,6 +947,153 @@ func LoadConfig(path string) (Config, error) {
 				return Config{}, fmt.Errorf("rules[%d].expiration: %w", i, err)
 			}
 			r.expDur = d
+		} else {
+			r.expDur = cfg.Storage.defaultExpDur
+			r.expInherited = true
+		}
+		for status, v := range r.ExpirationByStatus {
+			if status < 100 || status > 599 {
+				return Config{}, fmt.Errorf("rules[%d].expirationByStatus: invalid status %d", i, status)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].expirationByStatus[%d]: %w", i, status, err)
+			}
+			if d <= 0 {
+				return Config{}, fmt.Errorf("rules[%d].expirationByStatus[%d]: must be > 0", i, status)
+			}
+			if r.expByStatus == nil {
+				r.expByStatus = make(map[int]time.Duration, len(r.ExpirationByStatus))
+			}
+			r.expByStatus[status] = d
+		}
+		for k, v := range r.NegativeCache {
+			status, err := parseNegativeStatus(k)
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].negativeCache: %w", i, err)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].negativeCache[%s]: %w", i, k, err)
+			}
+			if d <= 0 {
+				return Config{}, fmt.Errorf("rules[%d].negativeCache[%s]: must be > 0", i, k)
+			}
+			if r.negTTL == nil {
+				r.negTTL = make(map[int]time.Duration, len(r.NegativeCache))
+			}
+			r.negTTL[status] = d
+		}
+		r.ResponseCacheControl = strings.TrimSpace(r.ResponseCacheControl)
+		if strings.TrimSpace(r.MaxAge) != "" {
+			d, err := time.ParseDuration(r.MaxAge)
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].maxAge: %w", i, err)
+			}
+			if d <= 0 {
+				return Config{}, fmt.Errorf("rules[%d].maxAge: must be > 0", i)
+			}
+			r.maxAgeDur = d
+		}
+		if strings.TrimSpace(r.StaleIfError) != "" {
+			d, err := time.ParseDuration(r.StaleIfError)
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].staleIfError: %w", i, err)
+			}
+			if d <= 0 {
+				return Config{}, fmt.Errorf("rules[%d].staleIfError: must be > 0", i)
+			}
+			r.staleErrDur = d
+		}
+		if strings.TrimSpace(r.OriginTimeout) != "" {
+			d, err := time.ParseDuration(r.OriginTimeout)
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].originTimeout: %w", i, err)
+			}
+			if d <= 0 {
+				return Config{}, fmt.Errorf("rules[%d].originTimeout: must be > 0", i)
+			}
+			r.originTimeoutDur = d
+		}
+		if strings.TrimSpace(r.Origin) != "" {
+			origin, err := parseOrigin(r.Origin)
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].origin: %w", i, err)
+			}
+			r.Origin = origin
+		}
+		switch tier := strings.ToLower(strings.TrimSpace(r.Tier)); tier {
+		case "", proxy.TierBoth:
+			r.tier = proxy.TierBoth
+		case proxy.TierRAM, proxy.TierDisk:
+			r.tier = tier
+		default:
+			return Config{}, fmt.Errorf("rules[%d].tier: must be one of ram, disk, both", i)
+		}
+		for j, h := range r.VaryBy {
+			h = strings.TrimSpace(h)
+			if h == "" {
+				return Config{}, fmt.Errorf("rules[%d].varyBy[%d]: header name is required", i, j)
+			}
+			r.varyBy = append(r.varyBy, http.CanonicalHeaderKey(h))
+		}
+		if len(r.CacheKeyQuery) > 0 && r.IgnoreQuery {
+			return Config{}, fmt.Errorf("rules[%d].cacheKeyQuery: cannot be combined with ignoreQuery", i)
+		}
+		for j, q := range r.CacheKeyQuery {
+			q = strings.TrimSpace(q)
+			if q == "" {
+				return Config{}, fmt.Errorf("rules[%d].cacheKeyQuery[%d]: parameter name is required", i, j)
+			}
+			r.keyQuery = append(r.keyQuery, q)
+		}
+		if r.Streamable {
+			r.streamMax = defaultStreamBufferMax
+			if strings.TrimSpace(r.StreamBufferMax) != "" {
+				n, err := parseBytes(r.StreamBufferMax)
+				if err != nil {
+					return Config{}, fmt.Errorf("rules[%d].streamBufferMax: %w", i, err)
+				}
+				if n <= 0 {
+					return Config{}, fmt.Errorf("rules[%d].streamBufferMax: must be > 0", i)
+				}
+				r.streamMax = n
+			}
+		}
+		hasGet := len(r.CacheMethods) == 0
+		for j, m := range r.CacheMethods {
+			switch strings.ToUpper(strings.TrimSpace(m)) {
+			case http.MethodGet:
+				hasGet = true
+			case http.MethodPost:
+				r.cachePost = true
+			default:
+				return Config{}, fmt.Errorf("rules[%d].cacheMethods[%d]: must be GET or POST, got %q", i, j, m)
+			}
+		}
+		if !hasGet {
+			return Config{}, fmt.Errorf("rules[%d].cacheMethods: must include GET", i)
+		}
+		r.bodyMax = defaultCacheBodyMax
+		if strings.TrimSpace(r.CacheBodyMax) != "" {
+			n, err := parseBytes(r.CacheBodyMax)
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].cacheBodyMax: %w", i, err)
+			}
+			if n <= 0 {
+				return Config{}, fmt.Errorf("rules[%d].cacheBodyMax: must be > 0", i)
+			}
+			r.bodyMax = n
+		}
+		if strings.TrimSpace(r.MaxBodyBytes) != "" {
+			n, err := parseBytes(r.MaxBodyBytes)
+			if err != nil {
+				return Config{}, fmt.Errorf("rules[%d].maxBodyBytes: %w", i, err)
+			}
+			if n <= 0 {
+				return Config{}, fmt.Errorf("rules[%d].maxBodyBytes: must be > 0", i)
+			}
+			r.maxBody = n
 		}
 		if r.WarmUp != nil {
 			if strings.TrimSpace(r.WarmUp.RunEvery) == "" {
@@ -224,9 +1109,39 @@ func LoadConfig(path string) (Config, error) {
 			if r.WarmUp.MaxRequestsAtATime <= 0 {
 				return Config{}, fmt.Errorf("rules[%d].warmUp.maxRequestsAtATime: must be > 0", i)
 			}
+			if r.WarmUp.MaxRequestsPerRun < 0 {
+				return Config{}, fmt.Errorf("rules[%d].warmUp.maxRequestsPerRun: must be >= 0", i)
+			}
+			if strings.TrimSpace(r.WarmUp.RampUp) != "" {
+				ramp, err := time.ParseDuration(r.WarmUp.RampUp)
+				if err != nil {
+					return Config{}, fmt.Errorf("rules[%d].warmUp.rampUp: %w", i, err)
+				}
+				if ramp < 0 {
+					return Config{}, fmt.Errorf("rules[%d].warmUp.rampUp: must be >= 0", i)
+				}
+				r.warmRamp = ramp
+			}
+			if strings.TrimSpace(r.WarmUp.Schedule) != "" {
+				var loc *time.Location
+				if tz := strings.TrimSpace(r.WarmUp.Timezone); tz != "" {
+					loc, err = time.LoadLocation(tz)
+					if err != nil {
+						return Config{}, fmt.Errorf("rules[%d].warmUp.timezone: %w", i, err)
+					}
+				}
+				win, err := revalidation.ParseWindow(r.WarmUp.Schedule, loc)
+				if err != nil {
+					return Config{}, fmt.Errorf("rules[%d].warmUp.schedule: %w", i, err)
+				}
+				r.warmWindow = win
+			} else if strings.TrimSpace(r.WarmUp.Timezone) != "" {
+				return Config{}, fmt.Errorf("rules[%d].warmUp.timezone: requires warmUp.schedule", i)
+			}
 			r.WarmUp.runEveryDur = d
 			r.warmEvery = d
 			r.warmMax = r.WarmUp.MaxRequestsAtATime
+			r.warmPerRun = r.WarmUp.MaxRequestsPerRun
 		}
 	}
 
@@ -237,42 +1152,115 @@ func LoadConfig(path string) (Config, error) {
 	return cfg, nil
 }
 
-func parseMatch(expr string) ([]pathPrefixMatcher, error) {
+// parseMatch compiles a match expression: Path(...), PathPrefix(...),
+// PathGlob(...) and PathRegexp(...) terms joined by "|". A "|" inside a term's parentheses, such as regexp
+// alternation, belongs to the term.
+func parseMatch(expr string) (pathMatcher, error) {
 	expr = strings.TrimSpace(expr)
 	if expr == "" {
-		return nil, fmt.Errorf("empty match")
+		return pathMatcher{}, fmt.Errorf("empty match")
 	}
 
-	parts := strings.Split(expr, "|")
-	out := make([]pathPrefixMatcher, 0, len(parts))
-	for _, p := range parts {
+	var terms, regexps []pathTerm
+	for _, p := range splitMatch(expr) {
 		p = strings.TrimSpace(p)
 		if p == "" {
 			continue
 		}
-		if !strings.HasPrefix(p, "PathPrefix(") || !strings.HasSuffix(p, ")") {
-			return nil, fmt.Errorf("only PathPrefix(...) supported, got %q", p)
+		name, inside, ok := matchTerm(p)
+		if !ok {
+			return pathMatcher{}, fmt.Errorf("only Path(...), PathPrefix(...), PathGlob(...) and PathRegexp(...) supported, got %q", p)
 		}
-		inside := strings.TrimSuffix(strings.TrimPrefix(p, "PathPrefix("), ")")
-		inside = strings.TrimSpace(inside)
-		if inside == "" || !strings.HasPrefix(inside, "/") {
-			return nil, fmt.Errorf("invalid prefix %q", inside)
+		switch name {
+		case "Path":
+			if !strings.HasPrefix(inside, "/") {
+				return pathMatcher{}, fmt.Errorf("invalid path %q", inside)
+			}
+			terms = append(terms, exactTerm(inside))
+		case "PathPrefix":
+			if !strings.HasPrefix(inside, "/") {
+				return pathMatcher{}, fmt.Errorf("invalid prefix %q", inside)
+			}
+			terms = append(terms, prefixTerm(inside))
+		case "PathGlob":
+			if !strings.HasPrefix(inside, "/") {
+				return pathMatcher{}, fmt.Errorf("invalid glob %q", inside)
+			}
+			if _, err := path.Match(inside, ""); err != nil {
+				return pathMatcher{}, fmt.Errorf("invalid glob %q: %w", inside, err)
+			}
+			terms = append(terms, globTerm(inside))
+		case "PathRegexp":
+			if inside == "" {
+				return pathMatcher{}, fmt.Errorf("empty regexp")
+			}
+			re, err := regexp.Compile(inside)
+			if err != nil {
+				return pathMatcher{}, fmt.Errorf("invalid regexp %q: %w", inside, err)
+			}
+			regexps = append(regexps, regexpTerm{re: re})
 		}
-		out = append(out, pathPrefixMatcher{Prefix: inside})
 	}
-	if len(out) == 0 {
-		return nil, fmt.Errorf("no valid matchers")
+	terms = append(terms, regexps...)
+	if len(terms) == 0 {
+		return pathMatcher{}, fmt.Errorf("no valid matchers")
 	}
-	return out, nil
+	return pathMatcher{terms: terms}, nil
 }
 
-func (r *Rule) Matches(path string) bool {
-	for _, m := range r.matchers {
-		if m.Match(path) {
-			return true
+// matchTerm splits a term such as "PathGlob(/a/*.js)" into its matcher name
+// and trimmed argument.
+func matchTerm(p string) (name, inside string, ok bool) {
+	open := strings.IndexByte(p, '(')
+	if open < 0 || !strings.HasSuffix(p, ")") {
+		return "", "", false
+	}
+	name = p[:open]
+	switch name {
+	case "Path", "PathPrefix", "PathGlob", "PathRegexp":
+		return name, strings.TrimSpace(p[open+1 : len(p)-1]), true
+	}
+	return "", "", false
+}
+
+// splitMatch splits expr on the "|" characters outside parentheses. Escaped
+// characters and bracketed character classes do not count as parentheses, so
+// a regexp such as ^/a\(([0-9]|[)])$ stays one term.
+func splitMatch(expr string) []string {
+	var parts []string
+	depth, start := 0, 0
+	inClass := false
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[' && depth > 0:
+			inClass = true
+			// A ']' right after '[' or '[^' is a literal.
+			if i+1 < len(expr) && expr[i+1] == '^' {
+				i++
+			}
+			if i+1 < len(expr) && expr[i+1] == ']' {
+				i++
+			}
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == '|' && depth == 0:
+			parts = append(parts, expr[start:i])
+			start = i + 1
 		}
 	}
-	return false
+	return append(parts, expr[start:])
+}
+
+func (r *Rule) Matches(path string) bool {
+	return r.matchers.Match(path)
 }
 
 func (c *InvalidationConfig) applyDefaults() {
@@ -356,6 +1344,14 @@ func (c *AuthConfig) validateAndResolveTokens() error {
 		}
 		t.Scopes = scopes
 	}
+	if c.DeniedStatus == 0 {
+		c.DeniedStatus = http.StatusForbidden
+	}
+	switch c.DeniedStatus {
+	case http.StatusUnauthorized, http.StatusForbidden:
+	default:
+		return fmt.Errorf("denied_status: must be 401 or 403, got %d", c.DeniedStatus)
+	}
 	return nil
 }
 
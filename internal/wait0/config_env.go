@@ -0,0 +1,91 @@
+package wait0
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envRef matches ${VAR} and ${VAR:-default} references in config values, and
+// the $${ escape that keeps a literal "${".
+var envRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces environment references in every scalar value under n.
+// Expansion runs on parsed values rather than the raw file, so a variable
+// holding ':' or '#' cannot change the YAML structure, and keys and comments
+// are left alone. path names n in errors, e.g. "rules[0].origin".
+func expandEnv(n *yaml.Node, path string) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			if err := expandEnv(c, path); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			if err := expandEnv(c, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			if err := expandEnv(n.Content[i+1], key); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(n.Value, "${") {
+			return nil
+		}
+		v, err := expandEnvValue(n.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		n.Value = v
+		if n.Style == 0 {
+			// Let a plain "${PORT}" resolve to the type of what it expanded
+			// to, as if that had been written in the file.
+			n.Tag = ""
+		}
+	}
+	// Aliases share their anchor's node, which is expanded where it is
+	// defined.
+	return nil
+}
+
+// expandEnvValue expands the references in one value. A variable that is
+// unset or empty takes its default; one that is unset without a default is an
+// error.
+func expandEnvValue(s string) (string, error) {
+	var err error
+	out := envRef.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$${" {
+			return "${"
+		}
+		sub := envRef.FindStringSubmatch(m)
+		name, hasDefault, def := sub[1], sub[2] != "", sub[3]
+		v, ok := os.LookupEnv(name)
+		switch {
+		case v != "":
+			return v
+		case hasDefault:
+			return def
+		case !ok && err == nil:
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package wait0
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnvValue(t *testing.T) {
+	t.Setenv("WAIT0_T_SET", "value")
+	t.Setenv("WAIT0_T_EMPTY", "")
+
+	cases := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "plain", want: "plain"},
+		{in: "${WAIT0_T_SET}", want: "value"},
+		{in: "http://${WAIT0_T_SET}:8080/${WAIT0_T_SET}", want: "http://value:8080/value"},
+		{in: "${WAIT0_T_UNSET:-fallback}", want: "fallback"},
+		{in: "${WAIT0_T_EMPTY:-fallback}", want: "fallback"},
+		{in: "${WAIT0_T_UNSET:-}", want: ""},
+		{in: "${WAIT0_T_EMPTY}", want: ""},
+		{in: "$${WAIT0_T_SET}", want: "${WAIT0_T_SET}"},
+		{in: "$WAIT0_T_SET", want: "$WAIT0_T_SET"},
+		{in: "${WAIT0_T_UNSET}", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := expandEnvValue(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("expandEnvValue(%q) err = %v, wantErr %v", tc.in, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Fatalf("expandEnvValue(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLoadConfig_ExpandsEnv(t *testing.T) {
+	t.Setenv("WAIT0_T_ORIGIN", "http://origin.internal:3000")
+	t.Setenv("WAIT0_T_PORT", "9090")
+	t.Setenv("WAIT0_T_TOKEN", "s3cr3t # not a comment")
+	cfgPath := filepath.Join(t.TempDir(), "wait0.yaml")
+	yaml := `storage:
+  ram: {max: "${WAIT0_T_RAM:-1m}"}
+  disk: {max: "1m"}
+server:
+  port: ${WAIT0_T_PORT}
+  origin: ${WAIT0_T_ORIGIN}
+auth:
+  tokens:
+    - id: "api"
+      token: "${WAIT0_T_TOKEN}"
+      scopes: ["stats:read"]
+rules:
+  - match: "PathPrefix(/)" # ${WAIT0_T_IN_COMMENT} is not expanded
+`
+	if err := os.WriteFile(cfgPath, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Server.Origin != "http://origin.internal:3000" || cfg.Server.Port != 9090 || cfg.Storage.RAM.Max != "1m" {
+		t.Fatalf("origin=%q port=%d ram.max=%q", cfg.Server.Origin, cfg.Server.Port, cfg.Storage.RAM.Max)
+	}
+	if got := cfg.Auth.Tokens[0].Token; got != "s3cr3t # not a comment" {
+		t.Fatalf("token = %q", got)
+	}
+}
+
+func TestLoadConfig_UnsetEnvNamesKey(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "wait0.yaml")
+	yaml := "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/api)\"\n    origin: \"${WAIT0_T_API_ORIGIN}\"\n"
+	if err := os.WriteFile(cfgPath, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	_, err := LoadConfig(cfgPath)
+	if err == nil || !strings.HasPrefix(err.Error(), "rules[0].origin:") || !strings.Contains(err.Error(), "WAIT0_T_API_ORIGIN") {
+		t.Fatalf("err = %v, want one naming rules[0].origin and the variable", err)
+	}
+}
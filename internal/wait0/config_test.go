@@ -3,6 +3,7 @@ package wait0
 import (
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -14,28 +15,94 @@ func TestLoadConfig_ValidAndCompiledFields(t *testing.T) {
 	yaml := `storage:
   ram:
     max: "64m"
+    flushOnShutdown: "5s"
+    promoteAfterHits: 3
+    promoteWindow: "30s"
+    maxEntryPercent: 10
   disk:
     max: "1g"
+    minFree: "512m"
+    maxConcurrentReads: 16
+    compactAfter: "256m"
+    maxEntryPercent: 25
+  audit:
+    every: "5m"
+    sampleSize: 50
+    reconcile: true
+  maxCacheableBytes: "8m"
 server:
   port: 8082
   origin: "http://localhost:3000/"
+  readOnly: true
+  defaultContentType: " application/octet-stream "
+  sniffContentType: true
+  forwardedHeaders: true
+  preserveHost: true
+  healthPath: "/_health"
+  shutdownTimeout: "25s"
+  originTimeout: "20s"
+  originRetries: 2
+  originRetryBackoff: "50ms"
+  upstream:
+    acceptEncoding: "GZIP"
+    maxHeaderValue: "16k"
+    traceConnections: true
+    timeout: "10s"
+  transport:
+    maxIdleConns: 200
+    maxIdleConnsPerHost: 100
+    idleConnTimeout: "2m"
+    keepAlive: "15s"
 urlsDiscover:
   initalDelay: "2s"
   rediscoverEvery: "1m"
+  incremental: true
+  maxSeeded: 5000
+  seedTTL: "72h"
   sitemaps:
     - "/sitemap.xml"
 logging:
+  level: "warn"
   log_stats_every: "10s"
+  event_webhook: "https://hooks.example.com/wait0"
+  stats_file: "/var/lib/wait0/stats.json"
+  stats_flush_every: "30s"
+debug:
+  originDelay: "250ms"
+  responseHeaders: true
 rules:
   - match: "PathPrefix(/admin)"
     priority: 2
     bypass: true
+  - match: "PathPrefix(/feed)"
+    priority: 3
+    streamable: true
+    cacheKeyQuery: [" page ", "sort"]
   - match: "PathPrefix(/)"
     priority: 1
     expiration: "30s"
+    maxAge: "10m"
+    staleIfError: "1h"
+    originTimeout: "2m"
+    origin: " https://static.example.com/ "
+    expirationByStatus: {200: "1h", 301: "24h", 404: "30s"}
+    negativeCache: {404: "30s", "5xx": "5s"}
+    responseCacheControl: " no-store "
+    cacheWithSetCookie: true
+    cacheOptions: true
+    cacheMethods: [get, POST]
+    cacheBodyMax: "16k"
+    maxBodyBytes: "2m"
+    tier: "RAM"
+    varyBy: ["accept"]
+    ignoreQuery: true
     warmUp:
       runEvery: "1m"
       maxRequestsAtATime: 3
+      maxRequestsPerRun: 500
+      rampUp: "5m"
+      schedule: "22:00-04:00"
+      timezone: "UTC"
 `
 	if err := os.WriteFile(cfgPath, []byte(yaml), 0o644); err != nil {
 		t.Fatalf("WriteFile: %v", err)
@@ -49,6 +116,15 @@ rules:
 	if cfg.Server.Origin != "http://localhost:3000" {
 		t.Fatalf("origin = %q", cfg.Server.Origin)
 	}
+	if cfg.Logging.Level != "warn" {
+		t.Fatalf("logging.level = %q", cfg.Logging.Level)
+	}
+	if cfg.Logging.EventWebhook != "https://hooks.example.com/wait0" {
+		t.Fatalf("logging.event_webhook = %q", cfg.Logging.EventWebhook)
+	}
+	if cfg.Logging.StatsFile != "/var/lib/wait0/stats.json" || cfg.Logging.statsFlushEveryDur != 30*time.Second {
+		t.Fatalf("stats persistence = %q every %s", cfg.Logging.StatsFile, cfg.Logging.statsFlushEveryDur)
+	}
 	if cfg.Logging.logStatsEveryDur != 10*time.Second {
 		t.Fatalf("logStatsEveryDur = %s", cfg.Logging.logStatsEveryDur)
 	}
@@ -58,7 +134,13 @@ rules:
 	if cfg.URLsDiscover.rediscoverEveryDur != time.Minute {
 		t.Fatalf("rediscoverEveryDur = %s", cfg.URLsDiscover.rediscoverEveryDur)
 	}
-	if len(cfg.Rules) != 2 {
+	if cfg.URLsDiscover.seedTTLDur != 72*time.Hour {
+		t.Fatalf("seedTTLDur = %s", cfg.URLsDiscover.seedTTLDur)
+	}
+	if !cfg.URLsDiscover.Incremental {
+		t.Fatalf("urlsDiscover.incremental = false, want true")
+	}
+	if len(cfg.Rules) != 3 {
 		t.Fatalf("rules = %d", len(cfg.Rules))
 	}
 	if cfg.Rules[0].Priority != 1 {
@@ -67,9 +149,129 @@ rules:
 	if cfg.Rules[0].expDur != 30*time.Second {
 		t.Fatalf("expiration = %s", cfg.Rules[0].expDur)
 	}
-	if cfg.Rules[0].warmEvery != time.Minute || cfg.Rules[0].warmMax != 3 {
+	if cfg.Rules[0].warmEvery != time.Minute || cfg.Rules[0].warmMax != 3 || cfg.Rules[0].warmPerRun != 500 {
 		t.Fatalf("warmup compiled fields not set")
 	}
+	if cfg.Rules[0].tier != "ram" || cfg.Rules[1].tier != "both" {
+		t.Fatalf("tiers = %q/%q, want ram/both", cfg.Rules[0].tier, cfg.Rules[1].tier)
+	}
+	if cfg.Storage.Disk.minFreeBytes != 512*1024*1024 {
+		t.Fatalf("minFreeBytes = %d", cfg.Storage.Disk.minFreeBytes)
+	}
+	if cfg.Storage.maxCacheable != 8*1024*1024 {
+		t.Fatalf("maxCacheable = %d", cfg.Storage.maxCacheable)
+	}
+	if got := cfg.Rules[0].expByStatus; len(got) != 3 || got[200] != time.Hour || got[301] != 24*time.Hour || got[404] != 30*time.Second {
+		t.Fatalf("expirationByStatus = %v", got)
+	}
+	if cfg.Storage.RAM.flushOnShutdownDur != 5*time.Second {
+		t.Fatalf("flushOnShutdownDur = %s", cfg.Storage.RAM.flushOnShutdownDur)
+	}
+	if cfg.Debug.originDelayDur != 250*time.Millisecond || cfg.Debug.responseDelayDur != 0 {
+		t.Fatalf("debug delays = %v/%v", cfg.Debug.originDelayDur, cfg.Debug.responseDelayDur)
+	}
+	if cfg.Rules[0].ResponseCacheControl != "no-store" {
+		t.Fatalf("responseCacheControl = %q", cfg.Rules[0].ResponseCacheControl)
+	}
+	if n := cfg.Rules[0].negTTL; len(n) != 2 || n[404] != 30*time.Second || n[5] != 5*time.Second {
+		t.Fatalf("negTTL = %v", n)
+	}
+	if !cfg.Debug.ResponseHeaders {
+		t.Fatalf("debug.responseHeaders not parsed")
+	}
+	if !cfg.Rules[0].CacheOptions || cfg.Rules[1].CacheOptions {
+		t.Fatalf("cacheOptions = %v/%v", cfg.Rules[0].CacheOptions, cfg.Rules[1].CacheOptions)
+	}
+	if !cfg.Rules[0].CacheWithSetCookie || cfg.Rules[1].CacheWithSetCookie {
+		t.Fatalf("cacheWithSetCookie = %v/%v", cfg.Rules[0].CacheWithSetCookie, cfg.Rules[1].CacheWithSetCookie)
+	}
+	if w := cfg.Rules[0].warmWindow; w.Start != 22*60 || w.End != 4*60 || w.Loc != time.UTC {
+		t.Fatalf("warmWindow = %+v", w)
+	}
+	if cfg.Rules[0].warmRamp != 5*time.Minute {
+		t.Fatalf("warmRamp = %v", cfg.Rules[0].warmRamp)
+	}
+	if cfg.Rules[0].originTimeoutDur != 2*time.Minute || cfg.Rules[1].originTimeoutDur != 0 {
+		t.Fatalf("originTimeout = %v/%v", cfg.Rules[0].originTimeoutDur, cfg.Rules[1].originTimeoutDur)
+	}
+	if cfg.Rules[0].Origin != "https://static.example.com" || cfg.Rules[1].Origin != "" {
+		t.Fatalf("rule origins = %q/%q", cfg.Rules[0].Origin, cfg.Rules[1].Origin)
+	}
+	if got := cfg.originFor("/page"); got != "https://static.example.com" {
+		t.Fatalf("originFor with rule origin = %q", got)
+	}
+	if got := (&Config{Server: cfg.Server}).originFor("/page"); got != "http://localhost:3000" {
+		t.Fatalf("originFor without rules = %q", got)
+	}
+	if cfg.Rules[0].staleErrDur != time.Hour || cfg.Rules[1].staleErrDur != 0 {
+		t.Fatalf("staleIfError = %v/%v", cfg.Rules[0].staleErrDur, cfg.Rules[1].staleErrDur)
+	}
+	if cfg.Rules[0].maxAgeDur != 10*time.Minute || cfg.Rules[1].maxAgeDur != 0 {
+		t.Fatalf("maxAge = %v/%v", cfg.Rules[0].maxAgeDur, cfg.Rules[1].maxAgeDur)
+	}
+	if cfg.Storage.Disk.MaxConcurrentReads != 16 {
+		t.Fatalf("maxConcurrentReads = %d", cfg.Storage.Disk.MaxConcurrentReads)
+	}
+	if a := cfg.Storage.Audit; a.everyDur != 5*time.Minute || a.SampleSize != 50 || !a.Reconcile {
+		t.Fatalf("audit = %+v", a)
+	}
+	if cfg.Storage.RAM.PromoteAfterHits != 3 || cfg.Storage.RAM.promoteWindowDur != 30*time.Second {
+		t.Fatalf("promotion = %d/%v", cfg.Storage.RAM.PromoteAfterHits, cfg.Storage.RAM.promoteWindowDur)
+	}
+	if cfg.Storage.RAM.MaxEntryPercent != 10 || cfg.Storage.Disk.MaxEntryPercent != 25 {
+		t.Fatalf("maxEntryPercent ram=%d disk=%d", cfg.Storage.RAM.MaxEntryPercent, cfg.Storage.Disk.MaxEntryPercent)
+	}
+	if cfg.Storage.Disk.compactAfterBytes != 256*1024*1024 {
+		t.Fatalf("compactAfterBytes = %d", cfg.Storage.Disk.compactAfterBytes)
+	}
+	if tc := cfg.Server.Transport; tc.MaxIdleConns != 200 || tc.MaxIdleConnsPerHost != 100 || tc.idleConnTimeoutDur != 2*time.Minute || tc.keepAliveDur != 15*time.Second {
+		t.Fatalf("transport = %+v", tc)
+	}
+	if !cfg.Server.Upstream.TraceConnections {
+		t.Fatalf("traceConnections not parsed")
+	}
+	if cfg.Server.originTimeoutDur != 20*time.Second {
+		t.Fatalf("originTimeoutDur = %s, want server.originTimeout over upstream.timeout", cfg.Server.originTimeoutDur)
+	}
+	if cfg.Server.DefaultContentType != "application/octet-stream" || !cfg.Server.SniffContentType || !cfg.Server.ForwardedHeaders || !cfg.Server.PreserveHost {
+		t.Fatalf("content type defaults = %q sniff=%v", cfg.Server.DefaultContentType, cfg.Server.SniffContentType)
+	}
+	if !cfg.Server.ReadOnly {
+		t.Fatalf("readOnly not parsed")
+	}
+	if cfg.Server.OriginRetries != 2 || cfg.Server.originRetryBackoffDur != 50*time.Millisecond {
+		t.Fatalf("origin retries = %d/%v", cfg.Server.OriginRetries, cfg.Server.originRetryBackoffDur)
+	}
+	if cfg.Server.shutdownTimeoutDur != 25*time.Second {
+		t.Fatalf("shutdownTimeoutDur = %s", cfg.Server.shutdownTimeoutDur)
+	}
+	if cfg.Server.HealthPath != "/_health" {
+		t.Fatalf("healthPath = %q", cfg.Server.HealthPath)
+	}
+	if cfg.Server.Upstream.maxHeaderValueBytes != 16*1024 {
+		t.Fatalf("maxHeaderValueBytes = %d", cfg.Server.Upstream.maxHeaderValueBytes)
+	}
+	if cfg.Server.Upstream.AcceptEncoding != "gzip" {
+		t.Fatalf("upstream acceptEncoding = %q, want gzip", cfg.Server.Upstream.AcceptEncoding)
+	}
+	if len(cfg.Rules[0].varyBy) != 1 || cfg.Rules[0].varyBy[0] != "Accept" {
+		t.Fatalf("varyBy = %v, want [Accept]", cfg.Rules[0].varyBy)
+	}
+	if !cfg.Rules[0].IgnoreQuery || cfg.Rules[1].IgnoreQuery {
+		t.Fatalf("ignoreQuery = %v/%v, want true/false", cfg.Rules[0].IgnoreQuery, cfg.Rules[1].IgnoreQuery)
+	}
+	if got := cfg.Rules[2].keyQuery; len(got) != 2 || got[0] != "page" || got[1] != "sort" {
+		t.Fatalf("cacheKeyQuery = %v, want [page sort]", got)
+	}
+	if !cfg.Rules[0].cachePost || cfg.Rules[0].bodyMax != 16<<10 || cfg.Rules[1].cachePost || cfg.Rules[1].bodyMax != defaultCacheBodyMax {
+		t.Fatalf("cachePost = %v/%v bodyMax = %d/%d", cfg.Rules[0].cachePost, cfg.Rules[1].cachePost, cfg.Rules[0].bodyMax, cfg.Rules[1].bodyMax)
+	}
+	if cfg.Rules[0].maxBody != 2<<20 || cfg.Rules[1].maxBody != 0 {
+		t.Fatalf("maxBody = %d/%d", cfg.Rules[0].maxBody, cfg.Rules[1].maxBody)
+	}
+	if cfg.Rules[2].streamMax != defaultStreamBufferMax || cfg.Rules[0].streamMax != 0 {
+		t.Fatalf("streamMax = %d/%d", cfg.Rules[2].streamMax, cfg.Rules[0].streamMax)
+	}
 }
 
 func TestLoadConfig_Errors(t *testing.T) {
@@ -78,8 +280,80 @@ func TestLoadConfig_Errors(t *testing.T) {
 		yaml string
 	}{
 		{name: "missing origin", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  port: 8080\nrules: []\n"},
+		{name: "bad upstream encoding", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  upstream:\n    acceptEncoding: \"br\"\nrules: []\n"},
 		{name: "bad match", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"BadExpr(/)\"\n"},
 		{name: "bad warmup", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    warmUp:\n      runEvery: \"\"\n      maxRequestsAtATime: 1\n"},
+		{name: "bad tier", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    tier: \"tape\"\n"},
+		{name: "bad stream buffer", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    streamable: true\n    streamBufferMax: \"lots\"\n"},
+		{name: "bad host template", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\ncacheKey:\n  hostTemplate: \"([a-z\"\nrules: []\n"},
+		{name: "negative disk reads", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\", maxConcurrentReads: -1}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "bad debug origin delay", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\ndebug:\n  originDelay: \"soon\"\nrules: []\n"},
+		{name: "negative debug response delay", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\ndebug:\n  responseDelay: \"-1s\"\nrules: []\n"},
+		{name: "bad rule origin timeout", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    originTimeout: \"0s\"\n"},
+		{name: "bad rule stale if error", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    staleIfError: \"-1h\"\n"},
+		{name: "bad server origin", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"localhost:3000\"\nrules: []\n"},
+		{name: "bad rule regexp", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathRegexp(^/products/[0-9+$)\"\n"},
+		{name: "bad rule glob", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathGlob(/assets/[a-.js)\"\n"},
+		{name: "relative exact path", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"Path(healthz)\"\n"},
+		{name: "bad cache method", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    cacheMethods: [GET, PUT]\n"},
+		{name: "cache methods without get", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    cacheMethods: [POST]\n"},
+		{name: "bad cache body max", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    cacheMethods: [GET, POST]\n    cacheBodyMax: \"0\"\n"},
+		{name: "bad rule max body bytes", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    maxBodyBytes: \"huge\"\n"},
+		{name: "unknown matcher", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"Host(example.com)\"\n"},
+		{name: "bad rule origin", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/api)\"\n    origin: \"ftp://api.local\"\n"},
+		{name: "bad rule max age", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    maxAge: \"0s\"\n"},
+		{name: "negative warmup budget", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    warmUp: {runEvery: \"1m\", maxRequestsAtATime: 2, maxRequestsPerRun: -1}\n"},
+		{name: "negative warmup ramp", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    warmUp: {runEvery: \"1m\", maxRequestsAtATime: 2, rampUp: \"-1m\"}\n"},
+		{name: "bad warmup schedule", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    warmUp: {runEvery: \"1m\", maxRequestsAtATime: 2, schedule: \"1am-5am\"}\n"},
+		{name: "empty warmup schedule", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    warmUp: {runEvery: \"1m\", maxRequestsAtATime: 2, schedule: \"01:00-01:00\"}\n"},
+		{name: "bad warmup timezone", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    warmUp: {runEvery: \"1m\", maxRequestsAtATime: 2, schedule: \"01:00-05:00\", timezone: \"Mars/Olympus\"}\n"},
+		{name: "warmup timezone without schedule", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    warmUp: {runEvery: \"1m\", maxRequestsAtATime: 2, timezone: \"UTC\"}\n"},
+		{name: "bad default content type", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  defaultContentType: \"text/\"\n"},
+		{name: "negative transport idle conns", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  transport:\n    maxIdleConnsPerHost: -1\nrules: []\n"},
+		{name: "bad transport idle timeout", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  transport:\n    idleConnTimeout: \"0s\"\n"},
+		{name: "bad transport keep alive", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  transport:\n    keepAlive: \"soon\"\n"},
+		{name: "bad origin header name", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  originHeaders:\n    \"X Token\": \"a\"\n"},
+		{name: "origin header host", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  originHeaders:\n    host: \"a\"\n"},
+		{name: "user agent with newline", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  userAgent: \"a\\nX-Injected: 1\"\n"},
+		{name: "missing tls ca file", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"https://x\"\n  tls:\n    caFile: \"/nonexistent/ca.pem\"\n"},
+		{name: "bad upstream max header value", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  upstream:\n    maxHeaderValue: \"0\"\nrules: []\n"},
+		{name: "cache key query with ignore query", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    ignoreQuery: true\n    cacheKeyQuery: [page]\n"},
+		{name: "empty cache key query param", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    cacheKeyQuery: [\" \"]\n"},
+		{name: "negative ram flush on shutdown", yaml: "storage:\n  ram: {max: \"1m\", flushOnShutdown: \"-1s\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "bad expiration by status code", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    expirationByStatus: {99: \"1m\"}\n"},
+		{name: "bad expiration by status duration", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    expirationByStatus: {404: \"0s\"}\n"},
+		{name: "negative max sitemaps", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nurlsDiscover:\n  maxSitemaps: -1\nrules: []\n"},
+		{name: "negative max urls", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nurlsDiscover:\n  maxURLs: -1\nrules: []\n"},
+		{name: "negative max seeded", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nurlsDiscover:\n  maxSeeded: -1\nrules: []\n"},
+		{name: "bad log level", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nlogging:\n  level: \"loud\"\nrules: []\n"},
+		{name: "bad max cacheable bytes", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\n  maxCacheableBytes: \"huge\"\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "zero max cacheable bytes", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\n  maxCacheableBytes: \"0\"\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "zero shutdown timeout", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  shutdownTimeout: \"0s\"\nrules: []\n"},
+		{name: "bad shutdown timeout", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  shutdownTimeout: \"soon\"\nrules: []\n"},
+		{name: "relative health path", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  healthPath: \"healthz\"\nrules: []\n"},
+		{name: "negative promote after hits", yaml: "storage:\n  ram: {max: \"1m\", promoteAfterHits: -1}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "short promote window", yaml: "storage:\n  ram: {max: \"1m\", promoteWindow: \"500ms\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "negative cache 2xx status", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    negativeCache: {200: \"5s\"}\n"},
+		{name: "negative cache bad class", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    negativeCache: {\"2xx\": \"5s\"}\n"},
+		{name: "negative cache zero ttl", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules:\n  - match: \"PathPrefix(/)\"\n    negativeCache: {404: \"0s\"}\n"},
+		{name: "bad audit every", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\n  audit: {every: \"0s\"}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "negative audit sample", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\n  audit: {every: \"1m\", sampleSize: -1}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "too many origin retries", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  originRetries: 11\nrules: []\n"},
+		{name: "bad origin retry backoff", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  originRetryBackoff: \"-1s\"\nrules: []\n"},
+		{name: "ram max entry percent over 100", yaml: "storage:\n  ram: {max: \"1m\", maxEntryPercent: 101}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "negative disk max entry percent", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\", maxEntryPercent: -1}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "bad disk compact after", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\", compactAfter: \"often\"}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "bad origin timeout", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  originTimeout: \"soon\"\nrules: []\n"},
+		{name: "zero origin timeout", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  originTimeout: \"0s\"\nrules: []\n"},
+		{name: "bad upstream timeout", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  upstream: {timeout: \"soon\"}\nrules: []\n"},
+		{name: "zero upstream timeout", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  upstream: {timeout: \"0s\"}\nrules: []\n"},
+		{name: "bad seed ttl", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nurlsDiscover:\n  sitemaps: [\"/s.xml\"]\n  seedTTL: \"-1h\"\nrules: []\n"},
+		{name: "relative event webhook", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nlogging:\n  event_webhook: \"/hook\"\nrules: []\n"},
+		{name: "bad auth denied status", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nauth:\n  denied_status: 404\nrules: []\n"},
+		{name: "bad disk min free", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\", minFree: \"plenty\"}\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "bad default expiration", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\n  defaultExpiration: \"soon\"\nserver:\n  origin: \"http://x\"\nrules: []\n"},
+		{name: "stats flush without file", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nlogging:\n  stats_flush_every: \"1m\"\nrules: []\n"},
+		{name: "bad stats flush", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nlogging:\n  stats_file: \"s.json\"\n  stats_flush_every: \"0s\"\nrules: []\n"},
 		{name: "bad log stats", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\nlogging:\n  log_stats_every: \"bad\"\nrules: []\n"},
 		{name: "duplicate auth token ids", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  invalidation:\n    enabled: true\nauth:\n  tokens:\n    - id: \"dup\"\n      token: \"a\"\n      scopes: [\"invalidation:write\"]\n    - id: \"dup\"\n      token: \"b\"\n      scopes: [\"invalidation:write\"]\nrules: []\n"},
 		{name: "invalidation enabled without auth scope", yaml: "storage:\n  ram: {max: \"1m\"}\n  disk: {max: \"1m\"}\nserver:\n  origin: \"http://x\"\n  invalidation:\n    enabled: true\nauth:\n  tokens:\n    - id: \"x\"\n      token: \"t\"\n      scopes: [\"other:scope\"]\nrules: []\n"},
@@ -98,6 +372,39 @@ func TestLoadConfig_Errors(t *testing.T) {
 	}
 }
 
+func TestParseMatch_Kinds(t *testing.T) {
+	cases := []struct {
+		expr string
+		yes  []string
+		no   []string
+	}{
+		{expr: "PathPrefix(/blog)", yes: []string{"/blog", "/blog/x"}, no: []string{"/", "/products/1"}},
+		{expr: "PathRegexp(^/products/[0-9]+$)", yes: []string{"/products/42"}, no: []string{"/products/42/reviews", "/products/x"}},
+		{expr: "PathPrefix(/blog) | PathRegexp(^/products/[0-9]+$)", yes: []string{"/blog/a", "/products/7"}, no: []string{"/products/"}},
+		{expr: "PathRegexp(^/(en|de)/docs/)", yes: []string{"/en/docs/a", "/de/docs/"}, no: []string{"/fr/docs/a"}},
+		{expr: "PathRegexp(^/a\\(([0-9]|[)])$)", yes: []string{"/a(1", "/a()"}, no: []string{"/a(x"}},
+		{expr: "Path(/healthz)", yes: []string{"/healthz"}, no: []string{"/healthz/", "/healthzz", "/"}},
+		{expr: "PathGlob(/assets/*.js)", yes: []string{"/assets/app.js"}, no: []string{"/assets/js/app.js", "/assets/app.css"}},
+		{expr: "PathGlob(/img/[a-c]?.png) | Path(/favicon.ico)", yes: []string{"/img/b1.png", "/favicon.ico"}, no: []string{"/img/d1.png"}},
+	}
+	for _, tc := range cases {
+		m, err := parseMatch(tc.expr)
+		if err != nil {
+			t.Fatalf("parseMatch(%q): %v", tc.expr, err)
+		}
+		for _, p := range tc.yes {
+			if !m.Match(p) {
+				t.Fatalf("%s should match %q", tc.expr, p)
+			}
+		}
+		for _, p := range tc.no {
+			if m.Match(p) {
+				t.Fatalf("%s should not match %q", tc.expr, p)
+			}
+		}
+	}
+}
+
 func TestLoadConfig_AuthTokenEnvOverride(t *testing.T) {
 	t.Setenv("WAIT0_INV_TOKEN", "from-env-token")
 
@@ -116,6 +423,7 @@ server:
     max_paths_per_request: 10
     max_tags_per_request: 10
 auth:
+  denied_status: 401
   tokens:
     - id: "backoffice"
       token: "from-file"
@@ -137,6 +445,9 @@ rules: []
 	if got := cfg.Auth.Tokens[0].Token; got != "from-env-token" {
 		t.Fatalf("token = %q, want from-env-token", got)
 	}
+	if cfg.Auth.DeniedStatus != 401 {
+		t.Fatalf("denied_status = %d, want 401", cfg.Auth.DeniedStatus)
+	}
 }
 
 func TestLoadConfig_LegacyInvalidationTokensStillSupported(t *testing.T) {
@@ -173,3 +484,111 @@ rules: []
 		t.Fatalf("legacy scopes = %#v", cfg.Auth.Tokens[0].Scopes)
 	}
 }
+
+func TestLoadConfig_LegacyUpstreamTimeoutStillSupported(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "wait0.yaml")
+	yaml := strings.TrimSpace(`
+storage:
+  ram: {max: "1m"}
+  disk: {max: "1m"}
+server:
+  origin: "http://x"
+  upstream: {timeout: "10s"}
+rules: []
+`) + "\n"
+	if err := os.WriteFile(cfgPath, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Server.originTimeoutDur != 10*time.Second {
+		t.Fatalf("originTimeoutDur = %s, want upstream.timeout when originTimeout is unset", cfg.Server.originTimeoutDur)
+	}
+}
+
+func TestLoadConfig_DefaultExpiration(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "wait0.yaml")
+	yaml := strings.TrimSpace(`
+storage:
+  ram: {max: "1m"}
+  disk: {max: "1m"}
+  defaultExpiration: "5m"
+server:
+  origin: "http://x"
+rules:
+  - match: "PathPrefix(/own)"
+    priority: 1
+    expiration: "10s"
+  - match: "PathPrefix(/)"
+    priority: 2
+`) + "\n"
+	if err := os.WriteFile(cfgPath, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Storage.defaultExpDur != 5*time.Minute {
+		t.Fatalf("defaultExpDur = %s", cfg.Storage.defaultExpDur)
+	}
+	if cfg.Server.originTimeoutDur != defaultOriginTimeout {
+		t.Fatalf("originTimeoutDur = %s, want default", cfg.Server.originTimeoutDur)
+	}
+	if cfg.Auth.DeniedStatus != 403 {
+		t.Fatalf("denied_status = %d, want 403 by default", cfg.Auth.DeniedStatus)
+	}
+	if cfg.Server.OriginRetries != 0 || cfg.Server.originRetryBackoffDur != defaultOriginRetryBackoff {
+		t.Fatalf("origin retries = %d/%v, want defaults", cfg.Server.OriginRetries, cfg.Server.originRetryBackoffDur)
+	}
+	if cfg.Logging.statsFlushEveryDur != defaultStatsFlushEvery {
+		t.Fatalf("default stats flush = %s", cfg.Logging.statsFlushEveryDur)
+	}
+	if cfg.Server.shutdownTimeoutDur != defaultShutdownTimeout {
+		t.Fatalf("shutdownTimeoutDur = %s, want default", cfg.Server.shutdownTimeoutDur)
+	}
+	if cfg.Storage.Audit.everyDur != 0 || cfg.Storage.Audit.SampleSize != defaultAuditSampleSize {
+		t.Fatalf("audit = %+v, want disabled with the default sample size", cfg.Storage.Audit)
+	}
+	if cfg.Storage.RAM.promoteWindowDur != defaultPromoteWindow {
+		t.Fatalf("promoteWindowDur = %s, want default", cfg.Storage.RAM.promoteWindowDur)
+	}
+	if cfg.Server.HealthPath != DefaultHealthPath {
+		t.Fatalf("healthPath = %q, want default", cfg.Server.HealthPath)
+	}
+	if cfg.Rules[0].expDur != 10*time.Second {
+		t.Fatalf("own expiration = %s, want 10s", cfg.Rules[0].expDur)
+	}
+	if cfg.Rules[1].expDur != 5*time.Minute {
+		t.Fatalf("inherited expiration = %s, want 5m", cfg.Rules[1].expDur)
+	}
+}
+
+func TestConfig_HostKey(t *testing.T) {
+	var cfg Config
+	if got := cfg.hostKey("acme.example.com"); got != "" {
+		t.Fatalf("no template host key = %q", got)
+	}
+
+	cfg.CacheKey.hostRe = regexp.MustCompile(`^([^.]+)\.example\.com$`)
+	tests := map[string]string{
+		"acme.example.com":      "acme",
+		"ACME.example.com:8080": "acme",
+		"example.com":           "",
+		"acme.other.com":        "",
+	}
+	for host, want := range tests {
+		if got := cfg.hostKey(host); got != want {
+			t.Fatalf("hostKey(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	cfg.CacheKey.hostRe = regexp.MustCompile(`[a-z]+`)
+	if got := cfg.hostKey("tenant.example.com"); got != "tenant" {
+		t.Fatalf("whole-match host key = %q", got)
+	}
+}
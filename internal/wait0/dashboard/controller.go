@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -16,6 +15,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"wait0/internal/wait0/logging"
 )
 
 const EndpointPath = "/wait0/dashboard"
@@ -103,7 +104,7 @@ func (c *Controller) Handle(w http.ResponseWriter, r *http.Request) {
 
 	principal, ok := c.authenticatedPrincipal(r)
 	if !ok {
-		log.Printf("dashboard auth failed: remote=%q method=%s path=%s", strings.TrimSpace(r.RemoteAddr), r.Method, r.URL.Path)
+		logging.Warnf("dashboard auth failed: remote=%q method=%s path=%s", strings.TrimSpace(r.RemoteAddr), r.Method, r.URL.Path)
 		writeBasicUnauthorized(w)
 		return
 	}
@@ -0,0 +1,32 @@
+package wait0
+
+import (
+	"context"
+	"time"
+
+	"wait0/internal/wait0/logging"
+)
+
+// warnDebug logs a warning when cfg injects artificial latency or exposes
+// debug response headers.
+func warnDebug(cfg *Config) {
+	if cfg.Debug.originDelayDur > 0 || cfg.Debug.responseDelayDur > 0 {
+		logging.Warnf("WARNING: debug delays active (originDelay=%s responseDelay=%s); do not use in production", cfg.Debug.originDelayDur, cfg.Debug.responseDelayDur)
+	}
+	if cfg.Debug.ResponseHeaders {
+		logging.Warnf("WARNING: debug.responseHeaders active; cache keys and rules are exposed to clients, do not use in production")
+	}
+}
+
+// debugSleep blocks for d, returning early if ctx ends first.
+func debugSleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
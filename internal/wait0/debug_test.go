@@ -0,0 +1,46 @@
+package wait0
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDebugSleep_StopsOnContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	debugSleep(ctx, time.Hour)
+	if time.Since(start) > time.Second {
+		t.Fatalf("debugSleep ignored canceled context")
+	}
+}
+
+func TestHandle_DebugDelays(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, []Rule{mustRule(t, "PathPrefix(/)")})
+	cfg := s.config()
+	cfg.Debug.originDelayDur = 60 * time.Millisecond
+	cfg.Debug.responseDelayDur = 40 * time.Millisecond
+
+	serve := func() (string, time.Duration) {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/slow", nil))
+		return w.Result().Header.Get("X-Wait0"), time.Since(start)
+	}
+
+	if wait0, took := serve(); wait0 != "miss" || took < cfg.Debug.originDelayDur {
+		t.Fatalf("miss = %q after %v, want delay >= %v", wait0, took, cfg.Debug.originDelayDur)
+	}
+	if wait0, took := serve(); wait0 != "hit" || took < cfg.Debug.responseDelayDur {
+		t.Fatalf("hit = %q after %v, want delay >= %v", wait0, took, cfg.Debug.responseDelayDur)
+	}
+}
@@ -5,15 +5,20 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrRunning is returned by DiscoverOnce when another run is in progress.
+var ErrRunning = errors.New("discovery already running")
+
 type Logger interface {
 	Printf(format string, v ...any)
 }
@@ -24,6 +29,22 @@ type Config struct {
 	InitialDelay    time.Duration
 	RediscoverEvery time.Duration
 	LogAutodiscover bool
+	// FromRobots adds the Sitemap: entries of the origin's robots.txt to
+	// Sitemaps on every run.
+	FromRobots bool
+	// Incremental sends the previous run's validators with every sitemap
+	// fetch, skips sitemaps that come back 304, and only seeds URLs that were
+	// not listed in the sitemap on the previous run.
+	Incremental bool
+	// MaxSeeded caps how many inactive seeds are kept; zero disables the cap.
+	MaxSeeded int
+	// MaxSitemaps and MaxURLs bound one run: the sitemaps fetched and the
+	// sitemap URLs considered for seeding. Zero disables a bound.
+	MaxSitemaps int
+	MaxURLs     int
+	// SeedTTL deletes inactive seeds seeded longer ago than this; zero
+	// disables the sweep.
+	SeedTTL time.Duration
 }
 
 type Rule struct {
@@ -45,6 +66,10 @@ type Runtime interface {
 	PeekRAM(path string) (Entry, bool)
 	PeekDisk(path string) (Entry, bool)
 	PutDisk(path string, ent Entry)
+	// Seeds lists inactive seeded paths with the unix nanos they were seeded.
+	Seeds() map[string]int64
+	// DropSeed deletes path's entry if it is still an inactive seed.
+	DropSeed(path string) bool
 	Do(req *http.Request) (*http.Response, error)
 }
 
@@ -54,6 +79,29 @@ type Controller struct {
 	stopCh <-chan struct{}
 	wg     *sync.WaitGroup
 	logger Logger
+	// errorLog receives failures, skipped runs, and maxSeeded warnings;
+	// defaults to logger.
+	errorLog Logger
+
+	// running guards against overlapping runs; skipped counts rejected ones.
+	running atomic.Bool
+	skipped atomic.Uint64
+
+	// sitemaps holds per-sitemap state from the previous run for incremental
+	// discovery, keyed by sitemap URL.
+	mu       sync.Mutex
+	sitemaps map[string]*sitemapState
+}
+
+type sitemapState struct {
+	validators
+	nested []string
+	paths  map[string]struct{}
+}
+
+type validators struct {
+	etag         string
+	lastModified string
 }
 
 type SitemapDoc struct {
@@ -62,11 +110,16 @@ type SitemapDoc struct {
 }
 
 func NewController(cfg Config, rt Runtime, stopCh <-chan struct{}, wg *sync.WaitGroup, logger Logger) *Controller {
-	return &Controller{cfg: cfg, rt: rt, stopCh: stopCh, wg: wg, logger: logger}
+	return &Controller{cfg: cfg, rt: rt, stopCh: stopCh, wg: wg, logger: logger, errorLog: logger, sitemaps: map[string]*sitemapState{}}
+}
+
+// SetErrorLog routes failures and skipped runs to l instead of the main logger.
+func (c *Controller) SetErrorLog(l Logger) {
+	c.errorLog = l
 }
 
 func (c *Controller) Start() {
-	if len(c.cfg.Sitemaps) == 0 {
+	if len(c.cfg.Sitemaps) == 0 && !c.cfg.FromRobots {
 		return
 	}
 
@@ -89,32 +142,69 @@ func (c *Controller) Start() {
 			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 			defer cancel()
 			stored, ignored, err := c.DiscoverOnce(ctx)
+			if errors.Is(err, ErrRunning) {
+				return
+			}
 			if err != nil {
-				c.logger.Printf("urlsDiscover: error: %v", err)
+				c.errorLog.Printf("urlsDiscover: error: %v", err)
 				return
 			}
 			c.logger.Printf("urlsDiscover: stored=%d ignored=%d", stored, ignored)
 		}
 
 		runOnce()
-		if period <= 0 {
+
+		var discoverC, sweepC <-chan time.Time
+		if period > 0 {
+			t := time.NewTicker(period)
+			defer t.Stop()
+			discoverC = t.C
+		}
+		if c.cfg.SeedTTL > 0 {
+			t := time.NewTicker(seedSweepEvery(c.cfg.SeedTTL))
+			defer t.Stop()
+			sweepC = t.C
+		}
+		if discoverC == nil && sweepC == nil {
 			return
 		}
-
-		t := time.NewTicker(period)
-		defer t.Stop()
 		for {
 			select {
 			case <-c.stopCh:
 				return
-			case <-t.C:
+			case <-discoverC:
 				runOnce()
+			case now := <-sweepC:
+				c.SweepSeeds(now)
 			}
 		}
 	}()
 }
 
+// DiscoverOnce crawls the configured sitemaps and seeds unseen paths. Only one
+// run proceeds at a time; concurrent calls are skipped with ErrRunning.
 func (c *Controller) DiscoverOnce(ctx context.Context) (stored int, ignored int, _ error) {
+	if !c.running.CompareAndSwap(false, true) {
+		n := c.skipped.Add(1)
+		c.errorLog.Printf("urlsDiscover: skipped, previous run still in progress (skipped=%d)", n)
+		return 0, 0, ErrRunning
+	}
+	defer c.running.Store(false)
+
+	budget := c.newSeedBudget()
+	defer func() {
+		if budget.hit() {
+			c.errorLog.Printf("urlsDiscover: maxSeeded=%d reached: evicted=%d skipped=%d", c.cfg.MaxSeeded, budget.evicted, budget.capped)
+		}
+	}()
+
+	var fetched, urls, skippedSitemaps, skippedURLs int
+	defer func() {
+		if skippedSitemaps > 0 || skippedURLs > 0 {
+			c.errorLog.Printf("urlsDiscover: crawl limit reached (maxSitemaps=%d maxURLs=%d): skipped sitemaps=%d urls=%d", c.cfg.MaxSitemaps, c.cfg.MaxURLs, skippedSitemaps, skippedURLs)
+		}
+	}()
+
 	seenSitemaps := map[string]struct{}{}
 	queue := make([]string, 0, len(c.cfg.Sitemaps))
 	for _, sm := range c.cfg.Sitemaps {
@@ -124,6 +214,19 @@ func (c *Controller) DiscoverOnce(ctx context.Context) (stored int, ignored int,
 		}
 		queue = append(queue, c.NormalizeMaybeRelativeURL(sm))
 	}
+	if c.cfg.FromRobots {
+		sms, err := c.robotsSitemaps(ctx)
+		if err != nil {
+			if len(queue) == 0 {
+				return stored, ignored, fmt.Errorf("fetch robots.txt: %w", err)
+			}
+			c.errorLog.Printf("urlsDiscover: robots.txt: %v", err)
+		}
+		if c.cfg.LogAutodiscover {
+			c.logger.Printf("urlsDiscover robots.txt sitemaps=%d", len(sms))
+		}
+		queue = append(queue, sms...)
+	}
 
 	for len(queue) > 0 {
 		select {
@@ -140,11 +243,20 @@ func (c *Controller) DiscoverOnce(ctx context.Context) (stored int, ignored int,
 			continue
 		}
 		seenSitemaps[smURL] = struct{}{}
+		if (c.cfg.MaxSitemaps > 0 && fetched >= c.cfg.MaxSitemaps) || (c.cfg.MaxURLs > 0 && urls >= c.cfg.MaxURLs) {
+			skippedSitemaps++
+			continue
+		}
+		fetched++
 
-		doc, err := c.FetchAndParseSitemap(ctx, smURL)
+		doc, next, err := c.loadSitemap(ctx, smURL)
 		if err != nil {
 			return stored, ignored, fmt.Errorf("fetch sitemap %q: %w", smURL, err)
 		}
+		unchanged := c.cfg.Incremental && next == nil
+		if unchanged && c.cfg.LogAutodiscover {
+			c.logger.Printf("urlsDiscover sitemap=%q unchanged", smURL)
+		}
 
 		for _, nested := range doc.Sitemaps {
 			nested = strings.TrimSpace(nested)
@@ -156,7 +268,14 @@ func (c *Controller) DiscoverOnce(ctx context.Context) (stored int, ignored int,
 
 		fit := 0
 		ignoredThis := 0
+		cut := false
 		for _, loc := range doc.URLs {
+			if c.cfg.MaxURLs > 0 && urls >= c.cfg.MaxURLs {
+				skippedURLs++
+				cut = true
+				continue
+			}
+			urls++
 			path := NormalizePathFromLoc(loc)
 			if path == "" {
 				ignoredThis++
@@ -174,7 +293,13 @@ func (c *Controller) DiscoverOnce(ctx context.Context) (stored int, ignored int,
 			if ent, ok := c.rt.PeekRAM(path); ok && !ent.Inactive {
 				continue
 			}
-			if ent, ok := c.rt.PeekDisk(path); ok && !ent.Inactive {
+			ent, onDisk := c.rt.PeekDisk(path)
+			if onDisk && !ent.Inactive {
+				continue
+			}
+			if onDisk {
+				budget.keep(path)
+			} else if !budget.admit(c.rt) {
 				continue
 			}
 
@@ -192,7 +317,15 @@ func (c *Controller) DiscoverOnce(ctx context.Context) (stored int, ignored int,
 			stored++
 		}
 
-		if c.cfg.LogAutodiscover {
+		// A sitemap cut short by MaxURLs keeps its previous state, so the
+		// URLs skipped now still count as new on the next run.
+		if next != nil && !cut {
+			c.mu.Lock()
+			c.sitemaps[smURL] = next
+			c.mu.Unlock()
+		}
+
+		if c.cfg.LogAutodiscover && !unchanged {
 			c.logger.Printf(
 				"urlsDiscover sitemap=%q urls=%d fit=%d ignored=%d",
 				smURL,
@@ -206,6 +339,61 @@ func (c *Controller) DiscoverOnce(ctx context.Context) (stored int, ignored int,
 	return stored, ignored, nil
 }
 
+// loadSitemap fetches smURL. Without Incremental it returns the full document
+// and a nil state. With Incremental it sends the previous run's validators:
+// a 304 yields only the previously seen nested sitemaps and a nil state;
+// otherwise doc.URLs holds just the locations new since the previous run and
+// the returned state should be saved once they are seeded.
+func (c *Controller) loadSitemap(ctx context.Context, smURL string) (SitemapDoc, *sitemapState, error) {
+	if !c.cfg.Incremental {
+		doc, err := c.FetchAndParseSitemap(ctx, smURL)
+		return doc, nil, err
+	}
+
+	c.mu.Lock()
+	prev := c.sitemaps[smURL]
+	c.mu.Unlock()
+
+	var since validators
+	if prev != nil {
+		since = prev.validators
+	}
+	doc, got, notModified, err := c.fetchSitemap(ctx, smURL, since)
+	if err != nil {
+		return SitemapDoc{}, nil, err
+	}
+	if notModified {
+		if prev == nil {
+			return SitemapDoc{}, nil, nil
+		}
+		return SitemapDoc{Sitemaps: prev.nested}, nil, nil
+	}
+
+	next := &sitemapState{
+		validators: got,
+		nested:     doc.Sitemaps,
+		paths:      make(map[string]struct{}, len(doc.URLs)),
+	}
+	fresh := doc.URLs[:0]
+	for _, loc := range doc.URLs {
+		path := NormalizePathFromLoc(loc)
+		next.paths[path] = struct{}{}
+		if prev != nil {
+			if _, ok := prev.paths[path]; ok {
+				continue
+			}
+		}
+		fresh = append(fresh, loc)
+	}
+	doc.URLs = fresh
+	return doc, next, nil
+}
+
+// Skipped reports how many runs were skipped because one was in progress.
+func (c *Controller) Skipped() uint64 {
+	return c.skipped.Load()
+}
+
 func (c *Controller) NormalizeMaybeRelativeURL(u string) string {
 	u = strings.TrimSpace(u)
 	if u == "" {
@@ -220,26 +408,83 @@ func (c *Controller) NormalizeMaybeRelativeURL(u string) string {
 	return c.cfg.Origin + u
 }
 
+// maxRobotsBytes bounds how much of robots.txt is read.
+const maxRobotsBytes = 512 << 10
+
+// robotsSitemaps returns the sitemap URLs listed in the origin's robots.txt.
+// A missing robots.txt lists none.
+func (c *Controller) robotsSitemaps(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.rt.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBytes))
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, line := range strings.Split(string(body), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "sitemap") {
+			continue
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			out = append(out, c.NormalizeMaybeRelativeURL(value))
+		}
+	}
+	return out, nil
+}
+
 func (c *Controller) FetchAndParseSitemap(ctx context.Context, sitemapURL string) (SitemapDoc, error) {
+	doc, _, _, err := c.fetchSitemap(ctx, sitemapURL, validators{})
+	return doc, err
+}
+
+// fetchSitemap fetches and parses a sitemap, sending since as conditional
+// request headers. It reports notModified on a 304 and returns the response
+// validators otherwise.
+func (c *Controller) fetchSitemap(ctx context.Context, sitemapURL string, since validators) (_ SitemapDoc, _ validators, notModified bool, _ error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
 	if err != nil {
-		return SitemapDoc{}, err
+		return SitemapDoc{}, validators{}, false, err
+	}
+	if since.etag != "" {
+		req.Header.Set("If-None-Match", since.etag)
+	}
+	if since.lastModified != "" {
+		req.Header.Set("If-Modified-Since", since.lastModified)
 	}
 
 	resp, err := c.rt.Do(req)
 	if err != nil {
-		return SitemapDoc{}, err
+		return SitemapDoc{}, validators{}, false, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return SitemapDoc{}, since, true, nil
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return SitemapDoc{}, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		return SitemapDoc{}, validators{}, false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
 	}
+	got := validators{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return SitemapDoc{}, err
+		return SitemapDoc{}, validators{}, false, err
 	}
 
 	tryGzip := strings.HasSuffix(strings.ToLower(sitemapURL), ".gz") || (len(body) >= 2 && body[0] == 0x1f && body[1] == 0x8b)
@@ -254,7 +499,7 @@ func (c *Controller) FetchAndParseSitemap(ctx context.Context, sitemapURL string
 
 	var doc SitemapDoc
 	if err := xml.Unmarshal(body, &doc); err != nil {
-		return SitemapDoc{}, err
+		return SitemapDoc{}, validators{}, false, err
 	}
 
 	for i := range doc.URLs {
@@ -264,7 +509,7 @@ func (c *Controller) FetchAndParseSitemap(ctx context.Context, sitemapURL string
 		doc.Sitemaps[i] = strings.TrimSpace(doc.Sitemaps[i])
 	}
 
-	return doc, nil
+	return doc, got, false, nil
 }
 
 func NormalizePathFromLoc(loc string) string {
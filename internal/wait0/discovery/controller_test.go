@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -21,9 +22,15 @@ type fakeRuntime struct {
 	disk  map[string]Entry
 
 	putDisk []string
+	dropped []string
 	doMap   map[string]*http.Response
 	doErr   map[string]error
 	doCalls []string
+
+	// gate, when set, blocks Do until it is closed.
+	gate chan struct{}
+	// doFunc, when set, answers every Do call instead of doMap.
+	doFunc func(req *http.Request) (*http.Response, error)
 }
 
 func newFakeRuntime() *fakeRuntime {
@@ -67,11 +74,41 @@ func (f *fakeRuntime) PutDisk(path string, ent Entry) {
 	f.disk[path] = ent
 }
 
+func (f *fakeRuntime) Seeds() map[string]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := map[string]int64{}
+	for p, ent := range f.disk {
+		if ent.Inactive {
+			out[p] = ent.StoredAt * int64(time.Second)
+		}
+	}
+	return out
+}
+
+func (f *fakeRuntime) DropSeed(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ent, ok := f.disk[path]
+	if !ok || !ent.Inactive {
+		return false
+	}
+	delete(f.disk, path)
+	f.dropped = append(f.dropped, path)
+	return true
+}
+
 func (f *fakeRuntime) Do(req *http.Request) (*http.Response, error) {
+	if f.gate != nil {
+		<-f.gate
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	url := req.URL.String()
 	f.doCalls = append(f.doCalls, url)
+	if f.doFunc != nil {
+		return f.doFunc(req)
+	}
 	if err, ok := f.doErr[url]; ok {
 		return nil, err
 	}
@@ -192,6 +229,106 @@ func TestController_DiscoverOnce_StoresAndIgnores(t *testing.T) {
 	}
 }
 
+func TestController_DiscoverOnce_FromRobots(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.rules["/a"] = &Rule{}
+	rt.rules["/b"] = &Rule{}
+	rt.doMap["http://origin.local/robots.txt"] = mkResp(http.StatusOK, "User-agent: *\r\nDisallow: /admin\r\nSitemap: http://origin.local/index.xml.gz\r\nsitemap:/pages.xml\r\n", nil)
+	rt.doMap["http://origin.local/index.xml.gz"] = mkResp(http.StatusOK, string(gzipBytes(t, `<sitemapindex><sitemap><loc>/nested.xml</loc></sitemap></sitemapindex>`)), nil)
+	rt.doMap["http://origin.local/nested.xml"] = mkResp(http.StatusOK, `<urlset><url><loc>/a</loc></url></urlset>`, nil)
+	rt.doMap["http://origin.local/pages.xml"] = mkResp(http.StatusOK, `<urlset><url><loc>/b</loc></url></urlset>`, nil)
+
+	var wg sync.WaitGroup
+	c := NewController(Config{Origin: "http://origin.local", FromRobots: true}, rt, make(chan struct{}), &wg, &captureLogger{})
+
+	stored, _, err := c.DiscoverOnce(context.Background())
+	if err != nil {
+		t.Fatalf("DiscoverOnce error: %v", err)
+	}
+	if stored != 2 || len(rt.putDisk) != 2 || rt.putDisk[0] != "/b" || rt.putDisk[1] != "/a" {
+		t.Fatalf("stored = %d putDisk = %v, want /a and /b from the robots.txt sitemaps", stored, rt.putDisk)
+	}
+}
+
+func TestController_DiscoverOnce_FromRobotsMissingOrFailing(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.rules["/a"] = &Rule{}
+	rt.doMap["http://origin.local/robots.txt"] = mkResp(http.StatusNotFound, "", nil)
+	rt.doMap["http://origin.local/sitemap.xml"] = mkResp(http.StatusOK, `<urlset><url><loc>/a</loc></url></urlset>`, nil)
+
+	var wg sync.WaitGroup
+	errLog := &captureLogger{}
+	c := NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/sitemap.xml"}, FromRobots: true}, rt, make(chan struct{}), &wg, &captureLogger{})
+	c.SetErrorLog(errLog)
+	if stored, _, err := c.DiscoverOnce(context.Background()); err != nil || stored != 1 {
+		t.Fatalf("missing robots.txt: stored=%d err=%v, want the configured sitemap crawled", stored, err)
+	}
+	if errLog.count() != 0 {
+		t.Fatalf("a missing robots.txt should not be logged as an error")
+	}
+
+	rt = newFakeRuntime()
+	rt.doErr["http://origin.local/robots.txt"] = errors.New("dial")
+	c = NewController(Config{Origin: "http://origin.local", FromRobots: true}, rt, make(chan struct{}), &wg, &captureLogger{})
+	if _, _, err := c.DiscoverOnce(context.Background()); err == nil || !strings.Contains(err.Error(), "robots.txt") {
+		t.Fatalf("err = %v, want the robots.txt failure when nothing else is configured", err)
+	}
+}
+
+func TestController_DiscoverOnce_CrawlLimits(t *testing.T) {
+	sitemaps := map[string]string{
+		"/index.xml": `<sitemapindex><sitemap><loc>/one.xml</loc></sitemap><sitemap><loc>/two.xml</loc></sitemap></sitemapindex>`,
+		"/one.xml":   `<urlset><url><loc>/a</loc></url><url><loc>/b</loc></url><url><loc>/c</loc></url></urlset>`,
+		"/two.xml":   `<urlset><url><loc>/d</loc></url></urlset>`,
+	}
+	setup := func() *fakeRuntime {
+		rt := newFakeRuntime()
+		for _, p := range []string{"/a", "/b", "/c", "/d"} {
+			rt.rules[p] = &Rule{}
+		}
+		rt.doFunc = func(req *http.Request) (*http.Response, error) {
+			return mkResp(http.StatusOK, sitemaps[req.URL.Path], nil), nil
+		}
+		return rt
+	}
+	var wg sync.WaitGroup
+
+	rt := setup()
+	errLog := &captureLogger{}
+	c := NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/index.xml"}, MaxSitemaps: 2}, rt, make(chan struct{}), &wg, &captureLogger{})
+	c.SetErrorLog(errLog)
+	if stored, _, err := c.DiscoverOnce(context.Background()); err != nil || stored != 3 {
+		t.Fatalf("maxSitemaps: stored=%d err=%v, want the 3 URLs of the first nested sitemap", stored, err)
+	}
+	if len(rt.doCalls) != 2 || errLog.count() != 1 {
+		t.Fatalf("maxSitemaps: fetched %v, warnings=%d, want 2 fetches and one warning", rt.doCalls, errLog.count())
+	}
+
+	rt = setup()
+	c = NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/index.xml"}, MaxURLs: 2}, rt, make(chan struct{}), &wg, &captureLogger{})
+	if stored, _, err := c.DiscoverOnce(context.Background()); err != nil || stored != 2 {
+		t.Fatalf("maxURLs: stored=%d err=%v, want 2", stored, err)
+	}
+	if len(rt.putDisk) != 2 || rt.putDisk[0] != "/a" || rt.putDisk[1] != "/b" || len(rt.doCalls) != 2 {
+		t.Fatalf("maxURLs: putDisk=%v fetched=%v, want /a and /b with two.xml never fetched", rt.putDisk, rt.doCalls)
+	}
+
+	// Incremental runs do not remember a cut-short sitemap, so the URLs it
+	// skipped are seeded once the bound allows.
+	rt = setup()
+	c = NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/index.xml"}, MaxURLs: 2, Incremental: true}, rt, make(chan struct{}), &wg, &captureLogger{})
+	if _, _, err := c.DiscoverOnce(context.Background()); err != nil {
+		t.Fatalf("incremental first run: %v", err)
+	}
+	c.cfg.MaxURLs = 0
+	if _, _, err := c.DiscoverOnce(context.Background()); err != nil {
+		t.Fatalf("incremental second run: %v", err)
+	}
+	if _, ok := rt.disk["/c"]; !ok {
+		t.Fatalf("putDisk = %v, want /c seeded on the run after it was cut", rt.putDisk)
+	}
+}
+
 func TestController_DiscoverOnce_StopEarly(t *testing.T) {
 	rt := newFakeRuntime()
 	stopCh := make(chan struct{})
@@ -208,6 +345,185 @@ func TestController_DiscoverOnce_StopEarly(t *testing.T) {
 	}
 }
 
+func TestController_DiscoverOnce_SkipsWhileRunning(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.rules["/a"] = &Rule{}
+	rt.doMap["http://origin.local/sitemap.xml"] = mkResp(http.StatusOK, `<?xml version="1.0"?><urlset><url><loc>/a</loc></url></urlset>`, nil)
+	rt.gate = make(chan struct{})
+	log := &captureLogger{}
+	c := NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/sitemap.xml"}}, rt, make(chan struct{}), &sync.WaitGroup{}, log)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.DiscoverOnce(context.Background())
+		done <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !c.running.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("first run never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, _, err := c.DiscoverOnce(context.Background()); !errors.Is(err, ErrRunning) {
+		t.Fatalf("overlapping run err = %v, want ErrRunning", err)
+	}
+	if c.Skipped() != 1 {
+		t.Fatalf("Skipped = %d, want 1", c.Skipped())
+	}
+
+	close(rt.gate)
+	if err := <-done; err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if len(rt.doCalls) != 1 {
+		t.Fatalf("sitemap fetches = %d, want 1", len(rt.doCalls))
+	}
+	rt.doMap["http://origin.local/sitemap.xml"] = mkResp(http.StatusOK, `<?xml version="1.0"?><urlset></urlset>`, nil)
+	if _, _, err := c.DiscoverOnce(context.Background()); err != nil {
+		t.Fatalf("run after completion: %v", err)
+	}
+}
+
+func TestController_DiscoverOnce_IncrementalSeedsOnlyNewURLs(t *testing.T) {
+	rt := newFakeRuntime()
+	for _, p := range []string{"/a", "/b", "/c"} {
+		rt.rules[p] = &Rule{}
+	}
+	etags := map[string]string{"/sitemap.xml": `"i1"`, "/nested.xml": `"n1"`}
+	bodies := map[string]string{
+		"/sitemap.xml": `<sitemapindex><sitemap><loc>/nested.xml</loc></sitemap></sitemapindex>`,
+		"/nested.xml":  `<urlset><url><loc>/a</loc></url><url><loc>/b</loc></url></urlset>`,
+	}
+	var conditional []string
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		path := req.URL.Path
+		if inm := req.Header.Get("If-None-Match"); inm != "" {
+			conditional = append(conditional, path)
+			if inm == etags[path] {
+				return mkResp(http.StatusNotModified, "", nil), nil
+			}
+		}
+		return mkResp(http.StatusOK, bodies[path], map[string]string{"ETag": etags[path]}), nil
+	}
+
+	log := &captureLogger{}
+	c := NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/sitemap.xml"}, LogAutodiscover: true, Incremental: true}, rt, make(chan struct{}), &sync.WaitGroup{}, log)
+
+	if stored, _, err := c.DiscoverOnce(context.Background()); err != nil || stored != 2 {
+		t.Fatalf("first run stored=%d err=%v, want 2", stored, err)
+	}
+	if len(conditional) != 0 {
+		t.Fatalf("first run sent validators for %v", conditional)
+	}
+
+	// A seed evicted between runs is not re-seeded while its sitemap is unchanged.
+	delete(rt.disk, "/a")
+	if stored, _, err := c.DiscoverOnce(context.Background()); err != nil || stored != 0 {
+		t.Fatalf("unchanged run stored=%d err=%v, want 0", stored, err)
+	}
+	if len(conditional) != 2 {
+		t.Fatalf("conditional fetches = %v, want index and nested", conditional)
+	}
+
+	etags["/nested.xml"] = `"n2"`
+	bodies["/nested.xml"] = `<urlset><url><loc>/a</loc></url><url><loc>/b</loc></url><url><loc>/c</loc></url></urlset>`
+	rt.putDisk = nil
+	if stored, _, err := c.DiscoverOnce(context.Background()); err != nil || stored != 1 {
+		t.Fatalf("changed run stored=%d err=%v, want 1", stored, err)
+	}
+	if len(rt.putDisk) != 1 || rt.putDisk[0] != "/c" {
+		t.Fatalf("putDisk = %v, want only the new /c", rt.putDisk)
+	}
+	unchanged := 0
+	for _, line := range log.lines {
+		if strings.HasSuffix(line, " unchanged") {
+			unchanged++
+		}
+	}
+	if unchanged != 3 {
+		t.Fatalf("unchanged logs = %d, want 3 in %v", unchanged, log.lines)
+	}
+}
+
+func TestController_SetErrorLog_RoutesFailures(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.doMap["http://origin.local/sitemap.xml"] = mkResp(http.StatusInternalServerError, "", nil)
+
+	var wg sync.WaitGroup
+	info := &captureLogger{}
+	errs := &captureLogger{}
+	c := NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/sitemap.xml"}}, rt, make(chan struct{}), &wg, info)
+	c.SetErrorLog(errs)
+
+	c.Start()
+	waitWG(t, &wg)
+	if errs.count() != 1 || !strings.Contains(errs.lines[0], "urlsDiscover: error") {
+		t.Fatalf("error log = %v, want the fetch failure", errs.lines)
+	}
+	if info.count() != 0 {
+		t.Fatalf("info log = %v, want nothing", info.lines)
+	}
+}
+
+func TestController_DiscoverOnce_MaxSeededDropsOldestSeeds(t *testing.T) {
+	rt := newFakeRuntime()
+	for _, p := range []string{"/old1", "/old2", "/old3", "/new1", "/new2", "/new3"} {
+		rt.rules[p] = &Rule{}
+	}
+	rt.disk["/old1"] = Entry{Inactive: true, StoredAt: 1}
+	rt.disk["/old2"] = Entry{Inactive: true, StoredAt: 2}
+	rt.disk["/old3"] = Entry{Inactive: true, StoredAt: 3}
+	rt.disk["/active"] = Entry{StoredAt: 0}
+	rt.doMap["http://origin.local/sitemap.xml"] = mkResp(http.StatusOK, `<?xml version="1.0"?><urlset>
+<url><loc>/old2</loc></url><url><loc>/new1</loc></url><url><loc>/new2</loc></url><url><loc>/new3</loc></url></urlset>`, nil)
+
+	errs := &captureLogger{}
+	c := NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/sitemap.xml"}, MaxSeeded: 3}, rt, make(chan struct{}), &sync.WaitGroup{}, &captureLogger{})
+	c.SetErrorLog(errs)
+
+	stored, _, err := c.DiscoverOnce(context.Background())
+	if err != nil {
+		t.Fatalf("DiscoverOnce: %v", err)
+	}
+	// /old2 is listed again and kept; /old1 and /old3 make room for two new
+	// seeds, and the third new URL no longer fits.
+	if stored != 3 {
+		t.Fatalf("stored = %d, want /old2 refreshed plus two new seeds", stored)
+	}
+	if got := strings.Join(rt.dropped, ","); got != "/old1,/old3" {
+		t.Fatalf("dropped = %s, want /old1,/old3", got)
+	}
+	if _, ok := rt.disk["/new3"]; ok {
+		t.Fatalf("/new3 seeded past the cap")
+	}
+	if _, ok := rt.disk["/active"]; !ok {
+		t.Fatalf("active entries must not be dropped")
+	}
+	if len(rt.Seeds()) != 3 {
+		t.Fatalf("seeds = %v, want 3", rt.Seeds())
+	}
+	if errs.count() != 1 || !strings.Contains(errs.lines[0], "maxSeeded=3 reached: evicted=2 skipped=1") {
+		t.Fatalf("cap log = %v", errs.lines)
+	}
+}
+
+func TestController_DiscoverOnce_LoweredMaxSeededTrims(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.disk["/a"] = Entry{Inactive: true, StoredAt: 1}
+	rt.disk["/b"] = Entry{Inactive: true, StoredAt: 2}
+	rt.doMap["http://origin.local/sitemap.xml"] = mkResp(http.StatusOK, `<?xml version="1.0"?><urlset></urlset>`, nil)
+
+	c := NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/sitemap.xml"}, MaxSeeded: 1}, rt, make(chan struct{}), &sync.WaitGroup{}, &captureLogger{})
+	if _, _, err := c.DiscoverOnce(context.Background()); err != nil {
+		t.Fatalf("DiscoverOnce: %v", err)
+	}
+	if got := strings.Join(rt.dropped, ","); got != "/a" {
+		t.Fatalf("dropped = %s, want /a", got)
+	}
+}
+
 func TestController_NormalizeMaybeRelativeURL(t *testing.T) {
 	c := NewController(Config{Origin: "http://origin.local"}, newFakeRuntime(), make(chan struct{}), &sync.WaitGroup{}, &captureLogger{})
 	tests := []struct {
@@ -0,0 +1,83 @@
+package discovery
+
+import "sort"
+
+// seedBudget enforces Config.MaxSeeded over one discovery run. Room for a new
+// seed is made by dropping the least recently seeded path from earlier runs;
+// once none are left, further seeds are skipped, so a run never writes more
+// than MaxSeeded seeds. A nil budget admits everything.
+type seedBudget struct {
+	max   int
+	count int
+	// oldest lists earlier seeds, least recently seeded first.
+	oldest []string
+	// kept holds earlier seeds listed again this run; they are not dropped.
+	kept map[string]struct{}
+
+	evicted int
+	capped  int
+}
+
+func (c *Controller) newSeedBudget() *seedBudget {
+	if c.cfg.MaxSeeded <= 0 {
+		return nil
+	}
+	seeds := c.rt.Seeds()
+	paths := make([]string, 0, len(seeds))
+	for p := range seeds {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if seeds[paths[i]] != seeds[paths[j]] {
+			return seeds[paths[i]] < seeds[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	b := &seedBudget{max: c.cfg.MaxSeeded, count: len(paths), oldest: paths, kept: map[string]struct{}{}}
+	// A lowered cap takes effect on the next run even if nothing new is seeded.
+	b.shrinkTo(c.rt, b.max)
+	return b
+}
+
+// keep marks an earlier seed that is listed again, so it is refreshed rather
+// than charged or dropped.
+func (b *seedBudget) keep(path string) {
+	if b == nil {
+		return
+	}
+	b.kept[path] = struct{}{}
+}
+
+// admit reports whether one more seed fits under the cap.
+func (b *seedBudget) admit(rt Runtime) bool {
+	if b == nil {
+		return true
+	}
+	b.shrinkTo(rt, b.max-1)
+	if b.count >= b.max {
+		b.capped++
+		return false
+	}
+	b.count++
+	return true
+}
+
+// shrinkTo drops the oldest earlier seeds until at most limit remain. A seed
+// that was activated since the run started no longer counts either way.
+func (b *seedBudget) shrinkTo(rt Runtime, limit int) {
+	for b.count > limit && len(b.oldest) > 0 {
+		p := b.oldest[0]
+		b.oldest = b.oldest[1:]
+		if _, ok := b.kept[p]; ok {
+			continue
+		}
+		b.count--
+		if rt.DropSeed(p) {
+			b.evicted++
+		}
+	}
+}
+
+func (b *seedBudget) hit() bool {
+	return b != nil && (b.evicted > 0 || b.capped > 0)
+}
@@ -0,0 +1,33 @@
+package discovery
+
+import "time"
+
+// seedSweepEvery spaces SweepSeeds runs so a seed outlives SeedTTL by at most
+// a quarter of it, and by no more than an hour.
+func seedSweepEvery(ttl time.Duration) time.Duration {
+	return min(max(ttl/4, time.Second), time.Hour)
+}
+
+// SweepSeeds deletes inactive seeds seeded more than SeedTTL before now and
+// reports how many were removed. A seed is never warmed while inactive, and a
+// sitemap that lists it again re-seeds it, so only seeds that neither produced
+// content nor were listed since expire.
+func (c *Controller) SweepSeeds(now time.Time) int {
+	if c.cfg.SeedTTL <= 0 {
+		return 0
+	}
+	cutoff := now.Add(-c.cfg.SeedTTL).UnixNano()
+	swept := 0
+	for path, seeded := range c.rt.Seeds() {
+		if seeded >= cutoff {
+			continue
+		}
+		if c.rt.DropSeed(path) {
+			swept++
+		}
+	}
+	if swept > 0 {
+		c.logger.Printf("urlsDiscover: swept %d seeds older than seedTTL=%s", swept, c.cfg.SeedTTL)
+	}
+	return swept
+}
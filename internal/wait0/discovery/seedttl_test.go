@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestController_SweepSeeds_DropsOnlyExpiredInactive(t *testing.T) {
+	now := time.Unix(10_000, 0)
+	rt := newFakeRuntime()
+	rt.disk["/stale"] = Entry{Inactive: true, StoredAt: now.Add(-2 * time.Hour).Unix()}
+	rt.disk["/fresh"] = Entry{Inactive: true, StoredAt: now.Add(-10 * time.Minute).Unix()}
+	rt.disk["/warmed"] = Entry{StoredAt: now.Add(-5 * time.Hour).Unix()}
+
+	log := &captureLogger{}
+	c := NewController(Config{SeedTTL: time.Hour}, rt, make(chan struct{}), &sync.WaitGroup{}, log)
+
+	if got := c.SweepSeeds(now); got != 1 {
+		t.Fatalf("swept = %d, want 1", got)
+	}
+	if got := strings.Join(rt.dropped, ","); got != "/stale" {
+		t.Fatalf("dropped = %s, want /stale", got)
+	}
+	if _, ok := rt.disk["/warmed"]; !ok {
+		t.Fatalf("active entries must survive the sweep")
+	}
+	if log.count() != 1 || !strings.Contains(log.lines[0], "swept 1 seeds older than seedTTL=1h0m0s") {
+		t.Fatalf("log = %v", log.lines)
+	}
+
+	if got := c.SweepSeeds(now); got != 0 || log.count() != 1 {
+		t.Fatalf("second sweep = %d, log = %v", got, log.lines)
+	}
+}
+
+func TestController_SweepSeeds_DisabledWithoutTTL(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.disk["/old"] = Entry{Inactive: true, StoredAt: 1}
+	c := NewController(Config{}, rt, make(chan struct{}), &sync.WaitGroup{}, &captureLogger{})
+	if got := c.SweepSeeds(time.Now()); got != 0 || len(rt.dropped) != 0 {
+		t.Fatalf("swept = %d, dropped = %v", got, rt.dropped)
+	}
+}
+
+func TestController_Start_SweepsSeedsWithoutRediscovery(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.disk["/old"] = Entry{Inactive: true, StoredAt: 1}
+	rt.doMap["http://origin.local/sitemap.xml"] = mkResp(200, `<?xml version="1.0"?><urlset></urlset>`, nil)
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	c := NewController(Config{Origin: "http://origin.local", Sitemaps: []string{"/sitemap.xml"}, SeedTTL: time.Millisecond}, rt, stopCh, &wg, &captureLogger{})
+	c.Start()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, ok := rt.PeekDisk("/old"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("seed not swept by the background ticker")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stopCh)
+	waitWG(t, &wg)
+}
+
+func TestSeedSweepEvery(t *testing.T) {
+	tests := []struct{ ttl, want time.Duration }{
+		{ttl: time.Millisecond, want: time.Second},
+		{ttl: 20 * time.Minute, want: 5 * time.Minute},
+		{ttl: 30 * 24 * time.Hour, want: time.Hour},
+	}
+	for _, tc := range tests {
+		if got := seedSweepEvery(tc.ttl); got != tc.want {
+			t.Fatalf("seedSweepEvery(%s) = %s, want %s", tc.ttl, got, tc.want)
+		}
+	}
+}
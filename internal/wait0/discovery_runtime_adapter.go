@@ -1,9 +1,12 @@
 package wait0
 
 import (
+	"context"
 	"net/http"
 
+	"wait0/internal/wait0/cachekey"
 	"wait0/internal/wait0/discovery"
+	"wait0/internal/wait0/proxy"
 )
 
 type discoveryRuntimeAdapter struct {
@@ -23,7 +26,7 @@ func (a *discoveryRuntimeAdapter) PickRule(path string) *discovery.Rule {
 }
 
 func (a *discoveryRuntimeAdapter) PeekRAM(path string) (discovery.Entry, bool) {
-	ent, ok := a.s.ram.Peek(path)
+	ent, ok := a.s.ram.Peek(a.s.pathKey(path))
 	if !ok {
 		return discovery.Entry{}, false
 	}
@@ -31,7 +34,7 @@ func (a *discoveryRuntimeAdapter) PeekRAM(path string) (discovery.Entry, bool) {
 }
 
 func (a *discoveryRuntimeAdapter) PeekDisk(path string) (discovery.Entry, bool) {
-	ent, ok := a.s.disk.Peek(path)
+	ent, ok := a.s.disk.Peek(a.s.pathKey(path))
 	if !ok {
 		return discovery.Entry{}, false
 	}
@@ -39,7 +42,7 @@ func (a *discoveryRuntimeAdapter) PeekDisk(path string) (discovery.Entry, bool)
 }
 
 func (a *discoveryRuntimeAdapter) PutDisk(path string, ent discovery.Entry) {
-	a.s.disk.PutAsync(path, CacheEntry{
+	a.s.disk.PutAsync(a.s.pathKey(path), CacheEntry{
 		Status:       ent.Status,
 		Header:       ent.Header,
 		Body:         ent.Body,
@@ -50,6 +53,46 @@ func (a *discoveryRuntimeAdapter) PutDisk(path string, ent discovery.Entry) {
 	})
 }
 
+// Seeds reports inactive disk entries under the active key version by path.
+// Their last refresh time is when they were seeded.
+func (a *discoveryRuntimeAdapter) Seeds() map[string]int64 {
+	out := map[string]int64{}
+	for key, meta := range a.s.disk.MetaSnapshot() {
+		if !meta.Inactive {
+			continue
+		}
+		path := cachekey.Path(key)
+		if a.s.pathKey(path) != key {
+			continue
+		}
+		out[path] = meta.LastRefreshUnixNano
+	}
+	return out
+}
+
+func (a *discoveryRuntimeAdapter) DropSeed(path string) bool {
+	key := a.s.pathKey(path)
+	ent, ok := a.s.disk.Peek(key)
+	if !ok || !ent.Inactive {
+		return false
+	}
+	a.s.disk.Delete(key)
+	return true
+}
+
+// Do bounds each sitemap or page request, body included, by the live
+// server.originTimeout.
 func (a *discoveryRuntimeAdapter) Do(req *http.Request) (*http.Response, error) {
-	return a.s.httpClient.Do(req)
+	d := a.s.config().Server.originTimeoutDur
+	if d <= 0 {
+		return a.s.httpClient.Do(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), d)
+	resp, err := a.s.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = proxy.CancelOnClose{ReadCloser: resp.Body, Cancel: cancel}
+	return resp, nil
 }
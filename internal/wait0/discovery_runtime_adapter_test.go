@@ -45,6 +45,27 @@ func TestDiscoveryRuntimeAdapter_RulesAndCacheViews(t *testing.T) {
 	}
 }
 
+func TestDiscoveryRuntimeAdapter_SeedsAndDropSeed(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	a := newDiscoveryRuntimeAdapter(s)
+
+	a.PutDisk("/seed", discovery.Entry{Status: http.StatusOK, Header: http.Header{}, StoredAt: 100, Inactive: true, DiscoveredBy: "sitemap"})
+	s.disk.PutAsync("/live", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x"), StoredAt: 100})
+	waitForDiscovery(t, func() bool { return s.disk.HasKey("/seed") && s.disk.HasKey("/live") })
+
+	seeds := a.Seeds()
+	if len(seeds) != 1 || seeds["/seed"] != 100*int64(time.Second) {
+		t.Fatalf("Seeds = %v, want /seed seeded at 100s", seeds)
+	}
+	if a.DropSeed("/live") {
+		t.Fatalf("DropSeed must not drop active entries")
+	}
+	if !a.DropSeed("/seed") {
+		t.Fatalf("expected DropSeed true for inactive seed")
+	}
+	waitForDiscovery(t, func() bool { return !s.disk.HasKey("/seed") })
+}
+
 func TestDiscoveryRuntimeAdapter_Do(t *testing.T) {
 	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusAccepted)
@@ -63,3 +84,18 @@ func TestDiscoveryRuntimeAdapter_Do(t *testing.T) {
 		t.Fatalf("status = %d", resp.StatusCode)
 	}
 }
+
+func TestDiscoveryRuntimeAdapter_DoHonorsOriginTimeout(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, nil)
+	s.config().Server.originTimeoutDur = 50 * time.Millisecond
+	req, _ := http.NewRequest(http.MethodGet, origin.URL+"/sitemap.xml", nil)
+	if resp, err := newDiscoveryRuntimeAdapter(s).Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected server.originTimeout to cut the request short")
+	}
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueueSize bounds how many events wait for delivery; Send drops events once
+// it is full.
+const QueueSize = 256
+
+// Timeout bounds a single webhook POST.
+const Timeout = 5 * time.Second
+
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Event describes a cached entry whose content changed on warmup or
+// revalidation.
+type Event struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	URI  string `json:"uri"`
+	// OldHash is empty when there was no previous entry.
+	OldHash string `json:"old_hash,omitempty"`
+	NewHash string `json:"new_hash"`
+	Bytes   int    `json:"bytes"`
+	By      string `json:"by"`
+	At      string `json:"at"`
+}
+
+// ContentChanged is the Event type sent when a body hash changes.
+const ContentChanged = "content_changed"
+
+// FormatHash renders a CRC32 body hash the way events carry it.
+func FormatHash(h uint32) string {
+	return fmt.Sprintf("%08x", h)
+}
+
+// Webhook POSTs events as JSON to a URL from a single background worker.
+// Delivery is best effort: events are dropped when the queue is full, and
+// failed posts are logged, never retried.
+type Webhook struct {
+	url    string
+	client *http.Client
+	log    Logger
+
+	queue   chan Event
+	dropped atomic.Uint64
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+}
+
+func NewWebhook(url string, client *http.Client, log Logger) *Webhook {
+	if client == nil {
+		client = &http.Client{Timeout: Timeout}
+	}
+	return &Webhook{url: url, client: client, log: log, queue: make(chan Event, QueueSize)}
+}
+
+// Send queues ev for delivery without blocking and reports whether it was
+// accepted.
+func (h *Webhook) Send(ev Event) bool {
+	select {
+	case h.queue <- ev:
+		return true
+	default:
+		h.dropped.Add(1)
+		if h.log != nil {
+			h.log.Printf("event webhook: queue full, dropping %s event for path=%q", ev.Type, ev.Path)
+		}
+		return false
+	}
+}
+
+// Start runs the delivery worker until stopCh closes. Events still queued at
+// that point are discarded.
+func (h *Webhook) Start(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-stopCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case ev := <-h.queue:
+				if err := h.post(ctx, ev); err != nil {
+					h.failed.Add(1)
+					if h.log != nil {
+						h.log.Printf("event webhook: %s event for path=%q: %v", ev.Type, ev.Path, err)
+					}
+					continue
+				}
+				h.sent.Add(1)
+			}
+		}
+	}()
+}
+
+func (h *Webhook) post(ctx context.Context, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sent counts events the webhook accepted with a 2xx status.
+func (h *Webhook) Sent() uint64 {
+	return h.sent.Load()
+}
+
+// Failed counts events whose POST errored or got a non-2xx status.
+func (h *Webhook) Failed() uint64 {
+	return h.failed.Load()
+}
+
+// Dropped counts events discarded because the queue was full.
+func (h *Webhook) Dropped() uint64 {
+	return h.dropped.Load()
+}
@@ -0,0 +1,149 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) Printf(format string, v ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) all() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met before timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhook_PostsJSON(t *testing.T) {
+	got := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("method=%s content-type=%q", r.Method, r.Header.Get("Content-Type"))
+		}
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got <- ev
+	}))
+	defer srv.Close()
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	h := NewWebhook(srv.URL, nil, &captureLogger{})
+	h.Start(stopCh, &wg)
+	defer func() { close(stopCh); wg.Wait() }()
+
+	want := Event{Type: ContentChanged, Path: "/p", URI: "/p?a=1", OldHash: FormatHash(1), NewHash: FormatHash(0xabcdef01), Bytes: 12, By: "warmup", At: "2026-01-02T03:04:05Z"}
+	if !h.Send(want) {
+		t.Fatalf("Send rejected an event on an empty queue")
+	}
+	select {
+	case ev := <-got:
+		if ev != want {
+			t.Fatalf("event = %+v, want %+v", ev, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("webhook not called")
+	}
+	waitFor(t, func() bool { return h.Sent() == 1 })
+}
+
+func TestWebhook_OmitsOldHashForNewEntries(t *testing.T) {
+	b, err := json.Marshal(Event{Type: ContentChanged, Path: "/p", NewHash: FormatHash(7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "old_hash") || !strings.Contains(string(b), `"new_hash":"00000007"`) {
+		t.Fatalf("json = %s", b)
+	}
+}
+
+func TestWebhook_DropsWhenQueueIsFull(t *testing.T) {
+	log := &captureLogger{}
+	h := NewWebhook("http://127.0.0.1:1", nil, log)
+	for i := 0; i < QueueSize; i++ {
+		if !h.Send(Event{Type: ContentChanged, Path: "/p"}) {
+			t.Fatalf("send %d rejected before the queue filled", i)
+		}
+	}
+	if h.Send(Event{Type: ContentChanged, Path: "/overflow"}) {
+		t.Fatalf("Send accepted an event past QueueSize")
+	}
+	if h.Dropped() != 1 || !strings.Contains(log.all(), `dropping content_changed event for path="/overflow"`) {
+		t.Fatalf("dropped = %d, log = %s", h.Dropped(), log.all())
+	}
+}
+
+func TestWebhook_CountsFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	log := &captureLogger{}
+	h := NewWebhook(srv.URL, nil, log)
+	h.Start(stopCh, &wg)
+	defer func() { close(stopCh); wg.Wait() }()
+
+	h.Send(Event{Type: ContentChanged, Path: "/p"})
+	waitFor(t, func() bool { return h.Failed() == 1 })
+	if !strings.Contains(log.all(), "status 500") || h.Sent() != 0 {
+		t.Fatalf("sent = %d, log = %s", h.Sent(), log.all())
+	}
+}
+
+func TestWebhook_StopAbortsInFlightPost(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	h := NewWebhook(srv.URL, nil, nil)
+	h.Start(stopCh, &wg)
+	h.Send(Event{Type: ContentChanged, Path: "/p"})
+	time.Sleep(50 * time.Millisecond)
+
+	close(stopCh)
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("worker did not stop while a post was in flight")
+	}
+}
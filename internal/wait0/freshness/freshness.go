@@ -0,0 +1,137 @@
+// Package freshness derives an entry's freshness lifetime from origin
+// response headers.
+package freshness
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stale is returned by FromHeader when the response was already stale on
+// arrival: max-age=0, or an Expires that is past or unparseable.
+const Stale int64 = -1
+
+// FromHeader returns the freshness lifetime in seconds left for a response.
+// The Cache-Control s-maxage directive wins, then max-age, then Expires
+// measured against Date (or now when Date is absent); the Age an upstream
+// cache reports is then subtracted. It returns 0 when the response carries
+// none of them, and Stale when the lifetime has already run out.
+func FromHeader(h http.Header, now time.Time) int64 {
+	if n, ok := ParseCacheControl(h).maxAge(); ok {
+		n -= Age(h)
+		if n <= 0 {
+			return Stale
+		}
+		return n
+	}
+	vals := h.Values("Expires")
+	if len(vals) == 0 {
+		return 0
+	}
+	expires, err := http.ParseTime(strings.TrimSpace(vals[0]))
+	if err != nil {
+		return Stale
+	}
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		now = date
+	}
+	n := int64(expires.Sub(now)/time.Second) - Age(h)
+	if n <= 0 {
+		return Stale
+	}
+	return n
+}
+
+// CleanAge rewrites h's Age header to the value Age parses, and removes it
+// when that is 0, so a stored header never holds a malformed Age.
+func CleanAge(h http.Header) {
+	if n := Age(h); n > 0 {
+		h.Set("Age", strconv.FormatInt(n, 10))
+		return
+	}
+	h.Del("Age")
+}
+
+// Age returns the Age header in seconds: how long an upstream cache had
+// held the response. Missing, negative or malformed values count as 0.
+func Age(h http.Header) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(h.Get("Age")), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// CacheControl holds the Cache-Control directives wait0 acts on.
+type CacheControl struct {
+	NoStore bool
+	NoCache bool
+	// Private marks a response meant for a single user, which a shared
+	// cache must not store.
+	Private bool
+	// MaxAge and SMaxAge are the directive values in seconds, valid only
+	// when the matching Has flag is set.
+	MaxAge     int64
+	HasMaxAge  bool
+	SMaxAge    int64
+	HasSMaxAge bool
+}
+
+// ParseCacheControl parses every Cache-Control value in h. Directive names
+// are matched as whole tokens, case-insensitively, so an unknown extension
+// such as no-cache-ext does not count as no-cache. Qualified forms like
+// private="Set-Cookie" count as the bare directive. A max-age or s-maxage
+// that is not a non-negative integer is ignored; the first s-maxage and the
+// last max-age win.
+func ParseCacheControl(h http.Header) CacheControl {
+	var cc CacheControl
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, val, hasVal := strings.Cut(strings.TrimSpace(d), "=")
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "no-store":
+				cc.NoStore = true
+			case "no-cache":
+				cc.NoCache = true
+			case "private":
+				cc.Private = true
+			case "s-maxage":
+				if n, ok := seconds(val, hasVal); ok && !cc.HasSMaxAge {
+					cc.SMaxAge, cc.HasSMaxAge = n, true
+				}
+			case "max-age":
+				if n, ok := seconds(val, hasVal); ok {
+					cc.MaxAge, cc.HasMaxAge = n, true
+				}
+			}
+		}
+	}
+	return cc
+}
+
+// Storable reports whether a shared cache may store the response: none of
+// no-store, no-cache or private is set.
+func (cc CacheControl) Storable() bool {
+	return !cc.NoStore && !cc.NoCache && !cc.Private
+}
+
+// maxAge returns s-maxage, else max-age.
+func (cc CacheControl) maxAge() (int64, bool) {
+	if cc.HasSMaxAge {
+		return cc.SMaxAge, true
+	}
+	return cc.MaxAge, cc.HasMaxAge
+}
+
+func seconds(val string, hasVal bool) (int64, bool) {
+	if !hasVal {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(val), `"`), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
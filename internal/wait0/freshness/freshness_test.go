@@ -0,0 +1,97 @@
+package freshness
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFromHeader(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+	for _, tc := range []struct {
+		name string
+		hdr  map[string]string
+		want int64
+	}{
+		{name: "none", want: 0},
+		{name: "max-age", hdr: map[string]string{"Cache-Control": "public, max-age=300"}, want: 300},
+		{name: "s-maxage wins", hdr: map[string]string{"Cache-Control": "max-age=300, s-maxage=60"}, want: 60},
+		{name: "quoted", hdr: map[string]string{"Cache-Control": `S-MaxAge="90"`}, want: 90},
+		{name: "invalid max-age ignored", hdr: map[string]string{"Cache-Control": "max-age=soon"}, want: 0},
+		{name: "max-age zero", hdr: map[string]string{"Cache-Control": "max-age=0"}, want: Stale},
+		{name: "expires vs date", hdr: map[string]string{"Date": date, "Expires": now.Add(10 * time.Minute).Format(http.TimeFormat)}, want: 600},
+		{name: "max-age beats expires", hdr: map[string]string{"Cache-Control": "max-age=30", "Expires": now.Add(time.Hour).Format(http.TimeFormat)}, want: 30},
+		{name: "expires in the past", hdr: map[string]string{"Date": date, "Expires": now.Add(-time.Minute).Format(http.TimeFormat)}, want: Stale},
+		{name: "expires unparseable", hdr: map[string]string{"Expires": "0"}, want: Stale},
+		{name: "expires without date", hdr: map[string]string{"Expires": now.Add(2 * time.Minute).Format(http.TimeFormat)}, want: 120},
+		{name: "upstream age", hdr: map[string]string{"Cache-Control": "max-age=300", "Age": "100"}, want: 200},
+		{name: "upstream age past max-age", hdr: map[string]string{"Cache-Control": "max-age=300", "Age": "300"}, want: Stale},
+		{name: "upstream age with expires", hdr: map[string]string{"Date": date, "Expires": now.Add(10 * time.Minute).Format(http.TimeFormat), "Age": "60"}, want: 540},
+		{name: "malformed age ignored", hdr: map[string]string{"Cache-Control": "max-age=300", "Age": "old"}, want: 300},
+	} {
+		h := http.Header{}
+		for k, v := range tc.hdr {
+			h.Set(k, v)
+		}
+		if got := FromHeader(h, now); got != tc.want {
+			t.Fatalf("%s: FromHeader = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAgeAndCleanAge(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		want   int64
+		stored string
+	}{
+		{in: "", want: 0, stored: ""},
+		{in: " 42 ", want: 42, stored: "42"},
+		{in: "-5", want: 0, stored: ""},
+		{in: "soon", want: 0, stored: ""},
+		{in: "0", want: 0, stored: ""},
+	} {
+		h := http.Header{}
+		if tc.in != "" {
+			h.Set("Age", tc.in)
+		}
+		if got := Age(h); got != tc.want {
+			t.Fatalf("Age(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+		CleanAge(h)
+		if got := h.Get("Age"); got != tc.stored {
+			t.Fatalf("CleanAge(%q) left %q, want %q", tc.in, got, tc.stored)
+		}
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		values   []string
+		want     CacheControl
+		storable bool
+	}{
+		{name: "none", storable: true},
+		{name: "public max-age", values: []string{"public, max-age=60"}, want: CacheControl{MaxAge: 60, HasMaxAge: true}, storable: true},
+		{name: "no-store", values: []string{"No-Store"}, want: CacheControl{NoStore: true}},
+		{name: "no-cache with fields", values: []string{`no-cache="Set-Cookie"`}, want: CacheControl{NoCache: true}},
+		{name: "private", values: []string{"private, max-age=0"}, want: CacheControl{Private: true, HasMaxAge: true}},
+		{name: "extension tokens do not match", values: []string{"no-cache-ext, private-ish, x-no-store"}, storable: true},
+		{name: "across header lines", values: []string{"max-age=10", "s-maxage=5, private"}, want: CacheControl{Private: true, MaxAge: 10, HasMaxAge: true, SMaxAge: 5, HasSMaxAge: true}},
+		{name: "first s-maxage wins", values: []string{"s-maxage=5, s-maxage=9"}, want: CacheControl{SMaxAge: 5, HasSMaxAge: true}, storable: true},
+		{name: "bare max-age ignored", values: []string{"max-age"}, storable: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{"Cache-Control": tc.values}
+			got := ParseCacheControl(h)
+			if got != tc.want {
+				t.Fatalf("ParseCacheControl = %+v, want %+v", got, tc.want)
+			}
+			if got.Storable() != tc.storable {
+				t.Fatalf("Storable = %v, want %v", got.Storable(), tc.storable)
+			}
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package wait0
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"wait0/internal/wait0/auth"
 	"wait0/internal/wait0/dashboard"
+	"wait0/internal/wait0/freshness"
 	"wait0/internal/wait0/proxy"
 	"wait0/internal/wait0/statapi"
 )
@@ -44,6 +50,360 @@ func TestHandle_CacheMissThenHit(t *testing.T) {
 	}
 }
 
+func TestHandle_VaryByAcceptKeepsVariantsApart(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("Accept") == "application/xml" {
+			fmt.Fprint(w, "<ok/>")
+			return
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer origin.Close()
+
+	rule := mustRule(t, "PathPrefix(/)")
+	rule.varyBy = []string{"Accept"}
+	s := newTestService(t, origin.URL, []Rule{rule})
+
+	get := func(accept string) (string, string) {
+		req := httptest.NewRequest(http.MethodGet, "http://wait0.local/item", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Result().Header.Get("X-Wait0"), w.Body.String()
+	}
+
+	if st, body := get("application/json"); st != "miss" || body != `{"ok":true}` {
+		t.Fatalf("json first = %s %q", st, body)
+	}
+	if st, body := get("application/xml"); st != "miss" || body != "<ok/>" {
+		t.Fatalf("xml first = %s %q", st, body)
+	}
+	if st, body := get("application/json"); st != "hit" || body != `{"ok":true}` {
+		t.Fatalf("json second = %s %q", st, body)
+	}
+	if st, body := get("application/xml"); st != "hit" || body != "<ok/>" {
+		t.Fatalf("xml second = %s %q", st, body)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("origin hits = %d, want 2", got)
+	}
+}
+
+func TestHandle_RewriteLocationPassesRedirectThrough(t *testing.T) {
+	var originURL string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, originURL+"/new", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "new")
+	}))
+	defer origin.Close()
+	originURL = origin.URL
+
+	rule := mustRule(t, "PathPrefix(/)")
+	rule.RewriteLocation = true
+	s := newTestService(t, origin.URL, []Rule{rule})
+
+	req := httptest.NewRequest(http.MethodGet, "http://wait0.local/old", nil)
+	req.Header.Set("X-Forwarded-Host", "www.example.com")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want 302", w.Code)
+	}
+	if got := w.Result().Header.Get("Location"); got != "https://www.example.com/new" {
+		t.Fatalf("Location = %q", got)
+	}
+}
+
+func TestHandle_HostTemplateSeparatesTenants(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, []Rule{mustRule(t, "PathPrefix(/)")})
+	s.config().CacheKey.hostRe = regexp.MustCompile(`^([^.]+)\.example\.com$`)
+
+	get := func(host string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/page", nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Result().Header.Get("X-Wait0")
+	}
+
+	if got := get("acme.example.com"); got != "miss" {
+		t.Fatalf("acme first = %q", got)
+	}
+	if got := get("globex.example.com"); got != "miss" {
+		t.Fatalf("globex first = %q", got)
+	}
+	if got := get("acme.example.com:443"); got != "hit" {
+		t.Fatalf("acme second = %q", got)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("origin hits = %d, want 2", got)
+	}
+	if _, ok := s.ram.Peek("/page#%40host=acme"); !ok {
+		t.Fatalf("expected tenant key in RAM")
+	}
+}
+
+func TestHandle_QueryStringSeparatesEntries(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "results for "+r.URL.Query().Get("q"))
+	}))
+	defer origin.Close()
+
+	plain := mustRule(t, "PathPrefix(/search)")
+	ignored := mustRule(t, "PathPrefix(/landing)")
+	ignored.IgnoreQuery = true
+	s := newTestService(t, origin.URL, []Rule{plain, ignored})
+
+	get := func(target string) (string, string) {
+		req := httptest.NewRequest(http.MethodGet, "http://wait0.local"+target, nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Result().Header.Get("X-Wait0"), w.Body.String()
+	}
+
+	get("/search?q=cats")
+	if wait0, body := get("/search?q=dogs"); wait0 != "miss" || body != "results for dogs" {
+		t.Fatalf("dogs = %q %q", wait0, body)
+	}
+	if wait0, body := get("/search?q=cats"); wait0 != "hit" || body != "results for cats" {
+		t.Fatalf("cats again = %q %q", wait0, body)
+	}
+
+	get("/landing?q=utm")
+	if wait0, body := get("/landing?q=other"); wait0 != "hit" || body != "results for utm" {
+		t.Fatalf("ignoreQuery rule = %q %q", wait0, body)
+	}
+}
+
+func TestHandle_NegativeCacheShieldsOrigin(t *testing.T) {
+	var calls atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer origin.Close()
+
+	rule := mustRule(t, "PathPrefix(/)")
+	rule.negTTL = map[int]time.Duration{5: time.Minute}
+	s := newTestService(t, origin.URL, []Rule{rule})
+
+	get := func() (int, string) {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/broken", nil))
+		return w.Code, w.Result().Header.Get("X-Wait0")
+	}
+	if code, wait0 := get(); code != http.StatusServiceUnavailable || wait0 != "ignore-by-status" {
+		t.Fatalf("first = %d %q", code, wait0)
+	}
+	if code, wait0 := get(); code != http.StatusServiceUnavailable || wait0 != "hit" {
+		t.Fatalf("second = %d %q, want the cached 503", code, wait0)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("origin calls = %d, want 1", n)
+	}
+}
+
+func TestHandle_StaleIfErrorServesCachedEntry(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer origin.Close()
+
+	rule := mustRule(t, "PathPrefix(/)")
+	rule.expDur = time.Minute
+	rule.maxAgeDur = 5 * time.Minute
+	rule.staleErrDur = time.Hour
+	s := newTestService(t, origin.URL, []Rule{rule})
+	s.ram.Put("/page", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("cached"), StoredAt: time.Now().Add(-10 * time.Minute).Unix()}, s.disk, s.overflowLog)
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/page", nil))
+
+	if w.Result().StatusCode != http.StatusOK || w.Body.String() != "cached" {
+		t.Fatalf("status=%d body=%q, want the cached entry", w.Result().StatusCode, w.Body.String())
+	}
+	if got := w.Header().Get("X-Wait0"); got != "stale-if-error" {
+		t.Fatalf("X-Wait0 = %q, want stale-if-error", got)
+	}
+	if _, ok := s.ram.Peek("/page"); !ok {
+		t.Fatalf("entry evicted by the origin error")
+	}
+}
+
+func TestHandle_RuleOriginRoutesMatchingPaths(t *testing.T) {
+	backend := func(name string, hits *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			fmt.Fprint(w, name)
+		}))
+	}
+	var siteHits, apiHits atomic.Int32
+	site := backend("site", &siteHits)
+	defer site.Close()
+	api := backend("api", &apiHits)
+	defer api.Close()
+
+	apiRule := mustRule(t, "PathPrefix(/api)")
+	apiRule.Priority = 1
+	apiRule.Origin = api.URL
+	s := newTestService(t, site.URL, []Rule{apiRule, mustRule(t, "PathPrefix(/)")})
+
+	for path, want := range map[string]string{"/api/items": "api", "/page": "site"} {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local"+path, nil))
+		if w.Body.String() != want {
+			t.Fatalf("%s body = %q, want %q", path, w.Body.String(), want)
+		}
+	}
+
+	s.reval.Once(context.Background(), "/api/items", "/api/items", "", "warmup")
+	if apiHits.Load() != 2 || siteHits.Load() != 1 {
+		t.Fatalf("api hits=%d site hits=%d, want revalidation to go to the rule origin", apiHits.Load(), siteHits.Load())
+	}
+}
+
+func TestHandle_CacheOptionsPreflight(t *testing.T) {
+	var preflights atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			preflights.Add(1)
+			w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fmt.Fprint(w, "body")
+	}))
+	defer origin.Close()
+
+	rule := mustRule(t, "PathPrefix(/api)")
+	rule.CacheOptions = true
+	s := newTestService(t, origin.URL, []Rule{rule})
+
+	serve := func(method, from string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://wait0.local/api/items", nil)
+		if from != "" {
+			req.Header.Set("Origin", from)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	serve(http.MethodOptions, "https://a.example")
+	w := serve(http.MethodOptions, "https://a.example")
+	if w.Header().Get("X-Wait0") != "hit" || w.Header().Get("Access-Control-Allow-Origin") != "https://a.example" {
+		t.Fatalf("second preflight = %q allow-origin=%q", w.Header().Get("X-Wait0"), w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if w := serve(http.MethodOptions, "https://b.example"); w.Header().Get("X-Wait0") != "miss" {
+		t.Fatalf("other origin = %q, want its own miss", w.Header().Get("X-Wait0"))
+	}
+	if preflights.Load() != 2 {
+		t.Fatalf("origin preflights = %d, want 2", preflights.Load())
+	}
+	if w := serve(http.MethodGet, ""); w.Header().Get("X-Wait0") != "miss" || w.Body.String() != "body" {
+		t.Fatalf("GET = %q %q, want the GET response, not the preflight", w.Header().Get("X-Wait0"), w.Body.String())
+	}
+}
+
+func TestHandle_CachePostByBody(t *testing.T) {
+	var posts atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPost {
+			posts.Add(1)
+		}
+		fmt.Fprintf(w, "%s %s", r.Method, b)
+	}))
+	defer origin.Close()
+
+	rule := mustRule(t, "PathPrefix(/graphql)")
+	rule.cachePost, rule.bodyMax = true, 1024
+	s := newTestService(t, origin.URL, []Rule{rule})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://wait0.local/graphql", strings.NewReader(body)))
+		return w
+	}
+
+	post(`{"q":"a"}`)
+	if w := post(`{"q":"a"}`); w.Header().Get("X-Wait0") != "hit" || w.Body.String() != `POST {"q":"a"}` {
+		t.Fatalf("repeat = %q %q, want a hit", w.Header().Get("X-Wait0"), w.Body.String())
+	}
+	if w := post(`{"q":"b"}`); w.Header().Get("X-Wait0") != "miss" || w.Body.String() != `POST {"q":"b"}` {
+		t.Fatalf("other body = %q %q, want its own miss", w.Header().Get("X-Wait0"), w.Body.String())
+	}
+	if posts.Load() != 2 {
+		t.Fatalf("origin POSTs = %d, want 2", posts.Load())
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/graphql", nil))
+	if w.Header().Get("X-Wait0") != "miss" || w.Body.String() != "GET " {
+		t.Fatalf("GET = %q %q, want the GET response, not a cached POST", w.Header().Get("X-Wait0"), w.Body.String())
+	}
+}
+
+func TestHandle_StoresOriginMaxAge(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=45")
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, []Rule{mustRule(t, "PathPrefix(/)")})
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://wait0.local/page", nil))
+
+	ent, ok := s.ram.Peek("/page")
+	if !ok || ent.MaxAge != 45 {
+		t.Fatalf("RAM entry MaxAge = %d ok=%v, want 45", ent.MaxAge, ok)
+	}
+	waitFor(t, time.Second, func() bool { return s.disk.HasKey("/page") })
+	if ent, _ := s.disk.Peek("/page"); ent.MaxAge != 45 {
+		t.Fatalf("disk entry MaxAge = %d, want 45", ent.MaxAge)
+	}
+}
+
+func TestHandle_PastExpiresIsStaleOnArrival(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Expires", "Thu, 01 Jan 1970 00:00:00 GMT")
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, []Rule{mustRule(t, "PathPrefix(/)")})
+	get := func() string {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/legacy", nil))
+		return w.Result().Header.Get("X-Wait0")
+	}
+
+	if got := get(); got != "miss" {
+		t.Fatalf("first = %q, want miss", got)
+	}
+	if ent, _ := s.ram.Peek("/legacy"); ent.MaxAge != freshness.Stale {
+		t.Fatalf("MaxAge = %d, want stale on arrival", ent.MaxAge)
+	}
+	if got := get(); got != "hit" {
+		t.Fatalf("second = %q, want hit", got)
+	}
+	waitFor(t, time.Second, func() bool { return hits.Load() == 2 })
+}
+
 func TestHandle_BypassWhenCookiePresent(t *testing.T) {
 	var hits atomic.Int32
 	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
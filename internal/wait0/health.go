@@ -0,0 +1,33 @@
+package wait0
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// DefaultHealthPath is where the health endpoint is served unless
+// server.healthPath moves it.
+const DefaultHealthPath = "/wait0/healthz"
+
+type healthResponse struct {
+	Status    string `json:"status"`
+	RAMBytes  int64  `json:"ramBytes"`
+	DiskBytes int64  `json:"diskBytes"`
+	Error     string `json:"error,omitempty"`
+}
+
+// handleHealth reports 200 with cache sizes while the disk cache answers
+// reads and 503 once it is closed or failing. The response is never cached.
+func (s *Service) handleHealth(w http.ResponseWriter) {
+	resp := healthResponse{Status: "ok", RAMBytes: s.ram.TotalSize(), DiskBytes: s.disk.TotalSize()}
+	code := http.StatusOK
+	if err := s.disk.Check(); err != nil {
+		resp.Status = "unavailable"
+		resp.Error = err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
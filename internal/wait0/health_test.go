@@ -0,0 +1,73 @@
+package wait0
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealth_ReportsSizesWithoutTouchingOrigin(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, []Rule{mustRule(t, "PathPrefix(/)")})
+	s.config().Server.HealthPath = DefaultHealthPath
+	s.ram.Put("/a", CacheEntry{Status: 200, Body: []byte("body")}, s.disk, s.overflowLog)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local"+DefaultHealthPath, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		if got := w.Result().Header.Get("Cache-Control"); got != "no-store" {
+			t.Fatalf("Cache-Control = %q", got)
+		}
+		var resp healthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.Status != "ok" || resp.RAMBytes == 0 {
+			t.Fatalf("response = %+v", resp)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("origin hits = %d, want none", hits.Load())
+	}
+	if _, ok := s.ram.Peek(s.pathKey(DefaultHealthPath)); ok {
+		t.Fatalf("health response was cached")
+	}
+}
+
+func TestHealth_CustomPathAndClosedDisk(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	s.config().Server.HealthPath = "/_health"
+
+	closed, err := newDiskCache(filepath.Join(t.TempDir(), "leveldb"), 1024, true)
+	if err != nil {
+		t.Fatalf("newDiskCache: %v", err)
+	}
+	closed.close()
+	orig := s.disk
+	s.disk = closed
+	defer func() { s.disk = orig }()
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/_health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+	var resp healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Status != "unavailable" || resp.Error == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+}
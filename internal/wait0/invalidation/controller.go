@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
 	"mime"
 	"net/http"
 	"strings"
@@ -14,6 +13,8 @@ import (
 	"time"
 
 	"wait0/internal/wait0/auth"
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/logging"
 )
 
 const WriteScope = "invalidation:write"
@@ -36,6 +37,13 @@ type Runtime interface {
 	HasKey(key string) bool
 	DeleteKey(key string)
 	RecrawlKey(ctx context.Context, key string) string
+	MarkStale(key string) bool
+	// PurgeKey deletes key from every tier and reports the bytes it was
+	// charged and whether it was cached.
+	PurgeKey(key string) (int64, bool)
+	// WarmPath queues a background fetch of path and reports whether it was
+	// queued; bypassed paths and a full worker pool yield false.
+	WarmPath(path string) bool
 }
 
 type request struct {
@@ -85,6 +93,11 @@ func NewController(cfg Config, authn *auth.Authenticator, rt Runtime, stop <-cha
 	return c
 }
 
+// QueueDepth reports how many accepted jobs are waiting for a worker.
+func (c *Controller) QueueDepth() int {
+	return len(c.queue)
+}
+
 func (c *Controller) Handle(w http.ResponseWriter, r *http.Request) {
 	if !c.cfg.Enabled {
 		http.NotFound(w, r)
@@ -105,7 +118,7 @@ func (c *Controller) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	actor, ok := c.authn.AuthenticateBearer(r.Header.Get("Authorization"))
+	actor, ok := c.authn.AuthenticateRequest(r)
 	if !ok {
 		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "unauthorized"})
 		return
@@ -154,14 +167,14 @@ func (c *Controller) Handle(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "paths limit exceeded"})
 			return
 		}
-		log.Printf("invalidation request over soft path limit: actor=%q requestPaths=%d maxPaths=%d", actor.ID, len(normalizedPaths), c.cfg.MaxPaths)
+		logging.Warnf("invalidation request over soft path limit: actor=%q requestPaths=%d maxPaths=%d", actor.ID, len(normalizedPaths), c.cfg.MaxPaths)
 	}
 	if len(normalizedTags) > c.cfg.MaxTags {
 		if c.cfg.HardLimits {
 			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "tags limit exceeded"})
 			return
 		}
-		log.Printf("invalidation request over soft tag limit: actor=%q requestTags=%d maxTags=%d", actor.ID, len(normalizedTags), c.cfg.MaxTags)
+		logging.Warnf("invalidation request over soft tag limit: actor=%q requestTags=%d maxTags=%d", actor.ID, len(normalizedTags), c.cfg.MaxTags)
 	}
 
 	job := Job{
@@ -176,7 +189,7 @@ func (c *Controller) Handle(w http.ResponseWriter, r *http.Request) {
 
 	select {
 	case c.queue <- job:
-		log.Printf("invalidation accepted: request_id=%q actor=%q remote=%q paths=%d tags=%d", job.RequestID, job.ActorID, job.RemoteAddr, len(job.Paths), len(job.Tags))
+		logging.Infof("invalidation accepted: request_id=%q actor=%q remote=%q paths=%d tags=%d", job.RequestID, job.ActorID, job.RemoteAddr, len(job.Paths), len(job.Tags))
 		writeJSON(w, http.StatusAccepted, map[string]any{
 			"status":     "accepted",
 			"request_id": job.RequestID,
@@ -224,6 +237,11 @@ func (c *Controller) processJob(workerID int, job Job) {
 	for _, p := range job.Paths {
 		keys[p] = struct{}{}
 	}
+	if len(job.Paths) > 0 {
+		for _, k := range c.resolveVariantKeys(keys) {
+			keys[k] = struct{}{}
+		}
+	}
 	if len(job.Tags) > 0 {
 		tagSet := make(map[string]struct{}, len(job.Tags))
 		for _, tag := range job.Tags {
@@ -267,6 +285,7 @@ func (c *Controller) processJob(workerID int, job Job) {
 			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 			kind := c.rt.RecrawlKey(ctx, k)
 			cancel()
+			logging.Debugf("invalidation recrawl: request_id=%q key=%q kind=%s", job.RequestID, k, kind)
 			mu.Lock()
 			if kind == "updated" || kind == "unchanged" {
 				recrawled++
@@ -279,7 +298,7 @@ func (c *Controller) processJob(workerID int, job Job) {
 	}
 	wg.Wait()
 
-	log.Printf(
+	logging.Infof(
 		"invalidation completed: request_id=%q actor=%q worker=%d requested_paths=%d requested_tags=%d resolved_keys=%d invalidated=%d recrawled=%d recrawl_errors=%d took=%s",
 		job.RequestID,
 		job.ActorID,
@@ -294,6 +313,22 @@ func (c *Controller) processJob(workerID int, job Job) {
 	)
 }
 
+// resolveVariantKeys returns cached keys that are variants (e.g. varyBy
+// headers) of the given paths.
+func (c *Controller) resolveVariantKeys(paths map[string]struct{}) []string {
+	var out []string
+	for _, key := range c.rt.CachedKeys() {
+		p := cachekey.Path(key)
+		if p == key {
+			continue
+		}
+		if _, ok := paths[p]; ok {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
 func (c *Controller) resolveKeysByTags(tags map[string]struct{}) []string {
 	if len(tags) == 0 {
 		return nil
@@ -15,6 +15,11 @@ type fakeRuntime struct {
 	tagsByKey   map[string][]string
 	present     map[string]bool
 	recrawlKind map[string]string
+	deleted     []string
+	stale       []string
+	warmed      []string
+	warmFull    bool
+	sizes       map[string]int64
 }
 
 func (f *fakeRuntime) CachedKeys() []string {
@@ -34,9 +39,35 @@ func (f *fakeRuntime) HasKey(key string) bool {
 }
 
 func (f *fakeRuntime) DeleteKey(key string) {
+	f.deleted = append(f.deleted, key)
 	delete(f.present, key)
 }
 
+func (f *fakeRuntime) WarmPath(path string) bool {
+	if f.warmFull {
+		return false
+	}
+	f.warmed = append(f.warmed, path)
+	return true
+}
+
+func (f *fakeRuntime) MarkStale(key string) bool {
+	if !f.present[key] {
+		return false
+	}
+	f.stale = append(f.stale, key)
+	return true
+}
+
+func (f *fakeRuntime) PurgeKey(key string) (int64, bool) {
+	if !f.present[key] {
+		return 0, false
+	}
+	f.deleted = append(f.deleted, key)
+	delete(f.present, key)
+	return f.sizes[key], true
+}
+
 func (f *fakeRuntime) RecrawlKey(_ context.Context, key string) string {
 	f.present[key] = true
 	if v, ok := f.recrawlKind[key]; ok {
@@ -96,3 +127,23 @@ func TestProcessJob_ByTag(t *testing.T) {
 		t.Fatalf("expected /b to remain present")
 	}
 }
+
+func TestProcessJob_ByPathIncludesVariants(t *testing.T) {
+	rt := &fakeRuntime{
+		tagsByKey: map[string][]string{"/a": nil, "/a#Accept=application%2Fjson": nil, "/ab": nil},
+		present:   map[string]bool{"/a": true, "/a#Accept=application%2Fjson": true, "/ab": true},
+	}
+	ctrl := NewController(Config{Enabled: true, QueueSize: 1, WorkerConcurrency: 1, MaxBodyBytes: 4096, MaxPaths: 10, MaxTags: 10}, auth.NewAuthenticator(nil), rt, make(chan struct{}), nil)
+	ctrl.processJob(1, Job{RequestID: "r1", ActorID: "x", Paths: []string{"/a"}})
+
+	deleted := map[string]bool{}
+	for _, k := range rt.deleted {
+		deleted[k] = true
+	}
+	if !deleted["/a"] || !deleted["/a#Accept=application%2Fjson"] {
+		t.Fatalf("deleted = %v, want /a and its variant", rt.deleted)
+	}
+	if deleted["/ab"] {
+		t.Fatalf("unrelated key /ab must not be invalidated")
+	}
+}
@@ -0,0 +1,100 @@
+package invalidation
+
+import (
+	"net/http"
+	"strings"
+
+	"wait0/internal/wait0/auth"
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/logging"
+)
+
+const PurgeEndpointPath = "/wait0/cache"
+
+// HandlePurge synchronously deletes the cached entry for ?path= from every
+// tier, along with keys that vary by request headers, and reports the bytes
+// freed. With ?prefix= instead it deletes every key whose path starts with
+// the prefix, the same test a PathPrefix rule applies. Unlike the
+// invalidation queue it does not recrawl anything.
+func (c *Controller) HandlePurge(w http.ResponseWriter, r *http.Request) {
+	if !c.cfg.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		return
+	}
+
+	actor, ok := c.authn.AuthenticateRequest(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "unauthorized"})
+		return
+	}
+	if !auth.AuthorizedForScope(actor, WriteScope) {
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": "forbidden"})
+		return
+	}
+
+	q := r.URL.Query()
+	path, err := NormalizePath(q.Get("path"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "path: " + err.Error()})
+		return
+	}
+	prefix, err := NormalizePath(q.Get("prefix"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "prefix: " + err.Error()})
+		return
+	}
+	if (path == "") == (prefix == "") {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "exactly one of path or prefix query parameters is required"})
+		return
+	}
+	if prefix != "" {
+		c.purgePrefix(w, r, actor, prefix)
+		return
+	}
+
+	keys := append([]string{path}, c.resolveVariantKeys(map[string]struct{}{path: {}})...)
+	purged := 0
+	var freed int64
+	for _, key := range keys {
+		if n, ok := c.rt.PurgeKey(key); ok {
+			purged++
+			freed += n
+		}
+	}
+	if purged == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not cached", "path": path})
+		return
+	}
+	logging.Infof("cache purged: actor=%q remote=%q path=%q keys=%d freed=%d", actor.ID, strings.TrimSpace(r.RemoteAddr), path, purged, freed)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"path":        path,
+		"keys":        purged,
+		"freed_bytes": freed,
+	})
+}
+
+func (c *Controller) purgePrefix(w http.ResponseWriter, r *http.Request, actor auth.Principal, prefix string) {
+	purged := 0
+	var freed int64
+	for _, key := range c.rt.CachedKeys() {
+		if !strings.HasPrefix(cachekey.Path(key), prefix) {
+			continue
+		}
+		// Keys purged concurrently by someone else, or evicted since the
+		// snapshot, report false and are not counted.
+		if n, ok := c.rt.PurgeKey(key); ok {
+			purged++
+			freed += n
+		}
+	}
+	logging.Infof("cache purged: actor=%q remote=%q prefix=%q keys=%d freed=%d", actor.ID, strings.TrimSpace(r.RemoteAddr), prefix, purged, freed)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"prefix":      prefix,
+		"keys":        purged,
+		"freed_bytes": freed,
+	})
+}
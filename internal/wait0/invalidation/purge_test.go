@@ -0,0 +1,115 @@
+package invalidation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlePurge_DeletesPathAndVariants(t *testing.T) {
+	rt := &fakeRuntime{
+		tagsByKey: map[string][]string{"/foo": nil, "/foo#Accept=application%2Fjson": nil, "/foobar": nil},
+		present:   map[string]bool{"/foo": true, "/foo#Accept=application%2Fjson": true, "/foobar": true},
+		sizes:     map[string]int64{"/foo": 100, "/foo#Accept=application%2Fjson": 40, "/foobar": 7},
+	}
+	ctrl := newStaleController(rt)
+
+	req := httptest.NewRequest(http.MethodDelete, "http://wait0.local"+PurgeEndpointPath+"?path=/foo", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	ctrl.HandlePurge(w, req)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", w.Result().StatusCode)
+	}
+	var resp map[string]any
+	if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp["path"] != "/foo" || resp["keys"].(float64) != 2 || resp["freed_bytes"].(float64) != 140 {
+		t.Fatalf("response = %v", resp)
+	}
+	if len(rt.deleted) != 2 || !rt.present["/foobar"] {
+		t.Fatalf("deleted = %v, want /foo and its variant only", rt.deleted)
+	}
+}
+
+func TestHandlePurge_Prefix(t *testing.T) {
+	rt := &fakeRuntime{
+		tagsByKey: map[string][]string{"/blog/": nil, "/blog/a": nil, "/blog/b#Accept=text%2Fhtml": nil, "/blogroll": nil, "/about": nil},
+		present:   map[string]bool{"/blog/": true, "/blog/a": true, "/blog/b#Accept=text%2Fhtml": true, "/blogroll": true, "/about": true},
+		sizes:     map[string]int64{"/blog/": 1, "/blog/a": 10, "/blog/b#Accept=text%2Fhtml": 100, "/blogroll": 1000},
+	}
+	ctrl := newStaleController(rt)
+
+	purge := func() map[string]any {
+		req := httptest.NewRequest(http.MethodDelete, "http://wait0.local"+PurgeEndpointPath+"?prefix=/blog/", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		ctrl.HandlePurge(w, req)
+		if w.Result().StatusCode != http.StatusOK {
+			t.Fatalf("status = %d", w.Result().StatusCode)
+		}
+		var resp map[string]any
+		if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp
+	}
+
+	resp := purge()
+	if resp["prefix"] != "/blog/" || resp["keys"].(float64) != 3 || resp["freed_bytes"].(float64) != 111 {
+		t.Fatalf("response = %v", resp)
+	}
+	if !rt.present["/blogroll"] || !rt.present["/about"] {
+		t.Fatalf("keys outside the prefix were purged: %v", rt.deleted)
+	}
+
+	// Keys already gone are not counted again.
+	if resp := purge(); resp["keys"].(float64) != 0 || resp["freed_bytes"].(float64) != 0 {
+		t.Fatalf("second purge = %v, want nothing counted", resp)
+	}
+}
+
+func TestHandlePurge_NotCachedAndErrors(t *testing.T) {
+	rt := &fakeRuntime{tagsByKey: map[string][]string{}, present: map[string]bool{}}
+	ctrl := newStaleController(rt)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{name: "not cached", method: http.MethodDelete, target: "?path=/nope", token: "secret", want: http.StatusNotFound},
+		{name: "missing path", method: http.MethodDelete, target: "", token: "secret", want: http.StatusBadRequest},
+		{name: "path and prefix", method: http.MethodDelete, target: "?path=/a&prefix=/b", token: "secret", want: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodPost, target: "?path=/a", token: "secret", want: http.StatusMethodNotAllowed},
+		{name: "unauthorized", method: http.MethodDelete, target: "?path=/a", token: "", want: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "http://wait0.local"+PurgeEndpointPath+tc.target, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			ctrl.HandlePurge(w, req)
+			if w.Result().StatusCode != tc.want {
+				t.Fatalf("status = %d, want %d", w.Result().StatusCode, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandlePurge_DisabledIsNotFound(t *testing.T) {
+	ctrl := NewController(Config{}, nil, &fakeRuntime{}, make(chan struct{}), nil)
+	req := httptest.NewRequest(http.MethodDelete, "http://wait0.local"+PurgeEndpointPath+"?path=/a", nil)
+	w := httptest.NewRecorder()
+	ctrl.HandlePurge(w, req)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Result().StatusCode)
+	}
+}
@@ -0,0 +1,59 @@
+package invalidation
+
+import (
+	"net/http"
+	"strings"
+
+	"wait0/internal/wait0/auth"
+	"wait0/internal/wait0/logging"
+)
+
+const StaleEndpointPath = "/wait0/stale"
+
+// HandleStale marks the cached entry for ?path= stale without deleting it, so
+// the next request serves it once more and triggers async revalidation. Keys
+// that vary by request headers are marked along with the plain path key.
+func (c *Controller) HandleStale(w http.ResponseWriter, r *http.Request) {
+	if !c.cfg.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		return
+	}
+
+	actor, ok := c.authn.AuthenticateRequest(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "unauthorized"})
+		return
+	}
+	if !auth.AuthorizedForScope(actor, WriteScope) {
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": "forbidden"})
+		return
+	}
+
+	path, err := NormalizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "path: " + err.Error()})
+		return
+	}
+	if path == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "path query parameter is required"})
+		return
+	}
+
+	keys := append([]string{path}, c.resolveVariantKeys(map[string]struct{}{path: {}})...)
+	marked := 0
+	for _, key := range keys {
+		if c.rt.MarkStale(key) {
+			marked++
+		}
+	}
+	logging.Infof("stale marked: actor=%q remote=%q path=%q keys=%d", actor.ID, strings.TrimSpace(r.RemoteAddr), path, marked)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"path":    path,
+		"existed": marked > 0,
+		"keys":    marked,
+	})
+}
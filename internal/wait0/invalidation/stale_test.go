@@ -0,0 +1,83 @@
+package invalidation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wait0/internal/wait0/auth"
+)
+
+func newStaleController(rt Runtime) *Controller {
+	authn := auth.NewAuthenticator([]auth.TokenConfig{{ID: "ops", Token: "secret", Scopes: []string{WriteScope}}})
+	return NewController(Config{Enabled: true, QueueSize: 1, MaxBodyBytes: 4096, MaxPaths: 10, MaxTags: 10}, authn, rt, make(chan struct{}), nil)
+}
+
+func TestHandleStale_MarksPathAndVariants(t *testing.T) {
+	rt := &fakeRuntime{
+		tagsByKey: map[string][]string{"/foo": nil, "/foo#Accept=application%2Fjson": nil, "/foobar": nil},
+		present:   map[string]bool{"/foo": true, "/foo#Accept=application%2Fjson": true, "/foobar": true},
+	}
+	ctrl := newStaleController(rt)
+
+	req := httptest.NewRequest(http.MethodPost, "http://wait0.local"+StaleEndpointPath+"?path=/foo", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	ctrl.HandleStale(w, req)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", w.Result().StatusCode)
+	}
+	var resp map[string]any
+	if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp["existed"] != true || resp["keys"].(float64) != 2 {
+		t.Fatalf("response = %v", resp)
+	}
+	if len(rt.stale) != 2 {
+		t.Fatalf("stale = %v, want /foo and its variant", rt.stale)
+	}
+	if len(rt.deleted) != 0 {
+		t.Fatalf("stale must not delete, deleted = %v", rt.deleted)
+	}
+}
+
+func TestHandleStale_MissingKeyAndErrors(t *testing.T) {
+	rt := &fakeRuntime{tagsByKey: map[string][]string{}, present: map[string]bool{}}
+	ctrl := newStaleController(rt)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{name: "unknown key", method: http.MethodPost, target: "?path=/nope", token: "secret", want: http.StatusOK},
+		{name: "missing path", method: http.MethodPost, target: "", token: "secret", want: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodGet, target: "?path=/a", token: "secret", want: http.StatusMethodNotAllowed},
+		{name: "unauthorized", method: http.MethodPost, target: "?path=/a", token: "", want: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "http://wait0.local"+StaleEndpointPath+tc.target, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			ctrl.HandleStale(w, req)
+			if w.Result().StatusCode != tc.want {
+				t.Fatalf("status = %d, want %d", w.Result().StatusCode, tc.want)
+			}
+			if tc.want == http.StatusOK {
+				var resp map[string]any
+				_ = json.NewDecoder(w.Result().Body).Decode(&resp)
+				if resp["existed"] != false {
+					t.Fatalf("existed = %v, want false", resp["existed"])
+				}
+			}
+		})
+	}
+}
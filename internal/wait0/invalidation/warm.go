@@ -0,0 +1,86 @@
+package invalidation
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"wait0/internal/wait0/auth"
+	"wait0/internal/wait0/logging"
+)
+
+const WarmEndpointPath = "/wait0/warm"
+
+// HandleWarm accepts a JSON array of paths and queues a background fetch for
+// each on the revalidation pool, so changed pages are cached before the next
+// warmup tick. Paths the pool has no room for are not queued; the response
+// reports how many were.
+func (c *Controller) HandleWarm(w http.ResponseWriter, r *http.Request) {
+	if !c.cfg.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(r.Header.Get("Content-Type")))
+	if err != nil || mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]any{"error": "content-type must be application/json"})
+		return
+	}
+
+	actor, ok := c.authn.AuthenticateRequest(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "unauthorized"})
+		return
+	}
+	if !auth.AuthorizedForScope(actor, WriteScope) {
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": "forbidden"})
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, int64(c.cfg.MaxBodyBytes))
+	defer body.Close()
+
+	var raw []string
+	dec := json.NewDecoder(body)
+	if err := dec.Decode(&raw); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid JSON body"})
+		return
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "JSON body must contain a single array"})
+		return
+	}
+
+	paths, err := NormalizePaths(raw)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	if len(paths) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "at least one non-empty path is required"})
+		return
+	}
+	// Warming fetches from origin, so the path limit is always enforced.
+	if len(paths) > c.cfg.MaxPaths {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "paths limit exceeded"})
+		return
+	}
+
+	enqueued := 0
+	for _, p := range paths {
+		if c.rt.WarmPath(p) {
+			enqueued++
+		}
+	}
+	logging.Infof("warm accepted: actor=%q remote=%q paths=%d enqueued=%d", actor.ID, strings.TrimSpace(r.RemoteAddr), len(paths), enqueued)
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"requested": len(paths),
+		"enqueued":  enqueued,
+	})
+}
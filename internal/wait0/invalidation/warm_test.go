@@ -0,0 +1,89 @@
+package invalidation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func warmRequest(body, token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "http://wait0.local"+WarmEndpointPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestHandleWarm_QueuesPaths(t *testing.T) {
+	rt := &fakeRuntime{}
+	ctrl := newStaleController(rt)
+
+	w := httptest.NewRecorder()
+	ctrl.HandleWarm(w, warmRequest(`["/a", "/b?x=1", "/a"]`, "secret"))
+
+	if w.Result().StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d", w.Result().StatusCode)
+	}
+	var resp map[string]any
+	if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp["requested"].(float64) != 2 || resp["enqueued"].(float64) != 2 {
+		t.Fatalf("response = %v", resp)
+	}
+	if len(rt.warmed) != 2 || rt.warmed[0] != "/a" || rt.warmed[1] != "/b" {
+		t.Fatalf("warmed = %v, want [/a /b]", rt.warmed)
+	}
+}
+
+func TestHandleWarm_ReportsPoolFull(t *testing.T) {
+	rt := &fakeRuntime{warmFull: true}
+	ctrl := newStaleController(rt)
+
+	w := httptest.NewRecorder()
+	ctrl.HandleWarm(w, warmRequest(`["/a"]`, "secret"))
+
+	var resp map[string]any
+	_ = json.NewDecoder(w.Result().Body).Decode(&resp)
+	if w.Result().StatusCode != http.StatusAccepted || resp["enqueued"].(float64) != 0 {
+		t.Fatalf("status = %d, response = %v", w.Result().StatusCode, resp)
+	}
+}
+
+func TestHandleWarm_Errors(t *testing.T) {
+	tooMany := `["/1","/2","/3","/4","/5","/6","/7","/8","/9","/10","/11"]`
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		token  string
+		want   int
+	}{
+		{name: "wrong method", method: http.MethodGet, body: `["/a"]`, token: "secret", want: http.StatusMethodNotAllowed},
+		{name: "unauthorized", method: http.MethodPost, body: `["/a"]`, token: "", want: http.StatusUnauthorized},
+		{name: "not an array", method: http.MethodPost, body: `{"paths":["/a"]}`, token: "secret", want: http.StatusBadRequest},
+		{name: "trailing value", method: http.MethodPost, body: `["/a"] ["/b"]`, token: "secret", want: http.StatusBadRequest},
+		{name: "empty", method: http.MethodPost, body: `[]`, token: "secret", want: http.StatusBadRequest},
+		{name: "too many paths", method: http.MethodPost, body: tooMany, token: "secret", want: http.StatusBadRequest},
+		{name: "body too large", method: http.MethodPost, body: `["/` + strings.Repeat("a", 5000) + `"]`, token: "secret", want: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &fakeRuntime{}
+			ctrl := newStaleController(rt)
+			req := warmRequest(tc.body, tc.token)
+			req.Method = tc.method
+			w := httptest.NewRecorder()
+			ctrl.HandleWarm(w, req)
+			if w.Result().StatusCode != tc.want {
+				t.Fatalf("status = %d, want %d", w.Result().StatusCode, tc.want)
+			}
+			if len(rt.warmed) != 0 {
+				t.Fatalf("warmed = %v, want none", rt.warmed)
+			}
+		})
+	}
+}
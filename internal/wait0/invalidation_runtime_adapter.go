@@ -3,7 +3,10 @@ package wait0
 import (
 	"context"
 	"strings"
+	"time"
 
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/freshness"
 	"wait0/internal/wait0/invalidation"
 )
 
@@ -77,13 +80,66 @@ func (a *invalidationRuntimeAdapter) HasKey(key string) bool {
 func (a *invalidationRuntimeAdapter) DeleteKey(key string) {
 	a.s.ram.Delete(key)
 	a.s.disk.Delete(key)
+	a.s.forgetVary(key)
 }
 
 func (a *invalidationRuntimeAdapter) RecrawlKey(ctx context.Context, key string) string {
 	if a.s.reval == nil {
 		return "error"
 	}
-	return a.s.reval.Once(ctx, key, key, "", "invalidate").Kind
+	p := cachekey.Parse(key)
+	return a.s.reval.Once(ctx, key, p.Path, p.Query, "invalidate").Kind
+}
+
+// MarkStale makes key's cached copies stale in place, in only the tiers that
+// hold them, and reports whether the key was cached. A copy with a lifetime
+// has its StoredAt backdated past it. A copy with none (no rule expiration,
+// origin max-age or default) never goes stale on its own, so it is marked
+// stale on arrival, as if origin had sent max-age=0, until revalidation
+// replaces it.
+func (a *invalidationRuntimeAdapter) MarkStale(key string) bool {
+	rule := newProxyRuntimeAdapter(a.s).PickRule(cachekey.Path(key))
+	stale := func(ent CacheEntry) CacheEntry {
+		if exp := rule.Freshness(toProxyEntry(ent)); exp > 0 {
+			ent.StoredAt = time.Now().Add(-exp - time.Second).Unix()
+		} else {
+			ent.MaxAge = freshness.Stale
+		}
+		return ent
+	}
+	marked := false
+	if ent, ok := a.s.ram.Peek(key); ok && a.s.ram.Refresh(key, stale(ent)) {
+		marked = true
+	}
+	// Disk touches only move StoredAt forward, so a backdated copy is
+	// rewritten in full.
+	if ent, ok := a.s.disk.Peek(key); ok {
+		a.s.disk.PutAsync(key, stale(ent))
+		marked = true
+	}
+	return marked
+}
+
+// PurgeKey deletes key from both tiers, and the Vary headers learned for
+// its path. Each tier reports a key only to the
+// purge that removed it, so concurrent purges never count it twice.
+func (a *invalidationRuntimeAdapter) PurgeKey(key string) (int64, bool) {
+	ramSize, inRAM := a.s.ram.Purge(key)
+	diskSize, onDisk := a.s.disk.Purge(key)
+	a.s.forgetVary(key)
+	return ramSize + diskSize, inRAM || onDisk
+}
+
+// WarmPath queues a background fetch of path on the revalidation pool unless
+// its rule bypasses the cache.
+func (a *invalidationRuntimeAdapter) WarmPath(path string) bool {
+	if a.s.reval == nil {
+		return false
+	}
+	if rule := newProxyRuntimeAdapter(a.s).PickRule(path); rule != nil && rule.Bypass {
+		return false
+	}
+	return a.s.reval.Async(a.s.pathKey(path), path, "", "warmup")
 }
 
 func (a *invalidationRuntimeAdapter) peekCacheEntry(key string) (CacheEntry, bool) {
@@ -4,8 +4,13 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/proxy"
 )
 
 func waitForInvalidation(t *testing.T, cond func() bool) {
@@ -76,3 +81,147 @@ func TestInvalidationRuntimeAdapter_RecrawlKey(t *testing.T) {
 		t.Fatalf("RecrawlKey nil reval kind = %q, want error", kind)
 	}
 }
+
+func TestInvalidationRuntimeAdapter_RecrawlKeyReplaysQuery(t *testing.T) {
+	uris := make(chan string, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uris <- r.URL.RequestURI()
+		_, _ = w.Write([]byte("results"))
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, nil)
+	key := cachekey.Parts{Path: "/search", Query: "page=2&q=cats"}.String()
+	if kind := newInvalidationRuntimeAdapter(s).RecrawlKey(context.Background(), key); kind != "updated" {
+		t.Fatalf("RecrawlKey kind = %q, want updated", kind)
+	}
+	if got := <-uris; got != "/search?page=2&q=cats" {
+		t.Fatalf("origin request = %q, want the query from the key", got)
+	}
+	if _, ok := s.ram.Peek(key); !ok {
+		t.Fatalf("recrawled entry not stored under its query key")
+	}
+}
+
+func TestInvalidationRuntimeAdapter_MarkStale(t *testing.T) {
+	rule := mustRule(t, "PathPrefix(/ttl)")
+	rule.expDur = time.Minute
+	s := newTestService(t, "http://example.com", []Rule{rule, mustRule(t, "PathPrefix(/none)")})
+	a := newInvalidationRuntimeAdapter(s)
+
+	if a.MarkStale("/missing") {
+		t.Fatalf("expected MarkStale false for missing key")
+	}
+
+	now := time.Now().Unix()
+	s.ram.Put("/ttl/ram", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x"), StoredAt: now}, s.disk, s.overflowLog)
+	if !a.MarkStale("/ttl/ram") {
+		t.Fatalf("expected MarkStale true for cached key")
+	}
+	ent, ok := s.ram.Peek("/ttl/ram")
+	if !ok {
+		t.Fatalf("stale entry must stay cached")
+	}
+	if age := now - ent.StoredAt; age <= int64(time.Minute/time.Second) {
+		t.Fatalf("StoredAt age = %ds, want past the 60s expiration", age)
+	}
+
+	s.disk.PutAsync("/ttl/disk", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x"), StoredAt: now})
+	waitFor(t, 500*time.Millisecond, func() bool { return s.disk.HasKey("/ttl/disk") })
+	if !a.MarkStale("/ttl/disk") {
+		t.Fatalf("expected MarkStale true for a disk-only key")
+	}
+	waitFor(t, 500*time.Millisecond, func() bool {
+		d, ok := s.disk.Peek("/ttl/disk")
+		return ok && now-d.StoredAt > int64(time.Minute/time.Second)
+	})
+	if _, ok := s.ram.Peek("/ttl/disk"); ok {
+		t.Fatalf("marking a disk-only key stale must not copy it into RAM")
+	}
+	if s.disk.HasKey("/ttl/ram") {
+		t.Fatalf("marking a RAM-only key stale must not write it to disk")
+	}
+
+	// Without any lifetime the entry would never go stale by age, so it is
+	// marked stale on arrival instead.
+	s.ram.Put("/none/p", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x"), StoredAt: now}, s.disk, s.overflowLog)
+	if !a.MarkStale("/none/p") {
+		t.Fatalf("expected MarkStale true for a key without a lifetime")
+	}
+	ent, _ = s.ram.Peek("/none/p")
+	pr := newProxyRuntimeAdapter(s).PickRule("/none/p")
+	if exp := pr.Freshness(toProxyEntry(ent)); exp <= 0 || !proxy.IsStale(toProxyEntry(ent), exp) {
+		t.Fatalf("entry without a lifetime not stale after MarkStale: MaxAge=%d", ent.MaxAge)
+	}
+}
+
+func TestInvalidationRuntimeAdapter_PurgeKey(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	a := newInvalidationRuntimeAdapter(s)
+
+	if _, ok := a.PurgeKey("/missing"); ok {
+		t.Fatalf("expected PurgeKey false for missing key")
+	}
+
+	s.ram.Put("/foo", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("body")}, s.disk, s.overflowLog)
+	s.disk.PutAsync("/foo", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("body")})
+	waitFor(t, 500*time.Millisecond, func() bool { return s.disk.HasKey("/foo") })
+	want := s.ram.TotalSize() + s.disk.TotalSize()
+
+	freed, ok := a.PurgeKey("/foo")
+	if !ok || freed != want {
+		t.Fatalf("PurgeKey = %d, %v; want %d, true", freed, ok, want)
+	}
+	if _, ok := s.ram.Peek("/foo"); ok {
+		t.Fatalf("expected RAM delete")
+	}
+	if s.disk.HasKey("/foo") {
+		t.Fatalf("disk key must be gone once PurgeKey returns")
+	}
+
+	s.ram.Put("/bar", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("body")}, s.disk, s.overflowLog)
+	var wg sync.WaitGroup
+	var hits atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := a.PurgeKey("/bar"); ok {
+				hits.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if hits.Load() != 1 {
+		t.Fatalf("concurrent purges reporting the key = %d, want 1", hits.Load())
+	}
+}
+
+func TestInvalidationRuntimeAdapter_WarmPath(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("warm"))
+	}))
+	defer origin.Close()
+
+	bypass := mustRule(t, "PathPrefix(/admin)")
+	bypass.Bypass = true
+	bypass.Priority = 1
+	s := newTestService(t, origin.URL, []Rule{bypass, mustRule(t, "PathPrefix(/)")})
+	a := newInvalidationRuntimeAdapter(s)
+
+	if a.WarmPath("/admin/x") {
+		t.Fatalf("expected WarmPath false for bypassed path")
+	}
+	if !a.WarmPath("/page") {
+		t.Fatalf("expected WarmPath true with a free pool")
+	}
+	waitFor(t, time.Second, func() bool {
+		ent, ok := s.ram.Peek("/page")
+		return ok && string(ent.Body) == "warm"
+	})
+
+	s.reval = nil
+	if a.WarmPath("/page") {
+		t.Fatalf("expected WarmPath false without revalidation")
+	}
+}
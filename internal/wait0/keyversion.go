@@ -0,0 +1,44 @@
+package wait0
+
+import (
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/logging"
+)
+
+// pathKey returns the plain cache key for path under the active key version.
+func (s *Service) pathKey(path string) string {
+	return cachekey.Parts{Path: path, Version: s.config().Storage.KeyVersion}.String()
+}
+
+// sweepStaleKeyVersions deletes cached entries built under a key version other
+// than the active one. Such entries are unreachable once storage.keyVersion
+// changes.
+func (s *Service) sweepStaleKeyVersions() int {
+	version := s.config().Storage.KeyVersion
+	var stale []string
+	collect := func(key string) bool {
+		if cachekey.Parse(key).Version != version {
+			stale = append(stale, key)
+		}
+		return true
+	}
+	s.ram.ForEach(collect)
+	s.disk.ForEach(collect)
+	for _, key := range stale {
+		s.ram.Delete(key)
+		s.forgetVary(key)
+		s.disk.Delete(key)
+	}
+	return len(stale)
+}
+
+// startKeyVersionSweep runs sweepStaleKeyVersions in the background.
+func (s *Service) startKeyVersionSweep() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if n := s.sweepStaleKeyVersions(); n > 0 {
+			logging.Infof("storage.keyVersion: swept %d stale keys (version=%q)", n, s.config().Storage.KeyVersion)
+		}
+	}()
+}
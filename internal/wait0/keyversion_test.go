@@ -0,0 +1,75 @@
+package wait0
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyVersion_ReloadMakesOldKeysUnreachableAndSweeps(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, []Rule{mustRule(t, "PathPrefix(/)")})
+	s.config().Storage.KeyVersion = "1"
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/page", nil))
+		return w.Result().Header.Get("X-Wait0")
+	}
+
+	if got := get(); got != "miss" {
+		t.Fatalf("first = %q, want miss", got)
+	}
+	if got := get(); got != "hit" {
+		t.Fatalf("second = %q, want hit", got)
+	}
+	if _, ok := s.ram.Peek("/page#%40v=1"); !ok {
+		t.Fatalf("expected versioned key in RAM")
+	}
+
+	next := *s.config()
+	next.Storage.KeyVersion = "2"
+	s.Reload(next)
+
+	if got := get(); got != "miss" {
+		t.Fatalf("after bump = %q, want miss", got)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("origin hits = %d, want 2", got)
+	}
+	waitFor(t, time.Second, func() bool {
+		_, inRAM := s.ram.Peek("/page#%40v=1")
+		return !inRAM && !s.disk.HasKey("/page#%40v=1")
+	})
+	if _, ok := s.ram.Peek("/page#%40v=2"); !ok {
+		t.Fatalf("current-version key must survive the sweep")
+	}
+}
+
+func TestSweepStaleKeyVersions(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	s.config().Storage.KeyVersion = "b"
+	ent := CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x")}
+	s.ram.Put("/a#%40v=a", ent, s.disk, s.overflowLog)
+	s.ram.Put("/a#%40v=b", ent, s.disk, s.overflowLog)
+	s.ram.Put("/plain", ent, s.disk, s.overflowLog)
+
+	if n := s.sweepStaleKeyVersions(); n != 2 {
+		t.Fatalf("swept = %d, want 2 (old version and unversioned)", n)
+	}
+	if _, ok := s.ram.Peek("/a#%40v=b"); !ok {
+		t.Fatalf("current-version key was swept")
+	}
+	if got := s.pathKey("/a"); got != "/a#%40v=b" {
+		t.Fatalf("pathKey = %q", got)
+	}
+}
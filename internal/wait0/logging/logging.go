@@ -0,0 +1,100 @@
+// Package logging filters log output by severity. Messages go to the standard
+// log package unchanged; the configured level only decides which are written.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is a log severity. Lower levels are more severe; a message is written
+// when its level is at or below the configured one.
+type Level int32
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+// DefaultLevel matches the verbosity wait0 had before levels existed.
+const DefaultLevel = LevelInfo
+
+var current atomic.Int32
+
+func init() {
+	current.Store(int32(DefaultLevel))
+}
+
+// ParseLevel parses error|warn|info|debug, case-insensitively. Empty yields
+// DefaultLevel.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return DefaultLevel, nil
+	case "error":
+		return LevelError, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "info":
+		return LevelInfo, nil
+	case "debug":
+		return LevelDebug, nil
+	}
+	return DefaultLevel, fmt.Errorf("unknown level %q (want error|warn|info|debug)", s)
+}
+
+func (l Level) String() string {
+	switch l {
+	case LevelError:
+		return "error"
+	case LevelWarn:
+		return "warn"
+	case LevelInfo:
+		return "info"
+	case LevelDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+// SetLevel sets the process-wide level. It is safe to call while logging.
+func SetLevel(l Level) {
+	current.Store(int32(l))
+}
+
+// CurrentLevel returns the process-wide level.
+func CurrentLevel() Level {
+	return Level(current.Load())
+}
+
+// Enabled reports whether messages at l are written.
+func Enabled(l Level) bool {
+	return l <= CurrentLevel()
+}
+
+func Errorf(format string, args ...any) { At(LevelError).Printf(format, args...) }
+func Warnf(format string, args ...any)  { At(LevelWarn).Printf(format, args...) }
+func Infof(format string, args ...any)  { At(LevelInfo).Printf(format, args...) }
+func Debugf(format string, args ...any) { At(LevelDebug).Printf(format, args...) }
+
+// Logger writes at a fixed level. It satisfies the Printf-style Logger
+// interfaces the module packages accept.
+type Logger struct {
+	level Level
+}
+
+// At returns a Logger that writes at l.
+func At(l Level) Logger {
+	return Logger{level: l}
+}
+
+func (l Logger) Printf(format string, args ...any) {
+	if !Enabled(l.level) {
+		return
+	}
+	log.Printf(format, args...)
+}
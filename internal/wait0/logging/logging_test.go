@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prevOut := log.Writer()
+	prevFlags := log.Flags()
+	prevLevel := CurrentLevel()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(prevOut)
+		log.SetFlags(prevFlags)
+		SetLevel(prevLevel)
+	})
+	return &buf
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Level
+		wantErr bool
+	}{
+		{in: "", want: LevelInfo},
+		{in: "error", want: LevelError},
+		{in: " WARN ", want: LevelWarn},
+		{in: "warning", want: LevelWarn},
+		{in: "info", want: LevelInfo},
+		{in: "Debug", want: LevelDebug},
+		{in: "trace", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := ParseLevel(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("ParseLevel(%q) err = %v, wantErr %v", tc.in, err, tc.wantErr)
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Fatalf("ParseLevel(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	buf := captureLog(t)
+
+	SetLevel(LevelWarn)
+	Errorf("e")
+	Warnf("w")
+	Infof("i")
+	Debugf("d")
+	if got := buf.String(); got != "e\nw\n" {
+		t.Fatalf("warn level output = %q", got)
+	}
+
+	buf.Reset()
+	SetLevel(LevelDebug)
+	At(LevelDebug).Printf("detail=%d", 1)
+	if got := buf.String(); !strings.Contains(got, "detail=1") {
+		t.Fatalf("debug level output = %q", got)
+	}
+}
+
+func TestDefaultLevelIsInfo(t *testing.T) {
+	if DefaultLevel != LevelInfo {
+		t.Fatalf("DefaultLevel = %s, want info", DefaultLevel)
+	}
+	if !Enabled(LevelInfo) || Enabled(LevelDebug) {
+		t.Fatalf("default level must write info and skip debug")
+	}
+}
@@ -0,0 +1,18 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"wait0/internal/wait0/freshness"
+)
+
+// withAge sets a single Age header on an entry served from cache: the age it
+// already had upstream when stored plus the time since StoredAt, so clients
+// behind a cache hierarchy see how old the response really is.
+func withAge(ent Entry, now time.Time) Entry {
+	age := freshness.Age(ent.Header) + max(now.Unix()-ent.StoredAt, 0)
+	ent.Header = CloneHeader(ent.Header)
+	ent.Header.Set("Age", strconv.FormatInt(age, 10))
+	return ent
+}
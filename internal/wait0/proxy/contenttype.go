@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// sniffLen is how much of a body http.DetectContentType looks at.
+const sniffLen = 512
+
+// FillContentType sets Content-Type on h when the origin sent none: detected
+// from body with http.DetectContentType when sniff is set, else def. Bodies
+// with a Content-Encoding are not sniffed, since their bytes say nothing about
+// the decoded type, and neither are empty ones. It reports whether it set a
+// value.
+func FillContentType(h http.Header, body []byte, def string, sniff bool) bool {
+	if _, ok := h["Content-Type"]; ok || h == nil {
+		return false
+	}
+	ct := def
+	if sniff && len(body) > 0 && identityEncoded(h) {
+		ct = http.DetectContentType(body)
+	}
+	if ct == "" {
+		return false
+	}
+	h.Set("Content-Type", ct)
+	return true
+}
+
+func identityEncoded(h http.Header) bool {
+	enc := h.Get("Content-Encoding")
+	return enc == "" || enc == EncodingIdentity
+}
+
+// fillContentType applies the fetcher's content type settings to h.
+func (f Fetcher) fillContentType(h http.Header, body []byte) {
+	FillContentType(h, body, f.DefaultContentType, f.SniffContentType)
+}
+
+// sniffBody fills a missing Content-Type on h from the first bytes of body
+// and returns a reader that still yields the whole body. Read errors are left
+// for the caller to meet on its own reads.
+func (f Fetcher) sniffBody(h http.Header, body io.ReadCloser) io.ReadCloser {
+	if _, ok := h["Content-Type"]; ok || !f.SniffContentType || !identityEncoded(h) {
+		f.fillContentType(h, nil)
+		return body
+	}
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(body, head)
+	head = head[:n]
+	f.fillContentType(h, head)
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), body), body}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFillContentType(t *testing.T) {
+	html := []byte("<!DOCTYPE html><html><body>hi</body></html>")
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		def    string
+		sniff  bool
+		want   string
+	}{
+		{name: "origin value kept", header: http.Header{"Content-Type": {"text/csv"}}, body: html, def: "application/octet-stream", sniff: true, want: "text/csv"},
+		{name: "default", header: http.Header{}, body: html, def: "application/octet-stream", want: "application/octet-stream"},
+		{name: "sniffed", header: http.Header{}, body: html, def: "application/octet-stream", sniff: true, want: "text/html; charset=utf-8"},
+		{name: "encoded body gets default", header: http.Header{"Content-Encoding": {"gzip"}}, body: []byte{0x1f, 0x8b, 8}, def: "application/octet-stream", sniff: true, want: "application/octet-stream"},
+		{name: "empty body gets default", header: http.Header{}, def: "text/plain", sniff: true, want: "text/plain"},
+		{name: "off", header: http.Header{}, body: html, want: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			FillContentType(tc.header, tc.body, tc.def, tc.sniff)
+			if got := tc.header.Get("Content-Type"); got != tc.want {
+				t.Fatalf("Content-Type = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func newUntypedOrigin(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A nil value stops net/http from sniffing a type itself.
+		w.Header()["Content-Type"] = nil
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func TestFetchFromOrigin_SniffsMissingContentType(t *testing.T) {
+	origin := newUntypedOrigin(t, "<html><body>page</body></html>")
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL}
+	ent, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil || ent.Header.Get("Content-Type") != "" {
+		t.Fatalf("without settings: Content-Type = %q, err %v, want none", ent.Header.Get("Content-Type"), err)
+	}
+
+	f.SniffContentType = true
+	ent, _, _, err = f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil || ent.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("sniffed Content-Type = %q, err %v", ent.Header.Get("Content-Type"), err)
+	}
+}
+
+func TestOpenFromOrigin_SniffKeepsWholeBody(t *testing.T) {
+	body := "%PDF-1.4 " + strings.Repeat("x", 2*sniffLen)
+	origin := newUntypedOrigin(t, body)
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, SniffContentType: true}
+	ent, _, _, rc, err := f.OpenFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/doc", nil))
+	if err != nil {
+		t.Fatalf("OpenFromOrigin: %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil || string(got) != body {
+		t.Fatalf("body = %d bytes, err %v, want the whole %d", len(got), err, len(body))
+	}
+	if ct := ent.Header.Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("Content-Type = %q, want application/pdf", ct)
+	}
+}
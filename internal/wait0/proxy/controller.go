@@ -1,25 +1,57 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Runtime interface {
 	HandleControl(w http.ResponseWriter, r *http.Request) bool
 	PickRule(path string) *Rule
+	// HostKey maps a request host to its cache key component; empty leaves
+	// the host out of the key.
+	HostKey(host string) string
+	// KeyVersion is folded into every cache key; empty leaves it out.
+	KeyVersion() string
+	// MaxCacheableBytes caps the body buffered on a miss; larger responses
+	// are streamed to the client uncached. Zero disables the cap.
+	MaxCacheableBytes() int64
+	// ReadOnly rejects methods other than GET and HEAD instead of
+	// forwarding them to origin.
+	ReadOnly() bool
+	// DebugHeaders adds X-Wait0-Debug-* headers describing the cache key,
+	// matched rule and entry freshness to responses.
+	DebugHeaders() bool
 	LoadRAM(key string, now int64) (Entry, bool)
 	LoadDisk(key string) (Entry, bool)
 	PromoteRAM(key string, ent Entry)
 	DeleteKey(key string)
 	FetchFromOrigin(r *http.Request) (Entry, bool, string, error)
-	Store(key string, ent Entry)
+	OpenFromOrigin(r *http.Request) (Entry, bool, string, io.ReadCloser, error)
+	Store(key string, ent Entry, tier string)
 	RevalidateAsync(key, path, query string)
 	WriteEntryWithStats(w http.ResponseWriter, ent Entry, wait0 string)
+	// ObserveOutcome is called once per proxied request with its X-Wait0
+	// value, including bad-gateway and read-only.
+	ObserveOutcome(path, wait0 string)
 }
 
 type Controller struct {
 	rt Runtime
+
+	flights   flightGroup
+	coalesced atomic.Uint64
+
+	// varies maps a varyKey to the request headers its origin responses
+	// vary on, learned from their Vary header. It holds at most maxVaries.
+	variesMu sync.Mutex
+	varies   map[string][]string
 }
 
 func NewController(rt Runtime) *Controller {
@@ -31,74 +63,311 @@ func (c *Controller) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && c.rt.ReadOnly() {
+		w.Header().Set("Allow", "GET, HEAD")
+		SetWait0Headers(w.Header(), "read-only")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		c.rt.ObserveOutcome(r.URL.Path, "read-only")
+		return
+	}
+
 	path := r.URL.Path
-	key := path
 	rule := c.rt.PickRule(path)
+	key := CacheKey(r, rule, c.rt.HostKey(r.Host), c.rt.KeyVersion())
+	if rule != nil && rule.RewriteLocation != nil {
+		r = withPassRedirects(r)
+	}
 
 	if rule != nil {
 		if rule.Bypass {
-			c.proxyPass(w, r, "bypass")
+			c.proxyPass(w, r, rule, key, "bypass")
 			return
 		}
 		if HasAnyCookie(r, rule.BypassWhenCookies) {
-			c.proxyPass(w, r, "ignore-by-cookie")
+			c.proxyPass(w, r, rule, key, "ignore-by-cookie")
+			return
+		}
+		if HasAnyHeader(r, rule.BypassWhenHeaders) {
+			c.proxyPass(w, r, rule, key, "ignore-by-header")
 			return
 		}
 	}
 
-	if r.Method != http.MethodGet {
-		c.proxyPass(w, r, "bypass")
+	switch {
+	case r.Method == http.MethodOptions && rule != nil && rule.CacheOptions:
+		key = optionsKey(r, key)
+	case r.Method == http.MethodPost && rule != nil && rule.CachePost:
+		br, body, ok := bufferBody(r, rule.CacheBodyMax)
+		if !ok {
+			c.proxyPass(w, br, rule, key, "bypass")
+			return
+		}
+		r, key = br, postKey(key, body)
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		c.proxyPass(w, r, rule, key, "bypass")
 		return
 	}
+	r = withKeyQuery(r, rule)
 
+	// base is the key before origin Vary headers; key is this request's
+	// variant of it, once a response has said which headers it varies on.
+	base := key
+	key = c.variant(r, base)
 	now := time.Now().Unix()
-	if ent, ok := c.rt.LoadRAM(key, now); ok {
-		if !ent.Inactive {
-			c.rt.WriteEntryWithStats(w, ent, "hit")
-			if rule != nil && rule.Expiration > 0 && IsStale(ent, rule.Expiration) {
-				c.rt.RevalidateAsync(key, r.URL.Path, r.URL.RawQuery)
-			}
-			return
+	ent, ok := c.lookup(key, rule, now)
+	if ok {
+		// An entry cached before its Vary was learned, e.g. before a
+		// restart, points at the variant this request should get.
+		if vk := c.learnVary(r, base, ent.Header); vk != key {
+			key = vk
+			ent, ok = c.lookup(key, rule, now)
 		}
 	}
-
-	if ent, ok := c.rt.LoadDisk(key); ok {
-		if !ent.Inactive {
-			c.rt.PromoteRAM(key, ent)
-			c.rt.WriteEntryWithStats(w, ent, "hit")
-			if rule != nil && rule.Expiration > 0 && IsStale(ent, rule.Expiration) {
-				c.rt.RevalidateAsync(key, r.URL.Path, r.URL.RawQuery)
-			}
-			return
+	if ok && r.Method == http.MethodPost {
+		// Revalidation cannot replay the request body, so a stale POST
+		// entry is refetched in line instead.
+		if exp := rule.Freshness(ent); exp > 0 && IsStale(ent, exp) {
+			ok = false
 		}
 	}
+	if ok {
+		c.write(w, r, rule, key, ent, "hit")
+		if exp := rule.Freshness(ent); exp > 0 && IsStale(ent, exp) {
+			c.rt.RevalidateAsync(key, r.URL.Path, r.URL.RawQuery)
+		}
+		return
+	}
+
+	// A HEAD is answered from the cached GET but never fills the cache: its
+	// origin response has no body to store.
+	if r.Method == http.MethodHead {
+		c.proxyPass(w, r, rule, key, "bypass")
+		return
+	}
 
-	respEnt, cacheable, statusKind, err := c.rt.FetchFromOrigin(r)
+	if rule != nil && rule.Streamable {
+		c.streamMiss(w, r, base, key, rule)
+		return
+	}
+
+	res, err := c.fetchMiss(r, base, key, rule)
 	if err != nil {
-		SetWait0Headers(w.Header(), "bad-gateway")
-		http.Error(w, "bad gateway", http.StatusBadGateway)
+		// The client's context ended while it waited on the shared fetch,
+		// which carries on and fills the cache. A client that is still
+		// connected but out of time gets an answer; a gone one does not.
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.gatewayTimeout(w, r)
+		}
+		return
+	}
+	if res.err != nil {
+		c.badGateway(w, r)
+		return
+	}
+	if res.kind == "stale-if-error" {
+		c.write(w, r, rule, res.key, res.ent, "stale-if-error")
+		return
+	}
+	if res.rest != nil {
+		c.streamTooLarge(w, r, rule, key, res)
 		return
 	}
-	if statusKind == "ignore-by-status" {
-		c.rt.DeleteKey(key)
-		c.rt.WriteEntryWithStats(w, respEnt, "ignore-by-status")
+	if res.kind == "ignore-by-status" {
+		c.write(w, r, rule, key, res.ent, "ignore-by-status")
 		return
 	}
-	if !cacheable {
-		c.rt.WriteEntryWithStats(w, respEnt, "bypass")
+	if !res.cacheable {
+		c.write(w, r, rule, key, res.ent, "bypass")
 		return
 	}
+	c.write(w, r, rule, res.key, res.ent, "miss")
+}
+
+// fetchMiss fetches key from origin and fills the cache with the result,
+// sharing one origin request among concurrent misses for the same key. The
+// shared fetch is detached from the client that started it, so a disconnect
+// neither cancels it for the others nor leaves the cache unfilled; for the same
+// reason it ignores that client's deadline and is bounded by the origin
+// timeout alone. Each client's wait, the starter's included, still ends with
+// its context, returning the context error. Only
+// cacheable results and errors are shared: anything else may be specific to
+// the first client, so other waiters fetch for themselves. That includes
+// responses over MaxCacheableBytes, whose unread rest only the caller that
+// started the fetch can stream, and responses whose Vary headers put a waiter
+// in a different variant. Cacheable results are stored under the variant of
+// base their Vary headers select, without Set-Cookie. When the origin fails and
+// the rule's StaleIfError window covers the cached entry, that entry is the
+// result instead.
+func (c *Controller) fetchMiss(r *http.Request, base, key string, rule *Rule) (fetchResult, error) {
+	fill := func(r *http.Request) fetchResult {
+		res := c.fetch(withoutConditionals(r), c.maxCacheable(rule))
+		res.cacheable = res.cacheable && rule.allowsSetCookie(res.ent.Header)
+		if res.err != nil || (res.kind == "ignore-by-status" && originFailed(res.ent.Status)) {
+			if ent, ok := c.staleOnError(key, rule); ok {
+				// Shared like a cacheable result; the entry stays cached.
+				res.close()
+				return fetchResult{ent: ent, cacheable: true, kind: "stale-if-error", key: key}
+			}
+		}
+		switch {
+		case res.err != nil:
+		case res.kind == "ignore-by-status" && rule.negativeCacheable(res.ent):
+			// Shared like a cacheable result so waiters do not each hit an
+			// origin that is failing.
+			res.cacheable, res.key = true, key
+			c.rt.Store(key, withoutSetCookie(res.ent), rule.TierName())
+		case res.kind == "ignore-by-status":
+			c.rt.DeleteKey(key)
+		case res.cacheable:
+			res.key = c.learnVary(r, base, res.ent.Header)
+			c.rt.Store(res.key, withoutSetCookie(res.ent), rule.TierName())
+		}
+		return res
+	}
 
-	c.rt.Store(key, respEnt)
-	c.rt.WriteEntryWithStats(w, respEnt, "miss")
+	detached := r.WithContext(context.WithoutCancel(r.Context()))
+	res, shared, err := c.flights.do(r.Context(), key, func() fetchResult { return fill(detached) })
+	if err != nil {
+		return fetchResult{}, err
+	}
+	if !shared {
+		return res, nil
+	}
+	if res.err == nil && (!res.cacheable || (res.kind == "ok" && c.learnVary(r, base, res.ent.Header) != res.key)) {
+		return fill(r), nil
+	}
+	c.coalesced.Add(1)
+	// Only the client that started the fetch gets its Set-Cookie.
+	res.ent = withoutSetCookie(res.ent)
+	res.ent.Header = res.ent.Header.Clone()
+	return res, nil
+}
+
+// maxCacheable returns the body cap for misses under rule: the smaller of
+// MaxCacheableBytes and the rule's MaxBodyBytes, or 0 when neither is set.
+func (c *Controller) maxCacheable(rule *Rule) int64 {
+	max := c.rt.MaxCacheableBytes()
+	if rule != nil && rule.MaxBodyBytes > 0 && (max <= 0 || rule.MaxBodyBytes < max) {
+		max = rule.MaxBodyBytes
+	}
+	return max
+}
+
+// fetch reads the origin response for a miss. With a cap from maxCacheable it
+// stops reading once the body exceeds max and returns the rest unread, so
+// an oversized response is never held in memory. A body cut short of its
+// Content-Length is returned as non-cacheable, never stored truncated.
+func (c *Controller) fetch(r *http.Request, max int64) fetchResult {
+	if max <= 0 {
+		ent, cacheable, kind, err := c.rt.FetchFromOrigin(r)
+		return fetchResult{ent: ent, cacheable: cacheable, kind: kind, err: err}
+	}
+	ent, cacheable, kind, body, err := c.rt.OpenFromOrigin(r)
+	if err != nil {
+		return fetchResult{err: err}
+	}
+	b, err := io.ReadAll(io.LimitReader(body, max+1))
+	if err == nil && int64(len(b)) > max {
+		ent.Body = b
+		return fetchResult{ent: ent, kind: kind, rest: body}
+	}
+	body.Close()
+	if err != nil {
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fetchResult{err: err}
+		}
+		cacheable = false
+	}
+	ent.Body = b
+	ent.Hash32 = crc32.ChecksumIEEE(b)
+	return fetchResult{ent: ent, cacheable: cacheable, kind: kind}
+}
+
+// lookup loads key from the tiers rule uses, RAM first, promoting disk hits
+// to RAM. Inactive entries and ones past the rule's MaxAge do not count.
+func (c *Controller) lookup(key string, rule *Rule, now int64) (Entry, bool) {
+	if rule.UsesRAM() {
+		if ent, ok := c.rt.LoadRAM(key, now); ok && !ent.Inactive && !rule.TooOld(ent) {
+			return ent, true
+		}
+	}
+	if rule.UsesDisk() {
+		if ent, ok := c.rt.LoadDisk(key); ok && !ent.Inactive && !rule.TooOld(ent) {
+			if rule.UsesRAM() {
+				c.rt.PromoteRAM(key, ent)
+			}
+			return ent, true
+		}
+	}
+	return Entry{}, false
 }
 
-func (c *Controller) proxyPass(w http.ResponseWriter, r *http.Request, wait0 string) {
+// Coalesced reports how many cache misses were served from another
+// request's origin fetch instead of their own.
+func (c *Controller) Coalesced() uint64 {
+	return c.coalesced.Load()
+}
+
+// Wait blocks until origin fetches that outlived their requests finish, so
+// their cache fills land before storage is closed.
+func (c *Controller) Wait() {
+	c.flights.wait()
+}
+
+// write hands ent to the runtime in an encoding the client accepts, with
+// redirects rewritten and Cache-Control overridden for the rule. Cached entries
+// carry an ETag, answer matching If-None-Match requests with 304 and single
+// byte Range requests with 206. HEAD requests get the same status and headers
+// without the body.
+func (c *Controller) write(w http.ResponseWriter, r *http.Request, rule *Rule, key string, ent Entry, wait0 string) {
+	var rw *LocationRewrite
+	if rule != nil {
+		rw = rule.RewriteLocation
+	}
+	cached := wait0 == "hit" || wait0 == "stale-if-error"
+	if cached {
+		ent = withAge(ent, time.Now())
+	}
+	if cached || wait0 == "miss" {
+		ent = withETag(ent)
+		if notModified(r, ent) {
+			ent = notModifiedEntry(ent)
+		}
+	}
+	sent := forClient(r, ent)
+	// Ranges index the stored representation; a body decoded for this client
+	// shares its ETag with the encoded one, so If-Range could not tell them
+	// apart.
+	if (cached || wait0 == "miss") && !needsDecode(r, ent) {
+		sent = withRange(r, sent)
+	}
+	ent = rule.withCacheControl(rewriteLocation(r, sent, rw))
+	if r.Method == http.MethodHead {
+		ent = headEntry(ent)
+	}
+	c.setDebugHeaders(w.Header(), key, rule, ent, wait0)
+	c.rt.WriteEntryWithStats(w, ent, wait0)
+	c.rt.ObserveOutcome(r.URL.Path, wait0)
+}
+
+// badGateway answers a request whose origin fetch failed.
+func (c *Controller) badGateway(w http.ResponseWriter, r *http.Request) {
+	SetWait0Headers(w.Header(), "bad-gateway")
+	http.Error(w, "bad gateway", http.StatusBadGateway)
+	c.rt.ObserveOutcome(r.URL.Path, "bad-gateway")
+}
+
+func (c *Controller) gatewayTimeout(w http.ResponseWriter, r *http.Request) {
+	SetWait0Headers(w.Header(), "gateway-timeout")
+	http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+	c.rt.ObserveOutcome(r.URL.Path, "gateway-timeout")
+}
+
+func (c *Controller) proxyPass(w http.ResponseWriter, r *http.Request, rule *Rule, key, wait0 string) {
 	ent, _, _, err := c.rt.FetchFromOrigin(r)
 	if err != nil {
-		SetWait0Headers(w.Header(), "bad-gateway")
-		http.Error(w, "bad gateway", http.StatusBadGateway)
+		c.badGateway(w, r)
 		return
 	}
-	c.rt.WriteEntryWithStats(w, ent, wait0)
+	c.write(w, r, rule, key, ent, wait0)
 }
@@ -1,20 +1,33 @@
 package proxy
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"wait0/internal/wait0/cachekey"
 )
 
 type fakeRuntime struct {
 	handleControl bool
 	rule          *Rule
+	hostKey       string
+	keyVersion    string
+	maxCacheable  int64
+	readOnly      bool
+	debugHeaders  bool
 
 	ramEnt Entry
 	ramOK  bool
+	// ramByKey, when set, replaces ramEnt with per-key RAM entries.
+	ramByKey map[string]Entry
 
 	diskEnt Entry
 	diskOK  bool
@@ -23,12 +36,44 @@ type fakeRuntime struct {
 	originCacheable bool
 	originStatus    string
 	originErr       error
+	originStream    io.ReadCloser
+
+	ramLoads  int
+	diskLoads int
 
+	fetched     []string
 	promoted    []string
 	deleted     []string
 	stored      []string
+	storedTier  []string
+	storedEnts  []Entry
 	revalidated []struct{ key, path, query string }
 	writeWait0  []string
+	outcomes    []string
+}
+
+func (f *fakeRuntime) ObserveOutcome(path, wait0 string) {
+	f.outcomes = append(f.outcomes, path+" "+wait0)
+}
+
+func (f *fakeRuntime) HostKey(string) string {
+	return f.hostKey
+}
+
+func (f *fakeRuntime) KeyVersion() string {
+	return f.keyVersion
+}
+
+func (f *fakeRuntime) DebugHeaders() bool {
+	return f.debugHeaders
+}
+
+func (f *fakeRuntime) ReadOnly() bool {
+	return f.readOnly
+}
+
+func (f *fakeRuntime) MaxCacheableBytes() int64 {
+	return f.maxCacheable
 }
 
 func (f *fakeRuntime) HandleControl(http.ResponseWriter, *http.Request) bool {
@@ -37,19 +82,44 @@ func (f *fakeRuntime) HandleControl(http.ResponseWriter, *http.Request) bool {
 
 func (f *fakeRuntime) PickRule(string) *Rule { return f.rule }
 
-func (f *fakeRuntime) LoadRAM(string, int64) (Entry, bool) { return f.ramEnt, f.ramOK }
+func (f *fakeRuntime) LoadRAM(key string, _ int64) (Entry, bool) {
+	f.ramLoads++
+	if f.ramByKey != nil {
+		ent, ok := f.ramByKey[key]
+		return ent, ok
+	}
+	return f.ramEnt, f.ramOK
+}
 
-func (f *fakeRuntime) LoadDisk(string) (Entry, bool) { return f.diskEnt, f.diskOK }
+func (f *fakeRuntime) LoadDisk(string) (Entry, bool) {
+	f.diskLoads++
+	return f.diskEnt, f.diskOK
+}
 
 func (f *fakeRuntime) PromoteRAM(key string, _ Entry) { f.promoted = append(f.promoted, key) }
 
 func (f *fakeRuntime) DeleteKey(key string) { f.deleted = append(f.deleted, key) }
 
-func (f *fakeRuntime) FetchFromOrigin(*http.Request) (Entry, bool, string, error) {
+func (f *fakeRuntime) FetchFromOrigin(r *http.Request) (Entry, bool, string, error) {
+	f.fetched = append(f.fetched, r.URL.RequestURI())
 	return f.originEnt, f.originCacheable, f.originStatus, f.originErr
 }
 
-func (f *fakeRuntime) Store(key string, _ Entry) { f.stored = append(f.stored, key) }
+func (f *fakeRuntime) OpenFromOrigin(*http.Request) (Entry, bool, string, io.ReadCloser, error) {
+	body := f.originStream
+	if body == nil {
+		body = io.NopCloser(bytes.NewReader(f.originEnt.Body))
+	}
+	ent := f.originEnt
+	ent.Body = nil
+	return ent, f.originCacheable, f.originStatus, body, f.originErr
+}
+
+func (f *fakeRuntime) Store(key string, ent Entry, tier string) {
+	f.stored = append(f.stored, key)
+	f.storedTier = append(f.storedTier, tier)
+	f.storedEnts = append(f.storedEnts, ent)
+}
 
 func (f *fakeRuntime) RevalidateAsync(key, path, query string) {
 	f.revalidated = append(f.revalidated, struct{ key, path, query string }{key: key, path: path, query: query})
@@ -99,6 +169,16 @@ func TestController_Handle_BypassPaths(t *testing.T) {
 			}(),
 			want: "ignore-by-cookie",
 		},
+		{
+			name: "header bypass",
+			rule: &Rule{BypassWhenHeaders: []string{"Authorization"}},
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "http://wait0.local/h", nil)
+				r.Header.Set("Authorization", "Bearer x")
+				return r
+			}(),
+			want: "ignore-by-header",
+		},
 		{
 			name: "non get bypass",
 			rule: &Rule{},
@@ -128,6 +208,45 @@ func TestController_Handle_BypassPaths(t *testing.T) {
 	}
 }
 
+func TestController_Handle_ReadOnlyRejectsWrites(t *testing.T) {
+	rt := &fakeRuntime{
+		readOnly:        true,
+		rule:            &Rule{Bypass: true},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("ok")},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch} {
+		w := httptest.NewRecorder()
+		c.Handle(w, httptest.NewRequest(method, "http://wait0.local/a", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s status = %d, want 405", method, w.Code)
+		}
+		if got := w.Result().Header.Get("Allow"); got != "GET, HEAD" {
+			t.Fatalf("%s Allow = %q", method, got)
+		}
+		if got := w.Result().Header.Get("X-Wait0"); got != "read-only" {
+			t.Fatalf("%s X-Wait0 = %q, want read-only", method, got)
+		}
+	}
+	if len(rt.fetched) != 0 {
+		t.Fatalf("fetched = %v, want no origin requests", rt.fetched)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := httptest.NewRecorder()
+		c.Handle(w, httptest.NewRequest(method, "http://wait0.local/a", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status = %d, want 200", method, w.Code)
+		}
+	}
+	if len(rt.fetched) != 2 {
+		t.Fatalf("fetched = %v, want GET and HEAD forwarded", rt.fetched)
+	}
+}
+
 func TestController_Handle_RAMHitAndStaleRevalidation(t *testing.T) {
 	ent := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("cached"), StoredAt: time.Now().Add(-2 * time.Minute).Unix()}
 	rt := &fakeRuntime{
@@ -140,6 +259,9 @@ func TestController_Handle_RAMHitAndStaleRevalidation(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/path?q=1", nil)
 
 	c.Handle(w, r)
+	if len(rt.outcomes) != 1 || rt.outcomes[0] != "/path hit" {
+		t.Fatalf("outcomes = %v, want [/path hit]", rt.outcomes)
+	}
 
 	if len(rt.writeWait0) != 1 || rt.writeWait0[0] != "hit" {
 		t.Fatalf("writeWait0 = %v, want [hit]", rt.writeWait0)
@@ -148,11 +270,346 @@ func TestController_Handle_RAMHitAndStaleRevalidation(t *testing.T) {
 		t.Fatalf("revalidate calls = %d, want 1", len(rt.revalidated))
 	}
 	call := rt.revalidated[0]
-	if call.key != "/path" || call.path != "/path" || call.query != "q=1" {
+	if call.key != "/path#%40q=q%3D1" || call.path != "/path" || call.query != "q=1" {
 		t.Fatalf("revalidate call = %+v", call)
 	}
 }
 
+func TestController_Handle_HeadServedFromCachedGet(t *testing.T) {
+	ent := Entry{Status: http.StatusOK, Header: http.Header{"Content-Type": {"text/html"}}, Body: []byte("cached"), StoredAt: time.Now().Unix()}
+	rt := &fakeRuntime{diskEnt: ent, diskOK: true}
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, httptest.NewRequest(http.MethodHead, "http://wait0.local/p", nil))
+
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("status=%d body=%q, want 200 and no body", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Length"); got != "6" {
+		t.Fatalf("Content-Length = %q, want the GET body length 6", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/html" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	if len(rt.writeWait0) != 1 || rt.writeWait0[0] != "hit" {
+		t.Fatalf("writeWait0 = %v, want [hit]", rt.writeWait0)
+	}
+	if len(rt.fetched) != 0 {
+		t.Fatalf("fetched = %v, want no origin request", rt.fetched)
+	}
+	if ent.Header.Get("Content-Length") != "" {
+		t.Fatal("cached entry header was mutated")
+	}
+}
+
+func TestController_Handle_RangeFromCache(t *testing.T) {
+	ent := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("0123456789"), StoredAt: time.Now().Unix()}
+	rt := &fakeRuntime{ramEnt: ent, ramOK: true}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/v", nil)
+	r.Header.Set("Range", "bytes=-4")
+	NewController(rt).Handle(w, r)
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "6789" {
+		t.Fatalf("status=%d body=%q, want 206 6789", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 6-9/10" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+	if len(rt.fetched) != 0 {
+		t.Fatalf("fetched = %v, want the range served from cache", rt.fetched)
+	}
+}
+
+func TestController_Handle_HeadMissGoesToOriginUncached(t *testing.T) {
+	rt := &fakeRuntime{
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}},
+		originCacheable: true,
+	}
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, httptest.NewRequest(http.MethodHead, "http://wait0.local/p", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if len(rt.fetched) != 1 {
+		t.Fatalf("fetched = %v, want one origin request", rt.fetched)
+	}
+	if len(rt.stored) != 0 {
+		t.Fatalf("stored = %v, want the HEAD response left uncached", rt.stored)
+	}
+	if len(rt.writeWait0) != 1 || rt.writeWait0[0] != "bypass" {
+		t.Fatalf("writeWait0 = %v, want [bypass]", rt.writeWait0)
+	}
+}
+
+func TestController_Handle_IgnoreQueryForwardsFullURL(t *testing.T) {
+	rt := &fakeRuntime{
+		rule:            &Rule{IgnoreQuery: true},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x")},
+		originCacheable: true,
+	}
+	NewController(rt).Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://wait0.local/landing?utm_source=x&b=2", nil))
+
+	if len(rt.fetched) != 1 || rt.fetched[0] != "/landing?utm_source=x&b=2" {
+		t.Fatalf("fetched = %v, want the original URL with its query", rt.fetched)
+	}
+	if len(rt.stored) != 1 || rt.stored[0] != "/landing" {
+		t.Fatalf("stored = %v, want the bare path key", rt.stored)
+	}
+}
+
+func TestController_Handle_NegativeCacheStoresErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		rule       *Rule
+		wantStored bool
+	}{
+		{name: "no negative cache", rule: &Rule{}},
+		{name: "listed status", rule: &Rule{NegativeTTL: map[int]time.Duration{404: time.Minute}}, wantStored: true},
+		{name: "other status", rule: &Rule{NegativeTTL: map[int]time.Duration{5: time.Minute}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &fakeRuntime{
+				rule:         tc.rule,
+				originEnt:    Entry{Status: http.StatusNotFound, Header: http.Header{}, Body: []byte("gone")},
+				originStatus: "ignore-by-status",
+			}
+			w := httptest.NewRecorder()
+			NewController(rt).Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil))
+
+			if w.Code != http.StatusNotFound || rt.writeWait0[0] != "ignore-by-status" {
+				t.Fatalf("response = %d %v, want 404 ignore-by-status", w.Code, rt.writeWait0)
+			}
+			if stored := len(rt.stored) == 1; stored != tc.wantStored {
+				t.Fatalf("stored = %v, want stored=%v", rt.stored, tc.wantStored)
+			}
+			if deleted := len(rt.deleted) == 1; deleted == tc.wantStored {
+				t.Fatalf("deleted = %v, want deleted=%v", rt.deleted, !tc.wantStored)
+			}
+		})
+	}
+}
+
+func TestController_Handle_CacheOptions(t *testing.T) {
+	preflight := func() *http.Request {
+		r := httptest.NewRequest(http.MethodOptions, "http://wait0.local/api", nil)
+		r.Header.Set("Origin", "https://a.example")
+		r.Header.Set("Access-Control-Request-Method", "PUT")
+		return r
+	}
+	origin := Entry{Status: http.StatusNoContent, Header: http.Header{"Access-Control-Allow-Methods": {"PUT"}}}
+
+	rt := &fakeRuntime{originEnt: origin, originCacheable: true}
+	NewController(rt).Handle(httptest.NewRecorder(), preflight())
+	if len(rt.stored) != 0 || rt.writeWait0[0] != "bypass" {
+		t.Fatalf("default: stored=%v wait0=%v, want OPTIONS passed through", rt.stored, rt.writeWait0)
+	}
+
+	rt = &fakeRuntime{rule: &Rule{CacheOptions: true}, originEnt: origin, originCacheable: true}
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, preflight())
+	want := "/api#%40m=OPTIONS&Access-Control-Request-Method=PUT&Origin=https%3A%2F%2Fa.example"
+	if len(rt.stored) != 1 || rt.stored[0] != want {
+		t.Fatalf("stored = %v, want [%s]", rt.stored, want)
+	}
+	if w.Code != http.StatusNoContent || rt.writeWait0[0] != "miss" {
+		t.Fatalf("response = %d %v, want 204 miss", w.Code, rt.writeWait0)
+	}
+}
+
+func TestController_Handle_CachePost(t *testing.T) {
+	post := func(body string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "http://wait0.local/graphql", strings.NewReader(body))
+	}
+	origin := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("data")}
+
+	rt := &fakeRuntime{rule: &Rule{CachePost: true, CacheBodyMax: 16}, originEnt: origin, originCacheable: true}
+	c := NewController(rt)
+	c.Handle(httptest.NewRecorder(), post(`{"q":"a"}`))
+	want := "/graphql#%40b=29a9829b3c03948275ca3be1cb7b633c0207849d2c0bd3215060f2ac05abce64&%40m=POST"
+	if len(rt.stored) != 1 || rt.stored[0] != want || rt.writeWait0[0] != "miss" {
+		t.Fatalf("stored=%v wait0=%v, want a miss stored under [%s]", rt.stored, rt.writeWait0, want)
+	}
+
+	c.Handle(httptest.NewRecorder(), post(strings.Repeat("x", 17)))
+	if len(rt.stored) != 1 || rt.writeWait0[1] != "bypass" {
+		t.Fatalf("stored=%v wait0=%v, want a body over CacheBodyMax passed through", rt.stored, rt.writeWait0)
+	}
+
+	rt = &fakeRuntime{rule: &Rule{}, originEnt: origin, originCacheable: true}
+	NewController(rt).Handle(httptest.NewRecorder(), post(`{"q":"a"}`))
+	if len(rt.stored) != 0 || rt.writeWait0[0] != "bypass" {
+		t.Fatalf("default: stored=%v wait0=%v, want POST passed through", rt.stored, rt.writeWait0)
+	}
+}
+
+func TestController_Handle_StalePostIsRefetched(t *testing.T) {
+	stale := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("old"), StoredAt: time.Now().Add(-time.Hour).Unix()}
+	rt := &fakeRuntime{
+		rule:            &Rule{CachePost: true, CacheBodyMax: 16, Expiration: time.Minute},
+		ramEnt:          stale,
+		ramOK:           true,
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("new")},
+		originCacheable: true,
+	}
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, httptest.NewRequest(http.MethodPost, "http://wait0.local/q", strings.NewReader("a")))
+
+	if w.Body.String() != "new" || rt.writeWait0[0] != "miss" || len(rt.revalidated) != 0 {
+		t.Fatalf("body=%q wait0=%v revalidated=%v, want an in-line refetch", w.Body.String(), rt.writeWait0, rt.revalidated)
+	}
+}
+
+func TestBufferBody_OverLimitRestoresBody(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "http://wait0.local/q", strings.NewReader("0123456789"))
+	out, _, ok := bufferBody(r, 4)
+	if ok {
+		t.Fatalf("bufferBody accepted a body over the limit")
+	}
+	if b, _ := io.ReadAll(out.Body); string(b) != "0123456789" {
+		t.Fatalf("restored body = %q", b)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "http://wait0.local/q", strings.NewReader("abc"))
+	out, body, ok := bufferBody(r, 4)
+	if !ok || string(body) != "abc" || out.GetBody == nil {
+		t.Fatalf("ok=%v body=%q GetBody=%v", ok, body, out.GetBody != nil)
+	}
+	for i := 0; i < 2; i++ {
+		rc, _ := out.GetBody()
+		if b, _ := io.ReadAll(rc); string(b) != "abc" {
+			t.Fatalf("replay %d = %q", i, b)
+		}
+	}
+}
+
+func TestController_Handle_SetCookieIsNotCached(t *testing.T) {
+	rt := &fakeRuntime{
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{"Set-Cookie": {"sid=1"}}, Body: []byte("x")},
+		originCacheable: true,
+	}
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil))
+
+	if len(rt.stored) != 0 {
+		t.Fatalf("stored = %v, want a Set-Cookie response left uncached", rt.stored)
+	}
+	if len(rt.writeWait0) != 1 || rt.writeWait0[0] != "bypass" {
+		t.Fatalf("writeWait0 = %v, want [bypass]", rt.writeWait0)
+	}
+	if w.Header().Get("Set-Cookie") != "sid=1" {
+		t.Fatalf("Set-Cookie = %q, want it passed through", w.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestController_Handle_CacheWithSetCookieStripsStoredCookie(t *testing.T) {
+	rt := &fakeRuntime{
+		rule:            &Rule{CacheWithSetCookie: true},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{"Set-Cookie": {"sid=1"}}, Body: []byte("x")},
+		originCacheable: true,
+	}
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil))
+
+	if len(rt.storedEnts) != 1 {
+		t.Fatalf("stored = %v, want one entry", rt.stored)
+	}
+	if got := rt.storedEnts[0].Header.Get("Set-Cookie"); got != "" {
+		t.Fatalf("stored Set-Cookie = %q, want it stripped", got)
+	}
+	if w.Header().Get("Set-Cookie") != "sid=1" {
+		t.Fatalf("Set-Cookie = %q, want the fetching client to keep it", w.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestController_Handle_StoresVaryVariants(t *testing.T) {
+	rt := &fakeRuntime{
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{"Vary": {"Accept-Language, Accept-Encoding"}}, Body: []byte("x")},
+		originCacheable: true,
+	}
+	c := NewController(rt)
+	for _, lang := range []string{"de", "fr"} {
+		r := httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil)
+		r.Header.Set("Accept-Language", lang)
+		r.Header.Set("Accept-Encoding", "gzip")
+		c.Handle(httptest.NewRecorder(), r)
+	}
+
+	want := []string{"/p#Accept-Language=de", "/p#Accept-Language=fr"}
+	if len(rt.stored) != 2 || rt.stored[0] != want[0] || rt.stored[1] != want[1] {
+		t.Fatalf("stored = %v, want %v", rt.stored, want)
+	}
+}
+
+func TestController_Handle_VaryFromCachedEntrySelectsVariant(t *testing.T) {
+	rt := &fakeRuntime{ramByKey: map[string]Entry{
+		"/p":                    {Status: http.StatusOK, Header: http.Header{"Vary": {"Accept-Language"}}, Body: []byte("de")},
+		"/p#Accept-Language=fr": {Status: http.StatusOK, Header: http.Header{"Vary": {"Accept-Language"}}, Body: []byte("fr")},
+	}}
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil)
+	r.Header.Set("Accept-Language", "fr")
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, r)
+
+	if w.Body.String() != "fr" {
+		t.Fatalf("body = %q, want the fr variant", w.Body.String())
+	}
+	if len(rt.writeWait0) != 1 || rt.writeWait0[0] != "hit" {
+		t.Fatalf("writeWait0 = %v, want [hit]", rt.writeWait0)
+	}
+}
+
+func TestController_LearnVary_SharedAcrossQueriesAndForgotten(t *testing.T) {
+	c := NewController(&fakeRuntime{})
+	h := http.Header{"Vary": {"Accept-Language"}}
+	key := func(q string) string { return cachekey.Parts{Path: "/p", Query: q}.String() }
+	for _, q := range []string{"a=1", "a=2", "b=3"} {
+		r := httptest.NewRequest(http.MethodGet, "http://wait0.local/p?"+q, nil)
+		c.learnVary(r, key(q), h)
+	}
+	if len(c.varies) != 1 {
+		t.Fatalf("varies = %v, want one record for /p", c.varies)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/p?z=9", nil)
+	r.Header.Set("Accept-Language", "de")
+	if got := c.variant(r, key("z=9")); got == key("z=9") {
+		t.Fatalf("variant = %q, want the learned Vary applied to a new query", got)
+	}
+
+	c.ForgetVary(key("a=1"))
+	if got := c.variant(r, key("z=9")); got != key("z=9") {
+		t.Fatalf("variant after ForgetVary = %q, want the base key", got)
+	}
+}
+
+func TestController_LearnVary_Bounded(t *testing.T) {
+	c := NewController(&fakeRuntime{})
+	h := http.Header{"Vary": {"Accept-Language"}}
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/", nil)
+	for i := range maxVaries + 10 {
+		c.learnVary(r, fmt.Sprintf("/p%d", i), h)
+	}
+	if len(c.varies) != maxVaries {
+		t.Fatalf("len(varies) = %d, want %d", len(c.varies), maxVaries)
+	}
+}
+
+func TestController_Handle_OriginMaxAgeDrivesRevalidation(t *testing.T) {
+	stale := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("cached"), StoredAt: time.Now().Add(-2 * time.Minute).Unix(), MaxAge: 60}
+	rt := &fakeRuntime{ramEnt: stale, ramOK: true}
+	NewController(rt).Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil))
+	if len(rt.revalidated) != 1 {
+		t.Fatalf("revalidate calls = %d, want 1 once origin max-age has passed", len(rt.revalidated))
+	}
+
+	rt = &fakeRuntime{rule: &Rule{Expiration: time.Hour}, ramEnt: stale, ramOK: true}
+	NewController(rt).Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil))
+	if len(rt.revalidated) != 0 {
+		t.Fatalf("rule expiration must override origin max-age, revalidated=%v", rt.revalidated)
+	}
+}
+
 func TestController_Handle_DiskHitPromotesRAM(t *testing.T) {
 	ent := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("disk")}
 	rt := &fakeRuntime{
@@ -173,6 +630,63 @@ func TestController_Handle_DiskHitPromotesRAM(t *testing.T) {
 	}
 }
 
+func TestController_Handle_TierRestrictsLookupsAndStore(t *testing.T) {
+	tests := []struct {
+		name          string
+		tier          string
+		wantRAMLoads  int
+		wantDiskLoads int
+		wantTier      string
+	}{
+		{name: "default both", tier: "", wantRAMLoads: 1, wantDiskLoads: 1, wantTier: TierBoth},
+		{name: "ram only", tier: TierRAM, wantRAMLoads: 1, wantDiskLoads: 0, wantTier: TierRAM},
+		{name: "disk only", tier: TierDisk, wantRAMLoads: 0, wantDiskLoads: 1, wantTier: TierDisk},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &fakeRuntime{
+				rule:            &Rule{Tier: tc.tier},
+				originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("origin")},
+				originCacheable: true,
+				originStatus:    "ok",
+			}
+			c := NewController(rt)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://wait0.local/tier", nil)
+
+			c.Handle(w, r)
+
+			if rt.ramLoads != tc.wantRAMLoads || rt.diskLoads != tc.wantDiskLoads {
+				t.Fatalf("loads ram=%d disk=%d, want ram=%d disk=%d", rt.ramLoads, rt.diskLoads, tc.wantRAMLoads, tc.wantDiskLoads)
+			}
+			if len(rt.storedTier) != 1 || rt.storedTier[0] != tc.wantTier {
+				t.Fatalf("stored tiers = %v, want [%s]", rt.storedTier, tc.wantTier)
+			}
+		})
+	}
+}
+
+func TestController_Handle_DiskOnlyHitDoesNotPromote(t *testing.T) {
+	rt := &fakeRuntime{
+		rule:    &Rule{Tier: TierDisk},
+		diskEnt: Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("disk")},
+		diskOK:  true,
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/disk", nil)
+
+	c.Handle(w, r)
+
+	if len(rt.promoted) != 0 {
+		t.Fatalf("promoted = %v, want none", rt.promoted)
+	}
+	if len(rt.writeWait0) != 1 || rt.writeWait0[0] != "hit" {
+		t.Fatalf("writeWait0 = %v, want [hit]", rt.writeWait0)
+	}
+}
+
 func TestController_Handle_OriginBranches(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -253,3 +767,224 @@ func TestController_Handle_OriginBranches(t *testing.T) {
 		})
 	}
 }
+
+func TestController_Handle_HitAnswersIfNoneMatch(t *testing.T) {
+	rt := &fakeRuntime{
+		ramEnt: Entry{Status: http.StatusOK, Header: http.Header{"Content-Length": {"6"}}, Body: []byte("cached"), Hash32: 7},
+		ramOK:  true,
+	}
+	c := NewController(rt)
+
+	w := httptest.NewRecorder()
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/page", nil))
+	etag := w.Result().Header.Get("ETag")
+	if etag != `"w0-00000007"` {
+		t.Fatalf("ETag = %q", etag)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/page", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	c.Handle(w, r)
+	if got := w.Result().StatusCode; got != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304", got)
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("304 body = %q, want empty", w.Body.String())
+	}
+	if got := w.Result().Header.Get("X-Wait0"); got != "hit" {
+		t.Fatalf("X-Wait0 = %q, want hit", got)
+	}
+}
+
+func TestController_Handle_HitAddsResidentTimeToUpstreamAge(t *testing.T) {
+	stored := time.Now().Add(-30 * time.Second).Unix()
+	tests := []struct {
+		name     string
+		upstream string
+		min, max int64
+	}{
+		{name: "no upstream age", min: 30, max: 32},
+		{name: "upstream age", upstream: "100", min: 130, max: 132},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{}
+			if tc.upstream != "" {
+				h.Set("Age", tc.upstream)
+			}
+			rt := &fakeRuntime{ramEnt: Entry{Status: http.StatusOK, Header: h, Body: []byte("cached"), StoredAt: stored}, ramOK: true}
+			w := httptest.NewRecorder()
+			NewController(rt).Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil))
+
+			got := w.Result().Header.Values("Age")
+			if len(got) != 1 {
+				t.Fatalf("Age headers = %q, want exactly one", got)
+			}
+			age, err := strconv.ParseInt(got[0], 10, 64)
+			if err != nil || age < tc.min || age > tc.max {
+				t.Fatalf("Age = %q, want %d..%d", got[0], tc.min, tc.max)
+			}
+			if h.Get("Age") != tc.upstream {
+				t.Fatalf("cached header mutated: Age = %q", h.Get("Age"))
+			}
+		})
+	}
+}
+
+func TestController_Handle_MaxAgeForcesOriginFetch(t *testing.T) {
+	old := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("old"), StoredAt: time.Now().Add(-20 * time.Minute).Unix()}
+	rt := &fakeRuntime{
+		rule:            &Rule{Expiration: time.Hour, MaxAge: 10 * time.Minute},
+		ramEnt:          old,
+		ramOK:           true,
+		diskEnt:         old,
+		diskOK:          true,
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("fresh")},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/sensitive", nil))
+
+	if got := w.Body.String(); got != "fresh" {
+		t.Fatalf("body = %q, want fresh", got)
+	}
+	if len(rt.writeWait0) != 1 || rt.writeWait0[0] != "miss" {
+		t.Fatalf("writeWait0 = %v, want [miss]", rt.writeWait0)
+	}
+	if len(rt.promoted) != 0 || len(rt.revalidated) != 0 {
+		t.Fatalf("promoted=%v revalidated=%v, want none", rt.promoted, rt.revalidated)
+	}
+	if len(rt.stored) != 1 {
+		t.Fatalf("stored = %v, want refetched entry stored", rt.stored)
+	}
+}
+
+func TestController_Handle_KeyQueryStripsUnknownParams(t *testing.T) {
+	rt := &fakeRuntime{
+		rule:            &Rule{KeyQuery: []string{"page", "sort"}},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("list")},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/blog?utm_source=mail&sort=new&page=2", nil))
+
+	if len(rt.fetched) != 1 || rt.fetched[0] != "/blog?page=2&sort=new" {
+		t.Fatalf("fetched = %v, want [/blog?page=2&sort=new]", rt.fetched)
+	}
+	if len(rt.stored) != 1 || rt.stored[0] != "/blog#%40q=page%3D2%26sort%3Dnew" {
+		t.Fatalf("stored = %v", rt.stored)
+	}
+}
+
+func TestController_Handle_ResponseCacheControlOverridesOrigin(t *testing.T) {
+	stored := http.Header{"Cache-Control": {"public, max-age=60"}}
+	rt := &fakeRuntime{
+		rule:   &Rule{ResponseCacheControl: "public, max-age=31536000"},
+		ramEnt: Entry{Status: http.StatusOK, Header: stored, Body: []byte("asset")},
+		ramOK:  true,
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/static/app.js", nil))
+
+	if got := w.Result().Header.Get("Cache-Control"); got != "public, max-age=31536000" {
+		t.Fatalf("Cache-Control = %q", got)
+	}
+	if got := stored.Get("Cache-Control"); got != "public, max-age=60" {
+		t.Fatalf("stored Cache-Control mutated to %q", got)
+	}
+}
+
+func TestController_Handle_StaleIfError(t *testing.T) {
+	old := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("old"), StoredAt: time.Now().Add(-20 * time.Minute).Unix()}
+	tests := []struct {
+		name       string
+		rule       *Rule
+		originEnt  Entry
+		originKind string
+		originErr  error
+		wantWait0  string
+		wantBody   string
+		wantDelete bool
+	}{
+		{
+			name:      "network error serves stale",
+			rule:      &Rule{Expiration: time.Hour, MaxAge: 10 * time.Minute, StaleIfError: time.Hour},
+			originErr: errors.New("dial"),
+			wantWait0: "stale-if-error",
+			wantBody:  "old",
+		},
+		{
+			name:       "5xx serves stale and keeps the entry",
+			rule:       &Rule{Expiration: time.Hour, MaxAge: 10 * time.Minute, StaleIfError: time.Hour},
+			originEnt:  Entry{Status: http.StatusServiceUnavailable, Header: http.Header{}, Body: []byte("down")},
+			originKind: "ignore-by-status",
+			wantWait0:  "stale-if-error",
+			wantBody:   "old",
+		},
+		{
+			name:       "404 is passed on",
+			rule:       &Rule{Expiration: time.Hour, MaxAge: 10 * time.Minute, StaleIfError: time.Hour},
+			originEnt:  Entry{Status: http.StatusNotFound, Header: http.Header{}, Body: []byte("gone")},
+			originKind: "ignore-by-status",
+			wantWait0:  "ignore-by-status",
+			wantBody:   "gone",
+			wantDelete: true,
+		},
+		{
+			name:       "past the window",
+			rule:       &Rule{Expiration: time.Hour, MaxAge: 5 * time.Minute, StaleIfError: 5 * time.Minute},
+			originEnt:  Entry{Status: http.StatusServiceUnavailable, Header: http.Header{}, Body: []byte("down")},
+			originKind: "ignore-by-status",
+			wantWait0:  "ignore-by-status",
+			wantBody:   "down",
+			wantDelete: true,
+		},
+		{
+			name:      "without staleIfError",
+			rule:      &Rule{Expiration: time.Hour, MaxAge: 10 * time.Minute},
+			originErr: errors.New("dial"),
+			wantBody:  "bad gateway\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &fakeRuntime{
+				rule:         tc.rule,
+				ramEnt:       old,
+				ramOK:        true,
+				originEnt:    tc.originEnt,
+				originStatus: tc.originKind,
+				originErr:    tc.originErr,
+			}
+			c := NewController(rt)
+			w := httptest.NewRecorder()
+
+			c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/page", nil))
+
+			if got := w.Body.String(); got != tc.wantBody {
+				t.Fatalf("body = %q, want %q", got, tc.wantBody)
+			}
+			if got := w.Header().Get("X-Wait0"); tc.wantWait0 != "" && got != tc.wantWait0 {
+				t.Fatalf("X-Wait0 = %q, want %q", got, tc.wantWait0)
+			}
+			if tc.wantWait0 == "stale-if-error" && w.Header().Get("Age") == "" {
+				t.Fatalf("stale response without Age")
+			}
+			if (len(rt.deleted) > 0) != tc.wantDelete {
+				t.Fatalf("deleted = %v, want delete %v", rt.deleted, tc.wantDelete)
+			}
+			if len(rt.stored) != 0 {
+				t.Fatalf("stored = %v, want none", rt.stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setDebugHeaders adds the X-Wait0-Debug-* headers when the runtime enables
+// them: the cache key, the matched rule and, for cached entries, when the
+// entry was stored and whether it was stale when served.
+func (c *Controller) setDebugHeaders(h http.Header, key string, rule *Rule, ent Entry, wait0 string) {
+	if !c.rt.DebugHeaders() {
+		return
+	}
+	h.Set("X-Wait0-Debug-Key", key)
+	if rule != nil && rule.Index >= 0 {
+		h.Set("X-Wait0-Debug-Rule-Index", strconv.Itoa(rule.Index))
+		h.Set("X-Wait0-Debug-Rule-Priority", strconv.Itoa(rule.Priority))
+	}
+	if (wait0 != "hit" && wait0 != "miss") || ent.StoredAt <= 0 {
+		return
+	}
+	h.Set("X-Wait0-Debug-Stored-At", time.Unix(ent.StoredAt, 0).UTC().Format(time.RFC3339))
+	exp := rule.Freshness(ent)
+	h.Set("X-Wait0-Debug-Stale", strconv.FormatBool(exp > 0 && IsStale(ent, exp)))
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestController_DebugHeadersOnHit(t *testing.T) {
+	stored := time.Now().Add(-time.Hour).Unix()
+	rt := &fakeRuntime{
+		debugHeaders: true,
+		rule:         &Rule{Index: 2, Priority: 7, Expiration: time.Minute},
+		ramEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x"), StoredAt: stored},
+		ramOK:        true,
+	}
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/p?b=2&a=1", nil))
+
+	want := map[string]string{
+		"X-Wait0-Debug-Key":           "/p#%40q=a%3D1%26b%3D2",
+		"X-Wait0-Debug-Rule-Index":    "2",
+		"X-Wait0-Debug-Rule-Priority": "7",
+		"X-Wait0-Debug-Stored-At":     time.Unix(stored, 0).UTC().Format(time.RFC3339),
+		"X-Wait0-Debug-Stale":         "true",
+	}
+	for name, v := range want {
+		if got := w.Header().Get(name); got != v {
+			t.Fatalf("%s = %q, want %q", name, got, v)
+		}
+	}
+}
+
+func TestController_DebugHeadersOffAndDefaultRule(t *testing.T) {
+	ent := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x"), StoredAt: time.Now().Unix()}
+	rt := &fakeRuntime{rule: &Rule{Index: 1}, ramEnt: ent, ramOK: true}
+	w := httptest.NewRecorder()
+	NewController(rt).Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil))
+	if got := w.Header().Get("X-Wait0-Debug-Key"); got != "" {
+		t.Fatalf("X-Wait0-Debug-Key = %q with debug headers off", got)
+	}
+
+	rt = &fakeRuntime{debugHeaders: true, rule: &Rule{Index: -1}, ramEnt: ent, ramOK: true}
+	w = httptest.NewRecorder()
+	NewController(rt).Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/p", nil))
+	if got := w.Header().Get("X-Wait0-Debug-Rule-Index"); got != "" {
+		t.Fatalf("X-Wait0-Debug-Rule-Index = %q for the default rule, want none", got)
+	}
+	if got := w.Header().Get("X-Wait0-Debug-Stale"); got != "false" {
+		t.Fatalf("X-Wait0-Debug-Stale = %q, want false", got)
+	}
+}
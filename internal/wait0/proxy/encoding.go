@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+)
+
+// AcceptsEncoding reports whether the request's Accept-Encoding admits enc,
+// either by name or via "*", with a non-zero q value.
+func AcceptsEncoding(r *http.Request, enc string) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			name = strings.TrimSpace(name)
+			if !strings.EqualFold(name, enc) && name != "*" {
+				continue
+			}
+			if k, v, ok := strings.Cut(params, "="); ok && strings.TrimSpace(k) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && q == 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// needsDecode reports whether ent carries a gzip body the client cannot take.
+func needsDecode(r *http.Request, ent Entry) bool {
+	return strings.EqualFold(strings.TrimSpace(ent.Header.Get("Content-Encoding")), EncodingGzip) &&
+		!AcceptsEncoding(r, EncodingGzip)
+}
+
+// forClient returns ent as the client can accept it. Gzip bodies stored from a
+// compressed origin are decoded for clients that do not accept gzip; anything
+// else, including bodies that fail to decode, is returned unchanged.
+func forClient(r *http.Request, ent Entry) Entry {
+	if !needsDecode(r, ent) {
+		return ent
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(ent.Body))
+	if err != nil {
+		return ent
+	}
+	defer zr.Close()
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return ent
+	}
+	out := ent
+	out.Header = CloneHeader(ent.Header)
+	out.Header.Del("Content-Encoding")
+	out.Body = b
+	return out
+}
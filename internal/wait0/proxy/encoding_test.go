@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"br, GZIP;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"deflate, br", false},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://wait0.local/", nil)
+		if tc.header != "" {
+			r.Header.Set("Accept-Encoding", tc.header)
+		}
+		if got := AcceptsEncoding(r, EncodingGzip); got != tc.want {
+			t.Fatalf("AcceptsEncoding(%q) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestFetcher_GzipUpstreamKeepsCompressedBody(t *testing.T) {
+	compressed := gzipBytes(t, "hello gzip")
+	var gotAE string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAE = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(compressed)
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, AcceptEncoding: EncodingGzip}
+	ent, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil {
+		t.Fatalf("FetchFromOrigin error: %v", err)
+	}
+	if gotAE != "gzip" {
+		t.Fatalf("origin Accept-Encoding = %q, want gzip", gotAE)
+	}
+	if !bytes.Equal(ent.Body, compressed) || ent.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected compressed body stored as-is, got %q (%v)", ent.Body, ent.Header)
+	}
+}
+
+func TestController_DecodesGzipForClientsWithoutGzip(t *testing.T) {
+	ent := Entry{Status: http.StatusOK, Header: http.Header{"Content-Encoding": {"gzip"}}, Body: gzipBytes(t, "plain")}
+	rt := &fakeRuntime{ramEnt: ent, ramOK: true}
+	c := NewController(rt)
+
+	w := httptest.NewRecorder()
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if w.Body.String() != "plain" || w.Result().Header.Get("Content-Encoding") != "" {
+		t.Fatalf("identity client got %q (%v)", w.Body.String(), w.Result().Header)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	c.Handle(w, r)
+	if !bytes.Equal(w.Body.Bytes(), ent.Body) || w.Result().Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("gzip client should get stored bytes, got %q", w.Body.Bytes())
+	}
+}
+
+func TestController_StreamMiss_DecodesGzipAndStoresCompressed(t *testing.T) {
+	compressed := gzipBytes(t, "line1\nline2\n")
+	rt := &fakeRuntime{
+		rule:            &Rule{Streamable: true, StreamBufferMax: 1024},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{"Content-Encoding": {"gzip"}}, Body: compressed},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/feed", nil))
+
+	if w.Body.String() != "line1\nline2\n" {
+		t.Fatalf("body = %q", w.Body.String())
+	}
+	if len(rt.storedEnts) != 1 || !bytes.Equal(rt.storedEnts[0].Body, compressed) {
+		t.Fatalf("expected compressed body stored, got %+v", rt.storedEnts)
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+// withETag returns ent with a wait0 ETag derived from its body hash when the
+// origin sent none. Origin ETags are kept as-is.
+func withETag(ent Entry) Entry {
+	if ent.Status != http.StatusOK || ent.Header.Get("ETag") != "" {
+		return ent
+	}
+	sum := ent.Hash32
+	if sum == 0 {
+		sum = crc32.ChecksumIEEE(ent.Body)
+	}
+	ent.Header = ent.Header.Clone()
+	if ent.Header == nil {
+		ent.Header = http.Header{}
+	}
+	ent.Header.Set("ETag", fmt.Sprintf(`"w0-%08x"`, sum))
+	return ent
+}
+
+// notModified reports whether r's If-None-Match matches ent's ETag, using
+// weak comparison.
+func notModified(r *http.Request, ent Entry) bool {
+	etag := ent.Header.Get("ETag")
+	inm := r.Header.Get("If-None-Match")
+	if etag == "" || inm == "" || ent.Status != http.StatusOK {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, cand := range strings.Split(inm, ",") {
+		cand = strings.TrimSpace(cand)
+		if cand == "*" || strings.TrimPrefix(cand, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedEntry turns ent into a bodiless 304 carrying its headers.
+func notModifiedEntry(ent Entry) Entry {
+	ent.Status = http.StatusNotModified
+	ent.Body = nil
+	ent.Header = ent.Header.Clone()
+	ent.Header.Del("Content-Length")
+	return ent
+}
+
+// withoutConditionals strips client validators and ranges so a cache fill
+// always gets a full body from origin; wait0 answers them itself from the
+// stored entry.
+func withoutConditionals(r *http.Request) *http.Request {
+	if r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" && r.Header.Get("Range") == "" {
+		return r
+	}
+	out := r.Clone(r.Context())
+	out.Header.Del("If-None-Match")
+	out.Header.Del("If-Modified-Since")
+	out.Header.Del("Range")
+	out.Header.Del("If-Range")
+	return out
+}
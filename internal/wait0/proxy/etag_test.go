@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithETag(t *testing.T) {
+	shared := http.Header{"Content-Type": {"text/plain"}}
+	ent := withETag(Entry{Status: http.StatusOK, Header: shared, Body: []byte("x"), Hash32: 0xabc})
+	if got := ent.Header.Get("ETag"); got != `"w0-00000abc"` {
+		t.Fatalf("ETag = %q", got)
+	}
+	if shared.Get("ETag") != "" {
+		t.Fatalf("withETag must not mutate the stored header")
+	}
+
+	origin := withETag(Entry{Status: http.StatusOK, Header: http.Header{"Etag": {`"v1"`}}, Hash32: 1})
+	if got := origin.Header.Get("ETag"); got != `"v1"` {
+		t.Fatalf("origin ETag = %q, want \"v1\"", got)
+	}
+
+	if got := withETag(Entry{Status: http.StatusMovedPermanently, Header: http.Header{}}).Header.Get("ETag"); got != "" {
+		t.Fatalf("non-200 ETag = %q, want none", got)
+	}
+	if got := withETag(Entry{Status: http.StatusOK, Body: []byte("legacy")}).Header.Get("ETag"); got == "" || got == `"w0-00000000"` {
+		t.Fatalf("legacy entry ETag = %q, want body-derived", got)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	ent := Entry{Status: http.StatusOK, Header: http.Header{"Etag": {`"w0-1"`}}}
+	tests := []struct {
+		inm  string
+		want bool
+	}{
+		{inm: "", want: false},
+		{inm: `"w0-1"`, want: true},
+		{inm: `W/"w0-1"`, want: true},
+		{inm: `"other", "w0-1"`, want: true},
+		{inm: `"w0-2"`, want: false},
+		{inm: "*", want: true},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://wait0.local/", nil)
+		if tc.inm != "" {
+			r.Header.Set("If-None-Match", tc.inm)
+		}
+		if got := notModified(r, ent); got != tc.want {
+			t.Fatalf("notModified(%q) = %v, want %v", tc.inm, got, tc.want)
+		}
+	}
+}
+
+func TestWithoutConditionals(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/", nil)
+	if withoutConditionals(r) != r {
+		t.Fatalf("unconditional request should pass through")
+	}
+	r.Header.Set("If-None-Match", `"w0-1"`)
+	r.Header.Set("If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT")
+	r.Header.Set("Range", "bytes=0-1")
+	r.Header.Set("If-Range", `"w0-1"`)
+	out := withoutConditionals(r)
+	if out.Header.Get("If-None-Match") != "" || out.Header.Get("If-Modified-Since") != "" || out.Header.Get("Range") != "" || out.Header.Get("If-Range") != "" {
+		t.Fatalf("validators not stripped: %v", out.Header)
+	}
+	if r.Header.Get("If-None-Match") == "" {
+		t.Fatalf("original request must keep its validators")
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+type fetchResult struct {
+	ent       Entry
+	cacheable bool
+	kind      string
+	err       error
+	// key is where a cacheable result was stored: the variant its Vary
+	// headers select for the request that fetched it.
+	key string
+	// rest is the unread remainder of a body over MaxCacheableBytes; ent.Body
+	// holds what was read before it. Whoever consumes it must close it.
+	rest io.ReadCloser
+}
+
+func (r fetchResult) close() {
+	if r.rest != nil {
+		r.rest.Close()
+	}
+}
+
+// flightGroup collapses concurrent fetches for the same cache key into one
+// call whose result every caller shares.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+	wg    sync.WaitGroup
+}
+
+type flightCall struct {
+	done chan struct{}
+	res  fetchResult
+}
+
+// do starts fn for key unless a call for key is already in flight, then waits
+// for that call. fn runs on its own goroutine so it finishes even if every
+// caller stops waiting; a caller whose ctx ends returns ctx.Err() early.
+// shared reports whether the result came from a call another caller started.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() fetchResult) (res fetchResult, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	call, shared := g.calls[key]
+	if !shared {
+		call = &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			call.res = fn()
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.res, shared, nil
+	case <-ctx.Done():
+		if !shared {
+			// Only the starter consumes an unread body, so release it here.
+			g.wg.Add(1)
+			go func() {
+				defer g.wg.Done()
+				<-call.done
+				call.res.close()
+			}()
+		}
+		return fetchResult{}, shared, ctx.Err()
+	}
+}
+
+// wait blocks until every started call has finished.
+func (g *flightGroup) wait() {
+	g.wg.Wait()
+}
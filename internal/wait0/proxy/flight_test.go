@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedRuntime blocks origin fetches until gate is closed and guards the
+// embedded fake for concurrent handlers.
+type gatedRuntime struct {
+	*fakeRuntime
+	mu      sync.Mutex
+	gate    chan struct{}
+	entered chan struct{}
+	fetches atomic.Int32
+}
+
+func newGatedRuntime(cacheable bool) *gatedRuntime {
+	return &gatedRuntime{
+		fakeRuntime: &fakeRuntime{
+			originEnt:       Entry{Status: http.StatusOK, Header: http.Header{"X-Origin": {"1"}}, Body: []byte("body")},
+			originCacheable: cacheable,
+		},
+		gate:    make(chan struct{}),
+		entered: make(chan struct{}, 64),
+	}
+}
+
+func (g *gatedRuntime) FetchFromOrigin(r *http.Request) (Entry, bool, string, error) {
+	g.fetches.Add(1)
+	g.entered <- struct{}{}
+	<-g.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.fakeRuntime.FetchFromOrigin(r)
+}
+
+func (g *gatedRuntime) OpenFromOrigin(r *http.Request) (Entry, bool, string, io.ReadCloser, error) {
+	g.fetches.Add(1)
+	g.entered <- struct{}{}
+	<-g.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.fakeRuntime.OpenFromOrigin(r)
+}
+
+func (g *gatedRuntime) LoadRAM(key string, now int64) (Entry, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.fakeRuntime.LoadRAM(key, now)
+}
+
+func (g *gatedRuntime) LoadDisk(key string) (Entry, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.fakeRuntime.LoadDisk(key)
+}
+
+func (g *gatedRuntime) Store(key string, ent Entry, tier string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.fakeRuntime.Store(key, ent, tier)
+}
+
+func (g *gatedRuntime) WriteEntryWithStats(w http.ResponseWriter, ent Entry, wait0 string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.fakeRuntime.WriteEntryWithStats(w, ent, wait0)
+}
+
+func (g *gatedRuntime) ObserveOutcome(path, wait0 string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.fakeRuntime.ObserveOutcome(path, wait0)
+}
+
+func TestController_Handle_CoalescesConcurrentMisses(t *testing.T) {
+	rt := newGatedRuntime(true)
+	c := NewController(rt)
+
+	const n = 10
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/hot", nil))
+			codes[i] = w.Code
+		}(i)
+	}
+	<-rt.entered
+	// Give the other requests time to join the in-flight fetch.
+	time.Sleep(50 * time.Millisecond)
+	close(rt.gate)
+	wg.Wait()
+
+	if got := rt.fetches.Load(); got != 1 {
+		t.Fatalf("origin fetches = %d, want 1", got)
+	}
+	if len(rt.stored) != 1 {
+		t.Fatalf("stores = %d, want 1", len(rt.stored))
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d status = %d", i, code)
+		}
+	}
+	if got := c.Coalesced(); got != n-1 {
+		t.Fatalf("Coalesced = %d, want %d", got, n-1)
+	}
+}
+
+func TestController_Handle_CanceledLeaderStillFillsCache(t *testing.T) {
+	rt := newGatedRuntime(true)
+	c := NewController(rt)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://wait0.local/hot", nil).WithContext(ctx))
+	}()
+	<-rt.entered
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("canceled request still waiting on the origin fetch")
+	}
+	if len(rt.writeWait0) != 0 {
+		t.Fatalf("writes = %v, want none for a gone client", rt.writeWait0)
+	}
+
+	close(rt.gate)
+	c.Wait()
+	if len(rt.stored) != 1 {
+		t.Fatalf("stores = %d, want the detached fetch cached", len(rt.stored))
+	}
+}
+
+func TestController_Handle_CoalescedWaiterHonorsDeadline(t *testing.T) {
+	rt := newGatedRuntime(true)
+	c := NewController(rt)
+
+	leader := make(chan int, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/hot", nil))
+		leader <- w.Code
+	}()
+	<-rt.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	start := time.Now()
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/hot", nil).WithContext(ctx))
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("waiter returned after %s, want it bounded by its 50ms deadline", took)
+	}
+	if w.Code != http.StatusGatewayTimeout || w.Header().Get("X-Wait0") != "gateway-timeout" {
+		t.Fatalf("waiter status=%d X-Wait0=%q, want 504 gateway-timeout", w.Code, w.Header().Get("X-Wait0"))
+	}
+
+	close(rt.gate)
+	if code := <-leader; code != http.StatusOK {
+		t.Fatalf("leader status = %d, want the shared fetch to finish", code)
+	}
+	if got := rt.fetches.Load(); got != 1 || len(rt.stored) != 1 {
+		t.Fatalf("fetches=%d stores=%d, want one shared fetch that fills the cache", got, len(rt.stored))
+	}
+}
+
+func TestController_Handle_UncacheableResultIsNotShared(t *testing.T) {
+	rt := newGatedRuntime(false)
+	c := NewController(rt)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://wait0.local/private", nil))
+		}()
+	}
+	<-rt.entered
+	time.Sleep(50 * time.Millisecond)
+	close(rt.gate)
+	wg.Wait()
+
+	if got := rt.fetches.Load(); got != 2 {
+		t.Fatalf("origin fetches = %d, want each request to fetch its own", got)
+	}
+	if got := c.Coalesced(); got != 0 {
+		t.Fatalf("Coalesced = %d, want 0", got)
+	}
+}
+
+type closeTracker struct {
+	io.Reader
+	closed atomic.Bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestController_Handle_CanceledLeaderReleasesOversizedBody(t *testing.T) {
+	rt := newGatedRuntime(true)
+	rt.maxCacheable = 2
+	body := &closeTracker{Reader: strings.NewReader("oversized")}
+	rt.originStream = body
+	c := NewController(rt)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://wait0.local/big", nil).WithContext(ctx))
+	}()
+	<-rt.entered
+	cancel()
+	<-done
+
+	close(rt.gate)
+	c.Wait()
+	if !body.closed.Load() {
+		t.Fatalf("unread origin body left open after its client went away")
+	}
+	if len(rt.stored) != 0 {
+		t.Fatalf("stores = %d, want none", len(rt.stored))
+	}
+}
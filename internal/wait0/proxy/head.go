@@ -0,0 +1,23 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// headEntry strips the body from ent for a HEAD request, keeping the
+// Content-Length the matching GET would have sent.
+func headEntry(ent Entry) Entry {
+	if ent.Body == nil {
+		return ent
+	}
+	ent.Header = ent.Header.Clone()
+	if ent.Header == nil {
+		ent.Header = http.Header{}
+	}
+	if ent.Header.Get("Content-Length") == "" {
+		ent.Header.Set("Content-Length", strconv.Itoa(len(ent.Body)))
+	}
+	ent.Body = nil
+	return ent
+}
@@ -2,11 +2,32 @@ package proxy
 
 import (
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// WriteEntry writes ent with its buffered body. Origin Content-Length values
+// are dropped when entries are stored, so an explicit length is computed from
+// the body here unless a HEAD or range response already set one. A nil body
+// is a HEAD passed through from origin, whose length is not known here.
 func WriteEntry(w http.ResponseWriter, ent Entry, wait0 string) {
+	if ent.Header.Get("Content-Length") == "" && ent.Body != nil && bodyAllowed(ent.Status) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(ent.Body)))
+	}
+	WriteHead(w, ent, wait0)
+	_, _ = w.Write(ent.Body)
+}
+
+// bodyAllowed reports whether a response with status may carry a body and so
+// a Content-Length (RFC 9110 section 8.6).
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// WriteHead writes the entry headers and status without the body.
+func WriteHead(w http.ResponseWriter, ent Entry, wait0 string) {
 	for k, vs := range ent.Header {
 		if strings.EqualFold(k, "x-wait0") {
 			continue
@@ -21,7 +42,35 @@ func WriteEntry(w http.ResponseWriter, ent Entry, wait0 string) {
 		setWait0RevalidatedHeaders(w.Header(), ent)
 	}
 	w.WriteHeader(ent.Status)
-	_, _ = w.Write(ent.Body)
+}
+
+// DropOversizedHeaders removes header values longer than max bytes, deleting
+// headers left without values, and returns the affected header names. A max
+// of zero or less disables the cap.
+func DropOversizedHeaders(h http.Header, max int64) []string {
+	if max <= 0 {
+		return nil
+	}
+	var dropped []string
+	for k, vs := range h {
+		kept := vs[:0]
+		for _, v := range vs {
+			if int64(len(v)) <= max {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == len(vs) {
+			continue
+		}
+		dropped = append(dropped, k)
+		if len(kept) == 0 {
+			delete(h, k)
+		} else {
+			h[k] = kept
+		}
+	}
+	sort.Strings(dropped)
+	return dropped
 }
 
 func SetWait0Headers(h http.Header, wait0 string) {
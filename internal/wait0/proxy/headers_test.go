@@ -3,6 +3,7 @@ package proxy
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -33,6 +34,29 @@ func TestWriteEntryAndHeaders(t *testing.T) {
 	}
 }
 
+func TestWriteEntry_ContentLength(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteEntry(w, Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("hello")}, "hit")
+	if got := w.Header().Get("Content-Length"); got != "5" {
+		t.Fatalf("Content-Length = %q, want 5", got)
+	}
+
+	// HEAD and range responses carry their own length.
+	w = httptest.NewRecorder()
+	WriteEntry(w, Entry{Status: http.StatusOK, Header: http.Header{"Content-Length": {"42"}}}, "hit")
+	if got := w.Header().Values("Content-Length"); len(got) != 1 || got[0] != "42" {
+		t.Fatalf("Content-Length = %q, want [42]", got)
+	}
+
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		w = httptest.NewRecorder()
+		WriteEntry(w, Entry{Status: status, Header: http.Header{}}, "hit")
+		if got := w.Header().Get("Content-Length"); got != "" {
+			t.Fatalf("%d: Content-Length = %q, want none", status, got)
+		}
+	}
+}
+
 func TestSetWait0Headers_ExposeHeaderNoDuplicate(t *testing.T) {
 	h := http.Header{}
 	h.Set("Access-Control-Expose-Headers", "X-Wait0, X-Other")
@@ -46,3 +70,28 @@ func TestSetWait0Headers_ExposeHeaderNoDuplicate(t *testing.T) {
 		t.Fatalf("Access-Control-Expose-Headers = %q", got)
 	}
 }
+
+func TestDropOversizedHeaders(t *testing.T) {
+	h := http.Header{
+		"X-Ok":    {"short"},
+		"X-Huge":  {strings.Repeat("a", 100)},
+		"X-Mixed": {"keep", strings.Repeat("b", 100)},
+	}
+	if got := DropOversizedHeaders(h.Clone(), 0); got != nil {
+		t.Fatalf("disabled cap dropped %v", got)
+	}
+
+	got := DropOversizedHeaders(h, 16)
+	if strings.Join(got, ",") != "X-Huge,X-Mixed" {
+		t.Fatalf("dropped = %v", got)
+	}
+	if _, ok := h["X-Huge"]; ok {
+		t.Fatalf("header with only oversized values must be removed")
+	}
+	if vs := h.Values("X-Mixed"); len(vs) != 1 || vs[0] != "keep" {
+		t.Fatalf("X-Mixed = %v, want [keep]", vs)
+	}
+	if h.Get("X-Ok") != "short" {
+		t.Fatalf("X-Ok = %q", h.Get("X-Ok"))
+	}
+}
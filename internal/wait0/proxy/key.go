@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"wait0/internal/wait0/cachekey"
+)
+
+// CacheKey builds the cache key for r under rule. The normalized query string
+// is part of the key unless the rule ignores it. host is the component
+// extracted from the request host, or empty to leave the host out; version is
+// the active storage.keyVersion, or empty.
+func CacheKey(r *http.Request, rule *Rule, host, version string) string {
+	p := cachekey.Parts{Path: r.URL.Path, Host: host, Version: version}
+	switch {
+	case rule == nil:
+		p.Query = cachekey.NormalizeQuery(r.URL.RawQuery)
+	case len(rule.KeyQuery) > 0:
+		p.Query = cachekey.FilterQuery(r.URL.RawQuery, rule.KeyQuery)
+	case !rule.IgnoreQuery:
+		p.Query = cachekey.NormalizeQuery(r.URL.RawQuery)
+	}
+	if rule != nil {
+		p.Vary = cachekey.VaryValues(r.Header, rule.VaryBy)
+	}
+	return p.String()
+}
+
+// corsRequestHeaders are the preflight request headers an OPTIONS response
+// depends on.
+var corsRequestHeaders = []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}
+
+// optionsKey returns the key a cached OPTIONS response for r is stored under:
+// key marked with the method and extended by r's CORS preflight headers, so
+// preflights from different origins or for different methods stay apart.
+func optionsKey(r *http.Request, key string) string {
+	p := cachekey.Parse(key)
+	p.Method = http.MethodOptions
+	return cachekey.WithVary(p.String(), r.Header, corsRequestHeaders)
+}
+
+// postKey returns the key a cached POST response for body is stored under:
+// key marked with the method and the body's SHA-256.
+func postKey(key string, body []byte) string {
+	sum := sha256.Sum256(body)
+	p := cachekey.Parse(key)
+	p.Method = http.MethodPost
+	p.Body = hex.EncodeToString(sum[:])
+	return p.String()
+}
+
+// bufferBody reads r's body for a cacheable POST. Within max bytes it returns
+// a copy of r whose GetBody replays the body to origin, and the body itself.
+// Over max it returns false and a copy of r with the body restored unread,
+// whose GetBody hands that body to origin once.
+func bufferBody(r *http.Request, max int64) (*http.Request, []byte, bool) {
+	out := r.WithContext(r.Context())
+	if r.Body == nil || r.Body == http.NoBody {
+		out.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return out, nil, true
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil || int64(len(b)) > max {
+		rest := struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+		out.Body = rest
+		// The bypass forwards the body to origin as it streams in. POSTs
+		// are never retried (see Fetcher.do), so the one stream is enough.
+		out.GetBody = func() (io.ReadCloser, error) { return rest, nil }
+		return out, nil, false
+	}
+	out.Body = io.NopCloser(bytes.NewReader(b))
+	out.ContentLength = int64(len(b))
+	out.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil }
+	return out, b, true
+}
+
+// withKeyQuery strips query parameters outside rule.KeyQuery, so what origin
+// sees for a cache fill matches the cache key.
+func withKeyQuery(r *http.Request, rule *Rule) *http.Request {
+	if rule == nil || len(rule.KeyQuery) == 0 {
+		return r
+	}
+	q := cachekey.FilterQuery(r.URL.RawQuery, rule.KeyQuery)
+	if q == r.URL.RawQuery {
+		return r
+	}
+	out := r.Clone(r.Context())
+	out.URL.RawQuery = q
+	return out
+}
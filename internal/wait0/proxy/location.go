@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// LocationRewrite maps absolute Location headers that point at Origin onto
+// the public host clients used to reach wait0.
+type LocationRewrite struct {
+	// Origin is the configured origin base URL.
+	Origin string
+	// PublicHost overrides the public host; it may carry a scheme
+	// ("https://www.example.com"). Empty falls back to X-Forwarded-Host, then
+	// the request Host.
+	PublicHost string
+}
+
+type passRedirectsKey struct{}
+
+// withPassRedirects marks r so the origin fetch returns 3xx responses as-is
+// instead of following them, letting their Location be rewritten.
+func withPassRedirects(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), passRedirectsKey{}, true))
+}
+
+func passRedirects(ctx context.Context) bool {
+	v, _ := ctx.Value(passRedirectsKey{}).(bool)
+	return v
+}
+
+// rewriteLocation returns ent with its Location header pointed at the public
+// host when ent is a 3xx redirect to the origin host. Other entries, and
+// relative or foreign Location values, are returned unchanged.
+func rewriteLocation(r *http.Request, ent Entry, rw *LocationRewrite) Entry {
+	if rw == nil || ent.Status < 300 || ent.Status >= 400 {
+		return ent
+	}
+	loc := ent.Header.Get("Location")
+	if loc == "" {
+		return ent
+	}
+	target, err := url.Parse(loc)
+	if err != nil || target.Host == "" {
+		return ent
+	}
+	origin, err := url.Parse(rw.Origin)
+	if err != nil || !strings.EqualFold(target.Host, origin.Host) {
+		return ent
+	}
+
+	scheme, host := publicSchemeHost(r, rw.PublicHost)
+	if host == "" {
+		return ent
+	}
+	target.Scheme = scheme
+	target.Host = host
+
+	out := ent
+	out.Header = CloneHeader(ent.Header)
+	out.Header.Set("Location", target.String())
+	return out
+}
+
+func publicSchemeHost(r *http.Request, public string) (string, string) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")); p != "" {
+		scheme = strings.ToLower(strings.TrimSpace(strings.Split(p, ",")[0]))
+	}
+
+	if public = strings.TrimSpace(public); public != "" {
+		if u, err := url.Parse(public); err == nil && u.Host != "" {
+			return u.Scheme, u.Host
+		}
+		return scheme, public
+	}
+	if fh := strings.TrimSpace(r.Header.Get("X-Forwarded-Host")); fh != "" {
+		return scheme, strings.TrimSpace(strings.Split(fh, ",")[0])
+	}
+	return scheme, r.Host
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRewriteLocation(t *testing.T) {
+	rw := &LocationRewrite{Origin: "http://backend.internal:3000"}
+	redirect := func(loc string) Entry {
+		return Entry{Status: http.StatusFound, Header: http.Header{"Location": {loc}}}
+	}
+
+	tests := []struct {
+		name  string
+		ent   Entry
+		rw    *LocationRewrite
+		setup func(r *http.Request)
+		want  string
+	}{
+		{name: "request host", ent: redirect("http://backend.internal:3000/login?next=/a"), rw: rw, want: "http://wait0.local/login?next=/a"},
+		{name: "forwarded host and proto", ent: redirect("http://backend.internal:3000/x"), rw: rw, setup: func(r *http.Request) {
+			r.Header.Set("X-Forwarded-Host", "www.example.com, proxy.local")
+			r.Header.Set("X-Forwarded-Proto", "https")
+		}, want: "https://www.example.com/x"},
+		{name: "configured public host", ent: redirect("http://backend.internal:3000/x"), rw: &LocationRewrite{Origin: rw.Origin, PublicHost: "https://shop.example.com"}, want: "https://shop.example.com/x"},
+		{name: "foreign host untouched", ent: redirect("https://sso.example.com/x"), rw: rw, want: "https://sso.example.com/x"},
+		{name: "relative untouched", ent: redirect("/x"), rw: rw, want: "/x"},
+		{name: "disabled", ent: redirect("http://backend.internal:3000/x"), rw: nil, want: "http://backend.internal:3000/x"},
+		{name: "non redirect status", ent: Entry{Status: http.StatusOK, Header: http.Header{"Location": {"http://backend.internal:3000/x"}}}, rw: rw, want: "http://backend.internal:3000/x"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://wait0.local/a", nil)
+			if tc.setup != nil {
+				tc.setup(r)
+			}
+			before := tc.ent.Header.Get("Location")
+			got := rewriteLocation(r, tc.ent, tc.rw)
+			if loc := got.Header.Get("Location"); loc != tc.want {
+				t.Fatalf("Location = %q, want %q", loc, tc.want)
+			}
+			if tc.ent.Header.Get("Location") != before {
+				t.Fatalf("input header must not be mutated")
+			}
+		})
+	}
+}
@@ -1,63 +1,255 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"hash/crc32"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
+
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/freshness"
 )
 
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
 type Fetcher struct {
 	Client *http.Client
 	Origin string
+	// AcceptEncoding is sent to origin; empty means EncodingIdentity.
+	AcceptEncoding string
+	// MaxHeaderValueBytes drops origin header values longer than this; zero
+	// disables the cap.
+	MaxHeaderValueBytes int64
+	// Logger receives warnings about misbehaving origin responses; may be nil.
+	Logger Logger
+	// Timeout caps each origin request. A deadline already on the client
+	// request wins when it ends sooner, so an impatient client does not keep
+	// the fetch alive after giving up. Zero leaves only the client deadline.
+	Timeout time.Duration
+	// Retries re-sends a GET or HEAD whose origin request failed to connect
+	// or timed out, up to this many times, waiting RetryBackoff before the
+	// first retry and doubling it before each next one. Responses, error
+	// statuses included, are never retried.
+	Retries      int
+	RetryBackoff time.Duration
+	// DefaultContentType is set on origin responses without a Content-Type;
+	// with SniffContentType the type is detected from the body first (see
+	// FillContentType). Both leave the origin's own value alone.
+	DefaultContentType string
+	SniffContentType   bool
+	// ForwardedHeaders adds X-Forwarded-For, X-Forwarded-Proto and
+	// X-Forwarded-Host for the client to origin requests (see
+	// setForwardedHeaders).
+	ForwardedHeaders bool
+	// PreserveHost sends the client's Host to origin instead of the host
+	// from Origin, for origins that serve virtual hosts.
+	PreserveHost bool
 }
 
+// FetchFromOrigin reads the full origin response. A body whose length does not
+// match the declared Content-Length is returned as non-cacheable. A HEAD
+// response has no body: it keeps the origin's Content-Length and a nil Body.
 func (f Fetcher) FetchFromOrigin(r *http.Request) (Entry, bool, string, error) {
-	originURL := f.Origin + r.URL.RequestURI()
-	ctx := r.Context()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, originURL, nil)
+	ent, cacheable, statusKind, resp, err := f.open(r)
 	if err != nil {
 		return Entry{}, false, "", err
 	}
-	CopyHeaders(req.Header, r.Header)
-	req.Header.Set("Accept-Encoding", "identity")
+	defer resp.Body.Close()
+	if r.Method == http.MethodHead {
+		return ent, cacheable, statusKind, nil
+	}
+	b, err := io.ReadAll(resp.Body)
+	declared := resp.ContentLength
+	if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && declared >= 0) {
+		return Entry{}, false, "", err
+	}
+	if declared >= 0 && int64(len(b)) != declared {
+		if f.Logger != nil {
+			f.Logger.Printf("origin body length mismatch, not caching: uri=%q declared=%d read=%d", r.URL.RequestURI(), declared, len(b))
+		}
+		cacheable = false
+	}
+	f.fillContentType(ent.Header, b)
+	ent.Body = b
+	ent.Hash32 = crc32.ChecksumIEEE(b)
+	return ent, cacheable, statusKind, nil
+}
 
-	resp, err := f.Client.Do(req)
+// OpenFromOrigin issues the origin request and returns the response head as an
+// Entry without body, plus the unread body. The caller must close the body.
+// Sniffing a missing Content-Type reads the first bytes of the body before
+// returning.
+func (f Fetcher) OpenFromOrigin(r *http.Request) (Entry, bool, string, io.ReadCloser, error) {
+	ent, cacheable, statusKind, resp, err := f.open(r)
 	if err != nil {
-		return Entry{}, false, "", err
+		return Entry{}, false, "", nil, err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	return ent, cacheable, statusKind, f.sniffBody(ent.Header, resp.Body), nil
+}
+
+func (f Fetcher) open(r *http.Request) (Entry, bool, string, *http.Response, error) {
+	resp, err := f.do(r)
 	if err != nil {
-		return Entry{}, false, "", err
+		return Entry{}, false, "", nil, err
 	}
 
 	now := time.Now().UTC()
 	ent := Entry{
 		Status:       resp.StatusCode,
 		Header:       CloneHeader(resp.Header),
-		Body:         body,
 		StoredAt:     now.Unix(),
 		Inactive:     false,
 		DiscoveredBy: "user",
 
 		RevalidatedAt: now.UnixNano(),
 		RevalidatedBy: "user",
+		MaxAge:        freshness.FromHeader(resp.Header, now),
+		ETag:          resp.Header.Get("ETag"),
+		LastModified:  resp.Header.Get("Last-Modified"),
+	}
+	if r.Method != http.MethodHead {
+		ent.Header.Del("Content-Length")
+	}
+	// The upstream Age is the entry's age at StoredAt; withAge adds the time
+	// since on every hit.
+	freshness.CleanAge(ent.Header)
+	if dropped := DropOversizedHeaders(ent.Header, f.MaxHeaderValueBytes); len(dropped) > 0 && f.Logger != nil {
+		f.Logger.Printf("origin header values over %d bytes dropped: uri=%q headers=%v", f.MaxHeaderValueBytes, r.URL.RequestURI(), dropped)
 	}
-	ent.Header.Del("Content-Length")
-	ent.Hash32 = crc32.ChecksumIEEE(body)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return ent, false, "ignore-by-status", nil
+		return ent, false, "ignore-by-status", resp, nil
 	}
 
-	cc := strings.ToLower(resp.Header.Get("Cache-Control"))
-	cacheable := true
-	if strings.Contains(cc, "no-store") || strings.Contains(cc, "no-cache") {
-		cacheable = false
+	_, varyAny := cachekey.ResponseVary(resp.Header)
+	cacheable := freshness.ParseCacheControl(resp.Header).Storable() && !varyAny
+	return ent, cacheable, "ok", resp, nil
+}
+
+// do sends r to origin, retrying transient failures of idempotent requests
+// per Retries. The backoff waits end early when the client goes away.
+func (f Fetcher) do(r *http.Request) (*http.Response, error) {
+	retries := f.Retries
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		retries = 0
+	}
+	backoff := f.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := f.send(r)
+		if err == nil || attempt >= retries || !transient(err) || r.Context().Err() != nil {
+			return resp, err
+		}
+		if f.Logger != nil {
+			f.Logger.Printf("origin request failed, retrying in %s (%d/%d): uri=%q err=%v", backoff, attempt+1, retries, r.URL.RequestURI(), err)
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return nil, err
+		}
+		backoff *= 2
 	}
-	return ent, cacheable, "ok", nil
+}
+
+// send issues one origin request for r. The returned body releases the
+// request context when closed.
+func (f Fetcher) send(r *http.Request) (*http.Response, error) {
+	originURL := f.Origin + r.URL.RequestURI()
+	ctx, cancel := f.requestContext(r.Context())
+	method := http.MethodGet
+	var body io.ReadCloser
+	switch {
+	case r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		method = r.Method
+	case r.Method == http.MethodPost && r.GetBody != nil:
+		// A POST read for caching: GetBody replays a buffered body on every
+		// attempt, or streams one over the buffer limit once.
+		b, err := r.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		method, body = r.Method, b
+	}
+	req, err := http.NewRequestWithContext(ctx, method, originURL, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = r.ContentLength
+	}
+	CopyHeaders(req.Header, r.Header)
+	if f.PreserveHost && r.Host != "" {
+		req.Host = r.Host
+	}
+	if f.ForwardedHeaders {
+		setForwardedHeaders(req.Header, r)
+	}
+	acceptEncoding := f.AcceptEncoding
+	if acceptEncoding == "" {
+		acceptEncoding = EncodingIdentity
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	client := f.Client
+	if passRedirects(ctx) {
+		c := *f.Client
+		c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		client = &c
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = CancelOnClose{ReadCloser: resp.Body, Cancel: cancel}
+	return resp, nil
+}
+
+// transient reports whether an origin request error is a connection failure
+// or timeout worth retrying, as opposed to a bad URL or a redirect policy
+// error.
+func transient(err error) bool {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// requestContext bounds an origin request by Timeout. context.WithTimeout
+// keeps the parent's deadline when it is earlier, which gives
+// min(client deadline remaining, Timeout).
+func (f Fetcher) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if f.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, f.Timeout)
+}
+
+// CancelOnClose releases the request context once the body is closed, so the
+// timeout keeps covering body reads.
+type CancelOnClose struct {
+	io.ReadCloser
+	Cancel context.CancelFunc
+}
+
+func (c CancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.Cancel()
+	return err
 }
 
 func CopyHeaders(dst, src http.Header) {
@@ -71,6 +263,26 @@ func CopyHeaders(dst, src http.Header) {
 	}
 }
 
+// setForwardedHeaders appends the client IP from r.RemoteAddr to any
+// X-Forwarded-For chain already in h, and replaces X-Forwarded-Proto and
+// X-Forwarded-Host with the scheme and Host r arrived with.
+func setForwardedHeaders(h http.Header, r *http.Request) {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && ip != "" {
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		h.Set("X-Forwarded-For", ip)
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	h.Set("X-Forwarded-Proto", proto)
+	if r.Host != "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+}
+
 func CloneHeader(h http.Header) http.Header {
 	out := make(http.Header, len(h))
 	for k, vs := range h {
@@ -1,9 +1,16 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -32,6 +39,40 @@ func TestFetchFromOrigin_NoStoreIsNotCacheable(t *testing.T) {
 	}
 }
 
+func TestFetchFromOrigin_PrivateIsNotCacheable(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL}
+	_, cacheable, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil {
+		t.Fatalf("FetchFromOrigin error: %v", err)
+	}
+	if cacheable {
+		t.Fatalf("expected Cache-Control: private to be non-cacheable")
+	}
+}
+
+func TestFetchFromOrigin_VaryAnyIsNotCacheable(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language, *")
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL}
+	_, cacheable, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil {
+		t.Fatalf("FetchFromOrigin error: %v", err)
+	}
+	if cacheable {
+		t.Fatalf("expected Vary: * to be non-cacheable")
+	}
+}
+
 func TestFetchFromOrigin_Non2xxIsIgnoreByStatus(t *testing.T) {
 	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusTeapot)
@@ -56,6 +97,246 @@ func TestFetchFromOrigin_Non2xxIsIgnoreByStatus(t *testing.T) {
 	}
 }
 
+func TestFetchFromOrigin_ShortBodyIsNotCacheable(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(buf, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\nContent-Type: text/plain\r\n\r\nshort")
+		_ = buf.Flush()
+	}))
+	defer origin.Close()
+
+	log := &captureLogger{}
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, Logger: log}
+	req := httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil)
+	ent, cacheable, statusKind, err := f.FetchFromOrigin(req)
+	if err != nil {
+		t.Fatalf("FetchFromOrigin error: %v", err)
+	}
+	if cacheable {
+		t.Fatalf("truncated body must not be cacheable")
+	}
+	if statusKind != "ok" || string(ent.Body) != "short" {
+		t.Fatalf("statusKind=%q body=%q", statusKind, ent.Body)
+	}
+	if len(log.lines) != 1 || !strings.Contains(log.lines[0], "declared=10 read=5") {
+		t.Fatalf("log lines = %v", log.lines)
+	}
+}
+
+func TestFetchFromOrigin_MatchingLengthIsCacheable(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2")
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL}
+	_, cacheable, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil || !cacheable {
+		t.Fatalf("cacheable=%v err=%v, want cacheable", cacheable, err)
+	}
+}
+
+func TestFetchFromOrigin_ForwardsHeadAndOptions(t *testing.T) {
+	methods := make(chan string, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods <- r.Method
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL}
+	for _, method := range []string{http.MethodHead, http.MethodOptions} {
+		if _, _, _, err := f.FetchFromOrigin(httptest.NewRequest(method, "http://wait0.local/x", nil)); err != nil {
+			t.Fatalf("FetchFromOrigin: %v", err)
+		}
+		if got := <-methods; got != method {
+			t.Fatalf("origin method = %q, want %s", got, method)
+		}
+	}
+}
+
+func TestFetchFromOrigin_HeadKeepsOriginContentLength(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "12345")
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(make([]byte, 12345))
+		}
+	}))
+	defer origin.Close()
+
+	log := &captureLogger{}
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, Logger: log}
+	ent, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodHead, "http://wait0.local/big", nil))
+	if err != nil {
+		t.Fatalf("FetchFromOrigin: %v", err)
+	}
+	if ent.Body != nil || len(log.lines) != 0 {
+		t.Fatalf("body=%d bytes logs=%v, want no body and no length mismatch", len(ent.Body), log.lines)
+	}
+
+	w := httptest.NewRecorder()
+	WriteEntry(w, headEntry(ent), "bypass")
+	if got := w.Header().Get("Content-Length"); got != "12345" {
+		t.Fatalf("HEAD miss Content-Length = %q, want the origin's 12345", got)
+	}
+}
+
+func TestFetchFromOrigin_ReplaysBufferedPost(t *testing.T) {
+	got := make(chan string, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- r.Method + " " + string(b)
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL}
+	r, _, _ := bufferBody(httptest.NewRequest(http.MethodPost, "http://wait0.local/q", strings.NewReader(`{"q":1}`)), 64)
+	if _, _, _, err := f.FetchFromOrigin(r); err != nil {
+		t.Fatalf("FetchFromOrigin: %v", err)
+	}
+	if s := <-got; s != `POST {"q":1}` {
+		t.Fatalf("origin got %q, want the buffered POST", s)
+	}
+}
+
+func TestFetchFromOrigin_ForwardsPostOverBufferLimit(t *testing.T) {
+	got := make(chan string, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- r.Method + " " + string(b)
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL}
+	payload := strings.Repeat("0123456789", 100)
+	r, _, ok := bufferBody(httptest.NewRequest(http.MethodPost, "http://wait0.local/q", strings.NewReader(payload)), 16)
+	if ok {
+		t.Fatalf("bufferBody accepted a body over the limit")
+	}
+	if _, _, _, err := f.FetchFromOrigin(r); err != nil {
+		t.Fatalf("FetchFromOrigin: %v", err)
+	}
+	if s := <-got; s != "POST "+payload {
+		t.Fatalf("origin got %.40q..., want the POST with its full body", s)
+	}
+}
+
+func TestFetchFromOrigin_UpstreamAgeShortensMaxAge(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=600")
+		w.Header().Set("Age", " 120")
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL}
+	ent, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil {
+		t.Fatalf("FetchFromOrigin: %v", err)
+	}
+	if ent.MaxAge != 480 {
+		t.Fatalf("MaxAge = %d, want 600 - upstream Age 120", ent.MaxAge)
+	}
+	if got := ent.Header.Values("Age"); len(got) != 1 || got[0] != "120" {
+		t.Fatalf("stored Age = %q, want [120]", got)
+	}
+}
+
+func TestFetchFromOrigin_DropsOversizedHeaderValues(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Huge", strings.Repeat("a", 4096))
+		w.Header().Set("X-Small", "ok")
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	log := &captureLogger{}
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, MaxHeaderValueBytes: 1024, Logger: log}
+	ent, cacheable, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil || !cacheable {
+		t.Fatalf("cacheable=%v err=%v", cacheable, err)
+	}
+	if ent.Header.Get("X-Huge") != "" || ent.Header.Get("X-Small") != "ok" {
+		t.Fatalf("headers = %v", ent.Header)
+	}
+	if len(log.lines) != 1 || !strings.Contains(log.lines[0], "X-Huge") {
+		t.Fatalf("log lines = %v", log.lines)
+	}
+}
+
+type captureLogger struct {
+	lines []string
+}
+
+func (l *captureLogger) Printf(format string, v ...any) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestFetchFromOrigin_TimeoutFollowsClientDeadline(t *testing.T) {
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer origin.Close()
+	defer close(release)
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		deadline time.Duration
+	}{
+		{name: "client deadline sooner", timeout: 5 * time.Second, deadline: 50 * time.Millisecond},
+		{name: "no client deadline", timeout: 50 * time.Millisecond},
+		{name: "cap sooner than deadline", timeout: 50 * time.Millisecond, deadline: 5 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := Fetcher{Client: &http.Client{}, Origin: origin.URL, Timeout: tc.timeout}
+			req := httptest.NewRequest(http.MethodGet, "http://wait0.local/slow", nil)
+			if tc.deadline > 0 {
+				ctx, cancel := context.WithTimeout(req.Context(), tc.deadline)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+			start := time.Now()
+			if _, _, _, err := f.FetchFromOrigin(req); err == nil {
+				t.Fatalf("expected a timeout error")
+			}
+			if took := time.Since(start); took > time.Second {
+				t.Fatalf("fetch took %s, want it bounded near 50ms", took)
+			}
+		})
+	}
+}
+
+func TestOpenFromOrigin_TimeoutCoversBodyUntilClose(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "streamed")
+	}))
+	defer origin.Close()
+
+	f := Fetcher{Client: &http.Client{}, Origin: origin.URL, Timeout: 2 * time.Second}
+	_, _, _, body, err := f.OpenFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil {
+		t.Fatalf("OpenFromOrigin: %v", err)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil || string(b) != "streamed" {
+		t.Fatalf("body = %q, err = %v", b, err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
 func TestCopyHeaders_SkipsHostAndCopiesValues(t *testing.T) {
 	src := http.Header{}
 	src.Add("Host", "example.com")
@@ -73,3 +354,157 @@ func TestCopyHeaders_SkipsHostAndCopiesValues(t *testing.T) {
 		t.Fatalf("X-Test = %v", got)
 	}
 }
+
+func TestFetchFromOrigin_ForwardedHeaders(t *testing.T) {
+	var got http.Header
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer origin.Close()
+
+	for _, forward := range []bool{false, true} {
+		f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, ForwardedHeaders: forward}
+		req := httptest.NewRequest(http.MethodGet, "http://www.example.com/x", nil)
+		req.RemoteAddr = "203.0.113.9:51234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		if _, _, _, err := f.FetchFromOrigin(req); err != nil {
+			t.Fatalf("FetchFromOrigin error: %v", err)
+		}
+		xff, proto, host := got.Get("X-Forwarded-For"), got.Get("X-Forwarded-Proto"), got.Get("X-Forwarded-Host")
+		if !forward {
+			if xff != "198.51.100.7" || proto != "https" || host != "" {
+				t.Fatalf("disabled: client headers not passed through unchanged: xff=%q proto=%q host=%q", xff, proto, host)
+			}
+			continue
+		}
+		if xff != "198.51.100.7, 203.0.113.9" || proto != "http" || host != "www.example.com" {
+			t.Fatalf("enabled: xff=%q proto=%q host=%q", xff, proto, host)
+		}
+	}
+}
+
+func TestFetchFromOrigin_PreserveHost(t *testing.T) {
+	var got string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Host
+	}))
+	defer origin.Close()
+	originHost := strings.TrimPrefix(origin.URL, "http://")
+
+	for _, keep := range []bool{false, true} {
+		f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, PreserveHost: keep}
+		if _, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://shop.example.com/x", nil)); err != nil {
+			t.Fatalf("FetchFromOrigin error: %v", err)
+		}
+		want := map[bool]string{false: originHost, true: "shop.example.com"}[keep]
+		if got != want {
+			t.Fatalf("preserveHost=%v: origin saw Host %q, want %q", keep, got, want)
+		}
+	}
+}
+
+func TestSetForwardedHeaders_TLSAndNoRemoteAddr(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "https://www.example.com/x", nil)
+	r.RemoteAddr = ""
+	h := http.Header{}
+
+	setForwardedHeaders(h, r)
+
+	if h.Get("X-Forwarded-For") != "" || h.Get("X-Forwarded-Proto") != "https" || h.Get("X-Forwarded-Host") != "www.example.com" {
+		t.Fatalf("headers = %v", h)
+	}
+}
+
+// flakyOrigin drops the connection for the first failures requests, then
+// answers "ok". It returns the server and a counter of requests seen.
+func flakyOrigin(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(origin.Close)
+	return origin, &calls
+}
+
+func TestFetchFromOrigin_RetriesConnectionFailures(t *testing.T) {
+	origin, calls := flakyOrigin(t, 2, http.StatusOK)
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, Retries: 2, RetryBackoff: time.Millisecond}
+
+	ent, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil {
+		t.Fatalf("FetchFromOrigin error: %v", err)
+	}
+	if string(ent.Body) != "ok" || calls.Load() != 3 {
+		t.Fatalf("body = %q after %d calls, want ok after 3", ent.Body, calls.Load())
+	}
+
+	origin, calls = flakyOrigin(t, 5, http.StatusOK)
+	f.Origin = origin.URL
+	if _, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil)); err == nil {
+		t.Fatalf("expected an error once retries run out")
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("calls = %d, want 1 attempt plus 2 retries", calls.Load())
+	}
+}
+
+func TestFetchFromOrigin_DoesNotRetryStatusOrNonIdempotent(t *testing.T) {
+	origin, calls := flakyOrigin(t, 0, http.StatusServiceUnavailable)
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, Retries: 3, RetryBackoff: time.Millisecond}
+	_, _, kind, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil))
+	if err != nil || kind != "ignore-by-status" || calls.Load() != 1 {
+		t.Fatalf("503: kind=%q err=%v calls=%d, want one attempt", kind, err, calls.Load())
+	}
+
+	origin, calls = flakyOrigin(t, 1, http.StatusOK)
+	f.Origin = origin.URL
+	if _, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodPost, "http://wait0.local/x", nil)); err == nil {
+		t.Fatalf("expected the POST failure to surface")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("POST calls = %d, want no retry", calls.Load())
+	}
+}
+
+func TestFetchFromOrigin_ClientCancelStopsRetries(t *testing.T) {
+	origin, calls := flakyOrigin(t, 5, http.StatusOK)
+	f := Fetcher{Client: &http.Client{Timeout: 2 * time.Second}, Origin: origin.URL, Retries: 3, RetryBackoff: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, _, _, err := f.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/x", nil).WithContext(ctx))
+	if err == nil || time.Since(start) > time.Second {
+		t.Fatalf("err = %v after %v, want a prompt failure", err, time.Since(start))
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("calls = %d, want no retry after the client left", calls.Load())
+	}
+}
+
+func TestTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{err: &url.Error{Op: "Get", URL: "http://o", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, want: true},
+		{err: &url.Error{Op: "Get", URL: "http://o", Err: io.EOF}, want: true},
+		{err: &url.Error{Op: "Get", URL: "http://o", Err: context.DeadlineExceeded}, want: true},
+		{err: &url.Error{Op: "Get", URL: "http://o", Err: errors.New("stopped after 10 redirects")}},
+		{err: errors.New("unsupported protocol scheme")},
+	} {
+		if got := transient(tc.err); got != tc.want {
+			t.Fatalf("transient(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// withRange answers a single-range GET from ent's full body: 206 with the
+// slice and Content-Range, or 416 when the range starts past the end. Every
+// 200 it leaves whole advertises Accept-Ranges. Multiple ranges, malformed
+// specs and a failed If-Range all get the full body, which RFC 9110 allows.
+func withRange(r *http.Request, ent Entry) Entry {
+	if ent.Status != http.StatusOK {
+		return ent
+	}
+	ent.Header = ent.Header.Clone()
+	if ent.Header == nil {
+		ent.Header = http.Header{}
+	}
+	ent.Header.Set("Accept-Ranges", "bytes")
+	spec := r.Header.Get("Range")
+	if spec == "" || r.Method != http.MethodGet || !ifRangeMatches(r.Header.Get("If-Range"), ent) {
+		return ent
+	}
+	size := int64(len(ent.Body))
+	start, end, ok := parseRange(spec, size)
+	if !ok {
+		return ent
+	}
+	if start < 0 {
+		ent.Status = http.StatusRequestedRangeNotSatisfiable
+		ent.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		ent.Header.Del("Content-Type")
+		ent.Header.Set("Content-Length", "0")
+		ent.Body = nil
+		return ent
+	}
+	ent.Status = http.StatusPartialContent
+	ent.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	ent.Header.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	ent.Body = ent.Body[start : end+1]
+	return ent
+}
+
+// parseRange parses a single "bytes=" range against size. ok is false when
+// the header should be ignored; start is -1 when the range is unsatisfiable.
+func parseRange(spec string, size int64) (start, end int64, ok bool) {
+	unit, set, found := strings.Cut(strings.TrimSpace(spec), "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") || strings.Contains(set, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(set), "-")
+	if !found {
+		return 0, 0, false
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+	if first == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		if n == 0 || size == 0 {
+			return -1, 0, true
+		}
+		return max(size-n, 0), size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return -1, 0, true
+	}
+	return start, end, true
+}
+
+// ifRangeMatches reports whether an If-Range validator still describes ent.
+// ETags use strong comparison and dates must equal Last-Modified exactly.
+func ifRangeMatches(ifRange string, ent Entry) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		etag := ent.Header.Get("ETag")
+		return etag != "" && !strings.HasPrefix(ifRange, "W/") && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	lm := ent.Header.Get("Last-Modified")
+	if lm == "" {
+		return false
+	}
+	want, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	have, err := http.ParseTime(lm)
+	return err == nil && want.Equal(have)
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		spec       string
+		start, end int64
+		ok         bool
+	}{
+		{"bytes=0-3", 0, 3, true},
+		{"bytes=4-", 4, 9, true},
+		{"bytes=-3", 7, 9, true},
+		{"bytes=-30", 0, 9, true},
+		{"bytes=5-100", 5, 9, true},
+		{"BYTES = 1-1", 1, 1, true},
+		{"bytes=10-", -1, 0, true},
+		{"bytes=-0", -1, 0, true},
+		{"bytes=0-1,3-4", 0, 0, false},
+		{"bytes=3-1", 0, 0, false},
+		{"bytes=x-1", 0, 0, false},
+		{"items=0-1", 0, 0, false},
+		{"bytes=5", 0, 0, false},
+	}
+	for _, tc := range cases {
+		start, end, ok := parseRange(tc.spec, 10)
+		if ok != tc.ok || (ok && (start != tc.start || (start >= 0 && end != tc.end))) {
+			t.Fatalf("parseRange(%q) = %d, %d, %v; want %d, %d, %v", tc.spec, start, end, ok, tc.start, tc.end, tc.ok)
+		}
+	}
+}
+
+func TestWithRange(t *testing.T) {
+	ent := Entry{Status: http.StatusOK, Header: http.Header{"Etag": {`"v1"`}, "Content-Type": {"video/mp4"}}, Body: []byte("0123456789")}
+	req := func(h map[string]string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://wait0.local/v", nil)
+		for k, v := range h {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	got := withRange(req(map[string]string{"Range": "bytes=2-5"}), ent)
+	if got.Status != http.StatusPartialContent || string(got.Body) != "2345" {
+		t.Fatalf("status=%d body=%q, want 206 2345", got.Status, got.Body)
+	}
+	if cr := got.Header.Get("Content-Range"); cr != "bytes 2-5/10" {
+		t.Fatalf("Content-Range = %q", cr)
+	}
+	if cl := got.Header.Get("Content-Length"); cl != "4" {
+		t.Fatalf("Content-Length = %q", cl)
+	}
+	if ent.Header.Get("Content-Range") != "" || ent.Header.Get("Accept-Ranges") != "" {
+		t.Fatal("cached entry header was mutated")
+	}
+
+	got = withRange(req(map[string]string{"Range": "bytes=20-"}), ent)
+	if got.Status != http.StatusRequestedRangeNotSatisfiable || len(got.Body) != 0 {
+		t.Fatalf("status=%d body=%q, want empty 416", got.Status, got.Body)
+	}
+	if cr := got.Header.Get("Content-Range"); cr != "bytes */10" {
+		t.Fatalf("416 Content-Range = %q", cr)
+	}
+
+	for name, h := range map[string]map[string]string{
+		"no range":         {},
+		"multi range":      {"Range": "bytes=0-1,4-5"},
+		"stale etag":       {"Range": "bytes=0-1", "If-Range": `"v0"`},
+		"weak etag":        {"Range": "bytes=0-1", "If-Range": `W/"v1"`},
+		"date without l-m": {"Range": "bytes=0-1", "If-Range": "Mon, 02 Jan 2006 15:04:05 GMT"},
+	} {
+		got := withRange(req(h), ent)
+		if got.Status != http.StatusOK || len(got.Body) != 10 {
+			t.Fatalf("%s: status=%d len=%d, want full 200", name, got.Status, len(got.Body))
+		}
+		if got.Header.Get("Accept-Ranges") != "bytes" {
+			t.Fatalf("%s: missing Accept-Ranges", name)
+		}
+	}
+
+	got = withRange(req(map[string]string{"Range": "bytes=0-1", "If-Range": `"v1"`}), ent)
+	if got.Status != http.StatusPartialContent {
+		t.Fatalf("matching If-Range: status = %d, want 206", got.Status)
+	}
+
+	lm := ent
+	lm.Header = http.Header{"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}
+	got = withRange(req(map[string]string{"Range": "bytes=0-1", "If-Range": "Mon, 02 Jan 2006 15:04:05 GMT"}), lm)
+	if got.Status != http.StatusPartialContent {
+		t.Fatalf("matching If-Range date: status = %d, want 206", got.Status)
+	}
+}
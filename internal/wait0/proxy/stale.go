@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// staleOnError returns the cached entry for key to serve in place of a failed
+// origin fetch, when the rule's StaleIfError window still covers it.
+func (c *Controller) staleOnError(key string, rule *Rule) (Entry, bool) {
+	if rule == nil || rule.StaleIfError <= 0 {
+		return Entry{}, false
+	}
+	if rule.UsesRAM() {
+		if ent, ok := c.rt.LoadRAM(key, time.Now().Unix()); ok && rule.ServableOnError(ent) {
+			return ent, true
+		}
+	}
+	if rule.UsesDisk() {
+		if ent, ok := c.rt.LoadDisk(key); ok && rule.ServableOnError(ent) {
+			return ent, true
+		}
+	}
+	return Entry{}, false
+}
+
+// serveStale answers with the stale entry for key if the origin failed and the
+// rule allows it, reporting whether it did.
+func (c *Controller) serveStale(w http.ResponseWriter, r *http.Request, rule *Rule, key string) bool {
+	ent, ok := c.staleOnError(key, rule)
+	if ok {
+		c.write(w, r, rule, key, ent, "stale-if-error")
+	}
+	return ok
+}
+
+// originFailed reports whether an origin response status is a failure that
+// stale-if-error covers.
+func originFailed(status int) bool {
+	return status >= 500
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+)
+
+const streamChunkSize = 32 * 1024
+
+// cappedBuffer collects up to max bytes and gives up once that is exceeded.
+type cappedBuffer struct {
+	buf bytes.Buffer
+	max int64
+	on  bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if !b.on {
+		return len(p), nil
+	}
+	if int64(b.buf.Len()+len(p)) > b.max {
+		b.on = false
+		b.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// streamMiss copies the origin response to the client as it arrives, flushing
+// after every chunk, while buffering up to rule.StreamBufferMax bytes. The
+// response is stored only when it completes cleanly within the cap. The
+// buffer holds the bytes exactly as origin sent them, so a gzip body decoded
+// for the client is still stored compressed. An origin failure is answered
+// from the cache when the rule's StaleIfError window allows.
+func (c *Controller) streamMiss(w http.ResponseWriter, r *http.Request, base, key string, rule *Rule) {
+	ent, cacheable, statusKind, body, err := c.rt.OpenFromOrigin(withoutConditionals(r))
+	if err != nil {
+		if !c.serveStale(w, r, rule, key) {
+			c.badGateway(w, r)
+		}
+		return
+	}
+	defer body.Close()
+
+	if statusKind == "ignore-by-status" && originFailed(ent.Status) && c.serveStale(w, r, rule, key) {
+		return
+	}
+
+	if statusKind == "ignore-by-status" {
+		c.rt.DeleteKey(key)
+	}
+	max := rule.StreamBufferMax
+	if limit := c.maxCacheable(rule); limit > 0 && limit < max {
+		max = limit
+	}
+	cacheable = cacheable && rule.allowsSetCookie(ent.Header)
+	captured := &cappedBuffer{max: max, on: cacheable && statusKind == "ok"}
+	raw := io.TeeReader(body, captured)
+
+	src := raw
+	head := ent
+	if needsDecode(r, ent) {
+		zr, err := gzip.NewReader(raw)
+		if err != nil {
+			c.badGateway(w, r)
+			return
+		}
+		defer zr.Close()
+		src = zr
+		head.Header = CloneHeader(ent.Header)
+		head.Header.Del("Content-Encoding")
+	}
+
+	c.setDebugHeaders(w.Header(), key, rule, Entry{}, "stream")
+	WriteHead(w, rule.withCacheControl(rewriteLocation(r, head, rule.RewriteLocation)), "stream")
+	c.rt.ObserveOutcome(r.URL.Path, "stream")
+	if copyFlushing(w, src) != nil {
+		return
+	}
+	if src != raw {
+		// The gzip reader can stop before the origin body is fully drained.
+		if _, err := io.Copy(io.Discard, raw); err != nil {
+			return
+		}
+	}
+
+	if !captured.on {
+		return
+	}
+	ent.Body = captured.buf.Bytes()
+	ent.Hash32 = crc32.ChecksumIEEE(ent.Body)
+	c.rt.Store(c.learnVary(r, base, ent.Header), withoutSetCookie(ent), rule.TierName())
+}
+
+// streamTooLarge sends a response over MaxCacheableBytes: the part fetch
+// already read, then the rest as it arrives from origin. Nothing is cached.
+func (c *Controller) streamTooLarge(w http.ResponseWriter, r *http.Request, rule *Rule, key string, res fetchResult) {
+	defer res.rest.Close()
+	var rw *LocationRewrite
+	if rule != nil {
+		rw = rule.RewriteLocation
+	}
+
+	head := res.ent
+	head.Body = nil
+	var src io.Reader = io.MultiReader(bytes.NewReader(res.ent.Body), res.rest)
+	if needsDecode(r, res.ent) {
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			c.badGateway(w, r)
+			return
+		}
+		defer zr.Close()
+		src = zr
+		head.Header = CloneHeader(res.ent.Header)
+		head.Header.Del("Content-Encoding")
+	}
+
+	c.setDebugHeaders(w.Header(), key, rule, Entry{}, "bypass-too-large")
+	WriteHead(w, rule.withCacheControl(rewriteLocation(r, head, rw)), "bypass-too-large")
+	c.rt.ObserveOutcome(r.URL.Path, "bypass-too-large")
+	_ = copyFlushing(w, src)
+}
+
+// copyFlushing copies src to w in chunks, flushing after each one so the
+// client sees bytes as they arrive. It returns the first read or write error
+// other than io.EOF.
+func copyFlushing(w http.ResponseWriter, src io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	chunk := make([]byte, streamChunkSize)
+	for {
+		n, rerr := src.Read(chunk)
+		if n > 0 {
+			if _, werr := w.Write(chunk[:n]); werr != nil {
+				return werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if rerr != nil {
+			if errors.Is(rerr, io.EOF) {
+				return nil
+			}
+			return rerr
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingReader struct {
+	data []byte
+	done bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, errors.New("connection reset")
+	}
+	r.done = true
+	return copy(p, r.data), nil
+}
+
+func (r *failingReader) Close() error { return nil }
+
+func TestController_StreamMiss_CachesWithinCap(t *testing.T) {
+	rt := &fakeRuntime{
+		rule:            &Rule{Streamable: true, StreamBufferMax: 64},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("{\"a\":1}\n{\"b\":2}\n")},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/feed", nil)
+
+	c.Handle(w, r)
+
+	if got := w.Result().Header.Get("X-Wait0"); got != "stream" {
+		t.Fatalf("X-Wait0 = %q, want stream", got)
+	}
+	if w.Body.String() != "{\"a\":1}\n{\"b\":2}\n" {
+		t.Fatalf("body = %q", w.Body.String())
+	}
+	if len(rt.storedEnts) != 1 || string(rt.storedEnts[0].Body) != w.Body.String() {
+		t.Fatalf("stored = %+v", rt.storedEnts)
+	}
+	if rt.storedEnts[0].Hash32 == 0 {
+		t.Fatalf("expected hash on stored entry")
+	}
+}
+
+func TestController_StreamMiss_OverCapStreamsWithoutCaching(t *testing.T) {
+	body := strings.Repeat("x", 200)
+	rt := &fakeRuntime{
+		rule:            &Rule{Streamable: true, StreamBufferMax: 64},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte(body)},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/feed", nil)
+
+	c.Handle(w, r)
+
+	if w.Body.String() != body {
+		t.Fatalf("body len = %d, want %d", w.Body.Len(), len(body))
+	}
+	if len(rt.stored) != 0 {
+		t.Fatalf("stored = %v, want none", rt.stored)
+	}
+}
+
+func TestController_StreamMiss_ReadErrorIsNotCached(t *testing.T) {
+	rt := &fakeRuntime{
+		rule:            &Rule{Streamable: true, StreamBufferMax: 64},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}},
+		originCacheable: true,
+		originStatus:    "ok",
+		originStream:    &failingReader{data: []byte("partial")},
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/feed", nil)
+
+	c.Handle(w, r)
+
+	if w.Body.String() != "partial" {
+		t.Fatalf("body = %q", w.Body.String())
+	}
+	if len(rt.stored) != 0 {
+		t.Fatalf("stored = %v, want none", rt.stored)
+	}
+}
+
+func TestController_StreamMiss_OriginError(t *testing.T) {
+	rt := &fakeRuntime{
+		rule:      &Rule{Streamable: true, StreamBufferMax: 64},
+		originErr: errors.New("dial"),
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/feed", nil)
+
+	c.Handle(w, r)
+
+	if len(rt.outcomes) != 1 || rt.outcomes[0] != "/feed bad-gateway" {
+		t.Fatalf("outcomes = %v", rt.outcomes)
+	}
+	if w.Result().StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", w.Result().StatusCode)
+	}
+}
+
+func TestController_StreamMiss_StaleIfError(t *testing.T) {
+	old := Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("old"), StoredAt: time.Now().Add(-20 * time.Minute).Unix()}
+	rt := &fakeRuntime{
+		rule:         &Rule{Streamable: true, StreamBufferMax: 64, MaxAge: 10 * time.Minute, StaleIfError: time.Hour},
+		ramEnt:       old,
+		ramOK:        true,
+		originEnt:    Entry{Status: http.StatusBadGateway, Header: http.Header{}, Body: []byte("down")},
+		originStatus: "ignore-by-status",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/feed", nil))
+
+	if w.Body.String() != "old" || w.Header().Get("X-Wait0") != "stale-if-error" {
+		t.Fatalf("body=%q X-Wait0=%q, want stale entry", w.Body.String(), w.Header().Get("X-Wait0"))
+	}
+	if len(rt.deleted) != 0 {
+		t.Fatalf("deleted = %v, want entry kept", rt.deleted)
+	}
+
+	rt.originErr = errors.New("dial")
+	w = httptest.NewRecorder()
+	c.Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/feed", nil))
+	if w.Header().Get("X-Wait0") != "stale-if-error" {
+		t.Fatalf("X-Wait0 = %q on origin error, want stale-if-error", w.Header().Get("X-Wait0"))
+	}
+}
+
+func TestController_MaxCacheable_StreamsOversizedMiss(t *testing.T) {
+	body := strings.Repeat("x", 200)
+	rt := &fakeRuntime{
+		maxCacheable:    64,
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{"X-Origin": {"1"}}, Body: []byte(body)},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/big.iso", nil)
+
+	c.Handle(w, r)
+
+	if got := w.Result().Header.Get("X-Wait0"); got != "bypass-too-large" {
+		t.Fatalf("X-Wait0 = %q, want bypass-too-large", got)
+	}
+	if w.Result().Header.Get("X-Origin") != "1" {
+		t.Fatalf("origin headers not passed on")
+	}
+	if w.Body.String() != body {
+		t.Fatalf("body len = %d, want %d", w.Body.Len(), len(body))
+	}
+	if len(rt.stored) != 0 || len(rt.fetched) != 0 {
+		t.Fatalf("stored = %v, fetched = %v, want neither", rt.stored, rt.fetched)
+	}
+	if len(rt.outcomes) != 1 || rt.outcomes[0] != "/big.iso bypass-too-large" {
+		t.Fatalf("outcomes = %v", rt.outcomes)
+	}
+}
+
+func TestController_RuleMaxBodyBytes(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	for _, tc := range []struct {
+		name         string
+		global, rule int64
+		want         string
+	}{
+		{name: "rule cap only", rule: 64, want: "bypass-too-large"},
+		{name: "rule cap below global", global: 1024, rule: 64, want: "bypass-too-large"},
+		{name: "global cap below rule", global: 64, rule: 1024, want: "bypass-too-large"},
+		{name: "within rule cap", rule: 1024, want: "miss"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &fakeRuntime{
+				rule:            &Rule{MaxBodyBytes: tc.rule},
+				maxCacheable:    tc.global,
+				originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte(body)},
+				originCacheable: true,
+				originStatus:    "ok",
+			}
+			w := httptest.NewRecorder()
+			NewController(rt).Handle(w, httptest.NewRequest(http.MethodGet, "http://wait0.local/asset", nil))
+
+			if got := w.Header().Get("X-Wait0"); got != tc.want || w.Body.String() != body {
+				t.Fatalf("X-Wait0 = %q body len = %d, want %s with the full body", got, w.Body.Len(), tc.want)
+			}
+			if cached := len(rt.stored) == 1; cached != (tc.want == "miss") {
+				t.Fatalf("stored = %v", rt.stored)
+			}
+		})
+	}
+}
+
+func TestController_MaxCacheable_CachesWithinCap(t *testing.T) {
+	rt := &fakeRuntime{
+		maxCacheable:    64,
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("small")},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/small", nil)
+
+	c.Handle(w, r)
+
+	if got := w.Result().Header.Get("X-Wait0"); got != "miss" {
+		t.Fatalf("X-Wait0 = %q, want miss", got)
+	}
+	if len(rt.storedEnts) != 1 || string(rt.storedEnts[0].Body) != "small" || rt.storedEnts[0].Hash32 == 0 {
+		t.Fatalf("stored = %+v", rt.storedEnts)
+	}
+}
+
+type truncatedReader struct{ failingReader }
+
+func (r *truncatedReader) Read(p []byte) (int, error) {
+	n, err := r.failingReader.Read(p)
+	if err != nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestController_MaxCacheable_TruncatedBodyIsNotCached(t *testing.T) {
+	rt := &fakeRuntime{
+		maxCacheable:    64,
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}},
+		originCacheable: true,
+		originStatus:    "ok",
+		originStream:    &truncatedReader{failingReader{data: []byte("part")}},
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/cut", nil)
+
+	c.Handle(w, r)
+
+	if got := w.Result().Header.Get("X-Wait0"); got != "bypass" {
+		t.Fatalf("X-Wait0 = %q, want bypass", got)
+	}
+	if w.Body.String() != "part" {
+		t.Fatalf("body = %q", w.Body.String())
+	}
+	if len(rt.stored) != 0 {
+		t.Fatalf("stored = %v, want none", rt.stored)
+	}
+}
+
+func TestController_MaxCacheable_ReadErrorIsBadGateway(t *testing.T) {
+	rt := &fakeRuntime{
+		maxCacheable:    64,
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}},
+		originCacheable: true,
+		originStatus:    "ok",
+		originStream:    &failingReader{data: []byte("part")},
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/reset", nil)
+
+	c.Handle(w, r)
+
+	if w.Result().StatusCode != http.StatusBadGateway || len(rt.stored) != 0 {
+		t.Fatalf("status = %d, stored = %v", w.Result().StatusCode, rt.stored)
+	}
+}
+
+func TestController_MaxCacheable_DecodesOversizedGzip(t *testing.T) {
+	plain := strings.Repeat("hello ", 100)
+	var zb bytes.Buffer
+	zw := gzip.NewWriter(&zb)
+	_, _ = zw.Write([]byte(plain))
+	_ = zw.Close()
+	rt := &fakeRuntime{
+		maxCacheable:    8,
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{"Content-Encoding": {"gzip"}}, Body: zb.Bytes()},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/big", nil)
+
+	c.Handle(w, r)
+
+	if w.Body.String() != plain {
+		t.Fatalf("body len = %d, want decoded %d", w.Body.Len(), len(plain))
+	}
+	if w.Result().Header.Get("Content-Encoding") != "" {
+		t.Fatalf("Content-Encoding should be dropped for a decoded body")
+	}
+}
+
+func TestController_MaxCacheable_CapsStreamBuffer(t *testing.T) {
+	rt := &fakeRuntime{
+		maxCacheable:    8,
+		rule:            &Rule{Streamable: true, StreamBufferMax: 64},
+		originEnt:       Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte(strings.Repeat("y", 20))},
+		originCacheable: true,
+		originStatus:    "ok",
+	}
+	c := NewController(rt)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/feed", nil)
+
+	c.Handle(w, r)
+
+	if w.Body.Len() != 20 || len(rt.stored) != 0 {
+		t.Fatalf("body len = %d, stored = %v", w.Body.Len(), rt.stored)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/freshness"
 )
 
 type Entry struct {
@@ -19,12 +22,113 @@ type Entry struct {
 
 	RevalidatedAt int64
 	RevalidatedBy string
+
+	// MaxAge is the origin freshness lifetime in seconds left at StoredAt,
+	// net of any upstream Age (see freshness.FromHeader).
+	MaxAge int64
+	// ETag is the origin's validator, sent as If-None-Match on revalidation.
+	ETag string
+	// LastModified is the origin's validator, sent as If-Modified-Since on
+	// revalidation.
+	LastModified string
 }
 
+// Cache tiers a rule can restrict lookups and stores to.
+const (
+	TierBoth = "both"
+	TierRAM  = "ram"
+	TierDisk = "disk"
+)
+
 type Rule struct {
+	// Index is the rule's position in the priority-ordered rule list and
+	// Priority its configured priority; Index is -1 for the default rule
+	// applied to paths no configured rule matches.
+	Index             int
+	Priority          int
 	Bypass            bool
 	BypassWhenCookies []string
+	BypassWhenHeaders []string
+	// Expiration is the rule's own freshness lifetime. It takes precedence
+	// over the origin's max-age; DefaultExpiration applies when neither is set.
 	Expiration        time.Duration
+	DefaultExpiration time.Duration
+	// ExpirationByStatus overrides the freshness lifetime for entries with a
+	// given response status.
+	ExpirationByStatus map[int]time.Duration
+	// NegativeTTL caches non-2xx responses for the given time, keyed by
+	// status or by class digit (4 for all 4xx). Cached error responses are
+	// served until they are that old, then refetched.
+	NegativeTTL map[int]time.Duration
+	// MaxAge, when set, is a hard ceiling: entries older than it are not
+	// served and are refetched from origin instead.
+	MaxAge time.Duration
+	// StaleIfError serves a cached entry in place of an origin failure, a
+	// network error or 5xx, for up to this long after the entry expires.
+	StaleIfError time.Duration
+	// Tier is one of TierBoth, TierRAM or TierDisk. Empty means TierBoth.
+	Tier string
+
+	// Streamable misses are streamed to the client while being buffered; the
+	// response is cached only if it completes within StreamBufferMax bytes.
+	Streamable      bool
+	StreamBufferMax int64
+
+	// VaryBy lists request headers folded into the cache key.
+	VaryBy []string
+	// IgnoreQuery leaves the query string out of the cache key, so all query
+	// variants of a path share one entry.
+	IgnoreQuery bool
+	// KeyQuery, when set, lists the only query parameters kept in the cache
+	// key and in cache-fill requests to origin; others are stripped.
+	KeyQuery []string
+
+	// RewriteLocation, when set, points 3xx Location headers aimed at the
+	// origin host back at the public host.
+	RewriteLocation *LocationRewrite
+
+	// ResponseCacheControl, when set, replaces the origin's Cache-Control on
+	// responses served to clients. The stored entry keeps the origin value.
+	ResponseCacheControl string
+
+	// CacheOptions caches OPTIONS responses, keyed by path and the CORS
+	// preflight request headers, instead of passing them through.
+	CacheOptions bool
+
+	// CachePost caches POST responses keyed by a hash of the request body,
+	// for APIs that use POST for idempotent queries. Bodies over
+	// CacheBodyMax bypass the cache.
+	CachePost    bool
+	CacheBodyMax int64
+
+	// MaxBodyBytes caps the body buffered on a miss for this rule, like
+	// MaxCacheableBytes; the smaller of the two applies. Zero means no
+	// rule cap.
+	MaxBodyBytes int64
+
+	// CacheWithSetCookie lets responses carrying Set-Cookie be cached. The
+	// cookies are stripped from the stored entry either way.
+	CacheWithSetCookie bool
+}
+
+// UsesRAM reports whether lookups and stores for the rule consult RAM.
+// A nil rule uses both tiers.
+func (r *Rule) UsesRAM() bool {
+	return r == nil || r.Tier != TierDisk
+}
+
+// UsesDisk reports whether lookups and stores for the rule consult disk.
+// A nil rule uses both tiers.
+func (r *Rule) UsesDisk() bool {
+	return r == nil || r.Tier != TierRAM
+}
+
+// TierName returns the normalized tier for the rule.
+func (r *Rule) TierName() string {
+	if r == nil || r.Tier == "" {
+		return TierBoth
+	}
+	return r.Tier
 }
 
 func IsStale(ent Entry, exp time.Duration) bool {
@@ -32,6 +136,114 @@ func IsStale(ent Entry, exp time.Duration) bool {
 	return time.Since(stored) > exp
 }
 
+// withCacheControl returns ent with the rule's ResponseCacheControl applied.
+func (r *Rule) withCacheControl(ent Entry) Entry {
+	if r == nil || r.ResponseCacheControl == "" {
+		return ent
+	}
+	ent.Header = CloneHeader(ent.Header)
+	ent.Header.Set("Cache-Control", r.ResponseCacheControl)
+	return ent
+}
+
+// allowsSetCookie reports whether a response with header h may be cached
+// under the rule. Set-Cookie is almost always user-specific, so it makes a
+// response non-cacheable unless the rule opts in.
+func (r *Rule) allowsSetCookie(h http.Header) bool {
+	return (r != nil && r.CacheWithSetCookie) || len(h.Values("Set-Cookie")) == 0
+}
+
+// withoutSetCookie returns ent without its Set-Cookie headers, so a stored
+// entry never replays one client's cookies to another.
+func withoutSetCookie(ent Entry) Entry {
+	if len(ent.Header.Values("Set-Cookie")) == 0 {
+		return ent
+	}
+	ent.Header = CloneHeader(ent.Header)
+	ent.Header.Del("Set-Cookie")
+	return ent
+}
+
+// staleOnArrival is the freshness of entries whose origin lifetime had already
+// run out when fetched; any stored entry is older than it.
+const staleOnArrival = time.Nanosecond
+
+// Freshness returns how long ent stays fresh under the rule: the negative
+// cache TTL for error responses, else the ExpirationByStatus entry for its
+// status, else Expiration, else the origin max-age recorded on ent, else
+// DefaultExpiration. Zero means ent never goes stale. A nil rule uses the
+// origin max-age only.
+func (r *Rule) Freshness(ent Entry) time.Duration {
+	if d, ok := r.negativeTTL(ent.Status); ok {
+		return d
+	}
+	if r != nil {
+		if d, ok := r.ExpirationByStatus[ent.Status]; ok {
+			return d
+		}
+	}
+	if r != nil && r.Expiration > 0 {
+		return r.Expiration
+	}
+	if ent.MaxAge == freshness.Stale {
+		return staleOnArrival
+	}
+	if ent.MaxAge > 0 {
+		return time.Duration(ent.MaxAge) * time.Second
+	}
+	if r != nil {
+		return r.DefaultExpiration
+	}
+	return 0
+}
+
+// TooOld reports whether ent is past the rule's MaxAge ceiling, or is an
+// error response past its negative cache TTL.
+func (r *Rule) TooOld(ent Entry) bool {
+	if ttl, ok := r.negativeTTL(ent.Status); ok && IsStale(ent, ttl) {
+		return true
+	}
+	return r != nil && r.MaxAge > 0 && IsStale(ent, r.MaxAge)
+}
+
+// ServableOnError reports whether ent may be served in place of an origin
+// failure under the rule: it is an active 2xx entry no older than its expiry
+// plus StaleIfError. The expiry is the entry's freshness lifetime, or the
+// MaxAge ceiling when that comes first.
+func (r *Rule) ServableOnError(ent Entry) bool {
+	if r == nil || r.StaleIfError <= 0 || ent.Inactive || ent.Status < 200 || ent.Status >= 300 {
+		return false
+	}
+	exp := r.Freshness(ent)
+	if r.MaxAge > 0 && (exp == 0 || r.MaxAge < exp) {
+		exp = r.MaxAge
+	}
+	return exp == 0 || !IsStale(ent, exp+r.StaleIfError)
+}
+
+// negativeTTL returns how long an error response with status is cached: the
+// exact status entry, else its class entry.
+func (r *Rule) negativeTTL(status int) (time.Duration, bool) {
+	if r == nil || len(r.NegativeTTL) == 0 || (status >= 200 && status < 300) {
+		return 0, false
+	}
+	if d, ok := r.NegativeTTL[status]; ok {
+		return d, true
+	}
+	d, ok := r.NegativeTTL[status/100]
+	return d, ok
+}
+
+// negativeCacheable reports whether the error response ent may be stored
+// under the rule's negative cache.
+func (r *Rule) negativeCacheable(ent Entry) bool {
+	if _, ok := r.negativeTTL(ent.Status); !ok {
+		return false
+	}
+	_, varyAny := cachekey.ResponseVary(ent.Header)
+	return freshness.ParseCacheControl(ent.Header).Storable() && !varyAny && r.allowsSetCookie(ent.Header)
+}
+
 func HasAnyCookie(r *http.Request, names []string) bool {
 	if len(names) == 0 {
 		return false
@@ -50,3 +262,14 @@ func HasAnyCookie(r *http.Request, names []string) bool {
 	}
 	return false
 }
+
+// HasAnyHeader reports whether r carries a non-empty value for any of names.
+func HasAnyHeader(r *http.Request, names []string) bool {
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n != "" && r.Header.Get(n) != "" {
+			return true
+		}
+	}
+	return false
+}
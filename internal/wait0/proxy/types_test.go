@@ -5,6 +5,8 @@ import (
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"wait0/internal/wait0/freshness"
 )
 
 func TestIsStale(t *testing.T) {
@@ -38,6 +40,120 @@ func TestIsStale(t *testing.T) {
 	}
 }
 
+func TestRule_TooOld(t *testing.T) {
+	old := Entry{StoredAt: time.Now().Add(-time.Hour).Unix()}
+	fresh := Entry{StoredAt: time.Now().Unix()}
+
+	var nilRule *Rule
+	if nilRule.TooOld(old) {
+		t.Fatalf("nil rule must not cap age")
+	}
+	if (&Rule{}).TooOld(old) {
+		t.Fatalf("rule without maxAge must not cap age")
+	}
+	r := &Rule{MaxAge: 10 * time.Minute}
+	if !r.TooOld(old) || r.TooOld(fresh) {
+		t.Fatalf("TooOld old=%v fresh=%v, want true/false", r.TooOld(old), r.TooOld(fresh))
+	}
+}
+
+func TestRule_ServableOnError(t *testing.T) {
+	at := func(age time.Duration) Entry {
+		return Entry{Status: http.StatusOK, StoredAt: time.Now().Add(-age).Unix()}
+	}
+	tests := []struct {
+		name string
+		rule *Rule
+		ent  Entry
+		want bool
+	}{
+		{name: "nil rule", rule: nil, ent: at(time.Minute), want: false},
+		{name: "no window", rule: &Rule{Expiration: time.Minute}, ent: at(2 * time.Minute), want: false},
+		{name: "within window past expiry", rule: &Rule{Expiration: time.Minute, StaleIfError: time.Hour}, ent: at(30 * time.Minute), want: true},
+		{name: "past window", rule: &Rule{Expiration: time.Minute, StaleIfError: time.Hour}, ent: at(2 * time.Hour), want: false},
+		{name: "maxAge ends freshness first", rule: &Rule{Expiration: time.Hour, MaxAge: time.Minute, StaleIfError: 10 * time.Minute}, ent: at(30 * time.Minute), want: false},
+		{name: "never expires", rule: &Rule{StaleIfError: time.Minute}, ent: at(48 * time.Hour), want: true},
+		{name: "inactive seed", rule: &Rule{StaleIfError: time.Hour}, ent: Entry{Status: http.StatusOK, Inactive: true}, want: false},
+		{name: "cached error", rule: &Rule{StaleIfError: time.Hour}, ent: Entry{Status: http.StatusNotFound, StoredAt: time.Now().Unix()}, want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.rule.ServableOnError(tc.ent); got != tc.want {
+				t.Fatalf("ServableOnError = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRule_NegativeTTL(t *testing.T) {
+	r := &Rule{NegativeTTL: map[int]time.Duration{404: 30 * time.Second, 5: 5 * time.Second}}
+	for _, tc := range []struct {
+		status int
+		want   time.Duration
+		ok     bool
+	}{
+		{status: 404, want: 30 * time.Second, ok: true},
+		{status: 503, want: 5 * time.Second, ok: true},
+		{status: 410},
+		{status: 200},
+	} {
+		if got, ok := r.negativeTTL(tc.status); got != tc.want || ok != tc.ok {
+			t.Fatalf("negativeTTL(%d) = %s/%v, want %s/%v", tc.status, got, ok, tc.want, tc.ok)
+		}
+	}
+	if r.Freshness(Entry{Status: 503, MaxAge: 600}) != 5*time.Second {
+		t.Fatalf("503 freshness must follow the negative TTL")
+	}
+
+	old := Entry{Status: 503, StoredAt: time.Now().Add(-10 * time.Second).Unix()}
+	if !r.TooOld(old) || r.TooOld(Entry{Status: 503, StoredAt: time.Now().Unix()}) {
+		t.Fatalf("negative entries must be refetched once past their TTL")
+	}
+	if r.negativeCacheable(Entry{Status: 404, Header: http.Header{"Cache-Control": {"no-store"}}}) {
+		t.Fatalf("no-store error responses must not be negatively cached")
+	}
+	if !r.negativeCacheable(Entry{Status: 404, Header: http.Header{}}) {
+		t.Fatalf("404 should be negatively cacheable")
+	}
+}
+
+func TestRule_Freshness(t *testing.T) {
+	withMaxAge := Entry{MaxAge: 120}
+	var nilRule *Rule
+	if got := nilRule.Freshness(withMaxAge); got != 2*time.Minute {
+		t.Fatalf("nil rule freshness = %s, want origin max-age", got)
+	}
+	if got := nilRule.Freshness(Entry{}); got != 0 {
+		t.Fatalf("nil rule without max-age = %s, want 0", got)
+	}
+	explicit := &Rule{Expiration: time.Hour, DefaultExpiration: time.Minute}
+	if got := explicit.Freshness(withMaxAge); got != time.Hour {
+		t.Fatalf("rule expiration must override max-age, got %s", got)
+	}
+	inherited := &Rule{DefaultExpiration: time.Minute}
+	if got := inherited.Freshness(withMaxAge); got != 2*time.Minute {
+		t.Fatalf("max-age must override default expiration, got %s", got)
+	}
+	if got := inherited.Freshness(Entry{}); got != time.Minute {
+		t.Fatalf("default expiration fallback = %s", got)
+	}
+	staleEnt := Entry{StoredAt: time.Now().Unix(), MaxAge: freshness.Stale}
+	if got := inherited.Freshness(staleEnt); got <= 0 || !IsStale(staleEnt, got) {
+		t.Fatalf("stale-on-arrival freshness = %s, want immediately stale", got)
+	}
+
+	byStatus := &Rule{Expiration: time.Hour, ExpirationByStatus: map[int]time.Duration{404: 30 * time.Second, 301: 24 * time.Hour}}
+	if got := byStatus.Freshness(Entry{Status: 404, MaxAge: 600}); got != 30*time.Second {
+		t.Fatalf("404 freshness = %s, want 30s", got)
+	}
+	if got := byStatus.Freshness(Entry{Status: 301}); got != 24*time.Hour {
+		t.Fatalf("301 freshness = %s, want 24h", got)
+	}
+	if got := byStatus.Freshness(Entry{Status: 200}); got != time.Hour {
+		t.Fatalf("unlisted status freshness = %s, want rule expiration", got)
+	}
+}
+
 func TestHasAnyCookie(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -62,3 +178,68 @@ func TestHasAnyCookie(t *testing.T) {
 		})
 	}
 }
+
+func TestHasAnyHeader(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wait0.local", nil)
+	r.Header.Set("X-Debug", "1")
+	r.Header.Set("Authorization", "")
+
+	if !HasAnyHeader(r, []string{"authorization", " x-debug "}) {
+		t.Fatalf("expected X-Debug to match case-insensitively")
+	}
+	if HasAnyHeader(r, []string{"Authorization", ""}) {
+		t.Fatalf("empty header value should not trigger bypass")
+	}
+	if HasAnyHeader(r, nil) {
+		t.Fatalf("no names should not match")
+	}
+}
+
+func TestCacheKey_VaryBy(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/api", nil)
+	if got := CacheKey(r, nil, "", ""); got != "/api" {
+		t.Fatalf("nil rule key = %q", got)
+	}
+	if got := CacheKey(r, &Rule{VaryBy: []string{"Accept"}}, "", ""); got != "/api" {
+		t.Fatalf("missing header key = %q", got)
+	}
+	r.Header.Set("Accept", "application/json")
+	if got := CacheKey(r, &Rule{VaryBy: []string{"Accept"}}, "", ""); got != "/api#Accept=application%2Fjson" {
+		t.Fatalf("vary key = %q", got)
+	}
+}
+
+func TestCacheKey_Query(t *testing.T) {
+	cats := httptest.NewRequest(http.MethodGet, "http://wait0.local/search?q=cats&page=2", nil)
+	dogs := httptest.NewRequest(http.MethodGet, "http://wait0.local/search?q=dogs", nil)
+	if CacheKey(cats, nil, "", "") == CacheKey(dogs, nil, "", "") {
+		t.Fatalf("different queries must not share a key")
+	}
+	reordered := httptest.NewRequest(http.MethodGet, "http://wait0.local/search?page=2&q=cats", nil)
+	if got, want := CacheKey(reordered, nil, "", ""), CacheKey(cats, nil, "", ""); got != want {
+		t.Fatalf("reordered key = %q, want %q", got, want)
+	}
+	if got := CacheKey(cats, nil, "", ""); got != "/search#%40q=page%3D2%26q%3Dcats" {
+		t.Fatalf("query key = %q", got)
+	}
+	if got := CacheKey(cats, &Rule{IgnoreQuery: true}, "", ""); got != "/search" {
+		t.Fatalf("ignoreQuery key = %q", got)
+	}
+	if got := CacheKey(cats, &Rule{KeyQuery: []string{"page"}}, "", ""); got != "/search#%40q=page%3D2" {
+		t.Fatalf("keyQuery key = %q", got)
+	}
+}
+
+func TestCacheKey_Host(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://acme.example.com/api", nil)
+	if got := CacheKey(r, nil, "acme", ""); got != "/api#%40host=acme" {
+		t.Fatalf("host key = %q", got)
+	}
+}
+
+func TestCacheKey_Version(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://wait0.local/api", nil)
+	if got := CacheKey(r, nil, "", "v2"); got != "/api#%40v=v2" {
+		t.Fatalf("version key = %q", got)
+	}
+}
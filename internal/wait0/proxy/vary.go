@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+
+	"wait0/internal/wait0/cachekey"
+)
+
+// maxVaries caps how many paths' learned Vary headers are kept. Past it an
+// arbitrary one is forgotten; its next miss fetches the base key again and
+// relearns it from the response.
+const maxVaries = 4096
+
+// varyKey is what learned Vary headers are kept under: the path, host,
+// version and method of a key, without its query, body hash or vary values,
+// so requests that differ only in those share one record instead of growing
+// the map per distinct query string.
+func varyKey(key string) string {
+	p := cachekey.Parse(key)
+	return cachekey.Parts{Path: p.Path, Host: p.Host, Version: p.Version, Method: p.Method}.String()
+}
+
+// variant returns the key r's variant of base is cached under, using the
+// Vary headers learned for base's path, or base when none are known.
+func (c *Controller) variant(r *http.Request, base string) string {
+	c.variesMu.Lock()
+	names, ok := c.varies[varyKey(base)]
+	c.variesMu.Unlock()
+	if !ok {
+		return base
+	}
+	return cachekey.WithVary(base, r.Header, names)
+}
+
+// learnVary records the headers an origin response for base varies on and
+// returns the key of r's variant. Responses without a Vary header leave what
+// was learned in place and map to base.
+func (c *Controller) learnVary(r *http.Request, base string, h http.Header) string {
+	names, _ := cachekey.ResponseVary(h)
+	if len(names) == 0 {
+		return base
+	}
+	vk := varyKey(base)
+	c.variesMu.Lock()
+	if c.varies == nil {
+		c.varies = map[string][]string{}
+	}
+	if _, ok := c.varies[vk]; !ok && len(c.varies) >= maxVaries {
+		for k := range c.varies {
+			delete(c.varies, k)
+			break
+		}
+	}
+	c.varies[vk] = names
+	c.variesMu.Unlock()
+	return cachekey.WithVary(base, r.Header, names)
+}
+
+// ForgetVary drops the Vary headers learned for key's path, for callers that
+// purge key from the cache.
+func (c *Controller) ForgetVary(key string) {
+	c.variesMu.Lock()
+	delete(c.varies, varyKey(key))
+	c.variesMu.Unlock()
+}
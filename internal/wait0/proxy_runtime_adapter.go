@@ -1,12 +1,15 @@
 package wait0
 
 import (
+	"context"
+	"io"
 	"net/http"
 
 	"wait0/internal/wait0/dashboard"
 	"wait0/internal/wait0/invalidation"
 	"wait0/internal/wait0/proxy"
 	"wait0/internal/wait0/statapi"
+	wstats "wait0/internal/wait0/stats"
 )
 
 type proxyRuntimeAdapter struct {
@@ -19,13 +22,23 @@ func newProxyRuntimeAdapter(s *Service) proxy.Runtime {
 	return &proxyRuntimeAdapter{
 		s: s,
 		fetcher: proxy.Fetcher{
-			Client: s.httpClient,
-			Origin: s.cfg.Server.Origin,
+			Client:              s.httpClient,
+			Origin:              s.config().Server.Origin,
+			AcceptEncoding:      s.config().Server.Upstream.AcceptEncoding,
+			MaxHeaderValueBytes: s.config().Server.Upstream.maxHeaderValueBytes,
+			Logger:              s.errorLog,
 		},
 	}
 }
 
 func (a *proxyRuntimeAdapter) HandleControl(w http.ResponseWriter, r *http.Request) bool {
+	if hp := a.s.config().Server.HealthPath; hp != "" && r.URL.Path == hp {
+		a.s.handleHealth(w)
+		return true
+	}
+	if isAdminPath(r.URL.Path) && !a.s.gateAdmin(w, r) {
+		return true
+	}
 	switch r.URL.Path {
 	case invalidation.EndpointPath:
 		if a.s.inv == nil {
@@ -34,6 +47,41 @@ func (a *proxyRuntimeAdapter) HandleControl(w http.ResponseWriter, r *http.Reque
 			a.s.inv.Handle(w, r)
 		}
 		return true
+	case invalidation.StaleEndpointPath:
+		if a.s.inv == nil {
+			http.NotFound(w, r)
+		} else {
+			a.s.inv.HandleStale(w, r)
+		}
+		return true
+	case invalidation.PurgeEndpointPath:
+		if a.s.inv == nil {
+			http.NotFound(w, r)
+		} else {
+			a.s.inv.HandlePurge(w, r)
+		}
+		return true
+	case invalidation.WarmEndpointPath:
+		if a.s.inv == nil {
+			http.NotFound(w, r)
+		} else {
+			a.s.inv.HandleWarm(w, r)
+		}
+		return true
+	case statapi.MetricsEndpointPath:
+		if a.s.stat == nil {
+			http.NotFound(w, r)
+		} else {
+			a.s.stat.HandleMetrics(w, r)
+		}
+		return true
+	case statapi.LiveEndpointPath:
+		if a.s.stat == nil {
+			http.NotFound(w, r)
+		} else {
+			a.s.stat.HandleLive(w, r)
+		}
+		return true
 	case statapi.EndpointPath, statapi.EndpointPath + "/":
 		if a.s.stat == nil {
 			http.NotFound(w, r)
@@ -54,15 +102,69 @@ func (a *proxyRuntimeAdapter) HandleControl(w http.ResponseWriter, r *http.Reque
 }
 
 func (a *proxyRuntimeAdapter) PickRule(path string) *proxy.Rule {
-	r := a.s.pickRule(path)
-	if r == nil {
+	cfg := a.s.config()
+	i := cfg.ruleIndex(path)
+	if i < 0 {
+		if d := cfg.Storage.defaultExpDur; d > 0 {
+			return &proxy.Rule{Index: -1, DefaultExpiration: d}
+		}
 		return nil
 	}
-	return &proxy.Rule{
-		Bypass:            r.Bypass,
-		BypassWhenCookies: append([]string(nil), r.BypassWhenCookies...),
-		Expiration:        r.expDur,
+	r := &cfg.Rules[i]
+	var rw *proxy.LocationRewrite
+	if r.RewriteLocation {
+		rw = &proxy.LocationRewrite{Origin: cfg.originFor(path), PublicHost: cfg.Server.PublicHost}
+	}
+	pr := &proxy.Rule{
+		Index:                i,
+		Priority:             r.Priority,
+		Bypass:               r.Bypass,
+		BypassWhenCookies:    append([]string(nil), r.BypassWhenCookies...),
+		BypassWhenHeaders:    append([]string(nil), r.BypassWhenHeaders...),
+		MaxAge:               r.maxAgeDur,
+		StaleIfError:         r.staleErrDur,
+		ExpirationByStatus:   r.expByStatus,
+		NegativeTTL:          r.negTTL,
+		Tier:                 r.tier,
+		Streamable:           r.Streamable,
+		StreamBufferMax:      r.streamMax,
+		VaryBy:               append([]string(nil), r.varyBy...),
+		IgnoreQuery:          r.IgnoreQuery,
+		KeyQuery:             append([]string(nil), r.keyQuery...),
+		RewriteLocation:      rw,
+		ResponseCacheControl: r.ResponseCacheControl,
+		CacheWithSetCookie:   r.CacheWithSetCookie,
+		CacheOptions:         r.CacheOptions,
+		CachePost:            r.cachePost,
+		CacheBodyMax:         r.bodyMax,
+		MaxBodyBytes:         r.maxBody,
 	}
+	if r.expInherited {
+		pr.DefaultExpiration = r.expDur
+	} else {
+		pr.Expiration = r.expDur
+	}
+	return pr
+}
+
+func (a *proxyRuntimeAdapter) HostKey(host string) string {
+	return a.s.config().hostKey(host)
+}
+
+func (a *proxyRuntimeAdapter) KeyVersion() string {
+	return a.s.config().Storage.KeyVersion
+}
+
+func (a *proxyRuntimeAdapter) ReadOnly() bool {
+	return a.s.config().Server.ReadOnly
+}
+
+func (a *proxyRuntimeAdapter) DebugHeaders() bool {
+	return a.s.config().Debug.ResponseHeaders
+}
+
+func (a *proxyRuntimeAdapter) MaxCacheableBytes() int64 {
+	return a.s.config().Storage.maxCacheable
 }
 
 func (a *proxyRuntimeAdapter) LoadRAM(key string, now int64) (proxy.Entry, bool) {
@@ -82,6 +184,9 @@ func (a *proxyRuntimeAdapter) LoadDisk(key string) (proxy.Entry, bool) {
 }
 
 func (a *proxyRuntimeAdapter) PromoteRAM(key string, ent proxy.Entry) {
+	if !a.s.disk.Promotable(key) {
+		return
+	}
 	a.s.ram.Put(key, fromProxyEntry(ent), a.s.disk, a.s.overflowLog)
 }
 
@@ -91,13 +196,34 @@ func (a *proxyRuntimeAdapter) DeleteKey(key string) {
 }
 
 func (a *proxyRuntimeAdapter) FetchFromOrigin(r *http.Request) (proxy.Entry, bool, string, error) {
-	return a.fetcher.FetchFromOrigin(r)
+	debugSleep(r.Context(), a.s.config().Debug.originDelayDur)
+	return a.originFetcher(r).FetchFromOrigin(r)
+}
+
+func (a *proxyRuntimeAdapter) OpenFromOrigin(r *http.Request) (proxy.Entry, bool, string, io.ReadCloser, error) {
+	debugSleep(r.Context(), a.s.config().Debug.originDelayDur)
+	return a.originFetcher(r).OpenFromOrigin(r)
 }
 
-func (a *proxyRuntimeAdapter) Store(key string, ent proxy.Entry) {
-	v := fromProxyEntry(ent)
-	a.s.ram.Put(key, v, a.s.disk, a.s.overflowLog)
-	a.s.disk.PutAsync(key, v)
+// originFetcher returns the fetcher for r with the current retry and content
+// type settings and the origin and origin timeout for r's path, all of which
+// a config reload may change.
+func (a *proxyRuntimeAdapter) originFetcher(r *http.Request) proxy.Fetcher {
+	cfg := a.s.config()
+	f := a.fetcher
+	f.Retries = cfg.Server.OriginRetries
+	f.RetryBackoff = cfg.Server.originRetryBackoffDur
+	f.DefaultContentType = cfg.Server.DefaultContentType
+	f.SniffContentType = cfg.Server.SniffContentType
+	f.ForwardedHeaders = cfg.Server.ForwardedHeaders
+	f.PreserveHost = cfg.Server.PreserveHost
+	f.Origin = cfg.originFor(r.URL.Path)
+	f.Timeout = cfg.originTimeout(r.URL.Path)
+	return f
+}
+
+func (a *proxyRuntimeAdapter) Store(key string, ent proxy.Entry, tier string) {
+	a.s.storeEntry(key, fromProxyEntry(ent), tier)
 }
 
 func (a *proxyRuntimeAdapter) RevalidateAsync(key, path, query string) {
@@ -108,8 +234,11 @@ func (a *proxyRuntimeAdapter) RevalidateAsync(key, path, query string) {
 }
 
 func (a *proxyRuntimeAdapter) WriteEntryWithStats(w http.ResponseWriter, ent proxy.Entry, wait0 string) {
+	if wait0 == "hit" {
+		debugSleep(context.Background(), a.s.config().Debug.responseDelayDur)
+	}
 	proxy.WriteEntry(w, ent, wait0)
-	if a.s.stats != nil {
+	if a.s.stats != nil && ent.Status != http.StatusNotModified {
 		switch wait0 {
 		case "hit", "miss":
 			a.s.stats.Observe(len(ent.Body))
@@ -117,6 +246,27 @@ func (a *proxyRuntimeAdapter) WriteEntryWithStats(w http.ResponseWriter, ent pro
 	}
 }
 
+func (a *proxyRuntimeAdapter) ObserveOutcome(path, wait0 string) {
+	if a.s.stats == nil {
+		return
+	}
+	a.s.stats.CountOutcome(wait0)
+	var hit bool
+	switch wait0 {
+	case "hit":
+		hit = true
+	case "miss", "stream":
+	default:
+		return
+	}
+	a.s.stats.ObserveOutcome(path, hit)
+	rule := wstats.NoRule
+	if r := a.s.pickRule(path); r != nil {
+		rule = r.Match
+	}
+	a.s.stats.ObserveRuleOutcome(rule, hit)
+}
+
 func toProxyEntry(ent CacheEntry) proxy.Entry {
 	return proxy.Entry{
 		Status:        ent.Status,
@@ -128,6 +278,9 @@ func toProxyEntry(ent CacheEntry) proxy.Entry {
 		DiscoveredBy:  ent.DiscoveredBy,
 		RevalidatedAt: ent.RevalidatedAt,
 		RevalidatedBy: ent.RevalidatedBy,
+		MaxAge:        ent.MaxAge,
+		ETag:          ent.ETag,
+		LastModified:  ent.LastModified,
 	}
 }
 
@@ -142,5 +295,8 @@ func fromProxyEntry(ent proxy.Entry) CacheEntry {
 		DiscoveredBy:  ent.DiscoveredBy,
 		RevalidatedAt: ent.RevalidatedAt,
 		RevalidatedBy: ent.RevalidatedBy,
+		MaxAge:        ent.MaxAge,
+		ETag:          ent.ETag,
+		LastModified:  ent.LastModified,
 	}
 }
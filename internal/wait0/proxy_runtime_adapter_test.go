@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,9 +26,13 @@ func TestProxyRuntimeAdapter_HandleControlAndRule(t *testing.T) {
 		t.Fatalf("expected false for non-invalidation path")
 	}
 
+	// Authenticated, so the requests get past gateAdmin to the missing
+	// controllers.
+	s.invAuth = auth.NewAuthenticator([]auth.TokenConfig{{ID: "t", Token: "secret", Scopes: []string{statapi.ReadScope}}})
 	s.inv = nil
 	w = httptest.NewRecorder()
 	r = httptest.NewRequest(http.MethodGet, "http://wait0.local"+invalidation.EndpointPath, nil)
+	r.Header.Set("Authorization", "Bearer secret")
 	if got := a.HandleControl(w, r); !got {
 		t.Fatalf("expected true for invalidation endpoint")
 	}
@@ -35,8 +40,19 @@ func TestProxyRuntimeAdapter_HandleControlAndRule(t *testing.T) {
 		t.Fatalf("status = %d, want 404", w.Result().StatusCode)
 	}
 
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "http://wait0.local"+invalidation.StaleEndpointPath+"?path=/a", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	if got := a.HandleControl(w, r); !got {
+		t.Fatalf("expected true for stale endpoint")
+	}
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404 for missing invalidation controller", w.Result().StatusCode)
+	}
+
 	w = httptest.NewRecorder()
 	r = httptest.NewRequest(http.MethodGet, "http://wait0.local"+statapi.EndpointPath, nil)
+	r.Header.Set("Authorization", "Bearer secret")
 	if got := a.HandleControl(w, r); !got {
 		t.Fatalf("expected true for stats endpoint")
 	}
@@ -58,10 +74,14 @@ func TestProxyRuntimeAdapter_HandleControlAndRule(t *testing.T) {
 		t.Fatalf("expected matching rule")
 	}
 	rule.BypassWhenCookies = append(rule.BypassWhenCookies, "session")
+	rule.BypassWhenHeaders = append(rule.BypassWhenHeaders, "Authorization")
 	base := s.pickRule("/api/x")
 	if len(base.BypassWhenCookies) != 0 {
 		t.Fatalf("rule cookie list should be copied")
 	}
+	if len(base.BypassWhenHeaders) != 0 {
+		t.Fatalf("rule header list should be copied")
+	}
 }
 
 func TestProxyRuntimeAdapter_HandleControl_StatsEndpointWithAuth(t *testing.T) {
@@ -98,6 +118,26 @@ func TestProxyRuntimeAdapter_HandleControl_StatsEndpointWithAuth(t *testing.T) {
 	if cacheObj["urls_total"].(float64) < 1 {
 		t.Fatalf("urls_total = %v", cacheObj["urls_total"])
 	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "http://wait0.local"+statapi.MetricsEndpointPath, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	if got := a.HandleControl(w, r); !got {
+		t.Fatalf("expected true for metrics endpoint")
+	}
+	if w.Result().StatusCode != http.StatusOK || !strings.Contains(w.Body.String(), "wait0_cached_paths 1\n") {
+		t.Fatalf("metrics status = %d body:\n%s", w.Result().StatusCode, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "http://wait0.local"+statapi.LiveEndpointPath, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	if got := a.HandleControl(w, r); !got {
+		t.Fatalf("expected true for live stats endpoint")
+	}
+	if w.Result().StatusCode != http.StatusOK || !strings.Contains(w.Body.String(), `"cached_paths":1`) {
+		t.Fatalf("live stats status = %d body:\n%s", w.Result().StatusCode, w.Body.String())
+	}
 }
 
 func TestProxyRuntimeAdapter_CacheAndStoreOps(t *testing.T) {
@@ -112,7 +152,7 @@ func TestProxyRuntimeAdapter_CacheAndStoreOps(t *testing.T) {
 		t.Fatalf("LoadRAM ok=%v ent=%+v", ok, ramEnt)
 	}
 
-	a.Store("/disk", proxy.Entry{Status: http.StatusAccepted, Header: http.Header{"X-S": {"1"}}, Body: []byte("body")})
+	a.Store("/disk", proxy.Entry{Status: http.StatusAccepted, Header: http.Header{"X-S": {"1"}}, Body: []byte("body")}, proxy.TierBoth)
 	if _, ok := s.ram.Peek("/disk"); !ok {
 		t.Fatalf("expected Store to populate RAM")
 	}
@@ -129,6 +169,108 @@ func TestProxyRuntimeAdapter_CacheAndStoreOps(t *testing.T) {
 	}
 }
 
+func TestProxyRuntimeAdapter_PromoteRAMWaitsForThreshold(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	s.disk.inner.SetPromotion(2, time.Minute)
+	a := newProxyRuntimeAdapter(s)
+
+	s.disk.PutAsync("/cold", CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("c")})
+	waitFor(t, 500*time.Millisecond, func() bool { return s.disk.HasKey("/cold") })
+
+	ent, ok := a.LoadDisk("/cold")
+	if !ok {
+		t.Fatalf("LoadDisk missed")
+	}
+	a.PromoteRAM("/cold", ent)
+	if _, ok := s.ram.Peek("/cold"); ok {
+		t.Fatalf("promoted after one disk hit, want the threshold to hold it back")
+	}
+
+	ent, _ = a.LoadDisk("/cold")
+	a.PromoteRAM("/cold", ent)
+	if _, ok := s.ram.Peek("/cold"); !ok {
+		t.Fatalf("not promoted after reaching the threshold")
+	}
+}
+
+func TestProxyRuntimeAdapter_PickRuleIndexAndPriority(t *testing.T) {
+	api := mustRule(t, "PathPrefix(/api)")
+	api.Priority = 5
+	s := newTestService(t, "http://example.com", []Rule{mustRule(t, "PathPrefix(/static)"), api})
+	a := newProxyRuntimeAdapter(s)
+
+	rule := a.PickRule("/api/x")
+	if rule == nil || rule.Index != 1 || rule.Priority != 5 {
+		t.Fatalf("rule = %+v, want index 1 priority 5", rule)
+	}
+}
+
+func TestProxyRuntimeAdapter_OriginTimeoutFollowsReloadAndRule(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer origin.Close()
+
+	slow := mustRule(t, "PathPrefix(/slow)")
+	slow.originTimeoutDur = 2 * time.Second
+	s := newTestService(t, origin.URL, []Rule{slow})
+	a := newProxyRuntimeAdapter(s)
+	if _, _, _, err := a.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/fast", nil)); err != nil {
+		t.Fatalf("fetch before reload: %v", err)
+	}
+
+	reloaded := *s.config()
+	reloaded.Server.originTimeoutDur = 50 * time.Millisecond
+	s.cfg.Store(&reloaded)
+	if _, _, _, err := a.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/fast", nil)); err == nil {
+		t.Fatalf("expected the reloaded server.originTimeout to cut the request short")
+	}
+	ent, _, _, err := a.FetchFromOrigin(httptest.NewRequest(http.MethodGet, "http://wait0.local/slow", nil))
+	if err != nil || string(ent.Body) != "slow" {
+		t.Fatalf("rule timeout fetch = %q, %v", ent.Body, err)
+	}
+}
+
+func TestProxyRuntimeAdapter_PickRuleDefaultExpiration(t *testing.T) {
+	s := newTestService(t, "http://example.com", []Rule{mustRule(t, "PathPrefix(/api)")})
+	a := newProxyRuntimeAdapter(s)
+
+	if rule := a.PickRule("/other"); rule != nil {
+		t.Fatalf("expected nil rule without default expiration, got %+v", rule)
+	}
+
+	s.config().Storage.defaultExpDur = time.Minute
+	rule := a.PickRule("/other")
+	if rule == nil || rule.DefaultExpiration != time.Minute || rule.Expiration != 0 {
+		t.Fatalf("unmatched rule = %+v, want default expiration", rule)
+	}
+	if rule.Bypass || rule.TierName() != proxy.TierBoth || rule.Index != -1 {
+		t.Fatalf("unmatched rule should keep defaults: %+v", rule)
+	}
+}
+
+func TestProxyRuntimeAdapter_StoreHonorsTier(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	a := newProxyRuntimeAdapter(s)
+	ent := proxy.Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("t")}
+
+	s.disk.PutAsync("/ram", fromProxyEntry(ent))
+	waitFor(t, 500*time.Millisecond, func() bool { return s.disk.HasKey("/ram") })
+	a.Store("/ram", ent, proxy.TierRAM)
+	if _, ok := s.ram.Peek("/ram"); !ok {
+		t.Fatalf("expected RAM tier store to populate RAM")
+	}
+	waitFor(t, 500*time.Millisecond, func() bool { return !s.disk.HasKey("/ram") })
+
+	s.ram.Put("/disk", fromProxyEntry(ent), s.disk, s.overflowLog)
+	a.Store("/disk", ent, proxy.TierDisk)
+	if _, ok := s.ram.Peek("/disk"); ok {
+		t.Fatalf("expected disk tier store to drop RAM copy")
+	}
+	waitFor(t, 500*time.Millisecond, func() bool { return s.disk.HasKey("/disk") })
+}
+
 func TestProxyRuntimeAdapter_RevalidateAndWriteStats(t *testing.T) {
 	s := newTestService(t, "http://example.com", nil)
 	a := newProxyRuntimeAdapter(s)
@@ -0,0 +1,128 @@
+package wait0
+
+import (
+	"reflect"
+	"time"
+
+	"wait0/internal/wait0/logging"
+	"wait0/internal/wait0/revalidation"
+)
+
+// Reload swaps the active configuration for next. Requests already in flight
+// keep the snapshot they loaded, so serving continues uninterrupted, and
+// cached entries are kept. Warmup loops restart when their settings changed. Settings
+// bound to running components at startup (port, origin, storage other than
+// keyVersion, auth, invalidation, discovery, the event webhook) keep their current values until
+// restart.
+func (s *Service) Reload(next Config) {
+	prev := s.config()
+	if prev != nil {
+		keepRestartOnly(prev, &next)
+	}
+	s.cfg.Store(&next)
+	applyLogLevel(&next)
+	warnDebug(&next)
+	if prev != nil {
+		s.reloadWarmupGroups(prev, &next)
+	}
+	if prev != nil && prev.Storage.KeyVersion != next.Storage.KeyVersion {
+		logging.Infof("config reload: storage.keyVersion %q -> %q, sweeping old keys", prev.Storage.KeyVersion, next.Storage.KeyVersion)
+		s.startKeyVersionSweep()
+	}
+}
+
+// ReloadFromFile loads the config at path and swaps it in. A config that fails
+// to load is logged and rejected, and the current config keeps serving.
+func (s *Service) ReloadFromFile(path string) error {
+	next, err := LoadConfig(path)
+	if err != nil {
+		logging.Errorf("config reload failed, keeping current config: %v", err)
+		return err
+	}
+	s.Reload(next)
+	logging.Infof("config reloaded: path=%q rules=%d", path, len(next.Rules))
+	return nil
+}
+
+// warmGroup is the part of a rule that shapes its warmup loop.
+type warmGroup struct {
+	match              string
+	every, ramp        time.Duration
+	maxAtATime, perRun int
+	window             revalidation.Window
+}
+
+func warmGroups(cfg *Config) []warmGroup {
+	var out []warmGroup
+	for _, r := range cfg.Rules {
+		if r.warmEvery <= 0 || r.warmMax <= 0 {
+			continue
+		}
+		out = append(out, warmGroup{match: r.Match, every: r.warmEvery, ramp: r.warmRamp, maxAtATime: r.warmMax, perRun: r.warmPerRun, window: r.warmWindow})
+	}
+	return out
+}
+
+// reloadWarmupGroups restarts the running warmup loops when a reload changed
+// any rule's warmup settings. Otherwise the loops keep running with the rules
+// they were started with, whose match expressions are unchanged.
+func (s *Service) reloadWarmupGroups(prev, next *Config) {
+	if reflect.DeepEqual(warmGroups(prev), warmGroups(next)) {
+		return
+	}
+	s.warmMu.Lock()
+	started := s.warmStop != nil
+	s.warmMu.Unlock()
+	if !started {
+		return
+	}
+	logging.Infof("config reload: warmup settings changed, restarting warmup groups")
+	s.startWarmupGroups()
+}
+
+func keepRestartOnly(prev *Config, next *Config) {
+	// upstream.timeout is the former name of server.originTimeout, which
+	// applies on reload.
+	upstreamTimeout := next.Server.Upstream.Timeout
+	next.Server.Upstream.Timeout = prev.Server.Upstream.Timeout
+	if next.Server.Port != prev.Server.Port || next.Server.Origin != prev.Server.Origin || next.Server.Upstream != prev.Server.Upstream || next.Server.Transport != prev.Server.Transport || !reflect.DeepEqual(next.Server.TLS, prev.Server.TLS) {
+		logging.Warnf("config reload: server.port/server.origin/server.upstream/server.transport/server.tls changes require a restart, keeping current values")
+	}
+	next.Server.Port = prev.Server.Port
+	next.Server.Origin = prev.Server.Origin
+	next.Server.Upstream = prev.Server.Upstream
+	next.Server.Upstream.Timeout = upstreamTimeout
+	next.Server.Transport = prev.Server.Transport
+	next.Server.TLS = prev.Server.TLS
+
+	keyVersion := next.Storage.KeyVersion
+	next.Storage.KeyVersion = prev.Storage.KeyVersion
+	if !reflect.DeepEqual(next.Storage, prev.Storage) {
+		logging.Warnf("config reload: storage changes require a restart, keeping current values")
+	}
+	next.Storage = prev.Storage
+	next.Storage.KeyVersion = keyVersion
+
+	if !reflect.DeepEqual(next.Auth, prev.Auth) || !reflect.DeepEqual(next.Server.Invalidation, prev.Server.Invalidation) {
+		logging.Warnf("config reload: auth/invalidation changes require a restart, keeping current values")
+	}
+	next.Auth = prev.Auth
+	next.Server.Invalidation = prev.Server.Invalidation
+
+	if !reflect.DeepEqual(next.URLsDiscover, prev.URLsDiscover) {
+		logging.Warnf("config reload: urlsDiscover changes require a restart, keeping current values")
+	}
+	next.URLsDiscover = prev.URLsDiscover
+
+	if next.Logging.EventWebhook != prev.Logging.EventWebhook {
+		logging.Warnf("config reload: logging.event_webhook changes require a restart, keeping current value")
+	}
+	next.Logging.EventWebhook = prev.Logging.EventWebhook
+
+	if next.Logging.StatsFile != prev.Logging.StatsFile || next.Logging.statsFlushEveryDur != prev.Logging.statsFlushEveryDur {
+		logging.Warnf("config reload: logging.stats_file/logging.stats_flush_every changes require a restart, keeping current values")
+	}
+	next.Logging.StatsFile = prev.Logging.StatsFile
+	next.Logging.StatsFlushEvery = prev.Logging.StatsFlushEvery
+	next.Logging.statsFlushEveryDur = prev.Logging.statsFlushEveryDur
+}
@@ -0,0 +1,167 @@
+package wait0
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wait0/internal/wait0/logging"
+)
+
+func writeReloadConfig(t *testing.T, path, origin, expiration string) {
+	t.Helper()
+	yaml := fmt.Sprintf(`storage:
+  ram: {max: "1m"}
+  disk: {max: "1m"}
+server:
+  origin: %q
+rules:
+  - match: "PathPrefix(/)"
+    expiration: %q
+`, origin, expiration)
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestReloadFromFile_ServesUnderConcurrentLoad(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, []Rule{mustRule(t, "PathPrefix(/)")})
+	cfgPath := filepath.Join(t.TempDir(), "wait0.yaml")
+
+	stop := make(chan struct{})
+	var failures atomic.Int32
+	var served atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://wait0.local/p%d", i%3), nil)
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					failures.Add(1)
+				}
+				served.Add(1)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 50; i++ {
+		if i%5 == 4 {
+			if err := os.WriteFile(cfgPath, []byte("rules: [\n"), 0o644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			if err := s.ReloadFromFile(cfgPath); err == nil {
+				t.Fatalf("expected broken config to be rejected")
+			}
+			continue
+		}
+		writeReloadConfig(t, cfgPath, origin.URL, fmt.Sprintf("%ds", i+1))
+		if err := s.ReloadFromFile(cfgPath); err != nil {
+			t.Fatalf("ReloadFromFile: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+
+	if failures.Load() != 0 {
+		t.Fatalf("failed requests = %d of %d", failures.Load(), served.Load())
+	}
+	if served.Load() == 0 {
+		t.Fatalf("expected requests to be served during reload")
+	}
+	if got := s.pickRule("/x").expDur; got != 49*time.Second {
+		t.Fatalf("active expiration = %s, want 49s", got)
+	}
+}
+
+func TestReloadFromFile_FailedReloadKeepsConfig(t *testing.T) {
+	s := newTestService(t, "http://example.com", []Rule{mustRule(t, "PathPrefix(/keep)")})
+	cfgPath := filepath.Join(t.TempDir(), "wait0.yaml")
+	if err := os.WriteFile(cfgPath, []byte("server:\n  port: 1\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	before := s.config()
+	if err := s.ReloadFromFile(cfgPath); err == nil {
+		t.Fatalf("expected error for config without origin")
+	}
+	if s.config() != before {
+		t.Fatalf("config snapshot changed after failed reload")
+	}
+	if s.pickRule("/keep/x") == nil {
+		t.Fatalf("expected old rules to keep serving")
+	}
+}
+
+func TestReload_KeepsRestartOnlySettings(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	next := Config{}
+	next.Server.Origin = "http://other.example.com"
+	next.Server.Port = 9999
+	next.Server.Transport.MaxIdleConns = 5
+	next.Server.Upstream.AcceptEncoding = "gzip"
+	next.Server.originTimeoutDur = 5 * time.Second
+	next.Server.TLS.InsecureSkipVerify = true
+	next.Storage.RAM.Max = "1g"
+	next.Storage.KeyVersion = "deploy-2"
+	next.Logging.EventWebhook = "http://hooks.example.com"
+	next.Logging.StatsFile = "/tmp/stats.json"
+	next.Rules = []Rule{mustRule(t, "PathPrefix(/new)")}
+
+	s.Reload(next)
+
+	cfg := s.config()
+	if cfg.Server.Origin != "http://example.com" || cfg.Server.Port != 0 || cfg.Server.Transport.MaxIdleConns != 0 || cfg.Server.TLS.InsecureSkipVerify {
+		t.Fatalf("restart-only settings changed: origin=%q port=%d", cfg.Server.Origin, cfg.Server.Port)
+	}
+	if cfg.Server.Upstream.AcceptEncoding != "" || cfg.Server.originTimeoutDur != 5*time.Second {
+		t.Fatalf("upstream.acceptEncoding=%q originTimeout=%s, want restart-only encoding and reloaded timeout", cfg.Server.Upstream.AcceptEncoding, cfg.Server.originTimeoutDur)
+	}
+	if cfg.Storage.RAM.Max != "" || cfg.Storage.KeyVersion != "deploy-2" {
+		t.Fatalf("storage after reload: ram.max=%q keyVersion=%q, want restart-only max and reloaded keyVersion", cfg.Storage.RAM.Max, cfg.Storage.KeyVersion)
+	}
+	if cfg.Logging.EventWebhook != "" || cfg.Logging.StatsFile != "" {
+		t.Fatalf("logging.event_webhook=%q stats_file=%q, want restart-only empty values", cfg.Logging.EventWebhook, cfg.Logging.StatsFile)
+	}
+	if s.pickRule("/new/x") == nil {
+		t.Fatalf("expected reloaded rules to be active")
+	}
+}
+
+func TestReload_AppliesLogLevel(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	t.Cleanup(func() { logging.SetLevel(logging.DefaultLevel) })
+
+	next := *s.config()
+	next.Logging.Level = "debug"
+	s.Reload(next)
+	if got := logging.CurrentLevel(); got != logging.LevelDebug {
+		t.Fatalf("level after reload = %s, want debug", got)
+	}
+
+	next.Logging.Level = ""
+	s.Reload(next)
+	if got := logging.CurrentLevel(); got != logging.LevelInfo {
+		t.Fatalf("level after reload without level = %s, want info", got)
+	}
+}
@@ -9,6 +9,10 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/freshness"
+	"wait0/internal/wait0/logging"
 )
 
 type Logger interface {
@@ -18,13 +22,33 @@ type Logger interface {
 type Runtime interface {
 	Peek(key string) (Entry, bool)
 	Put(key string, ent Entry)
+	// Refresh records a revalidation that found the body unchanged; only
+	// ent's timestamps and MaxAge differ from what is stored.
+	Refresh(key string, ent Entry)
 	Delete(key string)
 	SnapshotAccessTimes() map[string]int64
-	AllKeys() []string
-	Origin() string
+	ForEachKey(fn func(key string) bool)
+	// Origin returns the origin base URL for path.
+	Origin(path string) string
+	// OriginTimeout caps the origin request for path, body included; zero
+	// leaves it to the caller's context.
+	OriginTimeout(path string) time.Duration
+	AcceptEncoding() string
+	// MaxHeaderValueBytes drops origin header values longer than this; zero
+	// disables the cap.
+	MaxHeaderValueBytes() int64
 	Do(req *http.Request) (*http.Response, error)
 	SendRevalidateMarkers() bool
+	// PreserveHost reports whether origin requests carry the client's Host;
+	// revalidation then sends the host component of the cache key.
+	PreserveHost() bool
 	RandomString(n int) string
+	// CacheWithSetCookie reports whether the rule for path caches responses
+	// carrying Set-Cookie.
+	CacheWithSetCookie(path string) bool
+	// ServeStaleOnError reports whether the rule for path still serves ent in
+	// place of an origin failure (its staleIfError window).
+	ServeStaleOnError(path string, ent Entry) bool
 }
 
 type Controller struct {
@@ -41,6 +65,7 @@ type Controller struct {
 	errorLog     Logger
 
 	observeDuration func(time.Duration)
+	observeChange   func(Change)
 }
 
 func NewController(rt Runtime, bgSem chan struct{}, stopCh <-chan struct{}, wg *sync.WaitGroup, logWarmUp bool, summaryLog Logger, unchangedLog Logger, errorLog Logger) *Controller {
@@ -60,29 +85,37 @@ func (c *Controller) SetDurationObserver(fn func(time.Duration)) {
 	c.observeDuration = fn
 }
 
-func (c *Controller) Async(key, path, query, by string) {
+// SetChangeObserver registers fn to be called after an entry is stored with a
+// new body hash. It runs on the revalidation goroutine and must not block.
+func (c *Controller) SetChangeObserver(fn func(Change)) {
+	c.observeChange = fn
+}
+
+// Async runs Once in the background and reports whether it was started; it
+// does nothing when the worker pool is full.
+func (c *Controller) Async(key, path, query, by string) bool {
 	select {
 	case c.bgSem <- struct{}{}:
 	default:
-		return
+		return false
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
 		defer func() { <-c.bgSem }()
-		defer cancel()
-		_ = c.Once(ctx, key, path, query, by)
+		_ = c.Once(context.Background(), key, path, query, by)
 	}()
+	return true
 }
 
-func (c *Controller) Once(ctx context.Context, key, path, query, by string) Result {
+func (c *Controller) Once(ctx context.Context, key, path, query, by string) (res Result) {
 	start := time.Now()
 	defer func() {
 		if c.observeDuration != nil {
 			c.observeDuration(time.Since(start))
 		}
+		logging.Debugf("Revalidate %s: path=%q uri=%q by=%s took=%s", res.Kind, res.Path, res.URI, by, time.Since(start).Truncate(time.Millisecond))
 	}()
 	cur, hasCur := c.rt.Peek(key)
 
@@ -97,18 +130,49 @@ func (c *Controller) Once(ctx context.Context, key, path, query, by string) Resu
 	if query != "" {
 		uri += "?" + query
 	}
-	originURL := c.rt.Origin() + uri
+	originURL := c.rt.Origin(path) + uri
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, originURL, nil)
+	method := http.MethodGet
+	if m := cachekey.Parse(key).Method; m != "" {
+		method = m
+	}
+	if method == http.MethodPost {
+		// The request body is not stored with the entry; the proxy refetches
+		// a stale POST entry when a client asks for it.
+		return Result{OK: true, Changed: false, Dur: time.Since(start), URI: uri, Path: path, Kind: "kept-stale"}
+	}
+	if d := c.rt.OriginTimeout(path); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, originURL, nil)
 	if err != nil {
 		return Result{OK: false, Changed: false, Dur: time.Since(start), URI: uri, Path: path, Kind: "error", Err: err.Error()}
 	}
 
+	if h := cachekey.Parse(key).Host; h != "" && c.rt.PreserveHost() {
+		req.Host = h
+	}
 	if c.rt.SendRevalidateMarkers() {
 		req.Header.Set("X-Wait0-Revalidate-At", time.Now().UTC().Format(time.RFC3339Nano))
 		req.Header.Set("X-Wait0-Revalidate-Entropy", c.rt.RandomString(8))
 	}
-	req.Header.Set("Accept-Encoding", "identity")
+	cachekey.ApplyVary(req.Header, cachekey.Parse(key).Vary)
+	acceptEncoding := c.rt.AcceptEncoding()
+	if acceptEncoding == "" {
+		acceptEncoding = "identity"
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	conditional := hasCur && !cur.Inactive && (cur.ETag != "" || cur.LastModified != "")
+	if conditional {
+		if cur.ETag != "" {
+			req.Header.Set("If-None-Match", cur.ETag)
+		}
+		if cur.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cur.LastModified)
+		}
+	}
 
 	resp, err := c.rt.Do(req)
 	if err != nil {
@@ -116,14 +180,43 @@ func (c *Controller) Once(ctx context.Context, key, path, query, by string) Resu
 	}
 	defer resp.Body.Close()
 
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		// The stored body is still current: refresh its timestamps and keep
+		// everything else, including the encoded body.
+		now := time.Now().UTC()
+		cur.StoredAt = now.Unix()
+		cur.RevalidatedAt = now.UnixNano()
+		cur.RevalidatedBy = by
+		if resp.Header.Get("Cache-Control") != "" || resp.Header.Get("Expires") != "" {
+			cur.MaxAge = freshness.FromHeader(resp.Header, now)
+		}
+		if c.unchangedLog != nil {
+			c.unchangedLog.Printf("Revalidate not modified: path=%q uri=%q", path, uri)
+		}
+		c.rt.Refresh(key, cur)
+		return Result{OK: true, Changed: false, Dur: time.Since(start), URI: uri, Path: path, Kind: "unchanged", NotModified: true}
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return Result{OK: false, Changed: false, Dur: time.Since(start), URI: uri, Path: path, Kind: "error", Err: err.Error()}
 	}
 
-	res := Result{OK: true, Changed: false, Dur: time.Since(start), URI: uri, Path: path}
+	res = Result{OK: true, Changed: false, Dur: time.Since(start), URI: uri, Path: path}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if hasCur && cur.Inactive && !isDefinitiveMiss(resp.StatusCode) {
+			// Sitemap seeds survive transient origin failures so discovery
+			// does not have to re-seed them on every run.
+			res.Kind = "kept-inactive"
+			return res
+		}
+		if hasCur && resp.StatusCode >= 500 && c.rt.ServeStaleOnError(path, cur) {
+			// Deleting the entry now would turn an origin outage into
+			// errors for clients the entry can still serve.
+			res.Kind = "kept-stale"
+			return res
+		}
 		if hasCur {
 			c.rt.Delete(key)
 			res.Changed = true
@@ -134,8 +227,9 @@ func (c *Controller) Once(ctx context.Context, key, path, query, by string) Resu
 		return res
 	}
 
-	cc := strings.ToLower(resp.Header.Get("Cache-Control"))
-	if strings.Contains(cc, "no-store") || strings.Contains(cc, "no-cache") {
+	_, varyAny := cachekey.ResponseVary(resp.Header)
+	setCookie := len(resp.Header.Values("Set-Cookie")) > 0 && !c.rt.CacheWithSetCookie(path)
+	if !freshness.ParseCacheControl(resp.Header).Storable() || varyAny || setCookie {
 		if hasCur {
 			c.rt.Delete(key)
 			res.Changed = true
@@ -157,21 +251,48 @@ func (c *Controller) Once(ctx context.Context, key, path, query, by string) Resu
 		DiscoveredBy:  discoveredBy,
 		RevalidatedAt: now.UnixNano(),
 		RevalidatedBy: by,
+		MaxAge:        freshness.FromHeader(resp.Header, now),
+		ETag:          resp.Header.Get("ETag"),
+		LastModified:  resp.Header.Get("Last-Modified"),
 	}
 	newEnt.Header.Del("Content-Length")
+	newEnt.Header.Del("Set-Cookie")
+	freshness.CleanAge(newEnt.Header)
+	if max := c.rt.MaxHeaderValueBytes(); dropOversizedHeaders(newEnt.Header, max) && c.errorLog != nil {
+		c.errorLog.Printf("Revalidate dropped header values over %d bytes: path=%q uri=%q", max, path, uri)
+	}
 
 	if hasCur && cur.Hash32 == newEnt.Hash32 {
 		res.Kind = "unchanged"
 		if c.unchangedLog != nil {
 			c.unchangedLog.Printf("Revalidate unchanged: path=%q uri=%q", path, uri)
 		}
-		c.rt.Put(key, newEnt)
+		if cur.Inactive || newEnt.ETag != cur.ETag || newEnt.LastModified != cur.LastModified {
+			// Seeds must be activated and new validators persisted for the
+			// next conditional fetch.
+			c.rt.Put(key, newEnt)
+		} else {
+			c.rt.Refresh(key, newEnt)
+		}
 		return res
 	}
 
 	c.rt.Put(key, newEnt)
 	res.Changed = true
 	res.Kind = "updated"
+	if c.observeChange != nil {
+		c.observeChange(Change{
+			Key:     key,
+			Path:    path,
+			URI:     uri,
+			HadOld:  hasCur,
+			OldHash: cur.Hash32,
+			NewHash: newEnt.Hash32,
+			Bytes:   len(body),
+			By:      by,
+			At:      now,
+		})
+	}
 	return res
 }
 
@@ -187,16 +308,18 @@ func (c *Controller) WarmupGroupLoop(rule WarmRule) {
 	queue := make([]string, 0, 1024)
 
 	var inflight int
+	// budget is what is left of rule.MaxPerRun for the current tick.
+	var budget int
 	var batchStart time.Time
 	var urls int
 	var minRT, maxRT, sumRT time.Duration
-	var unchanged, updated, deleted, ignoredStatus, ignoredCacheControl, errors int
+	var unchanged, notModified, updated, deleted, keptInactive, keptStale, ignoredStatus, ignoredCacheControl, errors int
 
 	resetBatch := func() {
 		batchStart = time.Time{}
 		urls = 0
 		minRT, maxRT, sumRT = 0, 0, 0
-		unchanged, updated, deleted, ignoredStatus, ignoredCacheControl, errors = 0, 0, 0, 0, 0, 0
+		unchanged, notModified, updated, deleted, keptInactive, keptStale, ignoredStatus, ignoredCacheControl, errors = 0, 0, 0, 0, 0, 0, 0, 0, 0
 	}
 
 	makeSummary := func() WarmupSummary {
@@ -221,14 +344,28 @@ func (c *Controller) WarmupGroupLoop(rule WarmRule) {
 			AvgRT:               avg,
 			MaxRT:               maxRT,
 			Unchanged:           unchanged,
+			NotModified:         notModified,
 			Updated:             updated,
 			Deleted:             deleted,
+			KeptInactive:        keptInactive,
+			KeptStale:           keptStale,
 			IgnoredStatus:       ignoredStatus,
 			IgnoredCacheControl: ignoredCacheControl,
 			Errors:              errors,
 		}
 	}
 
+	logSummary := func() {
+		sum := makeSummary()
+		c.summaryLog.Printf(
+			"Revalidated for match %q: %d URLs (unchanged=%d notModified=%d updated=%d deleted=%d keptInactive=%d keptStale=%d ignoredStatus=%d ignoredCC=%d errors=%d updated+errors=%d), Took: %s, RPS: %.2f, resp time min/avg/max - %s/%s/%s",
+			sum.Match, sum.URLs,
+			sum.Unchanged, sum.NotModified, sum.Updated, sum.Deleted, sum.KeptInactive, sum.KeptStale, sum.IgnoredStatus, sum.IgnoredCacheControl, sum.Errors, sum.Updated+sum.Errors,
+			sum.Took.Truncate(time.Millisecond), sum.RPS,
+			sum.MinRT.Truncate(time.Millisecond), sum.AvgRT.Truncate(time.Millisecond), sum.MaxRT.Truncate(time.Millisecond),
+		)
+	}
+
 	maybeFinish := func() {
 		if batchStart.IsZero() {
 			return
@@ -237,20 +374,24 @@ func (c *Controller) WarmupGroupLoop(rule WarmRule) {
 			return
 		}
 		if c.logWarmUp && c.summaryLog != nil {
-			sum := makeSummary()
-			c.summaryLog.Printf(
-				"Revalidated for match %q: %d URLs (unchanged=%d updated=%d deleted=%d ignoredStatus=%d ignoredCC=%d errors=%d updated+errors=%d), Took: %s, RPS: %.2f, resp time min/avg/max - %s/%s/%s",
-				sum.Match, sum.URLs,
-				sum.Unchanged, sum.Updated, sum.Deleted, sum.IgnoredStatus, sum.IgnoredCacheControl, sum.Errors, sum.Updated+sum.Errors,
-				sum.Took.Truncate(time.Millisecond), sum.RPS,
-				sum.MinRT.Truncate(time.Millisecond), sum.AvgRT.Truncate(time.Millisecond), sum.MaxRT.Truncate(time.Millisecond),
-			)
+			logSummary()
 		}
 		resetBatch()
 	}
 
 	dispatch := func() {
-		for inflight < rule.WarmMax && len(queue) > 0 {
+		now := time.Now()
+		if !rule.Schedule.Open(now) {
+			return
+		}
+		limit := rule.EffectiveMax(now)
+		for inflight < limit && len(queue) > 0 {
+			if rule.MaxPerRun > 0 {
+				if budget == 0 {
+					return
+				}
+				budget--
+			}
 			key := queue[0]
 			queue = queue[1:]
 			delete(queued, key)
@@ -261,14 +402,16 @@ func (c *Controller) WarmupGroupLoop(rule WarmRule) {
 			go func(k string) {
 				defer c.wg.Done()
 				defer func() { <-sem }()
-				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-				defer cancel()
-				results <- c.Once(ctx, k, k, "", "warmup")
+				p := cachekey.Parse(k)
+				results <- c.Once(context.Background(), k, p.Path, p.Query, "warmup")
 			}(key)
 		}
 	}
 
 	refresh := func() {
+		if !rule.Schedule.Open(time.Now()) {
+			return
+		}
 		keys := c.KeysByLastAccessDesc(rule)
 		if len(keys) == 0 {
 			return
@@ -290,35 +433,35 @@ func (c *Controller) WarmupGroupLoop(rule WarmRule) {
 
 	stopping := false
 	stopCh := c.stopCh
+	ruleStop := rule.Stop
+	beginStop := func() {
+		stopping = true
+		stopCh, ruleStop = nil, nil
+		t.Stop()
+		for k := range queued {
+			delete(queued, k)
+		}
+		queue = queue[:0]
+	}
 
 	for {
 		if stopping && inflight == 0 {
 			if !batchStart.IsZero() && c.logWarmUp && c.summaryLog != nil {
-				sum := makeSummary()
-				c.summaryLog.Printf(
-					"Revalidated for match %q: %d URLs (unchanged=%d updated=%d deleted=%d ignoredStatus=%d ignoredCC=%d errors=%d updated+errors=%d), Took: %s, RPS: %.2f, resp time min/avg/max - %s/%s/%s",
-					sum.Match, sum.URLs,
-					sum.Unchanged, sum.Updated, sum.Deleted, sum.IgnoredStatus, sum.IgnoredCacheControl, sum.Errors, sum.Updated+sum.Errors,
-					sum.Took.Truncate(time.Millisecond), sum.RPS,
-					sum.MinRT.Truncate(time.Millisecond), sum.AvgRT.Truncate(time.Millisecond), sum.MaxRT.Truncate(time.Millisecond),
-				)
+				logSummary()
 			}
 			return
 		}
 
 		select {
 		case <-stopCh:
-			stopping = true
-			stopCh = nil
-			t.Stop()
-			for k := range queued {
-				delete(queued, k)
-			}
-			queue = queue[:0]
+			beginStop()
+		case <-ruleStop:
+			beginStop()
 		case <-t.C:
 			if stopping {
 				continue
 			}
+			budget = rule.MaxPerRun
 			refresh()
 			dispatch()
 		case res := <-results:
@@ -335,10 +478,17 @@ func (c *Controller) WarmupGroupLoop(rule WarmRule) {
 				switch res.Kind {
 				case "unchanged":
 					unchanged++
+					if res.NotModified {
+						notModified++
+					}
 				case "updated":
 					updated++
 				case "deleted":
 					deleted++
+				case "kept-inactive":
+					keptInactive++
+				case "kept-stale":
+					keptStale++
 				case "ignored-status":
 					ignoredStatus++
 				case "ignored-cache-control":
@@ -373,7 +523,7 @@ func (c *Controller) KeysByLastAccessDesc(rule WarmRule) []string {
 		ts int64
 	}, 0, len(access))
 	for k, ts := range access {
-		if rule.Matches != nil && !rule.Matches(k) {
+		if rule.Matches != nil && !rule.Matches(cachekey.Path(k)) {
 			continue
 		}
 		items = append(items, struct {
@@ -394,18 +544,58 @@ func (c *Controller) KeysByLastAccessDesc(rule WarmRule) []string {
 	return out
 }
 
+// AllKeysSnapshot returns every cached key once, sorted. Keys held in both
+// tiers are dropped after sorting rather than through a seen-set, so a large
+// cache costs one slice instead of a slice plus a map of the same size.
 func (c *Controller) AllKeysSnapshot() []string {
-	out := make([]string, 0)
-	seen := map[string]struct{}{}
-	for _, k := range c.rt.AllKeys() {
-		if _, ok := seen[k]; ok {
+	var out []string
+	c.rt.ForEachKey(func(k string) bool {
+		out = append(out, k)
+		return true
+	})
+	sort.Strings(out)
+	n := 0
+	for i, k := range out {
+		if i > 0 && k == out[n-1] {
 			continue
 		}
-		seen[k] = struct{}{}
-		out = append(out, k)
+		out[n] = k
+		n++
 	}
-	sort.Strings(out)
-	return out
+	return out[:n]
+}
+
+// isDefinitiveMiss reports whether an origin status means the URL is gone
+// for good, as opposed to a transient failure worth retrying later.
+func isDefinitiveMiss(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone
+}
+
+// dropOversizedHeaders removes header values longer than max bytes and reports
+// whether any were dropped. A max of zero or less disables the cap.
+func dropOversizedHeaders(h http.Header, max int64) bool {
+	if max <= 0 {
+		return false
+	}
+	dropped := false
+	for k, vs := range h {
+		kept := vs[:0]
+		for _, v := range vs {
+			if int64(len(v)) <= max {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == len(vs) {
+			continue
+		}
+		dropped = true
+		if len(kept) == 0 {
+			delete(h, k)
+		} else {
+			h[k] = kept
+		}
+	}
+	return dropped
 }
 
 func cloneHeader(h http.Header) http.Header {
@@ -3,9 +3,11 @@ package revalidation
 import (
 	"context"
 	"errors"
+	"fmt"
 	"hash/crc32"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -19,23 +21,31 @@ type fakeRuntime struct {
 	access  map[string]int64
 	allKeys []string
 	origin  string
+	timeout time.Duration
+	encode  string
+	maxHdr  int64
 
 	sendMarkers bool
+	keepHost    bool
 	random      string
+	cookieOK    bool
+	staleOK     bool
 	doFunc      func(req *http.Request) (*http.Response, error)
 
-	putCalls    map[string]Entry
-	deleteCalls []string
-	requests    []*http.Request
+	putCalls     map[string]Entry
+	refreshCalls map[string]Entry
+	deleteCalls  []string
+	requests     []*http.Request
 }
 
 func newFakeRuntime() *fakeRuntime {
 	return &fakeRuntime{
-		peekMap:  map[string]Entry{},
-		access:   map[string]int64{},
-		origin:   "http://origin.local",
-		random:   "entropy",
-		putCalls: map[string]Entry{},
+		peekMap:      map[string]Entry{},
+		access:       map[string]int64{},
+		origin:       "http://origin.local",
+		random:       "entropy",
+		putCalls:     map[string]Entry{},
+		refreshCalls: map[string]Entry{},
 	}
 }
 
@@ -52,6 +62,12 @@ func (f *fakeRuntime) Put(key string, ent Entry) {
 	f.putCalls[key] = ent
 }
 
+func (f *fakeRuntime) Refresh(key string, ent Entry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refreshCalls[key] = ent
+}
+
 func (f *fakeRuntime) Delete(key string) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -68,13 +84,36 @@ func (f *fakeRuntime) SnapshotAccessTimes() map[string]int64 {
 	return out
 }
 
-func (f *fakeRuntime) AllKeys() []string {
+func (f *fakeRuntime) ForEachKey(fn func(key string) bool) {
+	f.mu.Lock()
+	keys := append([]string(nil), f.allKeys...)
+	f.mu.Unlock()
+	for _, k := range keys {
+		if !fn(k) {
+			return
+		}
+	}
+}
+
+func (f *fakeRuntime) MaxHeaderValueBytes() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxHdr
+}
+
+func (f *fakeRuntime) AcceptEncoding() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.encode
+}
+
+func (f *fakeRuntime) OriginTimeout(string) time.Duration {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	return append([]string(nil), f.allKeys...)
+	return f.timeout
 }
 
-func (f *fakeRuntime) Origin() string {
+func (f *fakeRuntime) Origin(string) string {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	return f.origin
@@ -98,6 +137,24 @@ func (f *fakeRuntime) SendRevalidateMarkers() bool {
 	return f.sendMarkers
 }
 
+func (f *fakeRuntime) PreserveHost() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keepHost
+}
+
+func (f *fakeRuntime) CacheWithSetCookie(string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cookieOK
+}
+
+func (f *fakeRuntime) ServeStaleOnError(string, Entry) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.staleOK
+}
+
 func (f *fakeRuntime) RandomString(int) string {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -112,7 +169,7 @@ type captureLogger struct {
 func (l *captureLogger) Printf(format string, v ...any) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.lines = append(l.lines, format)
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
 }
 
 func (l *captureLogger) count() int {
@@ -133,7 +190,9 @@ func TestController_Async_DropsWhenQueueIsFull(t *testing.T) {
 	var wg sync.WaitGroup
 	c := NewController(rt, bgSem, make(chan struct{}), &wg, false, nil, nil, nil)
 
-	c.Async("/x", "/x", "", "user")
+	if c.Async("/x", "/x", "", "user") {
+		t.Fatalf("Async reported queued with a full pool")
+	}
 
 	wg.Wait()
 	if len(rt.requests) != 0 {
@@ -150,7 +209,9 @@ func TestController_Async_ExecutesOnce(t *testing.T) {
 	var wg sync.WaitGroup
 	c := NewController(rt, bgSem, make(chan struct{}), &wg, false, nil, nil, nil)
 
-	c.Async("/p", "/p", "q=1", "user")
+	if !c.Async("/p", "/p", "q=1", "user") {
+		t.Fatalf("Async reported dropped with a free pool")
+	}
 	wg.Wait()
 
 	if len(rt.requests) != 1 {
@@ -158,6 +219,291 @@ func TestController_Async_ExecutesOnce(t *testing.T) {
 	}
 }
 
+func TestController_Once_UsesMethodFromKey(t *testing.T) {
+	rt := newFakeRuntime()
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+	c.Once(context.Background(), "/api#%40m=OPTIONS&Origin=https%3A%2F%2Fa.example", "/api", "", "warmup")
+
+	if len(rt.requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(rt.requests))
+	}
+	req := rt.requests[0]
+	if req.Method != http.MethodOptions || req.Header.Get("Origin") != "https://a.example" {
+		t.Fatalf("request = %s Origin=%q, want an OPTIONS preflight replay", req.Method, req.Header.Get("Origin"))
+	}
+}
+
+func TestController_Once_KeepsPostEntries(t *testing.T) {
+	rt := newFakeRuntime()
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+	res := c.Once(context.Background(), "/graphql#%40b=ab12&%40m=POST", "/graphql", "", "warmup")
+
+	if len(rt.requests) != 0 || res.Kind != "kept-stale" {
+		t.Fatalf("requests=%d kind=%q, want a POST entry left for the proxy to refetch", len(rt.requests), res.Kind)
+	}
+}
+
+func TestController_Once_PreserveHostUsesKeyHost(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		rt := newFakeRuntime()
+		rt.keepHost = keep
+		var wg sync.WaitGroup
+		c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+		c.Once(context.Background(), "/a#%40host=shop.example.com", "/a", "", "warmup")
+		c.Once(context.Background(), "/b", "/b", "", "warmup")
+
+		want := map[bool]string{false: "origin.local", true: "shop.example.com"}[keep]
+		if got := rt.requests[0].Host; got != want {
+			t.Fatalf("preserveHost=%v: Host = %q, want %q", keep, got, want)
+		}
+		if got := rt.requests[1].Host; got != "origin.local" {
+			t.Fatalf("preserveHost=%v: key without host sent Host = %q, want origin host", keep, got)
+		}
+	}
+}
+
+func TestController_Once_AppliesVaryHeadersFromKey(t *testing.T) {
+	rt := newFakeRuntime()
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+	res := c.Once(context.Background(), "/api#Accept=application%2Fxml", "/api", "", "warmup")
+
+	if res.Kind != "updated" {
+		t.Fatalf("kind = %q, want updated", res.Kind)
+	}
+	if len(rt.requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(rt.requests))
+	}
+	req := rt.requests[0]
+	if req.URL.String() != "http://origin.local/api" {
+		t.Fatalf("url = %q", req.URL.String())
+	}
+	if got := req.Header.Get("Accept"); got != "application/xml" {
+		t.Fatalf("Accept = %q, want application/xml", got)
+	}
+	if _, ok := rt.putCalls["/api#Accept=application%2Fxml"]; !ok {
+		t.Fatalf("expected variant key to be stored, got %v", rt.putCalls)
+	}
+}
+
+func TestController_Once_BoundsRequestByOriginTimeout(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.timeout = time.Minute
+	var deadline time.Time
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		deadline, _ = req.Context().Deadline()
+		return nil, errors.New("unreachable")
+	}
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+	_ = c.Once(context.Background(), "/p", "/p", "", "warmup")
+	if left := time.Until(deadline); left <= 50*time.Second || left > time.Minute {
+		t.Fatalf("request deadline in %s, want the 1m origin timeout", left)
+	}
+}
+
+func TestController_Once_SendsConfiguredAcceptEncoding(t *testing.T) {
+	for _, tc := range []struct{ encode, want string }{{"", "identity"}, {"gzip", "gzip"}} {
+		rt := newFakeRuntime()
+		rt.encode = tc.encode
+		var wg sync.WaitGroup
+		c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+		_ = c.Once(context.Background(), "/p", "/p", "", "warmup")
+		if got := rt.requests[0].Header.Get("Accept-Encoding"); got != tc.want {
+			t.Fatalf("encode %q: Accept-Encoding = %q, want %q", tc.encode, got, tc.want)
+		}
+	}
+}
+
+func TestController_Once_RecordsOriginMaxAge(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.doFunc = func(*http.Request) (*http.Response, error) {
+		h := http.Header{}
+		h.Set("Cache-Control", "public, max-age=600, s-maxage=120")
+		return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(strings.NewReader("body"))}, nil
+	}
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+	_ = c.Once(context.Background(), "/p", "/p", "", "warmup")
+
+	if got := rt.putCalls["/p"].MaxAge; got != 120 {
+		t.Fatalf("stored MaxAge = %d, want s-maxage 120", got)
+	}
+}
+
+func TestController_Once_ConditionalNotModifiedKeepsBody(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.peekMap["/p"] = Entry{Status: http.StatusOK, Header: http.Header{"Etag": {`"v1"`}}, Body: []byte("cached"), StoredAt: 1, Hash32: 7, ETag: `"v1"`}
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		if got := req.Header.Get("If-None-Match"); got != `"v1"` {
+			t.Fatalf("If-None-Match = %q", got)
+		}
+		return &http.Response{StatusCode: http.StatusNotModified, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+	res := c.Once(context.Background(), "/p", "/p", "", "warmup")
+	if res.Kind != "unchanged" || res.Changed {
+		t.Fatalf("result = %+v, want unchanged", res)
+	}
+	if len(rt.putCalls) != 0 {
+		t.Fatalf("putCalls = %v, want a refresh only", rt.putCalls)
+	}
+	got := rt.refreshCalls["/p"]
+	if string(got.Body) != "cached" || got.Hash32 != 7 || got.ETag != `"v1"` {
+		t.Fatalf("stored = %+v, want the cached body kept", got)
+	}
+	if got.StoredAt <= 1 || got.RevalidatedBy != "warmup" {
+		t.Fatalf("StoredAt=%d RevalidatedBy=%q, want bumped", got.StoredAt, got.RevalidatedBy)
+	}
+	if len(rt.deleteCalls) != 0 {
+		t.Fatalf("deleted = %v", rt.deleteCalls)
+	}
+}
+
+func TestController_Once_IfModifiedSince(t *testing.T) {
+	lm := "Wed, 01 Jan 2025 00:00:00 GMT"
+	rt := newFakeRuntime()
+	rt.peekMap["/p"] = Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("cached"), StoredAt: 1, LastModified: lm}
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		if got := req.Header.Get("If-Modified-Since"); got != lm {
+			t.Fatalf("If-Modified-Since = %q", got)
+		}
+		if got := req.Header.Get("If-None-Match"); got != "" {
+			t.Fatalf("If-None-Match = %q, want none without an ETag", got)
+		}
+		h := http.Header{}
+		h.Set("Cache-Control", "max-age=300")
+		return &http.Response{StatusCode: http.StatusNotModified, Header: h, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+	if res := c.Once(context.Background(), "/p", "/p", "", "warmup"); res.Kind != "unchanged" {
+		t.Fatalf("kind = %q, want unchanged", res.Kind)
+	}
+	got := rt.refreshCalls["/p"]
+	if string(got.Body) != "cached" || got.StoredAt <= 1 || got.MaxAge != 300 {
+		t.Fatalf("refreshed = %+v, want body kept, StoredAt bumped, MaxAge from 304", got)
+	}
+}
+
+func TestController_Once_IdenticalBodyIgnoringValidators(t *testing.T) {
+	lm := "Wed, 01 Jan 2025 00:00:00 GMT"
+	newLM := "Thu, 02 Jan 2025 00:00:00 GMT"
+	tests := []struct {
+		name        string
+		respLM      string
+		wantRefresh bool
+	}{
+		{name: "same validators refresh only", respLM: lm, wantRefresh: true},
+		{name: "new validators are stored", respLM: newLM, wantRefresh: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := newFakeRuntime()
+			rt.peekMap["/p"] = Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("same"), StoredAt: 1, Hash32: crc32.ChecksumIEEE([]byte("same")), LastModified: lm}
+			rt.doFunc = func(*http.Request) (*http.Response, error) {
+				h := http.Header{}
+				h.Set("Last-Modified", tc.respLM)
+				return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(strings.NewReader("same"))}, nil
+			}
+			var wg sync.WaitGroup
+			c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+			if res := c.Once(context.Background(), "/p", "/p", "", "warmup"); res.Kind != "unchanged" {
+				t.Fatalf("kind = %q, want unchanged", res.Kind)
+			}
+			if tc.wantRefresh {
+				if len(rt.putCalls) != 0 || rt.refreshCalls["/p"].RevalidatedAt == 0 {
+					t.Fatalf("put=%v refresh=%v, want a refresh with RevalidatedAt", rt.putCalls, rt.refreshCalls)
+				}
+				return
+			}
+			if rt.putCalls["/p"].LastModified != newLM {
+				t.Fatalf("put = %+v, want the new Last-Modified stored", rt.putCalls["/p"])
+			}
+		})
+	}
+}
+
+func TestController_Once_ObservesBodyChanges(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.peekMap["/same"] = Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("ok"), Hash32: crc32.ChecksumIEEE([]byte("ok"))}
+	rt.peekMap["/old"] = Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("old"), Hash32: crc32.ChecksumIEEE([]byte("old"))}
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+	var got []Change
+	c.SetChangeObserver(func(ch Change) { got = append(got, ch) })
+
+	c.Once(context.Background(), "/same", "/same", "", "warmup")
+	c.Once(context.Background(), "/old", "/old", "a=1", "warmup")
+	c.Once(context.Background(), "/new", "/new", "", "user")
+
+	if len(got) != 2 {
+		t.Fatalf("changes = %+v, want /old and /new", got)
+	}
+	okHash := crc32.ChecksumIEEE([]byte("ok"))
+	if ch := got[0]; ch.Path != "/old" || ch.URI != "/old?a=1" || !ch.HadOld || ch.OldHash != crc32.ChecksumIEEE([]byte("old")) || ch.NewHash != okHash || ch.Bytes != 2 || ch.By != "warmup" || ch.At.IsZero() {
+		t.Fatalf("changed entry = %+v", ch)
+	}
+	if ch := got[1]; ch.Path != "/new" || ch.HadOld || ch.OldHash != 0 || ch.NewHash != okHash || ch.By != "user" {
+		t.Fatalf("new entry = %+v", ch)
+	}
+}
+
+func TestController_Once_RecordsETagAndSkipsValidatorWithoutOne(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.peekMap["/p"] = Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("old")}
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		if got := req.Header.Get("If-None-Match"); got != "" {
+			t.Fatalf("If-None-Match = %q, want none", got)
+		}
+		h := http.Header{}
+		h.Set("ETag", `W/"v2"`)
+		return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(strings.NewReader("new"))}, nil
+	}
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+	_ = c.Once(context.Background(), "/p", "/p", "", "warmup")
+	if got := rt.putCalls["/p"].ETag; got != `W/"v2"` {
+		t.Fatalf("stored ETag = %q", got)
+	}
+}
+
+func TestController_Once_DropsOversizedHeaderValues(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.maxHdr = 8
+	rt.doFunc = func(*http.Request) (*http.Response, error) {
+		h := http.Header{}
+		h.Set("X-Small", "ok")
+		h.Set("X-Huge", strings.Repeat("a", 64))
+		return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(strings.NewReader("body"))}, nil
+	}
+	errLog := &captureLogger{}
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, errLog)
+
+	_ = c.Once(context.Background(), "/p", "/p", "", "warmup")
+
+	ent := rt.putCalls["/p"]
+	if ent.Header.Get("X-Small") != "ok" || ent.Header.Get("X-Huge") != "" {
+		t.Fatalf("stored headers = %v", ent.Header)
+	}
+	if errLog.count() != 1 {
+		t.Fatalf("error log lines = %d, want 1", errLog.count())
+	}
+}
+
 func TestController_Once_Branches(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -165,6 +511,10 @@ func TestController_Once_Branches(t *testing.T) {
 		cur         Entry
 		respStatus  int
 		cacheCtl    string
+		vary        string
+		setCookie   bool
+		cookieOK    bool
+		staleOK     bool
 		body        string
 		sendMarkers bool
 		doErr       error
@@ -174,6 +524,7 @@ func TestController_Once_Branches(t *testing.T) {
 		wantChanged bool
 		wantDeleted bool
 		wantPut     bool
+		wantRefresh bool
 		wantReqHdr  bool
 	}{
 		{
@@ -196,7 +547,7 @@ func TestController_Once_Branches(t *testing.T) {
 			respStatus:  http.StatusOK,
 			body:        "same",
 			wantKind:    "unchanged",
-			wantPut:     true,
+			wantRefresh: true,
 			wantChanged: false,
 		},
 		{
@@ -209,6 +560,46 @@ func TestController_Once_Branches(t *testing.T) {
 			wantChanged: true,
 			wantDeleted: true,
 		},
+		{
+			name:        "inactive seed kept on transient status",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1, Inactive: true, DiscoveredBy: "sitemap"},
+			respStatus:  http.StatusBadGateway,
+			body:        "down",
+			wantKind:    "kept-inactive",
+			wantChanged: false,
+		},
+		{
+			name:        "inactive seed deleted on gone",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1, Inactive: true, DiscoveredBy: "sitemap"},
+			respStatus:  http.StatusGone,
+			body:        "gone",
+			wantKind:    "deleted",
+			wantChanged: true,
+			wantDeleted: true,
+		},
+		{
+			name:        "stale entry kept on 5xx within staleIfError",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1},
+			respStatus:  http.StatusServiceUnavailable,
+			staleOK:     true,
+			body:        "down",
+			wantKind:    "kept-stale",
+			wantChanged: false,
+		},
+		{
+			name:        "stale entry deleted on 404 despite staleIfError",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1},
+			respStatus:  http.StatusNotFound,
+			staleOK:     true,
+			body:        "missing",
+			wantKind:    "deleted",
+			wantChanged: true,
+			wantDeleted: true,
+		},
 		{
 			name:        "ignored status without current",
 			respStatus:  http.StatusNotFound,
@@ -228,10 +619,66 @@ func TestController_Once_Branches(t *testing.T) {
 			wantDeleted: true,
 		},
 		{
-			name:       "origin error",
-			doErr:      errors.New("origin down"),
-			wantKind:   "error",
-			wantChanged:false,
+			name:        "delete by private",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1},
+			respStatus:  http.StatusOK,
+			cacheCtl:    "private, max-age=60",
+			body:        "x",
+			wantKind:    "deleted",
+			wantChanged: true,
+			wantDeleted: true,
+		},
+		{
+			name:        "no-cache extension token is cacheable",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1},
+			respStatus:  http.StatusOK,
+			cacheCtl:    "no-cache-ext",
+			body:        "x",
+			wantKind:    "updated",
+			wantChanged: true,
+			wantPut:     true,
+		},
+		{
+			name:        "delete by vary any",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1},
+			respStatus:  http.StatusOK,
+			vary:        "*",
+			body:        "x",
+			wantKind:    "deleted",
+			wantChanged: true,
+			wantDeleted: true,
+		},
+		{
+			name:        "delete by set-cookie",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1},
+			respStatus:  http.StatusOK,
+			setCookie:   true,
+			body:        "x",
+			wantKind:    "deleted",
+			wantChanged: true,
+			wantDeleted: true,
+		},
+		{
+			name:        "set-cookie allowed by rule",
+			hasCur:      true,
+			cur:         Entry{Hash32: 1},
+			respStatus:  http.StatusOK,
+			setCookie:   true,
+			cookieOK:    true,
+			body:        "x",
+			wantKind:    "updated",
+			wantChanged: true,
+			wantPut:     true,
+		},
+		{
+			name:        "origin error",
+			doErr:       errors.New("origin down"),
+			wantKind:    "error",
+			wantChanged: false,
 		},
 		{
 			name:       "read error",
@@ -245,6 +692,8 @@ func TestController_Once_Branches(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			rt := newFakeRuntime()
 			rt.sendMarkers = tc.sendMarkers
+			rt.cookieOK = tc.cookieOK
+			rt.staleOK = tc.staleOK
 			if tc.hasCur {
 				rt.peekMap["/page"] = tc.cur
 			}
@@ -256,6 +705,12 @@ func TestController_Once_Branches(t *testing.T) {
 				if tc.cacheCtl != "" {
 					h.Set("Cache-Control", tc.cacheCtl)
 				}
+				if tc.vary != "" {
+					h.Set("Vary", tc.vary)
+				}
+				if tc.setCookie {
+					h.Set("Set-Cookie", "sid=1")
+				}
 				var body io.ReadCloser = io.NopCloser(strings.NewReader(tc.body))
 				if tc.readErr {
 					body = readErrBody{}
@@ -280,6 +735,12 @@ func TestController_Once_Branches(t *testing.T) {
 			if tc.wantPut != (len(rt.putCalls) == 1) {
 				t.Fatalf("putCalls = %d", len(rt.putCalls))
 			}
+			if tc.wantRefresh != (len(rt.refreshCalls) == 1) {
+				t.Fatalf("refreshCalls = %d", len(rt.refreshCalls))
+			}
+			if got := rt.putCalls["/page"].Header.Get("Set-Cookie"); got != "" {
+				t.Fatalf("stored Set-Cookie = %q, want it stripped", got)
+			}
 			if tc.wantReqHdr {
 				if len(rt.requests) != 1 {
 					t.Fatalf("requests = %d, want 1", len(rt.requests))
@@ -372,3 +833,289 @@ func TestController_WarmupGroupLoop_StopAndLogs(t *testing.T) {
 		t.Fatalf("expected warmup error log")
 	}
 }
+
+func TestController_WarmupGroupLoop_SummaryCountsOutcomes(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.access = map[string]int64{"/same": 3, "/new": 2, "/down": 1}
+	rt.peekMap["/same"] = Entry{Hash32: 1, ETag: `"v1"`}
+	rt.peekMap["/new"] = Entry{Hash32: 1}
+	rt.peekMap["/down"] = Entry{Hash32: 1}
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/same":
+			return &http.Response{StatusCode: http.StatusNotModified, Header: http.Header{}, Body: http.NoBody}, nil
+		case "/down":
+			return nil, errors.New("origin failed")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("updated"))}, nil
+	}
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	summaryLog := &captureLogger{}
+	c := NewController(rt, make(chan struct{}, 4), stopCh, &wg, true, summaryLog, nil, nil)
+	done := make(chan struct{})
+	go func() {
+		c.WarmupGroupLoop(WarmRule{Match: "/", WarmEvery: 10 * time.Millisecond, WarmMax: 3, Matches: func(string) bool { return true }})
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for summaryLog.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stopCh)
+	<-done
+	wg.Wait()
+
+	summaryLog.mu.Lock()
+	defer summaryLog.mu.Unlock()
+	if len(summaryLog.lines) == 0 {
+		t.Fatal("expected a warmup summary")
+	}
+	want := `Revalidated for match "/": 3 URLs (unchanged=1 notModified=1 updated=1 deleted=0 keptInactive=0 keptStale=0 ignoredStatus=0 ignoredCC=0 errors=1 updated+errors=2)`
+	if got := summaryLog.lines[0]; !strings.HasPrefix(got, want) {
+		t.Fatalf("summary = %q, want prefix %q", got, want)
+	}
+}
+
+func TestController_WarmupGroupLoop_StopsOnRuleStop(t *testing.T) {
+	rt := newFakeRuntime()
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+
+	ruleStop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.WarmupGroupLoop(WarmRule{Match: "/", WarmEvery: 10 * time.Millisecond, WarmMax: 1, Matches: func(string) bool { return true }, Stop: ruleStop})
+		close(done)
+	}()
+
+	close(ruleStop)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("warmup loop did not stop on its rule stop channel")
+	}
+}
+
+func TestController_WarmupGroupLoop_PausesOutsideSchedule(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.access = map[string]int64{"/x": 10}
+	rt.peekMap["/x"] = Entry{Hash32: 1}
+	fetched := make(chan struct{}, 8)
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		fetched <- struct{}{}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("updated"))}, nil
+	}
+
+	// A one-hour window starting two hours from now is closed for the
+	// whole test.
+	now := time.Now().UTC()
+	start := (now.Hour()+2)%24*60 + now.Minute()
+	closed := Window{Start: start, End: (start + 60) % (24 * 60), Loc: time.UTC}
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), stopCh, &wg, false, nil, nil, nil)
+	done := make(chan struct{})
+	go func() {
+		c.WarmupGroupLoop(WarmRule{Match: "/", WarmEvery: 5 * time.Millisecond, WarmMax: 1, Matches: func(string) bool { return true }, Schedule: closed})
+		close(done)
+	}()
+
+	select {
+	case <-fetched:
+		t.Fatal("warmup fetched outside its schedule")
+	case <-time.After(60 * time.Millisecond):
+	}
+	close(stopCh)
+	<-done
+	wg.Wait()
+}
+
+func TestController_WarmupGroupLoop_ReplaysQueryFromKey(t *testing.T) {
+	rt := newFakeRuntime()
+	key := "/search#%40q=q%3Dcats"
+	rt.access = map[string]int64{key: 10}
+	rt.peekMap[key] = Entry{Hash32: 1}
+	queries := make(chan string, 8)
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		queries <- req.URL.Path + "?" + req.URL.RawQuery
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("updated"))}, nil
+	}
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 2), stopCh, &wg, false, nil, nil, nil)
+	done := make(chan struct{})
+	go func() {
+		c.WarmupGroupLoop(WarmRule{Match: "/", WarmEvery: 10 * time.Millisecond, WarmMax: 1, Matches: func(string) bool { return true }})
+		close(done)
+	}()
+
+	select {
+	case got := <-queries:
+		if got != "/search?q=cats" {
+			t.Fatalf("warmup request = %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("warmup did not request origin")
+	}
+	close(stopCh)
+	<-done
+	wg.Wait()
+}
+
+func TestController_WarmupGroupLoop_MaxPerRunResumesNextTick(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.access = map[string]int64{"/a": 3, "/b": 2, "/c": 1}
+	for k := range rt.access {
+		rt.peekMap[k] = Entry{Hash32: 1}
+	}
+	paths := make(chan string, 16)
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		paths <- req.URL.Path
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("updated"))}, nil
+	}
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 4), stopCh, &wg, false, nil, nil, nil)
+	done := make(chan struct{})
+	go func() {
+		c.WarmupGroupLoop(WarmRule{Match: "/", WarmEvery: 50 * time.Millisecond, WarmMax: 4, MaxPerRun: 1, Matches: func(string) bool { return true }})
+		close(done)
+	}()
+
+	next := func() string {
+		select {
+		case p := <-paths:
+			return p
+		case <-time.After(2 * time.Second):
+			t.Fatal("warmup did not request origin")
+			return ""
+		}
+	}
+	for _, want := range []string{"/a", "/b", "/c"} {
+		if got := next(); got != want {
+			t.Fatalf("warmup request = %q, want %q", got, want)
+		}
+		select {
+		case p := <-paths:
+			t.Fatalf("second request %q in the same tick", p)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	close(stopCh)
+	<-done
+	wg.Wait()
+}
+
+func TestController_WarmupGroupLoop_HonorsPerRuleConcurrency(t *testing.T) {
+	rt := newFakeRuntime()
+	for i := 0; i < 12; i++ {
+		for _, section := range []string{"/heavy/", "/light/"} {
+			k := section + strconv.Itoa(i)
+			rt.access[k] = int64(i + 1)
+			rt.peekMap[k] = Entry{Hash32: 1}
+		}
+	}
+	var mu sync.Mutex
+	inflight := map[string]int{}
+	peak := map[string]int{}
+	finished := make(chan struct{}, 24)
+	rt.doFunc = func(req *http.Request) (*http.Response, error) {
+		section := strings.SplitN(req.URL.Path, "/", 3)[1]
+		mu.Lock()
+		inflight[section]++
+		peak[section] = max(peak[section], inflight[section])
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inflight[section]--
+		mu.Unlock()
+		select {
+		case finished <- struct{}{}:
+		default:
+			// A later tick warming the keys again.
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 32), stopCh, &wg, false, nil, nil, nil)
+	var loops sync.WaitGroup
+	for section, n := range map[string]int{"/heavy/": 2, "/light/": 6} {
+		section := section
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			c.WarmupGroupLoop(WarmRule{Match: section, WarmEvery: 50 * time.Millisecond, WarmMax: n, Matches: func(p string) bool { return strings.HasPrefix(p, section) }})
+		}()
+	}
+	for i := 0; i < 24; i++ {
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("warmup finished %d of 24 requests", i)
+		}
+	}
+	close(stopCh)
+	loops.Wait()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if peak["heavy"] > 2 || peak["light"] > 6 {
+		t.Fatalf("peak in-flight heavy=%d light=%d, want at most 2 and 6", peak["heavy"], peak["light"])
+	}
+	if peak["light"] <= 2 {
+		t.Fatalf("light peak = %d, want the lighter section warmed with more concurrency", peak["light"])
+	}
+}
+
+func TestWarmRule_EffectiveMax(t *testing.T) {
+	start := time.Unix(1000, 0)
+	r := WarmRule{WarmMax: 9, RampStart: start, RampUp: 8 * time.Minute}
+	tests := []struct {
+		at   time.Duration
+		want int
+	}{
+		{at: -time.Second, want: 1},
+		{at: 0, want: 1},
+		{at: 2 * time.Minute, want: 3},
+		{at: 4 * time.Minute, want: 5},
+		{at: 8 * time.Minute, want: 9},
+		{at: time.Hour, want: 9},
+	}
+	for _, tc := range tests {
+		if got := r.EffectiveMax(start.Add(tc.at)); got != tc.want {
+			t.Fatalf("EffectiveMax(+%v) = %d, want %d", tc.at, got, tc.want)
+		}
+	}
+
+	if got := (WarmRule{WarmMax: 4}).EffectiveMax(start); got != 4 {
+		t.Fatalf("no ramp EffectiveMax = %d, want 4", got)
+	}
+}
+
+func BenchmarkController_AllKeysSnapshot(b *testing.B) {
+	rt := newFakeRuntime()
+	// 1M keys, with the first quarter repeated as if held in both tiers.
+	for i := 0; i < 1_000_000; i++ {
+		rt.allKeys = append(rt.allKeys, "/p/"+strconv.Itoa(i))
+	}
+	rt.allKeys = append(rt.allKeys, rt.allKeys[:250_000]...)
+
+	var wg sync.WaitGroup
+	c := NewController(rt, make(chan struct{}, 1), make(chan struct{}), &wg, false, nil, nil, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if got := len(c.AllKeysSnapshot()); got != 1_000_000 {
+			b.Fatalf("len = %d, want 1000000", got)
+		}
+	}
+}
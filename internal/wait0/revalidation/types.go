@@ -18,6 +18,15 @@ type Entry struct {
 
 	RevalidatedAt int64
 	RevalidatedBy string
+
+	// MaxAge is the origin freshness lifetime in seconds left at StoredAt,
+	// net of any upstream Age (see freshness.FromHeader).
+	MaxAge int64
+	// ETag is the origin's validator, sent as If-None-Match on revalidation.
+	ETag string
+	// LastModified is the origin's validator, sent as If-Modified-Since on
+	// revalidation.
+	LastModified string
 }
 
 type Result struct {
@@ -30,6 +39,23 @@ type Result struct {
 
 	Kind string
 	Err  string
+	// NotModified is set on "unchanged" results where origin answered the
+	// conditional request with 304.
+	NotModified bool
+}
+
+// Change describes an entry whose body hash changed on revalidation.
+type Change struct {
+	Key  string
+	Path string
+	URI  string
+	// HadOld is false when no entry was cached before, leaving OldHash zero.
+	HadOld  bool
+	OldHash uint32
+	NewHash uint32
+	Bytes   int
+	By      string
+	At      time.Time
 }
 
 type WarmRule struct {
@@ -37,6 +63,36 @@ type WarmRule struct {
 	WarmEvery time.Duration
 	WarmMax   int
 	Matches   func(path string) bool
+
+	// MaxPerRun caps how many keys are revalidated per WarmEvery tick; the
+	// rest stay queued, in order, for the next tick. Zero is unlimited.
+	MaxPerRun int
+
+	// RampUp grows concurrency linearly from 1 to WarmMax over this long
+	// after RampStart. Zero runs at WarmMax from the start.
+	RampStart time.Time
+	RampUp    time.Duration
+
+	// Schedule limits warmup to a daily time window; outside it no keys
+	// are queued or dispatched. The zero Window never pauses.
+	Schedule Window
+
+	// Stop, once closed, ends the loop like the controller's stop channel
+	// does, so a config reload can replace it. Nil never fires.
+	Stop <-chan struct{}
+}
+
+// EffectiveMax returns the warmup concurrency allowed at now.
+func (r WarmRule) EffectiveMax(now time.Time) int {
+	elapsed := now.Sub(r.RampStart)
+	if r.RampUp <= 0 || elapsed >= r.RampUp || r.WarmMax <= 1 {
+		return r.WarmMax
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	n := 1 + int(int64(r.WarmMax-1)*int64(elapsed)/int64(r.RampUp))
+	return min(n, r.WarmMax)
 }
 
 type WarmupSummary struct {
@@ -51,7 +107,12 @@ type WarmupSummary struct {
 	Unchanged           int
 	Updated             int
 	Deleted             int
+	KeptInactive        int
+	KeptStale           int
 	IgnoredStatus       int
 	IgnoredCacheControl int
 	Errors              int
+
+	// NotModified counts the Unchanged revalidations origin answered with 304.
+	NotModified int
 }
@@ -0,0 +1,68 @@
+package revalidation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily time-of-day range in which warmup may run. The zero
+// Window is always open.
+type Window struct {
+	// Start and End are minutes since midnight; End before Start wraps
+	// past midnight, as in 22:00-04:00.
+	Start, End int
+	Loc        *time.Location
+}
+
+// ParseWindow parses an "HH:MM-HH:MM" range evaluated in loc, or in the
+// server's local time zone when loc is nil.
+func ParseWindow(spec string, loc *time.Location) (Window, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return Window{}, fmt.Errorf("want HH:MM-HH:MM, got %q", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return Window{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return Window{}, err
+	}
+	if start == end {
+		return Window{}, fmt.Errorf("start and end must differ, got %q", spec)
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	return Window{Start: start, End: end, Loc: loc}, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q, want HH:MM", strings.TrimSpace(s))
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Open reports whether now falls inside the window.
+func (w Window) Open(now time.Time) bool {
+	if w.Loc == nil {
+		return true
+	}
+	now = now.In(w.Loc)
+	m := now.Hour()*60 + now.Minute()
+	if w.Start < w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+func (w Window) String() string {
+	if w.Loc == nil {
+		return "always"
+	}
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %s", w.Start/60, w.Start%60, w.End/60, w.End%60, w.Loc)
+}
@@ -0,0 +1,68 @@
+package revalidation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	w, err := ParseWindow(" 01:00-05:30 ", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseWindow: %v", err)
+	}
+	if w.Start != 60 || w.End != 330 || w.Loc != time.UTC {
+		t.Fatalf("window = %+v", w)
+	}
+	if got := w.String(); got != "01:00-05:30 UTC" {
+		t.Fatalf("String = %q", got)
+	}
+
+	if w, err := ParseWindow("01:00-05:00", nil); err != nil || w.Loc != time.Local {
+		t.Fatalf("nil loc: window = %+v, err = %v", w, err)
+	}
+
+	for _, bad := range []string{"", "01:00", "1am-5am", "25:00-05:00", "01:00-01:00"} {
+		if _, err := ParseWindow(bad, time.UTC); err == nil {
+			t.Fatalf("ParseWindow(%q): expected error", bad)
+		}
+	}
+}
+
+func TestWindow_Open(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2024, 1, 1, h, m, 0, 0, time.UTC) }
+
+	night := Window{Start: 60, End: 300, Loc: time.UTC}
+	for _, tc := range []struct {
+		now  time.Time
+		want bool
+	}{
+		{at(0, 59), false},
+		{at(1, 0), true},
+		{at(4, 59), true},
+		{at(5, 0), false},
+		{at(12, 0), false},
+	} {
+		if got := night.Open(tc.now); got != tc.want {
+			t.Fatalf("01:00-05:00 Open(%s) = %v, want %v", tc.now.Format("15:04"), got, tc.want)
+		}
+	}
+
+	wrap := Window{Start: 22 * 60, End: 4 * 60, Loc: time.UTC}
+	if !wrap.Open(at(23, 0)) || !wrap.Open(at(3, 0)) || wrap.Open(at(12, 0)) {
+		t.Fatal("22:00-04:00 should wrap past midnight")
+	}
+
+	// The window is read in its own zone: 02:00 UTC is 21:00 the day
+	// before in New York, outside 01:00-05:00 there.
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	if (Window{Start: 60, End: 300, Loc: ny}).Open(at(2, 0)) {
+		t.Fatal("window should be evaluated in its location")
+	}
+
+	if !(Window{}).Open(at(12, 0)) {
+		t.Fatal("zero window should always be open")
+	}
+}
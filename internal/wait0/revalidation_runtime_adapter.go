@@ -2,7 +2,10 @@ package wait0
 
 import (
 	"net/http"
+	"time"
 
+	"wait0/internal/wait0/cachekey"
+	"wait0/internal/wait0/events"
 	"wait0/internal/wait0/proxy"
 	"wait0/internal/wait0/revalidation"
 )
@@ -26,9 +29,23 @@ func (a *revalidationRuntimeAdapter) Peek(key string) (revalidation.Entry, bool)
 }
 
 func (a *revalidationRuntimeAdapter) Put(key string, ent revalidation.Entry) {
-	v := fromRevalEntry(ent)
-	a.s.ram.Put(key, v, a.s.disk, a.s.overflowLog)
-	a.s.disk.PutAsync(key, v)
+	srv := a.s.config().Server
+	proxy.FillContentType(ent.Header, ent.Body, srv.DefaultContentType, srv.SniffContentType)
+	a.s.storeEntry(key, fromRevalEntry(ent), a.s.tierFor(cachekey.Path(key)))
+}
+
+// Refresh bumps the timestamps of the cached copies without rewriting the
+// body: RAM is updated in place and disk only rewrites the key's metadata.
+// When neither tier holds the key the entry is stored normally so the bump is
+// not lost.
+func (a *revalidationRuntimeAdapter) Refresh(key string, ent revalidation.Entry) {
+	cent := fromRevalEntry(ent)
+	inRAM := a.s.ram.Refresh(key, cent)
+	onDisk := a.s.disk.Refresh(key, cent)
+	if inRAM || onDisk {
+		return
+	}
+	a.Put(key, ent)
 }
 
 func (a *revalidationRuntimeAdapter) Delete(key string) {
@@ -51,26 +68,40 @@ func (a *revalidationRuntimeAdapter) SnapshotAccessTimes() map[string]int64 {
 	return out
 }
 
-func (a *revalidationRuntimeAdapter) AllKeys() []string {
-	m := map[string]struct{}{}
-	for _, k := range a.s.ram.Keys() {
-		m[k] = struct{}{}
-	}
-	for _, k := range a.s.disk.Keys() {
-		m[k] = struct{}{}
-	}
-	out := make([]string, 0, len(m))
-	for k := range m {
-		out = append(out, k)
+// ForEachKey yields RAM keys, then disk keys; keys held in both tiers are
+// yielded twice and callers dedupe.
+func (a *revalidationRuntimeAdapter) ForEachKey(fn func(key string) bool) {
+	stopped := false
+	a.s.ram.ForEach(func(k string) bool {
+		if !fn(k) {
+			stopped = true
+		}
+		return !stopped
+	})
+	if stopped {
+		return
 	}
-	return out
+	a.s.disk.ForEach(fn)
+}
+
+func (a *revalidationRuntimeAdapter) Origin(path string) string {
+	return a.s.config().originFor(path)
 }
 
-func (a *revalidationRuntimeAdapter) Origin() string {
-	return a.s.cfg.Server.Origin
+func (a *revalidationRuntimeAdapter) OriginTimeout(path string) time.Duration {
+	return a.s.config().originTimeout(path)
+}
+
+func (a *revalidationRuntimeAdapter) AcceptEncoding() string {
+	return a.s.config().Server.Upstream.AcceptEncoding
+}
+
+func (a *revalidationRuntimeAdapter) MaxHeaderValueBytes() int64 {
+	return a.s.config().Server.Upstream.maxHeaderValueBytes
 }
 
 func (a *revalidationRuntimeAdapter) Do(req *http.Request) (*http.Response, error) {
+	debugSleep(req.Context(), a.s.config().Debug.originDelayDur)
 	return a.s.httpClient.Do(req)
 }
 
@@ -78,6 +109,20 @@ func (a *revalidationRuntimeAdapter) SendRevalidateMarkers() bool {
 	return a.s.sendRevalidateMarkers
 }
 
+func (a *revalidationRuntimeAdapter) PreserveHost() bool {
+	return a.s.config().Server.PreserveHost
+}
+
+func (a *revalidationRuntimeAdapter) CacheWithSetCookie(path string) bool {
+	r := a.s.pickRule(path)
+	return r != nil && r.CacheWithSetCookie
+}
+
+func (a *revalidationRuntimeAdapter) ServeStaleOnError(path string, ent revalidation.Entry) bool {
+	rule := (&proxyRuntimeAdapter{s: a.s}).PickRule(path)
+	return rule.ServableOnError(proxy.Entry{Status: ent.Status, StoredAt: ent.StoredAt, Inactive: ent.Inactive, MaxAge: ent.MaxAge})
+}
+
 func (a *revalidationRuntimeAdapter) RandomString(n int) string {
 	return randomString(n)
 }
@@ -93,6 +138,9 @@ func toRevalEntry(ent CacheEntry) revalidation.Entry {
 		DiscoveredBy:  ent.DiscoveredBy,
 		RevalidatedAt: ent.RevalidatedAt,
 		RevalidatedBy: ent.RevalidatedBy,
+		MaxAge:        ent.MaxAge,
+		ETag:          ent.ETag,
+		LastModified:  ent.LastModified,
 	}
 }
 
@@ -107,5 +155,26 @@ func fromRevalEntry(ent revalidation.Entry) CacheEntry {
 		DiscoveredBy:  ent.DiscoveredBy,
 		RevalidatedAt: ent.RevalidatedAt,
 		RevalidatedBy: ent.RevalidatedBy,
+		MaxAge:        ent.MaxAge,
+		ETag:          ent.ETag,
+		LastModified:  ent.LastModified,
+	}
+}
+
+// sendChangeEvent forwards a revalidation that changed an entry's body to the
+// event webhook.
+func (s *Service) sendChangeEvent(ch revalidation.Change) {
+	ev := events.Event{
+		Type:    events.ContentChanged,
+		Path:    ch.Path,
+		URI:     ch.URI,
+		NewHash: events.FormatHash(ch.NewHash),
+		Bytes:   ch.Bytes,
+		By:      ch.By,
+		At:      ch.At.UTC().Format(time.RFC3339Nano),
+	}
+	if ch.HadOld {
+		ev.OldHash = events.FormatHash(ch.OldHash)
 	}
+	s.events.Send(ev)
 }
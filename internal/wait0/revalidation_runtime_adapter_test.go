@@ -1,11 +1,15 @@
 package wait0
 
 import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"wait0/internal/wait0/events"
 	"wait0/internal/wait0/revalidation"
 )
 
@@ -42,6 +46,30 @@ func TestRevalidationRuntimeAdapter_PeekPutDelete(t *testing.T) {
 	}
 }
 
+func TestRevalidationRuntimeAdapter_RefreshTouchesBothTiers(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	a := newRevalidationRuntimeAdapter(s)
+
+	a.Put("/both", revalidation.Entry{Status: 200, Header: http.Header{}, Body: []byte("b"), StoredAt: 1})
+	waitForAdapter(t, func() bool { _, ok := s.disk.Peek("/both"); return ok })
+	a.Refresh("/both", revalidation.Entry{Status: 200, Header: http.Header{}, Body: []byte("b"), StoredAt: 50})
+	if ent, _ := s.ram.Peek("/both"); ent.StoredAt != 50 {
+		t.Fatalf("RAM StoredAt = %d, want 50", ent.StoredAt)
+	}
+	waitForAdapter(t, func() bool { ent, _ := s.disk.Peek("/both"); return ent.StoredAt == 50 })
+
+	s.disk.PutAsync("/disk", CacheEntry{Status: 200, Header: http.Header{}, Body: []byte("d"), StoredAt: 1})
+	waitForAdapter(t, func() bool { return s.disk.HasKey("/disk") })
+	a.Refresh("/disk", revalidation.Entry{Status: 200, Header: http.Header{}, Body: []byte("d"), StoredAt: 60})
+	waitForAdapter(t, func() bool { ent, _ := s.disk.Peek("/disk"); return ent.StoredAt == 60 })
+	if _, ok := s.ram.Peek("/disk"); ok {
+		t.Fatalf("refresh of a disk-only key should not populate RAM")
+	}
+
+	a.Refresh("/absent", revalidation.Entry{Status: 200, Header: http.Header{}, Body: []byte("n"), StoredAt: 70})
+	waitForAdapter(t, func() bool { ent, ok := s.disk.Peek("/absent"); return ok && ent.StoredAt == 70 })
+}
+
 func TestRevalidationRuntimeAdapter_SnapshotsAndHelpers(t *testing.T) {
 	s := newTestService(t, "http://example.com", nil)
 	a := newRevalidationRuntimeAdapter(s)
@@ -55,18 +83,30 @@ func TestRevalidationRuntimeAdapter_SnapshotsAndHelpers(t *testing.T) {
 	if len(m) == 0 {
 		t.Fatalf("expected snapshot map")
 	}
-	waitForAdapter(t, func() bool { return len(a.AllKeys()) >= 2 })
-	keys := a.AllKeys()
-	if len(keys) < 2 {
-		t.Fatalf("expected deduped keys, got %v", keys)
+	keys := func() []string {
+		var out []string
+		a.ForEachKey(func(k string) bool { out = append(out, k); return true })
+		return out
+	}
+	waitForAdapter(t, func() bool { return len(keys()) >= 3 })
+	if got := keys(); len(got) != 3 {
+		t.Fatalf("expected RAM and disk keys, got %v", got)
+	}
+	first := 0
+	a.ForEachKey(func(string) bool { first++; return false })
+	if first != 1 {
+		t.Fatalf("ForEachKey should stop when fn returns false, got %d calls", first)
 	}
 
-	if a.Origin() != "http://example.com" {
-		t.Fatalf("origin = %q", a.Origin())
+	if got := a.Origin("/x"); got != "http://example.com" {
+		t.Fatalf("origin = %q", got)
 	}
 	if !a.SendRevalidateMarkers() {
 		t.Fatalf("expected send markers true")
 	}
+	if a.PreserveHost() {
+		t.Fatalf("preserveHost should default to false")
+	}
 	if got := a.RandomString(8); len(got) != 8 {
 		t.Fatalf("random string length = %d", len(got))
 	}
@@ -106,4 +146,126 @@ func TestRevalidationConverters_CopyData(t *testing.T) {
 	if rent.Header.Get("X") != "2" {
 		t.Fatalf("expected deep copy in fromRevalEntry")
 	}
+
+	if got := fromRevalEntry(toRevalEntry(CacheEntry{ETag: `"e"`})).ETag; got != `"e"` {
+		t.Fatalf("ETag lost in conversion: %q", got)
+	}
+}
+
+func TestRevalidation_ConditionalAgainstOrigin(t *testing.T) {
+	var full, notModified int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("page"))
+	}))
+	defer origin.Close()
+
+	s := newTestService(t, origin.URL, nil)
+	if res := s.reval.Once(context.Background(), "/p", "/p", "", "warmup"); res.Kind != "updated" {
+		t.Fatalf("first revalidation = %q, want updated", res.Kind)
+	}
+	if res := s.reval.Once(context.Background(), "/p", "/p", "", "warmup"); res.Kind != "unchanged" {
+		t.Fatalf("second revalidation = %q, want unchanged", res.Kind)
+	}
+	if full != 1 || notModified != 1 {
+		t.Fatalf("origin full=%d notModified=%d, want 1 and 1", full, notModified)
+	}
+	ent, ok := s.ram.Peek("/p")
+	if !ok || string(ent.Body) != "page" || ent.ETag != `"v1"` {
+		t.Fatalf("cached = %+v ok=%v", ent, ok)
+	}
+}
+
+func TestRevalidationRuntimeAdapter_PutFillsContentType(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	s.config().Server.DefaultContentType = "application/octet-stream"
+	a := newRevalidationRuntimeAdapter(s)
+
+	a.Put("/p", revalidation.Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("raw")})
+	got, ok := s.ram.Peek("/p")
+	if !ok || got.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("stored Content-Type = %q (ok=%v), want default", got.Header.Get("Content-Type"), ok)
+	}
+}
+
+func TestRevalidation_StaleIfErrorKeepsEntry(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer origin.Close()
+
+	rule := mustRule(t, "PathPrefix(/)")
+	rule.expDur = time.Minute
+	rule.staleErrDur = time.Hour
+	s := newTestService(t, origin.URL, []Rule{rule})
+	stale := CacheEntry{Status: http.StatusOK, Header: http.Header{}, Body: []byte("old"), StoredAt: time.Now().Add(-30 * time.Minute).Unix()}
+	s.ram.Put("/p", stale, s.disk, s.overflowLog)
+
+	if res := s.reval.Once(context.Background(), "/p", "/p", "", "warmup"); res.Kind != "kept-stale" {
+		t.Fatalf("revalidation within window = %q, want kept-stale", res.Kind)
+	}
+	if _, ok := s.ram.Peek("/p"); !ok {
+		t.Fatalf("entry deleted within staleIfError window")
+	}
+
+	stale.StoredAt = time.Now().Add(-2 * time.Hour).Unix()
+	s.ram.Put("/p", stale, s.disk, s.overflowLog)
+	if res := s.reval.Once(context.Background(), "/p", "/p", "", "warmup"); res.Kind != "deleted" {
+		t.Fatalf("revalidation past window = %q, want deleted", res.Kind)
+	}
+}
+
+func TestRevalidation_ChangeEventReachesWebhook(t *testing.T) {
+	body := "v1"
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer origin.Close()
+	got := make(chan events.Event, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev events.Event
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		got <- ev
+	}))
+	defer hook.Close()
+
+	s := newTestService(t, origin.URL, nil)
+	s.events = events.NewWebhook(hook.URL, nil, nil)
+	s.events.Start(s.stopCh, &s.wg)
+	s.reval.SetChangeObserver(s.sendChangeEvent)
+
+	s.reval.Once(context.Background(), "/p", "/p", "", "warmup")
+	s.reval.Once(context.Background(), "/p", "/p", "", "warmup")
+	body = "v2"
+	s.reval.Once(context.Background(), "/p", "/p", "", "user")
+
+	want := []events.Event{
+		{Type: events.ContentChanged, Path: "/p", URI: "/p", NewHash: events.FormatHash(crc32.ChecksumIEEE([]byte("v1"))), Bytes: 2, By: "warmup"},
+		{Type: events.ContentChanged, Path: "/p", URI: "/p", OldHash: events.FormatHash(crc32.ChecksumIEEE([]byte("v1"))), NewHash: events.FormatHash(crc32.ChecksumIEEE([]byte("v2"))), Bytes: 2, By: "user"},
+	}
+	for i, w := range want {
+		select {
+		case ev := <-got:
+			if _, err := time.Parse(time.RFC3339Nano, ev.At); err != nil {
+				t.Fatalf("event %d at = %q: %v", i, ev.At, err)
+			}
+			ev.At = ""
+			if ev != w {
+				t.Fatalf("event %d = %+v, want %+v", i, ev, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %d not delivered", i)
+		}
+	}
+	select {
+	case ev := <-got:
+		t.Fatalf("unexpected event for an unchanged body: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
 }
@@ -1,27 +1,42 @@
 package wait0
 
 import (
+	"context"
+	"errors"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"wait0/internal/wait0/auth"
+	"wait0/internal/wait0/cache"
 	"wait0/internal/wait0/dashboard"
 	"wait0/internal/wait0/discovery"
+	"wait0/internal/wait0/events"
 	"wait0/internal/wait0/invalidation"
+	"wait0/internal/wait0/logging"
 	"wait0/internal/wait0/proxy"
 	"wait0/internal/wait0/revalidation"
 	"wait0/internal/wait0/statapi"
 	wstats "wait0/internal/wait0/stats"
 )
 
+// diskGuardEvery is how often free space is probed when storage.disk.minFree
+// is set.
+const diskGuardEvery = 10 * time.Second
+
 type Service struct {
-	cfg Config
+	// cfg holds the active configuration snapshot. Reload swaps it atomically,
+	// so readers load it once via config() and use that snapshot throughout.
+	cfg atomic.Pointer[Config]
 
+	// httpClient sets no Timeout: each origin request is bounded by the live
+	// originTimeout for its path, so a reload changes it.
 	httpClient *http.Client
 
 	ram  *ramCache
@@ -32,21 +47,35 @@ type Service struct {
 	stopCh chan struct{}
 	wg     sync.WaitGroup
 
+	// warmStop stops the warmup loops started last; a reload that changes
+	// warmup settings closes it and starts new loops. warmStarted anchors
+	// rampUp across such restarts.
+	warmMu      sync.Mutex
+	warmStop    chan struct{}
+	warmStarted time.Time
+
 	overflowLog  *wstats.RateLimitedLogger
 	unchangedLog *wstats.RateLimitedLogger
 	errorLog     *wstats.RateLimitedLogger
+	authFailLog  *wstats.RateLimitedLogger
 
 	sendRevalidateMarkers bool
 
 	stats *wstats.Collector
+	// connTrace is set when server.upstream.traceConnections is on.
+	connTrace *wstats.ConnTracker
 
 	invAuth *auth.Authenticator
-	inv     *invalidation.Controller
-	stat    *statapi.Controller
-	dash    *dashboard.Controller
-	proxy   *proxy.Controller
-	reval   *revalidation.Controller
-	disco   *discovery.Controller
+	// adminAuthFailures counts requests gateAdmin failed to authenticate.
+	adminAuthFailures atomic.Uint64
+
+	inv    *invalidation.Controller
+	stat   *statapi.Controller
+	events *events.Webhook
+	dash   *dashboard.Controller
+	proxy  *proxy.Controller
+	reval  *revalidation.Controller
+	disco  *discovery.Controller
 }
 
 func envBool(name string, def bool) bool {
@@ -61,6 +90,10 @@ func envBool(name string, def bool) bool {
 	return b
 }
 
+// defaultDiskPath is the LevelDB directory of the disk cache, relative to the
+// working directory.
+const defaultDiskPath = "./data/leveldb"
+
 func envInt(name string, def int) int {
 	v, ok := os.LookupEnv(name)
 	if !ok || strings.TrimSpace(v) == "" {
@@ -101,24 +134,44 @@ func NewService(cfg Config) (*Service, error) {
 	// Disk cache is explicitly invalidated on every restart.
 	// This is done efficiently by deleting the LevelDB directory before opening.
 	invalidateDiskOnStart := envBool("WAIT0_INVALIDATE_DISK_CACHE_ON_START", true)
-	disk, err := newDiskCache("./data/leveldb", diskMax, invalidateDiskOnStart)
+	diskPath := cfg.Storage.Disk.path
+	if diskPath == "" {
+		diskPath = defaultDiskPath
+	}
+	disk, err := newDiskCache(diskPath, diskMax, invalidateDiskOnStart)
 	if err != nil {
 		return nil, err
 	}
 
+	disk.inner.SetMaxConcurrentReads(cfg.Storage.Disk.MaxConcurrentReads)
+	disk.inner.SetCompactAfter(cfg.Storage.Disk.compactAfterBytes)
+	disk.inner.SetPromotion(cfg.Storage.RAM.PromoteAfterHits, cfg.Storage.RAM.promoteWindowDur)
+	disk.inner.SetMaxEntryPercent(cfg.Storage.Disk.MaxEntryPercent)
+	ram := newRAMCache(ramMax)
+	ram.inner.SetMaxEntryPercent(cfg.Storage.RAM.MaxEntryPercent)
+
 	s := &Service{
-		cfg:                   cfg,
-		httpClient:            &http.Client{Timeout: 30 * time.Second},
-		ram:                   newRAMCache(ramMax),
+		httpClient:            &http.Client{Transport: newOriginTransport(&cfg)},
+		ram:                   ram,
 		disk:                  disk,
 		bgSem:                 make(chan struct{}, 32),
 		stopCh:                make(chan struct{}),
-		overflowLog:           wstats.NewRateLimitedLogger(1 * time.Minute),
-		unchangedLog:          wstats.NewRateLimitedLogger(10 * time.Second),
-		errorLog:              wstats.NewRateLimitedLogger(10 * time.Second),
+		overflowLog:           wstats.NewRateLimitedLogger(1*time.Minute, logging.At(logging.LevelWarn)),
+		unchangedLog:          wstats.NewRateLimitedLogger(10*time.Second, logging.At(logging.LevelInfo)),
+		errorLog:              wstats.NewRateLimitedLogger(10*time.Second, logging.At(logging.LevelError)),
+		authFailLog:           wstats.NewRateLimitedLogger(10*time.Second, logging.At(logging.LevelWarn)),
 		sendRevalidateMarkers: envBool("WAIT0_SEND_REVALIDATE_MARKERS", true),
 		stats:                 wstats.NewCollector(),
 	}
+	s.cfg.Store(&cfg)
+	s.httpClient.Transport = headerTransport{next: s.httpClient.Transport, config: s.config}
+	applyLogLevel(&cfg)
+	warnDebug(&cfg)
+	warnInsecureTLS(&cfg)
+	if cfg.Server.Upstream.TraceConnections {
+		s.connTrace = wstats.NewConnTracker()
+		s.httpClient.Transport = s.connTrace.Transport(s.httpClient.Transport)
+	}
 
 	authCfgs := make([]auth.TokenConfig, 0, len(cfg.Auth.Tokens))
 	for _, t := range cfg.Auth.Tokens {
@@ -152,27 +205,55 @@ func NewService(cfg Config) (*Service, error) {
 		s.stopCh,
 		&s.wg,
 		cfg.Logging.LogWarmUp,
-		log.Default(),
+		logging.At(logging.LevelInfo),
 		s.unchangedLog,
 		s.errorLog,
 	)
 	s.reval.SetDurationObserver(s.stats.ObserveRefreshDuration)
+	if cfg.Logging.EventWebhook != "" {
+		s.events = events.NewWebhook(cfg.Logging.EventWebhook, nil, wstats.NewRateLimitedLogger(time.Minute, logging.At(logging.LevelWarn)))
+		s.events.Start(s.stopCh, &s.wg)
+		s.reval.SetChangeObserver(s.sendChangeEvent)
+		logging.Infof("event webhook enabled: url=%q", cfg.Logging.EventWebhook)
+	}
 	s.proxy = proxy.NewController(newProxyRuntimeAdapter(s))
 	s.disco = discovery.NewController(
 		discovery.Config{
 			Origin:          cfg.Server.Origin,
 			Sitemaps:        append([]string(nil), cfg.URLsDiscover.Sitemaps...),
+			FromRobots:      cfg.URLsDiscover.FromRobots,
 			InitialDelay:    cfg.URLsDiscover.initialDelayDur,
 			RediscoverEvery: cfg.URLsDiscover.rediscoverEveryDur,
 			LogAutodiscover: cfg.Logging.LogURLAutodiscover,
+			Incremental:     cfg.URLsDiscover.Incremental,
+			MaxSeeded:       cfg.URLsDiscover.MaxSeeded,
+			MaxSitemaps:     cfg.URLsDiscover.MaxSitemaps,
+			MaxURLs:         cfg.URLsDiscover.MaxURLs,
+			SeedTTL:         cfg.URLsDiscover.seedTTLDur,
 		},
 		newDiscoveryRuntimeAdapter(s),
 		s.stopCh,
 		&s.wg,
-		log.Default(),
+		logging.At(logging.LevelInfo),
 	)
+	s.disco.SetErrorLog(logging.At(logging.LevelWarn))
 	if cfg.Server.Invalidation.Enabled {
-		log.Printf("invalidation API enabled: queueSize=%d workers=%d maxBodyBytes=%d maxPaths=%d maxTags=%d hardLimits=%t", cfg.Server.Invalidation.QueueSize, cfg.Server.Invalidation.WorkerConcurrency, cfg.Server.Invalidation.MaxBodyBytes, cfg.Server.Invalidation.MaxPaths, cfg.Server.Invalidation.MaxTags, cfg.Server.Invalidation.HardLimits)
+		logging.Infof("invalidation API enabled: queueSize=%d workers=%d maxBodyBytes=%d maxPaths=%d maxTags=%d hardLimits=%t", cfg.Server.Invalidation.QueueSize, cfg.Server.Invalidation.WorkerConcurrency, cfg.Server.Invalidation.MaxBodyBytes, cfg.Server.Invalidation.MaxPaths, cfg.Server.Invalidation.MaxTags, cfg.Server.Invalidation.HardLimits)
+	}
+
+	if path := cfg.Logging.StatsFile; path != "" {
+		s.restoreStats(path)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			wstats.TotalsFile{
+				Collector: s.stats,
+				Path:      path,
+				Every:     cfg.Logging.statsFlushEveryDur,
+				StopCh:    s.stopCh,
+				Logger:    logging.At(logging.LevelWarn),
+			}.Loop()
+		}()
 	}
 
 	if cfg.Logging.logStatsEveryDur > 0 {
@@ -184,11 +265,42 @@ func NewService(cfg Config) (*Service, error) {
 				StopCh:    s.stopCh,
 				Collector: s.stats,
 				Cache:     statsCacheIndex{s: s},
-				Logger:    log.Default(),
+				Logger:    logging.At(logging.LevelInfo),
 			})
 		}()
 	}
 
+	if minFree := cfg.Storage.Disk.minFreeBytes; minFree > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			cache.FreeSpaceGuard{
+				Disk:    s.disk.inner,
+				MinFree: uint64(minFree),
+				Every:   diskGuardEvery,
+				StopCh:  s.stopCh,
+				Logger:  wstats.NewRateLimitedLogger(time.Minute, logging.At(logging.LevelWarn)),
+			}.Loop()
+		}()
+	}
+
+	if audit := cfg.Storage.Audit; audit.everyDur > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			cache.TierAudit{
+				RAM:       s.ram.inner,
+				Disk:      s.disk.inner,
+				Every:     audit.everyDur,
+				Sample:    audit.SampleSize,
+				Reconcile: audit.Reconcile,
+				StopCh:    s.stopCh,
+				Logger:    logging.At(logging.LevelWarn),
+			}.Loop()
+		}()
+	}
+
+	s.startKeyVersionSweep()
 	s.startWarmupGroups()
 	if s.disco != nil {
 		s.disco.Start()
@@ -198,9 +310,93 @@ func NewService(cfg Config) (*Service, error) {
 }
 
 func (s *Service) Close() {
+	_ = s.Shutdown(context.Background())
+}
+
+// Shutdown stops background work, flushes RAM and closes storage like Close,
+// but stops waiting once ctx ends. Jobs still running then may yet write to
+// storage, so it is left open for process exit and ctx's error is returned.
+func (s *Service) Shutdown(ctx context.Context) error {
 	close(s.stopCh)
-	s.wg.Wait()
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		s.proxy.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		logging.Warnf("shutdown: background jobs still running at the deadline, exiting without closing the disk cache")
+		return ctx.Err()
+	}
+	s.flushRAMOnShutdown(ctx)
 	s.disk.close()
+	return nil
+}
+
+// restoreStats adds the totals saved in path to the stats collector. A missing
+// file is a first start; an unreadable one is logged and the counters start
+// from zero rather than blocking startup.
+func (s *Service) restoreStats(path string) {
+	t, err := wstats.LoadTotals(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		logging.Infof("stats: no saved totals at %q, starting from zero", path)
+	case err != nil:
+		logging.Warnf("stats: loading saved totals from %q failed, starting from zero: %v", path, err)
+	default:
+		s.stats.Restore(t)
+		logging.Infof("stats: restored totals from %q: responses=%d", path, t.TotalResponses)
+	}
+}
+
+// ShutdownTimeout is the live server.shutdownTimeout.
+func (s *Service) ShutdownTimeout() time.Duration {
+	return s.config().Server.shutdownTimeoutDur
+}
+
+// flushRAMOnShutdown queues RAM entries missing from disk for persistence,
+// within storage.ram.flushOnShutdown or ctx's deadline, whichever is sooner.
+// The disk writer drains them on close.
+func (s *Service) flushRAMOnShutdown(ctx context.Context) {
+	d := s.config().Storage.RAM.flushOnShutdownDur
+	if d <= 0 {
+		return
+	}
+	until := time.Now().Add(d)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(until) {
+		until = dl
+	}
+	n, complete := s.ram.FlushTo(s.disk, until)
+	logging.Infof("shutdown: flushed %d RAM entries to disk (complete=%v)", n, complete)
+}
+
+// LogSummary logs a one-off stats snapshot, for on-demand diagnostics. It is
+// written regardless of logging.level since an operator asked for it.
+func (s *Service) LogSummary() {
+	wstats.LogSummary(s.stats, statsCacheIndex{s: s}, s.entrySources(), wstats.QueueDepths{
+		Revalidations:   len(s.bgSem),
+		RevalidationCap: cap(s.bgSem),
+		DiskOps:         s.disk.PendingOps(),
+		Invalidations:   s.inv.QueueDepth(),
+	}, log.Default())
+}
+
+// entrySources tallies every cached path once by its DiscoveredBy, preferring
+// the RAM copy of a key held in both tiers.
+func (s *Service) entrySources() wstats.Sources {
+	var src wstats.Sources
+	ram := s.ram.MetaSnapshot()
+	for _, m := range ram {
+		src.Add(m.DiscoveredBy, m.Inactive)
+	}
+	for k, m := range s.disk.MetaSnapshot() {
+		if _, ok := ram[k]; !ok {
+			src.Add(m.DiscoveredBy, m.Inactive)
+		}
+	}
+	return src
 }
 
 func (s *Service) Handler() http.Handler {
@@ -212,30 +408,75 @@ func (s *Service) Handler() http.Handler {
 	return http.HandlerFunc(s.proxy.Handle)
 }
 
+func (s *Service) config() *Config {
+	return s.cfg.Load()
+}
+
 func (s *Service) pickRule(path string) *Rule {
-	for i := range s.cfg.Rules {
-		r := &s.cfg.Rules[i]
-		if r.Matches(path) {
-			return r
-		}
+	cfg := s.config()
+	if i := cfg.ruleIndex(path); i >= 0 {
+		return &cfg.Rules[i]
 	}
 	return nil
 }
 
+// ruleIndex returns the position of the first rule matching path in the
+// priority-ordered rules, or -1.
+func (cfg *Config) ruleIndex(path string) int {
+	for i := range cfg.Rules {
+		if cfg.Rules[i].Matches(path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// tierFor returns the cache tier of the rule matching path.
+func (s *Service) tierFor(path string) string {
+	if r := s.pickRule(path); r != nil && r.tier != "" {
+		return r.tier
+	}
+	return proxy.TierBoth
+}
+
+// storeEntry writes ent into the tiers allowed by tier and drops any copy
+// left in a tier the rule excludes.
+func (s *Service) storeEntry(key string, ent CacheEntry, tier string) {
+	switch tier {
+	case proxy.TierRAM:
+		s.ram.PutRAMOnly(key, ent, s.disk, s.overflowLog)
+		s.disk.Delete(key)
+	case proxy.TierDisk:
+		s.ram.Delete(key)
+		s.disk.PutAsync(key, ent)
+	default:
+		s.ram.Put(key, ent, s.disk, s.overflowLog)
+		s.disk.PutAsync(key, ent)
+	}
+}
+
+// forgetVary drops the Vary headers the proxy learned for key's path, so
+// purged keys leave nothing behind.
+func (s *Service) forgetVary(key string) {
+	if s.proxy != nil {
+		s.proxy.ForgetVary(key)
+	}
+}
+
 func (s *Service) configureDashboard() {
 	user := strings.TrimSpace(os.Getenv("WAIT0_DASHBOARD_USERNAME"))
 	pass := strings.TrimSpace(os.Getenv("WAIT0_DASHBOARD_PASSWORD"))
 	if user == "" || pass == "" {
-		log.Printf("dashboard disabled: missing WAIT0_DASHBOARD_USERNAME or WAIT0_DASHBOARD_PASSWORD")
+		logging.Infof("dashboard disabled: missing WAIT0_DASHBOARD_USERNAME or WAIT0_DASHBOARD_PASSWORD")
 		return
 	}
 
-	_, statsToken, ok := resolveAuthTokenByScope(s.cfg.Auth.Tokens, statapi.ReadScope)
+	_, statsToken, ok := resolveAuthTokenByScope(s.config().Auth.Tokens, statapi.ReadScope)
 	if !ok {
-		log.Printf("dashboard disabled: no auth token with scope %q", statapi.ReadScope)
+		logging.Infof("dashboard disabled: no auth token with scope %q", statapi.ReadScope)
 		return
 	}
-	_, invToken, invOK := resolveAuthTokenByScope(s.cfg.Auth.Tokens, invalidation.WriteScope)
+	_, invToken, invOK := resolveAuthTokenByScope(s.config().Auth.Tokens, invalidation.WriteScope)
 
 	s.dash = dashboard.NewController(
 		dashboard.Config{
@@ -253,10 +494,10 @@ func (s *Service) configureDashboard() {
 		},
 	)
 	if invOK {
-		log.Printf("dashboard enabled: stats and invalidation routes active")
+		logging.Infof("dashboard enabled: stats and invalidation routes active")
 		return
 	}
-	log.Printf("dashboard enabled (stats-only): no auth token with scope %q", invalidation.WriteScope)
+	logging.Infof("dashboard enabled (stats-only): no auth token with scope %q", invalidation.WriteScope)
 }
 
 func resolveAuthTokenByScope(tokens []AuthTokenConfig, scope string) (id, token string, ok bool) {
@@ -278,13 +519,33 @@ func resolveAuthTokenByScope(tokens []AuthTokenConfig, scope string) (id, token
 	return "", "", false
 }
 
+// startWarmupGroups starts one warmup loop per rule with warmUp, after stopping
+// the loops of a previous call.
 func (s *Service) startWarmupGroups() {
-	for i := range s.cfg.Rules {
-		r := &s.cfg.Rules[i]
+	s.warmMu.Lock()
+	defer s.warmMu.Unlock()
+	select {
+	case <-s.stopCh:
+		return
+	default:
+	}
+	if s.warmStop != nil {
+		close(s.warmStop)
+	}
+	stop := make(chan struct{})
+	s.warmStop = stop
+	if s.warmStarted.IsZero() {
+		s.warmStarted = time.Now()
+	}
+	started := s.warmStarted
+
+	cfg := s.config()
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
 		if r.warmEvery <= 0 || r.warmMax <= 0 {
 			continue
 		}
-		log.Printf("warmup group start: match=%q, runEvery=%s, maxRequestsAtATime=%d", r.Match, r.warmEvery, r.warmMax)
+		logging.Infof("warmup group start: match=%q, runEvery=%s, maxRequestsAtATime=%d, maxRequestsPerRun=%d, rampUp=%s, schedule=%s", r.Match, r.warmEvery, r.warmMax, r.warmPerRun, r.warmRamp, r.warmWindow)
 		s.wg.Add(1)
 		go func(rule *Rule) {
 			defer s.wg.Done()
@@ -292,18 +553,34 @@ func (s *Service) startWarmupGroups() {
 				Match:     rule.Match,
 				WarmEvery: rule.warmEvery,
 				WarmMax:   rule.warmMax,
-				Matches:   rule.Matches,
+				MaxPerRun: rule.warmPerRun,
+				Matches:   s.warmMatcher(rule.Match),
+				RampStart: started,
+				RampUp:    rule.warmRamp,
+				Schedule:  rule.warmWindow,
+				Stop:      stop,
 			})
 		}(r)
 	}
 }
 
+// warmMatcher selects the keys the warmup group of the rule with the given
+// match revalidates: paths that rule governs under the live config. A path a
+// more specific rule matches first warms on that rule's schedule only, or not
+// at all if that rule has no warmUp.
+func (s *Service) warmMatcher(match string) func(path string) bool {
+	return func(path string) bool {
+		r := s.pickRule(path)
+		return r != nil && r.Match == match
+	}
+}
+
 type statsCacheIndex struct {
 	s *Service
 }
 
-func (i statsCacheIndex) RAMKeys() []string {
-	return i.s.ram.Keys()
+func (i statsCacheIndex) ForEachRAMKey(fn func(key string) bool) {
+	i.s.ram.ForEach(fn)
 }
 
 func (i statsCacheIndex) DiskKeyCount() int {
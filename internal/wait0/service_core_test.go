@@ -1,9 +1,13 @@
 package wait0
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	wstats "wait0/internal/wait0/stats"
 )
 
 func TestEnvBool(t *testing.T) {
@@ -32,6 +36,7 @@ func TestNewService_Close_Handler(t *testing.T) {
 	cfg.Storage.RAM.Max = "2m"
 	cfg.Storage.Disk.Max = "8m"
 	cfg.Server.Origin = "http://localhost:3000"
+	cfg.Storage.Disk.path = filepath.Join(t.TempDir(), "leveldb")
 
 	s, err := NewService(cfg)
 	if err != nil {
@@ -40,18 +45,171 @@ func TestNewService_Close_Handler(t *testing.T) {
 	if s.Handler() == nil {
 		t.Fatalf("expected non-nil handler")
 	}
+	s.LogSummary()
+	if s.connTrace != nil {
+		t.Fatalf("connection tracing must be off by default")
+	}
 	s.Close()
 }
 
+func TestNewService_TraceConnections(t *testing.T) {
+	cfg := Config{}
+	cfg.Storage.RAM.Max = "2m"
+	cfg.Storage.Disk.Max = "8m"
+	cfg.Server.Origin = "http://localhost:3000"
+	cfg.Storage.Disk.path = filepath.Join(t.TempDir(), "leveldb")
+	cfg.Server.Upstream.TraceConnections = true
+
+	s, err := NewService(cfg)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	defer s.Close()
+	if s.connTrace == nil || s.httpClient.Transport == nil {
+		t.Fatalf("expected traced origin transport")
+	}
+	if got := newStatsRuntimeAdapter(s).OriginConnStats(); !got.Traced {
+		t.Fatalf("OriginConnStats = %+v, want traced", got)
+	}
+}
+
+func TestNewService_PersistsStatsTotals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	saved := wstats.NewCollector()
+	saved.CountOutcome("hit")
+	saved.Observe(10)
+	if err := wstats.SaveTotals(path, saved.Totals()); err != nil {
+		t.Fatalf("SaveTotals: %v", err)
+	}
+
+	cfg := Config{}
+	cfg.Storage.RAM.Max = "2m"
+	cfg.Storage.Disk.Max = "8m"
+	cfg.Server.Origin = "http://localhost:3000"
+	cfg.Storage.Disk.path = filepath.Join(t.TempDir(), "leveldb")
+	cfg.Logging.StatsFile = path
+	cfg.Logging.statsFlushEveryDur = time.Hour
+
+	s, err := NewService(cfg)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	if got := s.stats.Snapshot().TotalResponses; got != 1 {
+		s.Close()
+		t.Fatalf("restored TotalResponses = %d, want 1", got)
+	}
+	s.stats.CountOutcome("hit")
+	s.Close()
+
+	got, err := wstats.LoadTotals(path)
+	if err != nil || got.Outcomes["hit"] != 2 || got.TotalResponses != 1 {
+		t.Fatalf("totals saved on shutdown = %+v, %v", got, err)
+	}
+}
+
+func TestFlushRAMOnShutdown(t *testing.T) {
+	s := newTestService(t, "http://invalid.local", nil)
+	s.ram.Put("/hot", CacheEntry{Status: 200, Body: []byte("hot")}, nil, s.overflowLog)
+
+	s.flushRAMOnShutdown(context.Background())
+	time.Sleep(20 * time.Millisecond)
+	if s.disk.HasKey("/hot") {
+		t.Fatalf("flush must be off unless storage.ram.flushOnShutdown is set")
+	}
+
+	s.config().Storage.RAM.flushOnShutdownDur = time.Second
+	s.flushRAMOnShutdown(context.Background())
+	waitFor(t, time.Second, func() bool { return s.disk.HasKey("/hot") })
+}
+
+func TestShutdown_GivesUpAtDeadline(t *testing.T) {
+	s := newTestService(t, "http://invalid.local", nil)
+	release := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		<-release
+	}()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	// Shutdown closed stopCh; keep the test cleanup from closing it again.
+	stopOnceByService.Delete(s)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want deadline exceeded", err)
+	}
+	if err := s.disk.Check(); err != nil {
+		t.Fatalf("disk cache must stay open for abandoned jobs: %v", err)
+	}
+}
+
+func TestEntrySources(t *testing.T) {
+	s := newTestService(t, "http://invalid.local", nil)
+	s.ram.Put("/user", CacheEntry{Status: 200, Body: []byte("u"), DiscoveredBy: "user"}, nil, s.overflowLog)
+	s.ram.Put("/warm", CacheEntry{Status: 200, Body: []byte("w"), DiscoveredBy: "sitemap"}, nil, s.overflowLog)
+	s.disk.PutAsync("/warm", CacheEntry{Status: 200, Body: []byte("w"), DiscoveredBy: "sitemap"})
+	s.disk.PutAsync("/seed", CacheEntry{DiscoveredBy: "sitemap", Inactive: true})
+	waitFor(t, time.Second, func() bool { return s.disk.HasKey("/seed") && s.disk.HasKey("/warm") })
+
+	if got := s.entrySources(); got != (wstats.Sources{User: 1, Sitemap: 2, SitemapWarmed: 1}) {
+		t.Fatalf("sources = %+v", got)
+	}
+}
+
 func TestStartWarmupGroups_StopsOnClose(t *testing.T) {
 	rule := mustRule(t, "PathPrefix(/)")
 	rule.warmEvery = time.Millisecond
 	rule.warmMax = 1
 
 	s := newTestService(t, "http://invalid.local", []Rule{rule})
-	s.cfg.Rules = []Rule{rule}
+	s.config().Rules = []Rule{rule}
+
+	s.startWarmupGroups()
+	stopTestService(s)
+	s.wg.Wait()
+}
+
+func TestWarmMatcher_OnlyPathsTheRuleGoverns(t *testing.T) {
+	api := mustRule(t, "PathPrefix(/api)")
+	all := mustRule(t, "PathPrefix(/)")
+	s := newTestService(t, "http://invalid.local", []Rule{api, all})
+	s.config().Rules = []Rule{api, all}
+
+	if m := s.warmMatcher(all.Match); m("/api/items") || !m("/page") {
+		t.Fatalf("catch-all group: /api/items=%v /page=%v, want only /page", m("/api/items"), m("/page"))
+	}
+	if m := s.warmMatcher(api.Match); !m("/api/items") || m("/page") {
+		t.Fatalf("api group: /api/items=%v /page=%v, want only /api/items", m("/api/items"), m("/page"))
+	}
+}
 
+func TestReload_RestartsWarmupGroupsOnlyWhenChanged(t *testing.T) {
+	rule := mustRule(t, "PathPrefix(/)")
+	rule.warmEvery = time.Hour
+	rule.warmMax = 1
+	s := newTestService(t, "http://invalid.local", []Rule{rule})
+	s.config().Rules = []Rule{rule}
 	s.startWarmupGroups()
+	first := s.warmStop
+
+	s.Reload(Config{Rules: []Rule{rule}})
+	if s.warmStop != first {
+		t.Fatalf("unchanged warmup settings restarted the warmup groups")
+	}
+
+	rule.warmMax = 4
+	s.Reload(Config{Rules: []Rule{rule}})
+	if s.warmStop == first {
+		t.Fatalf("changed warmup settings kept the old warmup groups")
+	}
+	select {
+	case <-first:
+	default:
+		t.Fatalf("old warmup groups were not stopped")
+	}
+
 	stopTestService(s)
 	s.wg.Wait()
 }
@@ -12,7 +12,9 @@ func TestStatsCacheIndex_Methods(t *testing.T) {
 	waitFor(t, 700*time.Millisecond, func() bool { return s.disk.HasKey("/b") })
 
 	idx := statsCacheIndex{s: s}
-	if len(idx.RAMKeys()) == 0 {
+	ramKeys := 0
+	idx.ForEachRAMKey(func(string) bool { ramKeys++; return true })
+	if ramKeys == 0 {
 		t.Fatalf("expected RAM keys")
 	}
 	if idx.DiskKeyCount() == 0 {
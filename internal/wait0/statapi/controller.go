@@ -5,7 +5,6 @@ import (
 	"math"
 	"net/http"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +28,18 @@ type Runtime interface {
 	RAMMetaSnapshot() map[string]EntryMeta
 	DiskMetaSnapshot() map[string]EntryMeta
 	RefreshDurationStatsMillis() MetricTriplet
+	PrefixStats() []PrefixStat
+	DiskWriteErrors() uint64
+	DiskDeadLetters() uint64
+	DiskCompactions() uint64
+	DiskWritesPaused() bool
+	DiskReadsInFlight() int64
+	RAMOversizeDrops() uint64
+	DiskOversizeDrops() uint64
+	CoalescedMisses() uint64
+	OriginConnStats() OriginStats
+	Metrics() Metrics
+	LiveStats() Live
 }
 
 type Controller struct {
@@ -47,12 +58,49 @@ type response struct {
 	Memory             memoryPayload  `json:"memory"`
 	RefreshDurationMS  MetricTriplet  `json:"refresh_duration_ms"`
 	Sitemap            sitemapPayload `json:"sitemap"`
+	Origin             OriginStats    `json:"origin"`
 }
 
 type cachePayload struct {
-	URLsTotal               int           `json:"urls_total"`
-	ResponsesSizeBytesTotal uint64        `json:"responses_size_bytes_total"`
-	ResponseSizeBytes       MetricTriplet `json:"response_size_bytes"`
+	URLsTotal               int            `json:"urls_total"`
+	ResponsesSizeBytesTotal uint64         `json:"responses_size_bytes_total"`
+	ResponseSizeBytes       MetricTriplet  `json:"response_size_bytes"`
+	Prefixes                []PrefixStat   `json:"prefixes"`
+	DiskWriteErrors         uint64         `json:"disk_write_errors"`
+	DiskDeadLetters         uint64         `json:"disk_dead_letters"`
+	DiskWritesPaused        bool           `json:"disk_writes_paused"`
+	DiskReadsInFlight       int64          `json:"disk_reads_in_flight"`
+	DiskCompactions         uint64         `json:"disk_compactions"`
+	RAMOversizeDrops        uint64         `json:"ram_oversize_drops"`
+	DiskOversizeDrops       uint64         `json:"disk_oversize_drops"`
+	CoalescedMisses         uint64         `json:"coalesced_misses"`
+	URLsBySource            sourcesPayload `json:"urls_by_source"`
+}
+
+// sourcesPayload splits urls_total by how each path entered the cache.
+type sourcesPayload struct {
+	User    int `json:"user"`
+	Sitemap int `json:"sitemap"`
+}
+
+// PrefixStat is the hit/miss tally for one top-level path segment.
+type PrefixStat struct {
+	Prefix   string  `json:"prefix"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// OriginStats aggregates origin connection tracing. Traced is false, and the
+// counters zero, unless server.upstream.traceConnections is enabled.
+type OriginStats struct {
+	Traced               bool    `json:"traced"`
+	Requests             uint64  `json:"requests"`
+	ReusedConnections    uint64  `json:"reused_connections"`
+	ConnectionReuseRatio float64 `json:"connection_reuse_ratio"`
+	AvgDNSMS             float64 `json:"avg_dns_ms"`
+	AvgConnectMS         float64 `json:"avg_connect_ms"`
+	AvgTLSMS             float64 `json:"avg_tls_ms"`
 }
 
 type memoryPayload struct {
@@ -85,23 +133,31 @@ func (c *Controller) Handle(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	if r.Method != http.MethodGet {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+	if !c.authorize(w, r) {
 		return
 	}
 
-	actor, ok := c.authn.AuthenticateBearer(r.Header.Get("Authorization"))
+	resp := c.getSnapshot()
+	writeAnyJSON(w, http.StatusOK, resp)
+}
+
+// authorize admits GET requests carrying a bearer token with ReadScope and
+// answers anything else with the matching JSON error.
+func (c *Controller) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		return false
+	}
+	actor, ok := c.authn.AuthenticateRequest(r)
 	if !ok {
 		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "unauthorized"})
-		return
+		return false
 	}
 	if !auth.AuthorizedForScope(actor, ReadScope) {
 		writeJSON(w, http.StatusForbidden, map[string]any{"error": "forbidden"})
-		return
+		return false
 	}
-
-	resp := c.getSnapshot()
-	writeAnyJSON(w, http.StatusOK, resp)
+	return true
 }
 
 func (c *Controller) getSnapshot() response {
@@ -136,8 +192,7 @@ func (c *Controller) buildSnapshot(now time.Time) response {
 	respMax := uint64(0)
 	respCount := uint64(0)
 
-	sitemapDiscovered := 0
-	sitemapCrawled := 0
+	var src wstats.Sources
 
 	for key := range keys {
 		meta, ok := ram[key]
@@ -158,13 +213,7 @@ func (c *Controller) buildSnapshot(now time.Time) response {
 			respMax = sz
 		}
 
-		if strings.EqualFold(strings.TrimSpace(meta.DiscoveredBy), "sitemap") {
-			sitemapDiscovered++
-			if !meta.Inactive {
-				sitemapCrawled++
-			}
-		}
-
+		src.Add(meta.DiscoveredBy, meta.Inactive)
 	}
 
 	respStats := MetricTriplet{}
@@ -187,8 +236,8 @@ func (c *Controller) buildSnapshot(now time.Time) response {
 	runtime.ReadMemStats(&ms)
 
 	crawlPct := 0.0
-	if sitemapDiscovered > 0 {
-		crawlPct = float64(sitemapCrawled) * 100 / float64(sitemapDiscovered)
+	if src.Sitemap > 0 {
+		crawlPct = float64(src.SitemapWarmed) * 100 / float64(src.Sitemap)
 	}
 
 	return response{
@@ -198,6 +247,16 @@ func (c *Controller) buildSnapshot(now time.Time) response {
 			URLsTotal:               len(keys),
 			ResponsesSizeBytesTotal: totalSize,
 			ResponseSizeBytes:       respStats,
+			Prefixes:                c.rt.PrefixStats(),
+			DiskWriteErrors:         c.rt.DiskWriteErrors(),
+			DiskDeadLetters:         c.rt.DiskDeadLetters(),
+			DiskWritesPaused:        c.rt.DiskWritesPaused(),
+			DiskReadsInFlight:       c.rt.DiskReadsInFlight(),
+			DiskCompactions:         c.rt.DiskCompactions(),
+			RAMOversizeDrops:        c.rt.RAMOversizeDrops(),
+			DiskOversizeDrops:       c.rt.DiskOversizeDrops(),
+			CoalescedMisses:         c.rt.CoalescedMisses(),
+			URLsBySource:            sourcesPayload{User: src.User, Sitemap: src.Sitemap},
 		},
 		Memory: memoryPayload{
 			RSSBytes:     rssBytes,
@@ -205,10 +264,11 @@ func (c *Controller) buildSnapshot(now time.Time) response {
 		},
 		RefreshDurationMS: c.rt.RefreshDurationStatsMillis(),
 		Sitemap: sitemapPayload{
-			DiscoveredURLs:  sitemapDiscovered,
-			CrawledURLs:     sitemapCrawled,
+			DiscoveredURLs:  src.Sitemap,
+			CrawledURLs:     src.SitemapWarmed,
 			CrawlPercentage: crawlPct,
 		},
+		Origin: c.rt.OriginConnStats(),
 	}
 }
 
@@ -11,9 +11,21 @@ import (
 )
 
 type fakeRuntime struct {
-	ram  map[string]EntryMeta
-	disk map[string]EntryMeta
-	dur  MetricTriplet
+	ram   map[string]EntryMeta
+	disk  map[string]EntryMeta
+	dur   MetricTriplet
+	pfx   []PrefixStat
+	werr  uint64
+	dead  uint64
+	held  bool
+	rifl  int64
+	odrop uint64
+	ddrop uint64
+	coal  uint64
+	comp  uint64
+	orig  OriginStats
+	met   Metrics
+	live  Live
 }
 
 func (f *fakeRuntime) RAMMetaSnapshot() map[string]EntryMeta {
@@ -36,6 +48,54 @@ func (f *fakeRuntime) RefreshDurationStatsMillis() MetricTriplet {
 	return f.dur
 }
 
+func (f *fakeRuntime) DiskWritesPaused() bool {
+	return f.held
+}
+
+func (f *fakeRuntime) OriginConnStats() OriginStats {
+	return f.orig
+}
+
+func (f *fakeRuntime) DiskReadsInFlight() int64 {
+	return f.rifl
+}
+
+func (f *fakeRuntime) RAMOversizeDrops() uint64 {
+	return f.odrop
+}
+
+func (f *fakeRuntime) DiskOversizeDrops() uint64 {
+	return f.ddrop
+}
+
+func (f *fakeRuntime) CoalescedMisses() uint64 {
+	return f.coal
+}
+
+func (f *fakeRuntime) Metrics() Metrics {
+	return f.met
+}
+
+func (f *fakeRuntime) LiveStats() Live {
+	return f.live
+}
+
+func (f *fakeRuntime) DiskCompactions() uint64 {
+	return f.comp
+}
+
+func (f *fakeRuntime) DiskWriteErrors() uint64 {
+	return f.werr
+}
+
+func (f *fakeRuntime) DiskDeadLetters() uint64 {
+	return f.dead
+}
+
+func (f *fakeRuntime) PrefixStats() []PrefixStat {
+	return append([]PrefixStat(nil), f.pfx...)
+}
+
 func TestIsEndpointPath(t *testing.T) {
 	if !IsEndpointPath("/wait0") {
 		t.Fatal("expected /wait0 to match")
@@ -96,7 +156,17 @@ func TestHandle_MetricsPayload(t *testing.T) {
 			"/b": {Size: 999, LastRefreshUnixNano: now.Add(-1 * time.Second).UnixNano()},
 			"/c": {Size: 500, LastRefreshUnixNano: now.Add(-30 * time.Second).UnixNano(), DiscoveredBy: "user"},
 		},
-		dur: MetricTriplet{Min: 19, Avg: 66, Max: 119},
+		dur:   MetricTriplet{Min: 19, Avg: 66, Max: 119},
+		pfx:   []PrefixStat{{Prefix: "/blog", Hits: 3, Misses: 1, HitRatio: 0.75}},
+		werr:  2,
+		dead:  1,
+		held:  true,
+		rifl:  3,
+		odrop: 5,
+		ddrop: 6,
+		coal:  7,
+		comp:  4,
+		orig:  OriginStats{Traced: true, Requests: 4, ReusedConnections: 3, ConnectionReuseRatio: 0.75},
 	})
 
 	w := httptest.NewRecorder()
@@ -120,6 +190,50 @@ func TestHandle_MetricsPayload(t *testing.T) {
 		t.Fatalf("responses_size_bytes_total=%v", cacheObj["responses_size_bytes_total"])
 	}
 
+	if uint64(cacheObj["disk_write_errors"].(float64)) != 2 {
+		t.Fatalf("disk_write_errors=%v", cacheObj["disk_write_errors"])
+	}
+	if uint64(cacheObj["disk_dead_letters"].(float64)) != 1 {
+		t.Fatalf("disk_dead_letters=%v", cacheObj["disk_dead_letters"])
+	}
+
+	if cacheObj["disk_writes_paused"] != true {
+		t.Fatalf("disk_writes_paused=%v", cacheObj["disk_writes_paused"])
+	}
+
+	if int64(cacheObj["disk_reads_in_flight"].(float64)) != 3 {
+		t.Fatalf("disk_reads_in_flight=%v", cacheObj["disk_reads_in_flight"])
+	}
+	if uint64(cacheObj["ram_oversize_drops"].(float64)) != 5 {
+		t.Fatalf("ram_oversize_drops=%v", cacheObj["ram_oversize_drops"])
+	}
+	if uint64(cacheObj["disk_oversize_drops"].(float64)) != 6 {
+		t.Fatalf("disk_oversize_drops=%v", cacheObj["disk_oversize_drops"])
+	}
+	if uint64(cacheObj["coalesced_misses"].(float64)) != 7 {
+		t.Fatalf("coalesced_misses=%v", cacheObj["coalesced_misses"])
+	}
+	if uint64(cacheObj["disk_compactions"].(float64)) != 4 {
+		t.Fatalf("disk_compactions=%v", cacheObj["disk_compactions"])
+	}
+
+	if src := cacheObj["urls_by_source"].(map[string]any); src["user"].(float64) != 1 || src["sitemap"].(float64) != 2 {
+		t.Fatalf("urls_by_source=%v", src)
+	}
+
+	prefixes := cacheObj["prefixes"].([]any)
+	if len(prefixes) != 1 {
+		t.Fatalf("prefixes=%v", prefixes)
+	}
+	if p := prefixes[0].(map[string]any); p["prefix"] != "/blog" || p["hit_ratio"].(float64) != 0.75 {
+		t.Fatalf("prefix bucket=%v", p)
+	}
+
+	originObj := resp["origin"].(map[string]any)
+	if originObj["traced"] != true || originObj["connection_reuse_ratio"].(float64) != 0.75 || int(originObj["requests"].(float64)) != 4 {
+		t.Fatalf("origin=%v", originObj)
+	}
+
 	sitemapObj := resp["sitemap"].(map[string]any)
 	if int(sitemapObj["discovered_urls"].(float64)) != 2 {
 		t.Fatalf("discovered_urls=%v", sitemapObj["discovered_urls"])
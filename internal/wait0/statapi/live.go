@@ -0,0 +1,100 @@
+package statapi
+
+import (
+	"net/http"
+	"time"
+
+	wstats "wait0/internal/wait0/stats"
+)
+
+const LiveEndpointPath = "/wait0/stats"
+
+// Live is the runtime state served on LiveEndpointPath. Every field is read
+// from counters or cache totals, never by walking the cache.
+type Live struct {
+	Collector   wstats.Snapshot
+	Rules       []RuleStat
+	RAMBytes    int64
+	DiskBytes   int64
+	CachedPaths int
+}
+
+// RuleStat is the hit/miss tally for one rule, keyed by its match pattern.
+type RuleStat struct {
+	Rule     string  `json:"rule"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+type liveResponse struct {
+	GeneratedAt string           `json:"generated_at"`
+	Responses   liveResponses    `json:"responses"`
+	Refreshes   liveRefreshes    `json:"refreshes"`
+	Cache       liveCachePayload `json:"cache"`
+	Rules       []RuleStat       `json:"rules"`
+	// RSSBytes is omitted where the platform does not report it.
+	RSSBytes *uint64 `json:"rss_bytes,omitempty"`
+}
+
+type liveResponses struct {
+	Count      uint64        `json:"count"`
+	BytesTotal uint64        `json:"bytes_total"`
+	Bytes      MetricTriplet `json:"bytes"`
+}
+
+type liveRefreshes struct {
+	Count      uint64        `json:"count"`
+	DurationMS MetricTriplet `json:"duration_ms"`
+}
+
+type liveCachePayload struct {
+	RAMBytes    int64 `json:"ram_bytes"`
+	DiskBytes   int64 `json:"disk_bytes"`
+	CachedPaths int   `json:"cached_paths"`
+}
+
+// HandleLive serves a JSON view of the live collector counters, cache totals
+// and per-rule hit/miss tallies. Unlike Handle it is not cached, and it only
+// reads counters, so it is cheap enough to poll while debugging.
+func (c *Controller) HandleLive(w http.ResponseWriter, r *http.Request) {
+	if !c.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeAnyJSON(w, http.StatusOK, buildLive(c.rt.LiveStats(), time.Now().UTC()))
+}
+
+func buildLive(l Live, now time.Time) liveResponse {
+	ss := l.Collector
+	rules := l.Rules
+	if rules == nil {
+		rules = []RuleStat{}
+	}
+	resp := liveResponse{
+		GeneratedAt: now.Format(time.RFC3339Nano),
+		Responses: liveResponses{
+			Count:      ss.TotalResponses,
+			BytesTotal: ss.TotalRespBytes,
+			Bytes:      MetricTriplet{Min: ss.MinRespBytes, Avg: ss.AvgRespBytes, Max: ss.MaxRespBytes},
+		},
+		Refreshes: liveRefreshes{
+			Count: ss.RefreshCount,
+			DurationMS: MetricTriplet{
+				Min: ss.MinRefreshDurNs / uint64(time.Millisecond),
+				Avg: ss.AvgRefreshDurNs / uint64(time.Millisecond),
+				Max: ss.MaxRefreshDurNs / uint64(time.Millisecond),
+			},
+		},
+		Cache: liveCachePayload{
+			RAMBytes:    l.RAMBytes,
+			DiskBytes:   l.DiskBytes,
+			CachedPaths: l.CachedPaths,
+		},
+		Rules: rules,
+	}
+	if rss, ok := wstats.ProcessRSSBytes(); ok {
+		resp.RSSBytes = &rss
+	}
+	return resp
+}
@@ -0,0 +1,111 @@
+package statapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wait0/internal/wait0/auth"
+	wstats "wait0/internal/wait0/stats"
+)
+
+func TestHandleLive_Payload(t *testing.T) {
+	authn := auth.NewAuthenticator([]auth.TokenConfig{{ID: "ops", Token: "tok", Scopes: []string{ReadScope}}})
+	ctrl := NewController(authn, &fakeRuntime{live: Live{
+		Collector: wstats.Snapshot{
+			TotalResponses:  4,
+			TotalRespBytes:  400,
+			MinRespBytes:    10,
+			AvgRespBytes:    100,
+			MaxRespBytes:    250,
+			RefreshCount:    2,
+			MinRefreshDurNs: uint64(5 * time.Millisecond),
+			AvgRefreshDurNs: uint64(15 * time.Millisecond),
+			MaxRefreshDurNs: uint64(25 * time.Millisecond),
+		},
+		Rules:       []RuleStat{{Rule: "/blog/*", Hits: 3, Misses: 1, HitRatio: 0.75}},
+		RAMBytes:    1024,
+		DiskBytes:   4096,
+		CachedPaths: 7,
+	}})
+
+	req := httptest.NewRequest(http.MethodGet, LiveEndpointPath, nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	w := httptest.NewRecorder()
+	ctrl.HandleLive(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if cc := w.Result().Header.Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("Cache-Control = %q", cc)
+	}
+	var got liveResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Responses != (liveResponses{Count: 4, BytesTotal: 400, Bytes: MetricTriplet{Min: 10, Avg: 100, Max: 250}}) {
+		t.Fatalf("responses = %+v", got.Responses)
+	}
+	if got.Refreshes != (liveRefreshes{Count: 2, DurationMS: MetricTriplet{Min: 5, Avg: 15, Max: 25}}) {
+		t.Fatalf("refreshes = %+v", got.Refreshes)
+	}
+	if got.Cache != (liveCachePayload{RAMBytes: 1024, DiskBytes: 4096, CachedPaths: 7}) {
+		t.Fatalf("cache = %+v", got.Cache)
+	}
+	if len(got.Rules) != 1 || got.Rules[0] != (RuleStat{Rule: "/blog/*", Hits: 3, Misses: 1, HitRatio: 0.75}) {
+		t.Fatalf("rules = %+v", got.Rules)
+	}
+	if _, ok := wstats.ProcessRSSBytes(); ok != (got.RSSBytes != nil) {
+		t.Fatalf("rss_bytes presence = %v, want %v", got.RSSBytes != nil, ok)
+	}
+}
+
+func TestHandleLive_EmptyRulesEncodeAsArray(t *testing.T) {
+	authn := auth.NewAuthenticator([]auth.TokenConfig{{ID: "ops", Token: "tok", Scopes: []string{ReadScope}}})
+	ctrl := NewController(authn, &fakeRuntime{})
+
+	req := httptest.NewRequest(http.MethodGet, LiveEndpointPath, nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	w := httptest.NewRecorder()
+	ctrl.HandleLive(w, req)
+
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(got["rules"]) != "[]" {
+		t.Fatalf("rules = %s, want []", got["rules"])
+	}
+}
+
+func TestHandleLive_AuthAndMethod(t *testing.T) {
+	authn := auth.NewAuthenticator([]auth.TokenConfig{
+		{ID: "ops", Token: "tok", Scopes: []string{ReadScope}},
+		{ID: "writer", Token: "w", Scopes: []string{"invalidation:write"}},
+	})
+	ctrl := NewController(authn, &fakeRuntime{})
+
+	tests := []struct {
+		method, token string
+		want          int
+	}{
+		{method: http.MethodGet, want: http.StatusUnauthorized},
+		{method: http.MethodGet, token: "w", want: http.StatusForbidden},
+		{method: http.MethodPost, token: "tok", want: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, token: "tok", want: http.StatusOK},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, LiveEndpointPath, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		ctrl.HandleLive(w, req)
+		if w.Code != tc.want {
+			t.Fatalf("%s token=%q status = %d, want %d", tc.method, tc.token, w.Code, tc.want)
+		}
+	}
+}
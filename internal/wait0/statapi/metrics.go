@@ -0,0 +1,65 @@
+package statapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+const MetricsEndpointPath = "/wait0/metrics"
+
+// Metrics is the counter and gauge set served in Prometheus text format.
+type Metrics struct {
+	// Responses counts proxied responses by X-Wait0 value.
+	Responses     map[string]uint64
+	Revalidations uint64
+	RAMEvictions  uint64
+	DiskEvictions uint64
+
+	RAMBytes    int64
+	DiskBytes   int64
+	CachedPaths int
+}
+
+// HandleMetrics serves live counters in the Prometheus text exposition format.
+// It uses the same bearer auth as the stats endpoint but no snapshot cache, so
+// every scrape sees current values.
+func (c *Controller) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !c.authorize(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	writeMetrics(w, c.rt.Metrics())
+}
+
+func writeMetrics(w io.Writer, m Metrics) {
+	statuses := make([]string, 0, len(m.Responses))
+	for s := range m.Responses {
+		statuses = append(statuses, s)
+	}
+	sort.Strings(statuses)
+
+	header(w, "wait0_responses_total", "counter", "Proxied responses by X-Wait0 value.")
+	for _, s := range statuses {
+		fmt.Fprintf(w, "wait0_responses_total{status=%q} %d\n", s, m.Responses[s])
+	}
+	header(w, "wait0_revalidations_total", "counter", "Completed background revalidations.")
+	fmt.Fprintf(w, "wait0_revalidations_total %d\n", m.Revalidations)
+	header(w, "wait0_evictions_total", "counter", "Entries evicted to stay within a cache budget, by tier.")
+	fmt.Fprintf(w, "wait0_evictions_total{tier=\"ram\"} %d\n", m.RAMEvictions)
+	fmt.Fprintf(w, "wait0_evictions_total{tier=\"disk\"} %d\n", m.DiskEvictions)
+	header(w, "wait0_ram_bytes", "gauge", "Bytes charged against the RAM cache budget.")
+	fmt.Fprintf(w, "wait0_ram_bytes %d\n", m.RAMBytes)
+	header(w, "wait0_disk_bytes", "gauge", "Bytes charged against the disk cache budget.")
+	fmt.Fprintf(w, "wait0_disk_bytes %d\n", m.DiskBytes)
+	header(w, "wait0_cached_paths", "gauge", "Distinct cache keys held in RAM or on disk.")
+	fmt.Fprintf(w, "wait0_cached_paths %d\n", m.CachedPaths)
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
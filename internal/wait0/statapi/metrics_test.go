@@ -0,0 +1,85 @@
+package statapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wait0/internal/wait0/auth"
+)
+
+func TestHandleMetrics_Exposition(t *testing.T) {
+	authn := auth.NewAuthenticator([]auth.TokenConfig{{ID: "prom", Token: "tok", Scopes: []string{ReadScope}}})
+	ctrl := NewController(authn, &fakeRuntime{met: Metrics{
+		Responses:     map[string]uint64{"miss": 3, "hit": 7, "bad-gateway": 1},
+		Revalidations: 4,
+		RAMEvictions:  2,
+		DiskEvictions: 5,
+		RAMBytes:      1024,
+		DiskBytes:     4096,
+		CachedPaths:   9,
+	}})
+
+	req := httptest.NewRequest(http.MethodGet, MetricsEndpointPath, nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	w := httptest.NewRecorder()
+	ctrl.HandleMetrics(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if ct := w.Result().Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE wait0_responses_total counter",
+		`wait0_responses_total{status="bad-gateway"} 1`,
+		`wait0_responses_total{status="hit"} 7`,
+		`wait0_responses_total{status="miss"} 3`,
+		"wait0_revalidations_total 4",
+		`wait0_evictions_total{tier="ram"} 2`,
+		`wait0_evictions_total{tier="disk"} 5`,
+		"# TYPE wait0_ram_bytes gauge",
+		"wait0_ram_bytes 1024",
+		"wait0_disk_bytes 4096",
+		"wait0_cached_paths 9",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, body)
+		}
+	}
+	if strings.Index(body, `status="bad-gateway"`) > strings.Index(body, `status="hit"`) {
+		t.Fatalf("statuses should be sorted:\n%s", body)
+	}
+}
+
+func TestHandleMetrics_AuthAndMethod(t *testing.T) {
+	authn := auth.NewAuthenticator([]auth.TokenConfig{
+		{ID: "prom", Token: "tok", Scopes: []string{ReadScope}},
+		{ID: "writer", Token: "w", Scopes: []string{"invalidation:write"}},
+	})
+	ctrl := NewController(authn, &fakeRuntime{})
+
+	tests := []struct {
+		method, token string
+		want          int
+	}{
+		{method: http.MethodGet, want: http.StatusUnauthorized},
+		{method: http.MethodGet, token: "w", want: http.StatusForbidden},
+		{method: http.MethodPost, token: "tok", want: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, token: "tok", want: http.StatusOK},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, MetricsEndpointPath, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		ctrl.HandleMetrics(w, req)
+		if w.Code != tc.want {
+			t.Fatalf("%s token=%q status = %d, want %d", tc.method, tc.token, w.Code, tc.want)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package stats
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -18,10 +20,16 @@ type Collector struct {
 	refreshCount      atomic.Uint64
 	minRefreshDurNs   atomic.Uint64
 	maxRefreshDurNs   atomic.Uint64
+
+	prefixes *PrefixCounter
+
+	outcomesMu sync.Mutex
+	outcomes   map[string]uint64
+	rules      map[string]*prefixCounter
 }
 
 func NewCollector() *Collector {
-	s := &Collector{}
+	s := &Collector{prefixes: NewPrefixCounter(), outcomes: map[string]uint64{}, rules: map[string]*prefixCounter{}}
 	s.minRespBytes.Store(math.MaxUint64)
 	s.minRefreshDurNs.Store(math.MaxUint64)
 	return s
@@ -84,6 +92,92 @@ func (s *Collector) ObserveRefreshDuration(d time.Duration) {
 	}
 }
 
+// ObserveOutcome records a cache hit or miss for path's prefix bucket.
+func (s *Collector) ObserveOutcome(path string, hit bool) {
+	s.prefixes.Observe(path, hit)
+}
+
+// CountOutcome tallies a served response by its X-Wait0 value.
+func (s *Collector) CountOutcome(wait0 string) {
+	s.outcomesMu.Lock()
+	s.outcomes[wait0]++
+	s.outcomesMu.Unlock()
+}
+
+// OutcomeCounts returns the CountOutcome tallies keyed by X-Wait0 value.
+func (s *Collector) OutcomeCounts() map[string]uint64 {
+	s.outcomesMu.Lock()
+	defer s.outcomesMu.Unlock()
+	out := make(map[string]uint64, len(s.outcomes))
+	for k, v := range s.outcomes {
+		out[k] = v
+	}
+	return out
+}
+
+// NoRule labels outcomes for paths no configured rule matches.
+const NoRule = "(none)"
+
+// ObserveRuleOutcome records a cache hit or miss for the rule with the given
+// match pattern; use NoRule for unmatched paths.
+func (s *Collector) ObserveRuleOutcome(rule string, hit bool) {
+	s.outcomesMu.Lock()
+	defer s.outcomesMu.Unlock()
+	b, ok := s.rules[rule]
+	if !ok {
+		b = &prefixCounter{}
+		s.rules[rule] = b
+	}
+	if hit {
+		b.hits++
+	} else {
+		b.misses++
+	}
+}
+
+type RuleSnapshot struct {
+	Rule     string
+	Hits     uint64
+	Misses   uint64
+	HitRatio float64
+}
+
+// RuleSnapshot returns the per-rule tallies ordered by traffic, busiest first.
+func (s *Collector) RuleSnapshot() []RuleSnapshot {
+	s.outcomesMu.Lock()
+	out := make([]RuleSnapshot, 0, len(s.rules))
+	for rule, b := range s.rules {
+		rs := RuleSnapshot{Rule: rule, Hits: b.hits, Misses: b.misses}
+		if total := b.hits + b.misses; total > 0 {
+			rs.HitRatio = float64(b.hits) / float64(total)
+		}
+		out = append(out, rs)
+	}
+	s.outcomesMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		ti, tj := out[i].Hits+out[i].Misses, out[j].Hits+out[j].Misses
+		if ti == tj {
+			return out[i].Rule < out[j].Rule
+		}
+		return ti > tj
+	})
+	return out
+}
+
+func (s *Collector) PrefixSnapshot() []PrefixSnapshot {
+	return s.prefixes.Snapshot()
+}
+
+// HitTotals sums hit/miss outcomes across all prefix buckets.
+func (s *Collector) HitTotals() (hits, misses uint64) {
+	for _, p := range s.prefixes.Snapshot() {
+		hits += p.Hits
+		misses += p.Misses
+	}
+	return hits, misses
+}
+
 type Snapshot struct {
 	TotalResponses uint64
 	TotalRespBytes uint64
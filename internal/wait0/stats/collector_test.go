@@ -50,6 +50,59 @@ func TestCollectorSnapshot_RefreshDurations(t *testing.T) {
 	}
 }
 
+func TestCollectorHitTotals(t *testing.T) {
+	s := NewCollector()
+	if hits, misses := s.HitTotals(); hits != 0 || misses != 0 {
+		t.Fatalf("empty totals = %d/%d", hits, misses)
+	}
+	s.ObserveOutcome("/a", true)
+	s.ObserveOutcome("/b", false)
+	s.ObserveOutcome("/b", true)
+	if hits, misses := s.HitTotals(); hits != 2 || misses != 1 {
+		t.Fatalf("totals = %d/%d, want 2/1", hits, misses)
+	}
+}
+
+func TestCollectorOutcomeCounts(t *testing.T) {
+	s := NewCollector()
+	s.CountOutcome("hit")
+	s.CountOutcome("hit")
+	s.CountOutcome("bad-gateway")
+	got := s.OutcomeCounts()
+	if len(got) != 2 || got["hit"] != 2 || got["bad-gateway"] != 1 {
+		t.Fatalf("outcomes = %v", got)
+	}
+	got["hit"] = 99
+	if s.OutcomeCounts()["hit"] != 2 {
+		t.Fatalf("OutcomeCounts must return a copy")
+	}
+}
+
+func TestCollectorRuleSnapshot(t *testing.T) {
+	s := NewCollector()
+	s.ObserveRuleOutcome("/blog/*", true)
+	s.ObserveRuleOutcome("/blog/*", false)
+	s.ObserveRuleOutcome(NoRule, false)
+	s.ObserveRuleOutcome("/api/*", true)
+	s.ObserveRuleOutcome("/api/*", true)
+	s.ObserveRuleOutcome("/api/*", true)
+
+	got := s.RuleSnapshot()
+	want := []RuleSnapshot{
+		{Rule: "/api/*", Hits: 3, HitRatio: 1},
+		{Rule: "/blog/*", Hits: 1, Misses: 1, HitRatio: 0.5},
+		{Rule: NoRule, Misses: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("rules = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("rules[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
 func TestFormatBytes(t *testing.T) {
 	tests := []struct {
 		in   uint64
@@ -0,0 +1,145 @@
+package stats
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnTracker aggregates origin connection reuse and dial phase timings
+// collected with httptrace.
+type ConnTracker struct {
+	requests atomic.Uint64
+	reused   atomic.Uint64
+
+	dns     durationSum
+	connect durationSum
+	tls     durationSum
+}
+
+type durationSum struct {
+	count   atomic.Uint64
+	totalNs atomic.Uint64
+}
+
+func (d *durationSum) observe(v time.Duration) {
+	if v < 0 {
+		v = 0
+	}
+	d.count.Add(1)
+	d.totalNs.Add(uint64(v))
+}
+
+func (d *durationSum) avgMillis() float64 {
+	n := d.count.Load()
+	if n == 0 {
+		return 0
+	}
+	return float64(d.totalNs.Load()) / float64(n) / float64(time.Millisecond)
+}
+
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{}
+}
+
+// Transport wraps base so every request it carries is traced by t.
+func (t *ConnTracker) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return tracingTransport{base: base, t: t}
+}
+
+type tracingTransport struct {
+	base http.RoundTripper
+	t    *ConnTracker
+}
+
+func (tt tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := httptrace.WithClientTrace(req.Context(), tt.t.clientTrace())
+	return tt.base.RoundTrip(req.WithContext(ctx))
+}
+
+// clientTrace returns a trace for a single request. Connect callbacks may fire
+// concurrently for multiple addresses, so start times are guarded.
+func (t *ConnTracker) clientTrace() *httptrace.ClientTrace {
+	var mu sync.Mutex
+	var dnsStart, tlsStart time.Time
+	connectStart := map[string]time.Time{}
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.requests.Add(1)
+			if info.Reused {
+				t.reused.Add(1)
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			start := dnsStart
+			mu.Unlock()
+			if !start.IsZero() {
+				t.dns.observe(time.Since(start))
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			connectStart[network+" "+addr] = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			start, ok := connectStart[network+" "+addr]
+			mu.Unlock()
+			if ok && err == nil {
+				t.connect.observe(time.Since(start))
+			}
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mu.Lock()
+			start := tlsStart
+			mu.Unlock()
+			if !start.IsZero() && err == nil {
+				t.tls.observe(time.Since(start))
+			}
+		},
+	}
+}
+
+type ConnSnapshot struct {
+	Requests     uint64
+	Reused       uint64
+	ReuseRatio   float64
+	AvgDNSMs     float64
+	AvgConnectMs float64
+	AvgTLSMs     float64
+}
+
+func (t *ConnTracker) Snapshot() ConnSnapshot {
+	reqs := t.requests.Load()
+	reused := t.reused.Load()
+	ratio := 0.0
+	if reqs > 0 {
+		ratio = float64(reused) / float64(reqs)
+	}
+	return ConnSnapshot{
+		Requests:     reqs,
+		Reused:       reused,
+		ReuseRatio:   ratio,
+		AvgDNSMs:     t.dns.avgMillis(),
+		AvgConnectMs: t.connect.avgMillis(),
+		AvgTLSMs:     t.tls.avgMillis(),
+	}
+}
@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnTracker_CountsReuse(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	tr := NewConnTracker()
+	client := &http.Client{Timeout: 2 * time.Second, Transport: tr.Transport(&http.Transport{})}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	ss := tr.Snapshot()
+	if ss.Requests != 3 || ss.Reused != 2 {
+		t.Fatalf("requests=%d reused=%d, want 3/2", ss.Requests, ss.Reused)
+	}
+	if ss.ReuseRatio < 0.66 || ss.ReuseRatio > 0.67 {
+		t.Fatalf("ReuseRatio = %v", ss.ReuseRatio)
+	}
+	if ss.AvgConnectMs <= 0 {
+		t.Fatalf("AvgConnectMs = %v, want > 0", ss.AvgConnectMs)
+	}
+	if ss.AvgTLSMs != 0 {
+		t.Fatalf("AvgTLSMs = %v, want 0 for plain http", ss.AvgTLSMs)
+	}
+}
+
+func TestConnTracker_EmptySnapshot(t *testing.T) {
+	if ss := NewConnTracker().Snapshot(); ss != (ConnSnapshot{}) {
+		t.Fatalf("empty snapshot = %+v", ss)
+	}
+}
@@ -0,0 +1,186 @@
+package stats
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Totals are the Collector's cumulative counters, the part of its state worth
+// keeping across restarts. Rates and gauges derived at read time are not
+// included.
+type Totals struct {
+	TotalResponses uint64 `json:"total_responses"`
+	TotalRespBytes uint64 `json:"total_resp_bytes"`
+	MinRespBytes   uint64 `json:"min_resp_bytes"`
+	MaxRespBytes   uint64 `json:"max_resp_bytes"`
+
+	RefreshCount      uint64 `json:"refresh_count"`
+	TotalRefreshDurNs uint64 `json:"total_refresh_dur_ns"`
+	MinRefreshDurNs   uint64 `json:"min_refresh_dur_ns"`
+	MaxRefreshDurNs   uint64 `json:"max_refresh_dur_ns"`
+
+	// Outcomes are the CountOutcome tallies keyed by X-Wait0 value.
+	Outcomes map[string]uint64 `json:"outcomes,omitempty"`
+	// Prefixes and Rules are the hit/miss tallies by path prefix and by
+	// rule match pattern.
+	Prefixes map[string]Tally `json:"prefixes,omitempty"`
+	Rules    map[string]Tally `json:"rules,omitempty"`
+}
+
+type Tally struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// Totals returns the current cumulative counters.
+func (s *Collector) Totals() Totals {
+	snap := s.Snapshot()
+	t := Totals{
+		TotalResponses:    snap.TotalResponses,
+		TotalRespBytes:    snap.TotalRespBytes,
+		MinRespBytes:      snap.MinRespBytes,
+		MaxRespBytes:      snap.MaxRespBytes,
+		RefreshCount:      snap.RefreshCount,
+		TotalRefreshDurNs: snap.TotalRefreshDurNs,
+		MinRefreshDurNs:   snap.MinRefreshDurNs,
+		MaxRefreshDurNs:   snap.MaxRefreshDurNs,
+		Outcomes:          s.OutcomeCounts(),
+		Prefixes:          map[string]Tally{},
+		Rules:             map[string]Tally{},
+	}
+	for _, p := range s.PrefixSnapshot() {
+		t.Prefixes[p.Prefix] = Tally{Hits: p.Hits, Misses: p.Misses}
+	}
+	for _, r := range s.RuleSnapshot() {
+		t.Rules[r.Rule] = Tally{Hits: r.Hits, Misses: r.Misses}
+	}
+	return t
+}
+
+// Restore adds t to the collector's counters, as if the observations behind
+// t had been made by this process. Minimums and maximums are merged.
+func (s *Collector) Restore(t Totals) {
+	if t.TotalResponses > 0 {
+		s.totalResponses.Add(t.TotalResponses)
+		s.totalRespBytes.Add(t.TotalRespBytes)
+		storeMin(&s.minRespBytes, t.MinRespBytes)
+		storeMax(&s.maxRespBytes, t.MaxRespBytes)
+	}
+	if t.RefreshCount > 0 {
+		s.refreshCount.Add(t.RefreshCount)
+		s.totalRefreshDurNs.Add(t.TotalRefreshDurNs)
+		storeMin(&s.minRefreshDurNs, t.MinRefreshDurNs)
+		storeMax(&s.maxRefreshDurNs, t.MaxRefreshDurNs)
+	}
+	for prefix, n := range t.Prefixes {
+		s.prefixes.add(prefix, n.Hits, n.Misses)
+	}
+	s.outcomesMu.Lock()
+	defer s.outcomesMu.Unlock()
+	for k, v := range t.Outcomes {
+		s.outcomes[k] += v
+	}
+	for rule, n := range t.Rules {
+		b, ok := s.rules[rule]
+		if !ok {
+			b = &prefixCounter{}
+			s.rules[rule] = b
+		}
+		b.hits += n.Hits
+		b.misses += n.Misses
+	}
+}
+
+func storeMin(v *atomic.Uint64, n uint64) {
+	for {
+		cur := v.Load()
+		if n >= cur || v.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+func storeMax(v *atomic.Uint64, n uint64) {
+	for {
+		cur := v.Load()
+		if n <= cur || v.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// LoadTotals reads totals saved by SaveTotals. A missing file returns an error
+// satisfying errors.Is(err, fs.ErrNotExist).
+func LoadTotals(path string) (Totals, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Totals{}, err
+	}
+	var t Totals
+	if err := json.Unmarshal(b, &t); err != nil {
+		return Totals{}, err
+	}
+	return t, nil
+}
+
+// SaveTotals writes t to path as JSON. It writes a temporary file next to
+// path and renames it over path, so a crash mid-write leaves the previous
+// totals intact.
+func SaveTotals(path string, t Totals) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// TotalsFile saves a collector's totals to Path every Every until StopCh
+// closes, and once more then, so a graceful shutdown keeps the latest counts.
+type TotalsFile struct {
+	Collector *Collector
+	Path      string
+	Every     time.Duration
+	StopCh    <-chan struct{}
+	Logger    Logger
+}
+
+// Loop runs the periodic saves; failures are logged and retried next tick.
+func (f TotalsFile) Loop() {
+	t := time.NewTicker(f.Every)
+	defer t.Stop()
+	for {
+		select {
+		case <-f.StopCh:
+			f.save()
+			return
+		case <-t.C:
+			f.save()
+		}
+	}
+}
+
+func (f TotalsFile) save() {
+	if err := SaveTotals(f.Path, f.Collector.Totals()); err != nil && f.Logger != nil {
+		f.Logger.Printf("stats: saving totals to %q failed: %v", f.Path, err)
+	}
+}
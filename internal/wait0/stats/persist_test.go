@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTotals_SaveLoadRestoreRoundTrip(t *testing.T) {
+	s := NewCollector()
+	s.Observe(100)
+	s.Observe(20)
+	s.ObserveRefreshDuration(5 * time.Millisecond)
+	s.CountOutcome("hit")
+	s.CountOutcome("hit")
+	s.CountOutcome("miss")
+	s.ObserveOutcome("/blog/a", true)
+	s.ObserveOutcome("/blog/b", false)
+	s.ObserveRuleOutcome("PathPrefix(/blog)", true)
+
+	path := filepath.Join(t.TempDir(), "stats.json")
+	if err := SaveTotals(path, s.Totals()); err != nil {
+		t.Fatalf("SaveTotals: %v", err)
+	}
+	saved, err := LoadTotals(path)
+	if err != nil {
+		t.Fatalf("LoadTotals: %v", err)
+	}
+
+	next := NewCollector()
+	next.Observe(300)
+	next.CountOutcome("hit")
+	next.Restore(saved)
+
+	snap := next.Snapshot()
+	if snap.TotalResponses != 3 || snap.TotalRespBytes != 420 || snap.MinRespBytes != 20 || snap.MaxRespBytes != 300 {
+		t.Fatalf("snapshot = %+v, want saved totals merged with new ones", snap)
+	}
+	if snap.RefreshCount != 1 || snap.MinRefreshDurNs != uint64(5*time.Millisecond) {
+		t.Fatalf("refresh = %d/%d", snap.RefreshCount, snap.MinRefreshDurNs)
+	}
+	if got := next.OutcomeCounts(); got["hit"] != 3 || got["miss"] != 1 {
+		t.Fatalf("outcomes = %v", got)
+	}
+	if hits, misses := next.HitTotals(); hits != 1 || misses != 1 {
+		t.Fatalf("prefix totals = %d/%d", hits, misses)
+	}
+	if rules := next.RuleSnapshot(); len(rules) != 1 || rules[0].Hits != 1 {
+		t.Fatalf("rules = %+v", rules)
+	}
+}
+
+func TestTotals_RestoreEmptyKeepsMinimums(t *testing.T) {
+	s := NewCollector()
+	s.Restore(NewCollector().Totals())
+	s.Observe(7)
+	if snap := s.Snapshot(); snap.MinRespBytes != 7 {
+		t.Fatalf("MinRespBytes = %d, want 7", snap.MinRespBytes)
+	}
+}
+
+func TestLoadTotals_Missing(t *testing.T) {
+	_, err := LoadTotals(filepath.Join(t.TempDir(), "none.json"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("err = %v, want not exist", err)
+	}
+}
+
+func TestTotalsFile_SavesOnStop(t *testing.T) {
+	s := NewCollector()
+	s.CountOutcome("hit")
+	path := filepath.Join(t.TempDir(), "stats.json")
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		TotalsFile{Collector: s, Path: path, Every: time.Hour, StopCh: stop}.Loop()
+		close(done)
+	}()
+	close(stop)
+	<-done
+
+	got, err := LoadTotals(path)
+	if err != nil || got.Outcomes["hit"] != 1 {
+		t.Fatalf("saved = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MaxPrefixBuckets bounds how many distinct path prefixes are tracked.
+// Outcomes for prefixes first seen after the limit is reached are counted
+// under OtherPrefix.
+const MaxPrefixBuckets = 64
+
+const OtherPrefix = "(other)"
+
+type prefixCounter struct {
+	hits   uint64
+	misses uint64
+}
+
+// PrefixCounter buckets cache hit/miss outcomes by top-level path segment.
+type PrefixCounter struct {
+	mu      sync.Mutex
+	buckets map[string]*prefixCounter
+}
+
+func NewPrefixCounter() *PrefixCounter {
+	return &PrefixCounter{buckets: map[string]*prefixCounter{}}
+}
+
+// PathPrefix returns the bucket for path: "/" for the root, otherwise the
+// first segment ("/blog/post" -> "/blog").
+func PathPrefix(path string) string {
+	p := strings.TrimPrefix(path, "/")
+	if p == "" {
+		return "/"
+	}
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		p = p[:i]
+	}
+	return "/" + p
+}
+
+func (c *PrefixCounter) Observe(path string, hit bool) {
+	if hit {
+		c.add(PathPrefix(path), 1, 0)
+	} else {
+		c.add(PathPrefix(path), 0, 1)
+	}
+}
+
+// add counts hits and misses under prefix, or under OtherPrefix once
+// MaxPrefixBuckets are tracked.
+func (c *PrefixCounter) add(prefix string, hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.buckets[prefix]
+	if !ok {
+		if len(c.buckets) >= MaxPrefixBuckets {
+			prefix = OtherPrefix
+			b = c.buckets[prefix]
+		}
+		if b == nil {
+			b = &prefixCounter{}
+			c.buckets[prefix] = b
+		}
+	}
+	b.hits += hits
+	b.misses += misses
+}
+
+type PrefixSnapshot struct {
+	Prefix   string
+	Hits     uint64
+	Misses   uint64
+	HitRatio float64
+}
+
+// Snapshot returns the buckets ordered by traffic, busiest first.
+func (c *PrefixCounter) Snapshot() []PrefixSnapshot {
+	c.mu.Lock()
+	out := make([]PrefixSnapshot, 0, len(c.buckets))
+	for prefix, b := range c.buckets {
+		ps := PrefixSnapshot{Prefix: prefix, Hits: b.hits, Misses: b.misses}
+		if total := b.hits + b.misses; total > 0 {
+			ps.HitRatio = float64(b.hits) / float64(total)
+		}
+		out = append(out, ps)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		ti, tj := out[i].Hits+out[i].Misses, out[j].Hits+out[j].Misses
+		if ti == tj {
+			return out[i].Prefix < out[j].Prefix
+		}
+		return ti > tj
+	})
+	return out
+}
@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPathPrefix(t *testing.T) {
+	tests := map[string]string{
+		"":           "/",
+		"/":          "/",
+		"/blog":      "/blog",
+		"/blog/":     "/blog",
+		"/api/v1/x":  "/api",
+		"no-leading": "/no-leading",
+	}
+	for in, want := range tests {
+		if got := PathPrefix(in); got != want {
+			t.Fatalf("PathPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPrefixCounter_RatiosAndOrder(t *testing.T) {
+	c := NewPrefixCounter()
+	c.Observe("/blog/a", true)
+	c.Observe("/blog/b", true)
+	c.Observe("/blog/c", true)
+	c.Observe("/blog/c", false)
+	c.Observe("/api/x", false)
+
+	snap := c.Snapshot()
+	if len(snap) != 2 {
+		t.Fatalf("buckets = %+v", snap)
+	}
+	if snap[0].Prefix != "/blog" || snap[0].Hits != 3 || snap[0].Misses != 1 || snap[0].HitRatio != 0.75 {
+		t.Fatalf("blog bucket = %+v", snap[0])
+	}
+	if snap[1].Prefix != "/api" || snap[1].HitRatio != 0 {
+		t.Fatalf("api bucket = %+v", snap[1])
+	}
+}
+
+func TestPrefixCounter_BoundedBuckets(t *testing.T) {
+	c := NewPrefixCounter()
+	for i := 0; i < MaxPrefixBuckets+10; i++ {
+		c.Observe(fmt.Sprintf("/p%d/x", i), true)
+	}
+	snap := c.Snapshot()
+	if len(snap) != MaxPrefixBuckets+1 {
+		t.Fatalf("buckets = %d, want %d", len(snap), MaxPrefixBuckets+1)
+	}
+	if snap[0].Prefix != OtherPrefix || snap[0].Hits != 10 {
+		t.Fatalf("overflow bucket = %+v", snap[0])
+	}
+}
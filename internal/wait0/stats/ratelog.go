@@ -10,10 +10,16 @@ type RateLimitedLogger struct {
 	mu       sync.Mutex
 	lastAt   time.Time
 	interval time.Duration
+	out      Logger
 }
 
-func NewRateLimitedLogger(interval time.Duration) *RateLimitedLogger {
-	return &RateLimitedLogger{interval: interval}
+// NewRateLimitedLogger writes at most one line per interval to out, or to the
+// standard logger when out is nil.
+func NewRateLimitedLogger(interval time.Duration, out Logger) *RateLimitedLogger {
+	if out == nil {
+		out = log.Default()
+	}
+	return &RateLimitedLogger{interval: interval, out: out}
 }
 
 func (l *RateLimitedLogger) Printf(format string, args ...any) {
@@ -24,5 +30,5 @@ func (l *RateLimitedLogger) Printf(format string, args ...any) {
 		return
 	}
 	l.lastAt = now
-	log.Printf(format, args...)
+	l.out.Printf(format, args...)
 }
@@ -2,6 +2,7 @@ package stats
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"strings"
 	"testing"
@@ -19,7 +20,7 @@ func TestRateLimitedLogger_Printf(t *testing.T) {
 		log.SetFlags(prevFlags)
 	}()
 
-	rl := NewRateLimitedLogger(40 * time.Millisecond)
+	rl := NewRateLimitedLogger(40*time.Millisecond, nil)
 	rl.Printf("line-1")
 	rl.Printf("line-2")
 
@@ -33,3 +34,19 @@ func TestRateLimitedLogger_Printf(t *testing.T) {
 		t.Fatalf("log count = %d, want 2; buf=%q", got, buf.String())
 	}
 }
+
+type lineLogger struct{ lines []string }
+
+func (l *lineLogger) Printf(format string, args ...any) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestRateLimitedLogger_WritesToOut(t *testing.T) {
+	out := &lineLogger{}
+	rl := NewRateLimitedLogger(time.Hour, out)
+	rl.Printf("a=%d", 1)
+	rl.Printf("a=%d", 2)
+	if len(out.lines) != 1 || out.lines[0] != "a=1" {
+		t.Fatalf("lines = %v, want [a=1]", out.lines)
+	}
+}
@@ -10,23 +10,27 @@ type Logger interface {
 }
 
 type CacheIndex interface {
-	RAMKeys() []string
+	ForEachRAMKey(fn func(key string) bool)
 	DiskKeyCount() int
 	DiskHasKey(key string) bool
 	RAMTotalSize() uint64
 	DiskTotalSize() uint64
 }
 
+// CachedPathsCount counts keys across both tiers without building their
+// union: disk keys are counted from the index and RAM keys only when they
+// are not also on disk.
 func CachedPathsCount(index CacheIndex) int {
-	ramKeys := index.RAMKeys()
 	diskCount := index.DiskKeyCount()
-	intersect := 0
-	for _, k := range ramKeys {
+	ramCount, intersect := 0, 0
+	index.ForEachRAMKey(func(k string) bool {
+		ramCount++
 		if index.DiskHasKey(k) {
 			intersect++
 		}
-	}
-	return len(ramKeys) + diskCount - intersect
+		return true
+	})
+	return ramCount + diskCount - intersect
 }
 
 type LoopConfig struct {
@@ -95,3 +99,38 @@ func Loop(cfg LoopConfig) {
 		}
 	}
 }
+
+// QueueDepths are background work backlogs included in the on-demand summary.
+type QueueDepths struct {
+	Revalidations   int // in-flight background revalidations
+	RevalidationCap int
+	DiskOps         int // queued disk writes/deletes
+	Invalidations   int // invalidation jobs waiting for a worker
+}
+
+// LogSummary writes a one-line diagnostic snapshot: cached paths, cache
+// usage, where cached paths came from, overall hit ratio and background queue
+// depths.
+func LogSummary(c *Collector, index CacheIndex, src Sources, q QueueDepths, logger Logger) {
+	hits, misses := c.HitTotals()
+	ratio := 0.0
+	if total := hits + misses; total > 0 {
+		ratio = float64(hits) / float64(total)
+	}
+	logger.Printf(
+		"Summary: Paths: %d, RAM usage: %s, Disk usage: %s, Sources: user=%d sitemap=%d (warmed=%d), Hit ratio: %.3f (hits=%d misses=%d), Queues: revalidate=%d/%d disk=%d invalidation=%d",
+		CachedPathsCount(index),
+		FormatBytes(index.RAMTotalSize()),
+		FormatBytes(index.DiskTotalSize()),
+		src.User,
+		src.Sitemap,
+		src.SitemapWarmed,
+		ratio,
+		hits,
+		misses,
+		q.Revalidations,
+		q.RevalidationCap,
+		q.DiskOps,
+		q.Invalidations,
+	)
+}
@@ -1,6 +1,9 @@
 package stats
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -14,21 +17,30 @@ type fakeCacheIndex struct {
 	diskTotal uint64
 }
 
-func (f fakeCacheIndex) RAMKeys() []string       { return append([]string(nil), f.ramKeys...) }
-func (f fakeCacheIndex) DiskKeyCount() int       { return f.diskCount }
+func (f fakeCacheIndex) ForEachRAMKey(fn func(key string) bool) {
+	for _, k := range f.ramKeys {
+		if !fn(k) {
+			return
+		}
+	}
+}
+
+func (f fakeCacheIndex) DiskKeyCount() int          { return f.diskCount }
 func (f fakeCacheIndex) DiskHasKey(key string) bool { return f.diskSet[key] }
-func (f fakeCacheIndex) RAMTotalSize() uint64    { return f.ramTotal }
-func (f fakeCacheIndex) DiskTotalSize() uint64   { return f.diskTotal }
+func (f fakeCacheIndex) RAMTotalSize() uint64       { return f.ramTotal }
+func (f fakeCacheIndex) DiskTotalSize() uint64      { return f.diskTotal }
 
 type captureLogger struct {
 	mu    sync.Mutex
 	lines int
+	last  string
 }
 
-func (l *captureLogger) Printf(string, ...any) {
+func (l *captureLogger) Printf(format string, args ...any) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.lines++
+	l.last = fmt.Sprintf(format, args...)
 }
 
 func (l *captureLogger) count() int {
@@ -51,6 +63,24 @@ func TestCachedPathsCount(t *testing.T) {
 	}
 }
 
+func BenchmarkCachedPathsCount(b *testing.B) {
+	idx := fakeCacheIndex{diskCount: 1_000_000, diskSet: map[string]bool{}}
+	for i := 0; i < 1_000_000; i++ {
+		k := "/p/" + strconv.Itoa(i)
+		idx.diskSet[k] = true
+		if i%4 == 0 {
+			idx.ramKeys = append(idx.ramKeys, k)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if got := CachedPathsCount(idx); got != 1_000_000 {
+			b.Fatalf("CachedPathsCount = %d, want 1000000", got)
+		}
+	}
+}
+
 func TestLoop_LogsAndStops(t *testing.T) {
 	collector := NewCollector()
 	collector.Observe(128)
@@ -95,3 +125,24 @@ func TestLoop_LogsAndStops(t *testing.T) {
 		t.Fatal("Loop did not stop")
 	}
 }
+
+func TestLogSummary(t *testing.T) {
+	c := NewCollector()
+	c.ObserveOutcome("/a", true)
+	c.ObserveOutcome("/a", true)
+	c.ObserveOutcome("/b/x", true)
+	c.ObserveOutcome("/b/y", false)
+	idx := fakeCacheIndex{ramKeys: []string{"/a"}, diskCount: 1, diskSet: map[string]bool{"/a": true}, ramTotal: 2048}
+	logger := &captureLogger{}
+
+	LogSummary(c, idx, Sources{User: 4, Sitemap: 2, SitemapWarmed: 1}, QueueDepths{Revalidations: 3, RevalidationCap: 32, DiskOps: 5, Invalidations: 1}, logger)
+
+	if logger.count() != 1 {
+		t.Fatalf("lines = %d, want 1", logger.count())
+	}
+	for _, want := range []string{"Paths: 1", "RAM usage: 2kb", "Sources: user=4 sitemap=2 (warmed=1)", "Hit ratio: 0.750 (hits=3 misses=1)", "revalidate=3/32 disk=5 invalidation=1"} {
+		if !strings.Contains(logger.last, want) {
+			t.Fatalf("summary %q missing %q", logger.last, want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package stats
+
+import "strings"
+
+// Sources tallies cached paths by how they entered the cache, from each
+// entry's DiscoveredBy.
+type Sources struct {
+	// User counts paths first cached by client traffic, including entries
+	// without a recorded source.
+	User int
+	// Sitemap counts paths seeded by sitemap discovery, warmed or not.
+	Sitemap int
+	// SitemapWarmed counts sitemap seeds that have been fetched into active
+	// entries; the rest are inactive placeholders still waiting for warmup.
+	SitemapWarmed int
+}
+
+// Add counts one cached path.
+func (s *Sources) Add(discoveredBy string, inactive bool) {
+	if !strings.EqualFold(strings.TrimSpace(discoveredBy), "sitemap") {
+		s.User++
+		return
+	}
+	s.Sitemap++
+	if !inactive {
+		s.SitemapWarmed++
+	}
+}
@@ -0,0 +1,14 @@
+package stats
+
+import "testing"
+
+func TestSources_Add(t *testing.T) {
+	var s Sources
+	s.Add("user", false)
+	s.Add("", false)
+	s.Add(" Sitemap ", true)
+	s.Add("sitemap", false)
+	if s != (Sources{User: 2, Sitemap: 2, SitemapWarmed: 1}) {
+		t.Fatalf("sources = %+v", s)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"wait0/internal/wait0/cache"
 	"wait0/internal/wait0/statapi"
+	wstats "wait0/internal/wait0/stats"
 )
 
 type statsRuntimeAdapter struct {
@@ -37,6 +38,98 @@ func (a *statsRuntimeAdapter) RefreshDurationStatsMillis() statapi.MetricTriplet
 	}
 }
 
+func (a *statsRuntimeAdapter) PrefixStats() []statapi.PrefixStat {
+	if a.s.stats == nil {
+		return []statapi.PrefixStat{}
+	}
+	in := a.s.stats.PrefixSnapshot()
+	out := make([]statapi.PrefixStat, 0, len(in))
+	for _, p := range in {
+		out = append(out, statapi.PrefixStat{Prefix: p.Prefix, Hits: p.Hits, Misses: p.Misses, HitRatio: p.HitRatio})
+	}
+	return out
+}
+
+func (a *statsRuntimeAdapter) DiskWriteErrors() uint64 {
+	return a.s.disk.WriteErrors()
+}
+
+func (a *statsRuntimeAdapter) DiskDeadLetters() uint64 {
+	return a.s.disk.DeadLetters()
+}
+
+func (a *statsRuntimeAdapter) Metrics() statapi.Metrics {
+	m := statapi.Metrics{
+		Responses:     map[string]uint64{},
+		RAMEvictions:  a.s.ram.Evictions(),
+		DiskEvictions: a.s.disk.Evictions(),
+		RAMBytes:      a.s.ram.TotalSize(),
+		DiskBytes:     a.s.disk.TotalSize(),
+		CachedPaths:   wstats.CachedPathsCount(statsCacheIndex{s: a.s}),
+	}
+	if a.s.stats != nil {
+		m.Responses = a.s.stats.OutcomeCounts()
+		m.Revalidations = a.s.stats.Snapshot().RefreshCount
+	}
+	return m
+}
+
+func (a *statsRuntimeAdapter) LiveStats() statapi.Live {
+	l := statapi.Live{
+		Rules:       []statapi.RuleStat{},
+		RAMBytes:    a.s.ram.TotalSize(),
+		DiskBytes:   a.s.disk.TotalSize(),
+		CachedPaths: wstats.CachedPathsCount(statsCacheIndex{s: a.s}),
+	}
+	if a.s.stats != nil {
+		l.Collector = a.s.stats.Snapshot()
+		for _, r := range a.s.stats.RuleSnapshot() {
+			l.Rules = append(l.Rules, statapi.RuleStat{Rule: r.Rule, Hits: r.Hits, Misses: r.Misses, HitRatio: r.HitRatio})
+		}
+	}
+	return l
+}
+
+func (a *statsRuntimeAdapter) DiskCompactions() uint64 {
+	return a.s.disk.Compactions()
+}
+
+func (a *statsRuntimeAdapter) DiskWritesPaused() bool {
+	return a.s.disk.WritesPaused()
+}
+
+func (a *statsRuntimeAdapter) DiskReadsInFlight() int64 {
+	return a.s.disk.ReadsInFlight()
+}
+
+func (a *statsRuntimeAdapter) RAMOversizeDrops() uint64 {
+	return a.s.ram.OversizeDrops()
+}
+
+func (a *statsRuntimeAdapter) DiskOversizeDrops() uint64 {
+	return a.s.disk.OversizeDrops()
+}
+
+func (a *statsRuntimeAdapter) CoalescedMisses() uint64 {
+	return a.s.proxy.Coalesced()
+}
+
+func (a *statsRuntimeAdapter) OriginConnStats() statapi.OriginStats {
+	if a.s.connTrace == nil {
+		return statapi.OriginStats{}
+	}
+	cs := a.s.connTrace.Snapshot()
+	return statapi.OriginStats{
+		Traced:               true,
+		Requests:             cs.Requests,
+		ReusedConnections:    cs.Reused,
+		ConnectionReuseRatio: cs.ReuseRatio,
+		AvgDNSMS:             cs.AvgDNSMs,
+		AvgConnectMS:         cs.AvgConnectMs,
+		AvgTLSMS:             cs.AvgTLSMs,
+	}
+}
+
 func toStatMeta(in map[string]cache.EntryMeta) map[string]statapi.EntryMeta {
 	out := make(map[string]statapi.EntryMeta, len(in))
 	for k, v := range in {
@@ -3,6 +3,8 @@ package wait0
 import (
 	"testing"
 	"time"
+
+	"wait0/internal/wait0/statapi"
 )
 
 func TestStatsRuntimeAdapter_MetaSnapshots(t *testing.T) {
@@ -35,3 +37,74 @@ func TestStatsRuntimeAdapter_MetaSnapshots(t *testing.T) {
 		t.Fatalf("unexpected duration stats: %+v", dur)
 	}
 }
+
+func TestStatsRuntimeAdapter_PrefixStatsFromProxyOutcomes(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	p := newProxyRuntimeAdapter(s)
+	p.ObserveOutcome("/blog/a", "hit")
+	p.ObserveOutcome("/blog/b", "miss")
+	p.ObserveOutcome("/api/x", "stream")
+	p.ObserveOutcome("/api/x", "bypass")
+
+	got := newStatsRuntimeAdapter(s).PrefixStats()
+	if len(got) != 2 {
+		t.Fatalf("prefix stats = %+v", got)
+	}
+	if got[0].Prefix != "/blog" || got[0].Hits != 1 || got[0].Misses != 1 || got[0].HitRatio != 0.5 {
+		t.Fatalf("blog bucket = %+v", got[0])
+	}
+	if got[1].Prefix != "/api" || got[1].Hits != 0 || got[1].Misses != 1 {
+		t.Fatalf("api bucket = %+v", got[1])
+	}
+}
+
+func TestStatsRuntimeAdapter_Metrics(t *testing.T) {
+	s := newTestService(t, "http://example.com", nil)
+	p := newProxyRuntimeAdapter(s)
+	p.ObserveOutcome("/a", "hit")
+	p.ObserveOutcome("/a", "hit")
+	p.ObserveOutcome("/b", "bad-gateway")
+	s.stats.ObserveRefreshDuration(time.Millisecond)
+	s.ram.Put("/a", CacheEntry{Body: []byte("ram")}, s.disk, s.overflowLog)
+	s.disk.PutAsync("/b", CacheEntry{Body: []byte("disk")})
+	waitFor(t, 700*time.Millisecond, func() bool { return s.disk.HasKey("/b") })
+
+	got := newStatsRuntimeAdapter(s).Metrics()
+	want := statapi.Metrics{
+		Responses:     map[string]uint64{"hit": 2, "bad-gateway": 1},
+		Revalidations: 1,
+		RAMBytes:      s.ram.TotalSize(),
+		DiskBytes:     s.disk.TotalSize(),
+		CachedPaths:   2,
+	}
+	if got.Responses["hit"] != 2 || got.Responses["bad-gateway"] != 1 || len(got.Responses) != 2 {
+		t.Fatalf("responses = %v", got.Responses)
+	}
+	got.Responses = want.Responses
+	if got.Revalidations != want.Revalidations || got.RAMBytes != want.RAMBytes || got.DiskBytes != want.DiskBytes || got.CachedPaths != want.CachedPaths {
+		t.Fatalf("metrics = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsRuntimeAdapter_LiveStatsCountsPerRule(t *testing.T) {
+	s := newTestService(t, "http://example.com", []Rule{mustRule(t, "PathPrefix(/blog)")})
+	p := newProxyRuntimeAdapter(s)
+	p.ObserveOutcome("/blog/a", "hit")
+	p.ObserveOutcome("/blog/b", "miss")
+	p.ObserveOutcome("/blog/b", "bypass")
+	p.ObserveOutcome("/other", "stream")
+	s.stats.Observe(10)
+	s.ram.Put("/a", CacheEntry{Body: []byte("ram")}, s.disk, s.overflowLog)
+
+	got := newStatsRuntimeAdapter(s).LiveStats()
+	if got.Collector.TotalResponses != 1 || got.RAMBytes != s.ram.TotalSize() || got.CachedPaths != 1 {
+		t.Fatalf("live = %+v", got)
+	}
+	want := []statapi.RuleStat{
+		{Rule: "PathPrefix(/blog)", Hits: 1, Misses: 1, HitRatio: 0.5},
+		{Rule: "(none)", Misses: 1},
+	}
+	if len(got.Rules) != len(want) || got.Rules[0] != want[0] || got.Rules[1] != want[1] {
+		t.Fatalf("rules = %+v, want %+v", got.Rules, want)
+	}
+}
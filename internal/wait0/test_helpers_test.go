@@ -43,24 +43,25 @@ func newTestService(t *testing.T, origin string, rules []Rule) *Service {
 	cfg.Rules = rules
 
 	s := &Service{
-		cfg:                   cfg,
 		httpClient:            &http.Client{Timeout: 2 * time.Second},
 		ram:                   newRAMCache(8 * 1024 * 1024),
 		disk:                  disk,
 		bgSem:                 make(chan struct{}, 8),
 		stopCh:                make(chan struct{}),
-		overflowLog:           wstats.NewRateLimitedLogger(time.Hour),
-		unchangedLog:          wstats.NewRateLimitedLogger(time.Hour),
-		errorLog:              wstats.NewRateLimitedLogger(time.Hour),
+		overflowLog:           wstats.NewRateLimitedLogger(time.Hour, nil),
+		unchangedLog:          wstats.NewRateLimitedLogger(time.Hour, nil),
+		errorLog:              wstats.NewRateLimitedLogger(time.Hour, nil),
+		authFailLog:           wstats.NewRateLimitedLogger(time.Hour, nil),
 		sendRevalidateMarkers: true,
 		stats:                 wstats.NewCollector(),
 	}
+	s.cfg.Store(&cfg)
 	s.reval = revalidation.NewController(
 		newRevalidationRuntimeAdapter(s),
 		s.bgSem,
 		s.stopCh,
 		&s.wg,
-		s.cfg.Logging.LogWarmUp,
+		cfg.Logging.LogWarmUp,
 		log.Default(),
 		s.unchangedLog,
 		s.errorLog,
@@ -0,0 +1,197 @@
+package wait0
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"wait0/internal/wait0/logging"
+)
+
+// Origin transport defaults. MaxIdleConnsPerHost is raised well above
+// net/http's 2: wait0 talks to a single origin host, and warmup plus live
+// traffic would otherwise keep dialing new connections.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 64
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	originDialTimeout          = 30 * time.Second
+)
+
+// compileTransport validates server.transport and fills in its defaults.
+func compileTransport(cfg *Config) error {
+	tc := &cfg.Server.Transport
+	if tc.MaxIdleConns < 0 {
+		return fmt.Errorf("server.transport.maxIdleConns: must be >= 0")
+	}
+	if tc.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("server.transport.maxIdleConnsPerHost: must be >= 0")
+	}
+	if tc.MaxConnsPerHost < 0 {
+		return fmt.Errorf("server.transport.maxConnsPerHost: must be >= 0")
+	}
+	if tc.MaxIdleConns == 0 {
+		tc.MaxIdleConns = defaultMaxIdleConns
+	}
+	if tc.MaxIdleConnsPerHost == 0 {
+		tc.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	tc.idleConnTimeoutDur = defaultIdleConnTimeout
+	if strings.TrimSpace(tc.IdleConnTimeout) != "" {
+		d, err := time.ParseDuration(tc.IdleConnTimeout)
+		if err != nil {
+			return fmt.Errorf("server.transport.idleConnTimeout: %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("server.transport.idleConnTimeout: must be > 0")
+		}
+		tc.idleConnTimeoutDur = d
+	}
+	tc.keepAliveDur = defaultKeepAlive
+	if strings.TrimSpace(tc.KeepAlive) != "" {
+		d, err := time.ParseDuration(tc.KeepAlive)
+		if err != nil {
+			return fmt.Errorf("server.transport.keepAlive: %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("server.transport.keepAlive: must be > 0")
+		}
+		tc.keepAliveDur = d
+	}
+	return nil
+}
+
+// defaultUserAgent is sent on outbound requests without a User-Agent when
+// server.userAgent is unset.
+const defaultUserAgent = "wait0/1.0"
+
+// compileOriginHeaders validates server.userAgent and server.originHeaders
+// and canonicalizes the header names.
+func compileOriginHeaders(cfg *Config) error {
+	cfg.Server.UserAgent = strings.TrimSpace(cfg.Server.UserAgent)
+	if cfg.Server.UserAgent == "" {
+		cfg.Server.UserAgent = defaultUserAgent
+	}
+	if strings.ContainsAny(cfg.Server.UserAgent, "\r\n\x00") {
+		return fmt.Errorf("server.userAgent: must not contain control characters")
+	}
+	if len(cfg.Server.OriginHeaders) == 0 {
+		return nil
+	}
+	h := make(http.Header, len(cfg.Server.OriginHeaders))
+	for name, v := range cfg.Server.OriginHeaders {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("server.originHeaders: invalid header name %q", name)
+		}
+		if strings.EqualFold(name, "Host") {
+			return fmt.Errorf("server.originHeaders: Host cannot be set here; see server.preserveHost")
+		}
+		if strings.ContainsAny(v, "\r\n\x00") {
+			return fmt.Errorf("server.originHeaders.%s: must not contain control characters", name)
+		}
+		h.Set(name, v)
+	}
+	cfg.Server.originHeaders = h
+	return nil
+}
+
+// headerTransport sets the configured User-Agent on outbound requests, and
+// the static origin headers on those to an origin host, so a sitemap on
+// another host or a redirect away from origin never sees them. It reads both
+// from the live config, so they apply on reload.
+type headerTransport struct {
+	next   http.RoundTripper
+	config func() *Config
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg := t.config()
+	ua := cfg.Server.UserAgent
+	if ua == "" {
+		ua = defaultUserAgent
+	}
+	out := req.Clone(req.Context())
+	if out.Header.Get("User-Agent") == "" {
+		out.Header.Set("User-Agent", ua)
+	}
+	if len(cfg.Server.originHeaders) > 0 && cfg.isOriginHost(out.URL.Host) {
+		for name, vs := range cfg.Server.originHeaders {
+			out.Header[name] = vs
+		}
+	}
+	return t.next.RoundTrip(out)
+}
+
+// compileTLS validates server.tls and loads its CA bundle, which is added to
+// the system roots rather than replacing them.
+func compileTLS(cfg *Config) error {
+	tc := &cfg.Server.TLS
+	tc.ServerName = strings.TrimSpace(tc.ServerName)
+	tc.CAFile = strings.TrimSpace(tc.CAFile)
+	tc.rootCAs = nil
+	if tc.CAFile == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(tc.CAFile)
+	if err != nil {
+		return fmt.Errorf("server.tls.caFile: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("server.tls.caFile: no PEM certificates in %s", tc.CAFile)
+	}
+	tc.rootCAs = pool
+	return nil
+}
+
+// originTLSConfig returns the client TLS config for origin connections, or nil
+// when server.tls is unset so net/http keeps its defaults.
+func originTLSConfig(cfg *Config) *tls.Config {
+	tc := cfg.Server.TLS
+	if !tc.InsecureSkipVerify && tc.rootCAs == nil && tc.ServerName == "" {
+		return nil
+	}
+	return &tls.Config{
+		InsecureSkipVerify: tc.InsecureSkipVerify,
+		RootCAs:            tc.rootCAs,
+		ServerName:         tc.ServerName,
+	}
+}
+
+// warnInsecureTLS logs a warning when origin certificates are not verified.
+func warnInsecureTLS(cfg *Config) {
+	if cfg.Server.TLS.InsecureSkipVerify {
+		logging.Warnf("WARNING: server.tls.insecureSkipVerify active; origin certificates are NOT verified and traffic to %s can be intercepted, do not use in production", cfg.Server.Origin)
+	}
+}
+
+// newOriginTransport builds the transport for origin requests from the
+// compiled server.transport and server.tls settings, keeping net/http's defaults (proxy from
+// environment, TLS handshake timeout) for everything else.
+func newOriginTransport(cfg *Config) *http.Transport {
+	tc := cfg.Server.Transport
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: originDialTimeout, KeepAlive: tc.keepAliveDur}
+	t.DialContext = dialer.DialContext
+	t.MaxIdleConns = tc.MaxIdleConns
+	t.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	t.MaxConnsPerHost = tc.MaxConnsPerHost
+	t.IdleConnTimeout = tc.idleConnTimeoutDur
+	t.DisableKeepAlives = tc.DisableKeepAlives
+	t.ForceAttemptHTTP2 = !tc.DisableHTTP2
+	t.TLSClientConfig = originTLSConfig(cfg)
+	if tc.DisableHTTP2 {
+		// A non-nil empty map is how net/http is told not to negotiate h2.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
@@ -0,0 +1,204 @@
+package wait0
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileTransport_Defaults(t *testing.T) {
+	cfg := Config{}
+	if err := compileTransport(&cfg); err != nil {
+		t.Fatalf("compileTransport: %v", err)
+	}
+	tr := newOriginTransport(&cfg)
+	if tr.MaxIdleConns != defaultMaxIdleConns || tr.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost || tr.MaxConnsPerHost != 0 {
+		t.Fatalf("pool = %d/%d/%d", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.MaxConnsPerHost)
+	}
+	if tr.IdleConnTimeout != defaultIdleConnTimeout || tr.DisableKeepAlives || !tr.ForceAttemptHTTP2 {
+		t.Fatalf("idle=%s keepAlives disabled=%v h2=%v", tr.IdleConnTimeout, tr.DisableKeepAlives, tr.ForceAttemptHTTP2)
+	}
+}
+
+func TestNewOriginTransport_AppliesSettings(t *testing.T) {
+	cfg := Config{}
+	cfg.Server.Transport.MaxIdleConns = 10
+	cfg.Server.Transport.MaxIdleConnsPerHost = 5
+	cfg.Server.Transport.MaxConnsPerHost = 20
+	cfg.Server.Transport.IdleConnTimeout = "15s"
+	cfg.Server.Transport.DisableKeepAlives = true
+	cfg.Server.Transport.DisableHTTP2 = true
+	if err := compileTransport(&cfg); err != nil {
+		t.Fatalf("compileTransport: %v", err)
+	}
+	tr := newOriginTransport(&cfg)
+	if tr.MaxIdleConns != 10 || tr.MaxIdleConnsPerHost != 5 || tr.MaxConnsPerHost != 20 || tr.IdleConnTimeout != 15*time.Second {
+		t.Fatalf("transport = %d/%d/%d/%s", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.MaxConnsPerHost, tr.IdleConnTimeout)
+	}
+	if !tr.DisableKeepAlives || tr.ForceAttemptHTTP2 || tr.TLSNextProto == nil {
+		t.Fatalf("keepAlives/h2 settings not applied")
+	}
+}
+
+func TestNewOriginTransport_NegotiatesHTTP2(t *testing.T) {
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	origin.EnableHTTP2 = true
+	origin.StartTLS()
+	defer origin.Close()
+
+	for _, disable := range []bool{false, true} {
+		cfg := Config{}
+		cfg.Server.Transport.DisableHTTP2 = disable
+		if err := compileTransport(&cfg); err != nil {
+			t.Fatalf("compileTransport: %v", err)
+		}
+		tr := newOriginTransport(&cfg)
+		tr.TLSClientConfig = origin.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		resp, err := (&http.Client{Transport: tr}).Get(origin.URL)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		resp.Body.Close()
+		if want := map[bool]int{false: 2, true: 1}[disable]; resp.ProtoMajor != want {
+			t.Fatalf("disableHTTP2=%v: proto = %s, want HTTP/%d", disable, resp.Proto, want)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestNewOriginTransport_TLS(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: origin.Certificate().Raw}
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		caFile     string
+		serverName string
+		skip       bool
+		wantErr    bool
+	}{
+		{name: "system roots only", wantErr: true},
+		{name: "ca file", caFile: caFile},
+		{name: "ca file with matching server name", caFile: caFile, serverName: "example.com"},
+		{name: "ca file with wrong server name", caFile: caFile, serverName: "other.test", wantErr: true},
+		{name: "insecure skip verify", skip: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{}
+			cfg.Server.TLS.CAFile = tc.caFile
+			cfg.Server.TLS.ServerName = tc.serverName
+			cfg.Server.TLS.InsecureSkipVerify = tc.skip
+			if err := compileTLS(&cfg); err != nil {
+				t.Fatalf("compileTLS: %v", err)
+			}
+			tr := newOriginTransport(&cfg)
+			defer tr.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: tr}).Get(origin.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompileTLS_Unset(t *testing.T) {
+	cfg := Config{}
+	if err := compileTLS(&cfg); err != nil {
+		t.Fatalf("compileTLS: %v", err)
+	}
+	if tr := newOriginTransport(&cfg); tr.TLSClientConfig != nil {
+		t.Fatalf("TLSClientConfig = %+v, want net/http default", tr.TLSClientConfig)
+	}
+}
+
+func TestCompileTLS_BadCAFile(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	for _, path := range []string{notPEM, filepath.Join(t.TempDir(), "missing.pem")} {
+		cfg := Config{}
+		cfg.Server.TLS.CAFile = path
+		if err := compileTLS(&cfg); err == nil || !strings.HasPrefix(err.Error(), "server.tls.caFile:") {
+			t.Fatalf("compileTLS(%s) err = %v", path, err)
+		}
+	}
+}
+
+func TestCompileOriginHeaders(t *testing.T) {
+	cfg := Config{}
+	if err := compileOriginHeaders(&cfg); err != nil {
+		t.Fatalf("compileOriginHeaders: %v", err)
+	}
+	if cfg.Server.UserAgent != defaultUserAgent || cfg.Server.originHeaders != nil {
+		t.Fatalf("defaults: userAgent=%q headers=%v", cfg.Server.UserAgent, cfg.Server.originHeaders)
+	}
+
+	cfg.Server.UserAgent = " staging-warmer/2 "
+	cfg.Server.OriginHeaders = map[string]string{"x-staging-token": "s3cr3t"}
+	if err := compileOriginHeaders(&cfg); err != nil {
+		t.Fatalf("compileOriginHeaders: %v", err)
+	}
+	if cfg.Server.UserAgent != "staging-warmer/2" || cfg.Server.originHeaders.Get("X-Staging-Token") != "s3cr3t" {
+		t.Fatalf("userAgent=%q headers=%v", cfg.Server.UserAgent, cfg.Server.originHeaders)
+	}
+}
+
+func TestHeaderTransport(t *testing.T) {
+	seen := make(chan http.Header, 1)
+	capture := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen <- r.Header.Clone() })
+	origin := httptest.NewServer(capture)
+	defer origin.Close()
+	other := httptest.NewServer(capture)
+	defer other.Close()
+
+	cfg := Config{}
+	cfg.Server.Origin = origin.URL
+	cfg.Server.OriginHeaders = map[string]string{"X-Staging-Token": "s3cr3t"}
+	if err := compileOriginHeaders(&cfg); err != nil {
+		t.Fatalf("compileOriginHeaders: %v", err)
+	}
+	client := &http.Client{Transport: headerTransport{next: http.DefaultTransport, config: func() *Config { return &cfg }}}
+
+	get := func(url, ua, token string) http.Header {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if ua != "" {
+			req.Header.Set("User-Agent", ua)
+		}
+		if token != "" {
+			req.Header.Set("X-Staging-Token", token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		resp.Body.Close()
+		return <-seen
+	}
+
+	if h := get(origin.URL+"/sitemap.xml", "", "forged"); h.Get("User-Agent") != defaultUserAgent || h.Get("X-Staging-Token") != "s3cr3t" {
+		t.Fatalf("origin request: User-Agent=%q token=%q", h.Get("User-Agent"), h.Get("X-Staging-Token"))
+	}
+	if h := get(origin.URL+"/page", "Mozilla/5.0", ""); h.Get("User-Agent") != "Mozilla/5.0" {
+		t.Fatalf("client User-Agent = %q, want it kept", h.Get("User-Agent"))
+	}
+	if h := get(other.URL+"/sitemap.xml", "", ""); h.Get("User-Agent") != defaultUserAgent || h.Get("X-Staging-Token") != "" {
+		t.Fatalf("other host: User-Agent=%q token=%q, want the agent but no origin headers", h.Get("User-Agent"), h.Get("X-Staging-Token"))
+	}
+}
@@ -24,4 +24,18 @@ type CacheEntry struct {
 	// RevalidatedBy indicates what triggered the last revalidation.
 	// Expected values: "user" | "warmup" | "invalidate".
 	RevalidatedBy string
+
+	// MaxAge is the freshness lifetime from the origin's Cache-Control
+	// s-maxage or max-age, else Expires, in seconds. Zero means the origin sent
+	// none; freshness.Stale means the response was already stale on arrival.
+	MaxAge int64
+
+	// ETag is the origin's ETag response header, sent as If-None-Match when
+	// revalidating so an unchanged resource costs a 304 instead of a body.
+	// Empty when the origin sent none.
+	ETag string
+
+	// LastModified is the origin's Last-Modified response header, sent as
+	// If-Modified-Since when revalidating. Empty when the origin sent none.
+	LastModified string
 }